@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"errors"
+	"go-game/middleware"
+	"go-game/service"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetTodayChallenge 当天的每日挑战，登录时返回当前用户是否已挑战
+func GetTodayChallenge(c *gin.Context) {
+	challenge, err := service.GetTodayChallenge(middleware.CurrentUser(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "获取每日挑战失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status_code": http.StatusOK,
+		"message":     "获取成功",
+		"data":        challenge,
+	})
+}
+
+// StartChallenge 当前用户开始当天的挑战，返回的房间只有该玩家能入座，每人每天一次
+func StartChallenge(c *gin.Context) {
+	roomID, err := service.StartChallenge(middleware.CurrentUser(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status_code": http.StatusOK,
+		"message":     "挑战开始",
+		"data":        gin.H{"roomID": roomID},
+	})
+}
+
+// GetChallengeLeaderboard 每日挑战排行：/challenge/leaderboard?date=2006-01-02&page=1&pageSize=20，date 省略时为当天
+func GetChallengeLeaderboard(c *gin.Context) {
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("pageSize"))
+	leaderboard, err := service.GetChallengeLeaderboard(c.Query("date"), page, pageSize)
+	if errors.Is(err, service.ErrChallengeNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "获取挑战排行失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status_code": http.StatusOK,
+		"message":     "获取成功",
+		"data":        leaderboard,
+	})
+}
@@ -0,0 +1,45 @@
+package controller
+
+import (
+	"errors"
+	"go-game/repository"
+	"go-game/service"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetPlayerHistory 玩家的对局历史：/history/player/:playerID?page=1&pageSize=20
+func GetPlayerHistory(c *gin.Context) {
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("pageSize"))
+	history, err := service.GetPlayerHistory(c.Param("playerID"), page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "获取对局历史失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status_code": http.StatusOK,
+		"message":     "获取成功",
+		"data":        history,
+	})
+}
+
+// GetMatch 单局对局结果
+func GetMatch(c *gin.Context) {
+	match, err := service.GetMatch(c.Param("matchID"))
+	if errors.Is(err, repository.ErrMatchNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "获取对局结果失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status_code": http.StatusOK,
+		"message":     "获取成功",
+		"data":        match,
+	})
+}
@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"encoding/json"
+	"go-game/middleware"
+	"go-game/ws"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetReplayList 已结束的对局列表，最近结束的在前
+func GetReplayList(c *gin.Context) {
+	replays, err := ws.ListReplays()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "获取对局列表失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status_code": http.StatusOK,
+		"message":     "获取成功",
+		"data":        replays,
+	})
+}
+
+// GetReplay 已结束对局的概要
+func GetReplay(c *gin.Context) {
+	summary, _, err := ws.LoadReplay(c.Param("gameID"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status_code": http.StatusOK,
+		"message":     "获取成功",
+		"data":        summary,
+	})
+}
+
+// GetReplayEvents 已结束对局的公开记录（JSONL，一行一条记录）。带 userID 时包含该玩家自己才能看到的事件，
+// 需要以该玩家的身份登录
+func GetReplayEvents(c *gin.Context) {
+	viewerID := c.Query("userID")
+	if viewerID != "" && viewerID != middleware.CurrentUser(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只能以自己的视角回放"})
+		return
+	}
+	records, err := ws.ReplayEvents(c.Param("gameID"), viewerID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(c.Writer)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			log.Println("❌ 发送对局记录失败:", err)
+			return
+		}
+	}
+}
@@ -2,8 +2,10 @@ package controller
 
 import (
 	"go-game/dto"
+	"go-game/middleware"
 	"go-game/service"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -47,24 +49,80 @@ func DeleteRoom(c *gin.Context) {
 	})
 }
 
-func GetRoomList(c *gin.Context) {
-	rooms, err := service.GetRoomList()
+func AddAI(c *gin.Context) {
+	var req dto.AddAIRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少必要字段"})
+		return
+	}
+	req.UserID = middleware.CurrentUser(c)
+	aiID, err := service.AddAI(req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "获取房间列表失败"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status_code": http.StatusOK,
+		"message":     "AI 添加成功",
+		"data":        dto.AIPlayerResponse{AiID: aiID},
+	})
+}
+
+func RemoveAI(c *gin.Context) {
+	var req dto.RemoveAIRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少必要字段"})
 		return
 	}
-	onlinePlayer, err := service.GetOnlinePlayer()
+	req.UserID = middleware.CurrentUser(c)
+	if err := service.RemoveAI(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status_code": http.StatusOK,
+		"message":     "AI 移除成功",
+	})
+}
+
+func ReplaceWithAI(c *gin.Context) {
+	var req dto.ReplaceWithAIRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少必要字段"})
+		return
+	}
+	req.UserID = middleware.CurrentUser(c)
+	aiID, err := service.ReplaceWithAI(req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "获取在线玩家失败"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status_code": http.StatusOK,
+		"message":     "AI 接管成功",
+		"data":        dto.AIPlayerResponse{AiID: aiID},
+	})
+}
+
+// GetRoomList 房间列表：/room/list?status=waiting&freeSeats=1&page=1&pageSize=20，
+// 筛选和分页参数都可省略。大厅连接订阅房间列表后会实时推送变化，不需要轮询
+func GetRoomList(c *gin.Context) {
+	var filter dto.RoomFilter
+	if err := c.ShouldBindQuery(&filter); err != nil || !filter.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "筛选条件无效"})
+		return
+	}
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("pageSize"))
+	list, err := service.GetRoomList(filter, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "获取房间列表失败"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":     "获取成功",
 		"status_code": http.StatusOK,
-		"data": dto.GetRoomList{
-			Rooms:        rooms,
-			OnlinePlayer: onlinePlayer,
-		},
+		"data":        list,
 	})
 }
@@ -0,0 +1,22 @@
+package controller
+
+import (
+	"go-game/ws"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetReplayTimeline 已结束对局每回合的总资产走势
+func GetReplayTimeline(c *gin.Context) {
+	timeline, err := ws.NetWorthTimeline(c.Param("gameID"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status_code": http.StatusOK,
+		"message":     "获取成功",
+		"data":        timeline,
+	})
+}
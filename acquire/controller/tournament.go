@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"errors"
+	"go-game/dto"
+	"go-game/middleware"
+	"go-game/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateTournament 当前用户作为组织者创建锦标赛，第一轮的房间随即创建，玩家通过大厅连接收到 tournament_table
+func CreateTournament(c *gin.Context) {
+	var req dto.CreateTournamentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少必要字段"})
+		return
+	}
+	req.UserID = middleware.CurrentUser(c)
+	tournament, err := service.CreateTournament(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status_code": http.StatusOK,
+		"message":     "锦标赛创建成功",
+		"data":        tournament,
+	})
+}
+
+// GetTournamentList 所有锦标赛
+func GetTournamentList(c *gin.Context) {
+	list, err := service.ListTournaments()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "获取锦标赛列表失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status_code": http.StatusOK,
+		"message":     "获取成功",
+		"data":        list,
+	})
+}
+
+// GetTournament 锦标赛详情：各轮分桌、每桌成绩和当前排名
+func GetTournament(c *gin.Context) {
+	tournament, err := service.GetTournament(c.Param("tournamentID"))
+	if errors.Is(err, service.ErrTournamentNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "获取锦标赛失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status_code": http.StatusOK,
+		"message":     "获取成功",
+		"data":        tournament,
+	})
+}
+
+// GetTournamentStandings 锦标赛当前排名
+func GetTournamentStandings(c *gin.Context) {
+	standings, err := service.GetTournamentStandings(c.Param("tournamentID"))
+	if errors.Is(err, service.ErrTournamentNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "获取锦标赛排名失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status_code": http.StatusOK,
+		"message":     "获取成功",
+		"data":        standings,
+	})
+}
+
+// CancelTournament 组织者取消锦标赛，只有创建者本人可以取消
+func CancelTournament(c *gin.Context) {
+	err := service.CancelTournament(c.Param("tournamentID"), middleware.CurrentUser(c))
+	if errors.Is(err, service.ErrTournamentNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status_code": http.StatusOK,
+		"message":     "锦标赛已取消",
+	})
+}
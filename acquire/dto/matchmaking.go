@@ -0,0 +1,10 @@
+package dto
+
+type JoinQueueRequest struct {
+	UserID       string `json:"userID"` // 由鉴权中间件填入，请求体中的值会被忽略
+	Game         string `json:"game"`   // 可省略，填写时必须与当前服务的游戏一致
+	Players      int    `json:"players" binding:"required"`
+	MinRating    int    `json:"minRating"` // 0 表示不限
+	MaxRating    int    `json:"maxRating"` // 0 表示不限
+	AiDifficulty string `json:"aiDifficulty"`
+}
@@ -3,13 +3,16 @@ package dto
 type RoomPlayer struct {
 	PlayerID string `json:"playerID"`
 	Online   bool   `json:"online"`
+	Rating   int    `json:"rating,omitempty"` // 仅房间列表中返回
 }
 type RoomInfo struct {
-	RoomID     string       `json:"roomID"`
-	UserID     string       `json:"userID"`
-	MaxPlayers int          `json:"maxPlayers"`
-	Status     bool         `json:"status"`
-	RoomPlayer []RoomPlayer `json:"roomPlayer"`
+	RoomID         string       `json:"roomID"`
+	UserID         string       `json:"userID"`
+	MaxPlayers     int          `json:"maxPlayers"`
+	Status         bool         `json:"status"`
+	Private        bool         `json:"private"`
+	RoomPlayer     []RoomPlayer `json:"roomPlayer"`
+	SpectatorCount int          `json:"spectatorCount"`
 }
 
 type PlayerInfo struct {
@@ -17,15 +20,41 @@ type PlayerInfo struct {
 }
 
 type CreateRoomRequest struct {
-	MaxPlayers int    `json:"maxPlayers" binding:"required"`
-	AiCount    int    `json:"aiCount"`
-	UserID     string `json:"userID" binding:"required"`
+	MaxPlayers   int    `json:"maxPlayers" binding:"required"`
+	AiCount      int    `json:"aiCount"`
+	AiDifficulty string `json:"aiDifficulty"`
+	UserID       string `json:"userID" binding:"required"`
+	Private      bool   `json:"private"`
+	Seed         uint64 `json:"-"` // 服务端指定的随机种子（每日挑战），0 表示随机
 }
 
 type DeleteRoomRequest struct {
 	RoomID string `json:"roomID" binding:"required"`
 }
 
+type AddAIRequest struct {
+	RoomID     string `json:"roomID" binding:"required"`
+	UserID     string `json:"userID"` // 操作者，由鉴权中间件填入，请求体中的值会被忽略
+	Difficulty string `json:"difficulty"`
+}
+
+type RemoveAIRequest struct {
+	RoomID string `json:"roomID" binding:"required"`
+	UserID string `json:"userID"` // 同上
+	AiID   string `json:"aiID" binding:"required"`
+}
+
+type ReplaceWithAIRequest struct {
+	RoomID     string `json:"roomID" binding:"required"`
+	UserID     string `json:"userID"` // 同上
+	PlayerID   string `json:"playerID" binding:"required"`
+	Difficulty string `json:"difficulty"`
+}
+
+type AIPlayerResponse struct {
+	AiID string `json:"aiID"`
+}
+
 type CreateRoomResponse struct {
 	Room_id string `json:"room_id" binding:"required"`
 }
@@ -33,6 +62,37 @@ type CreateRoomResponse struct {
 type GetRoomList struct {
 	Rooms        []RoomInfo `json:"rooms"`
 	OnlinePlayer int        `json:"onlinePlayer"`
+	Total        int        `json:"total"`              // 符合筛选条件的房间数
+	Page         int        `json:"page,omitempty"`     // 带分页参数时返回
+	PageSize     int        `json:"pageSize,omitempty"` // 带分页参数时返回
+}
+
+// 房间列表按状态筛选
+const (
+	RoomFilterWaiting = "waiting" // 等待开始
+	RoomFilterPlaying = "playing" // 游戏中
+)
+
+// RoomFilter 房间列表的筛选条件，REST 接口和大厅订阅共用
+type RoomFilter struct {
+	Status    string `json:"status" form:"status"`       // 为空时不限
+	FreeSeats int    `json:"freeSeats" form:"freeSeats"` // 至少剩余几个空位
+}
+
+func (f RoomFilter) Valid() bool {
+	switch f.Status {
+	case "", RoomFilterWaiting, RoomFilterPlaying:
+		return f.FreeSeats >= 0
+	}
+	return false
+}
+
+// Match 房间是否符合筛选条件
+func (f RoomFilter) Match(room RoomInfo) bool {
+	if f.Status == RoomFilterWaiting && room.Status || f.Status == RoomFilterPlaying && !room.Status {
+		return false
+	}
+	return room.MaxPlayers-len(room.RoomPlayer) >= f.FreeSeats
 }
 
 type Tile struct {
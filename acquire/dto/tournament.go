@@ -0,0 +1,11 @@
+package dto
+
+type CreateTournamentRequest struct {
+	Name         string   `json:"name"`
+	UserID       string   `json:"userID"` // 组织者，由鉴权中间件填入，请求体中的值会被忽略
+	Game         string   `json:"game"`   // 可省略，填写时必须与当前服务的游戏一致
+	Participants []string `json:"participants" binding:"required"`
+	Rounds       int      `json:"rounds" binding:"required"`
+	TableSize    int      `json:"tableSize" binding:"required"` // 每桌人数，人数不足的桌用 AI 补满
+	AiDifficulty string   `json:"aiDifficulty"`
+}
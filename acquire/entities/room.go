@@ -19,4 +19,5 @@ type RoomInfo struct {
 	GameStatus dto.RoomStatus `json:"gameStatus"`
 	MaxPlayers int            `json:"maxPlayers"`
 	UserID     string         `json:"userID"`
+	Private    bool           `json:"private"` // 私人房间不允许观战
 }
@@ -0,0 +1,406 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// Match 一局已结束对局的结果
+type Match struct {
+	ID          string        `json:"id"` // 即对局 ID，可用于查询回放
+	Game        string        `json:"game"`
+	RoomID      string        `json:"roomID"`
+	Variant     string        `json:"variant"`
+	StartedAt   int64         `json:"startedAt"` // 毫秒时间戳
+	EndedAt     int64         `json:"endedAt"`
+	DurationSec int64         `json:"durationSec"`
+	Winner      string        `json:"winner"`
+	Rated       bool          `json:"rated"`     // 是否计入等级分
+	Abandoned   bool          `json:"abandoned"` // 无人继续、房间回收时按当时局面结算
+	Players     []MatchPlayer `json:"players"`   // 按座位顺序
+}
+
+// MatchPlayer 对局中一名玩家的结果
+type MatchPlayer struct {
+	PlayerID string          `json:"playerID"`
+	Seat     int             `json:"seat"`
+	Rank     int             `json:"rank"`
+	Score    int             `json:"score"` // Acquire 为总资产，Splendor 为声望分
+	AI       bool            `json:"ai"`
+	Left     bool            `json:"left"`             // 中途离开，排在所有完成对局的玩家之后
+	Detail   json.RawMessage `json:"detail,omitempty"` // 各游戏自己的结算数据
+
+	RatingBefore int `json:"ratingBefore,omitempty"` // 对局前后的等级分，AI 为固定分，不计分的对局为 0
+	RatingAfter  int `json:"ratingAfter,omitempty"`
+}
+
+// Rating 玩家在本游戏中的等级分
+type Rating struct {
+	PlayerID  string `json:"playerID"`
+	Rating    int    `json:"rating"`
+	Games     int    `json:"games"` // 计分对局数
+	Wins      int    `json:"wins"`
+	UpdatedAt int64  `json:"updatedAt"` // 毫秒时间戳
+}
+
+// RatingFunc 根据对局结果和玩家当前的等级分（没有记录的玩家不在 current 中）计算新的等级分，
+// 同时填写 match 中各玩家的 RatingBefore、RatingAfter
+type RatingFunc func(match *Match, current map[string]Rating) []Rating
+
+// MatchStore 对局历史的持久化存储
+type MatchStore interface {
+	// SaveMatch 保存对局结果，match.Rated 时在同一事务中用 rate 更新等级分。同一 ID 重复保存时忽略
+	SaveMatch(ctx context.Context, match *Match, rate RatingFunc) error
+	// GetMatch 按 ID 查询对局，不存在时返回 ErrMatchNotFound
+	GetMatch(ctx context.Context, id string) (*Match, error)
+	// PlayerMatches 玩家参与过的对局，最近结束的在前，同时返回总数
+	PlayerMatches(ctx context.Context, playerID string, offset, limit int) ([]Match, int, error)
+	// Ratings 查询多名玩家的等级分，没有记录的玩家不在结果中
+	Ratings(ctx context.Context, playerIDs []string) (map[string]Rating, error)
+	// Leaderboard 等级分排行，同时返回总人数
+	Leaderboard(ctx context.Context, offset, limit int) ([]Rating, int, error)
+	Close() error
+}
+
+var ErrMatchNotFound = errors.New("对局不存在")
+
+// Matches 对局历史存储，由 InitMatchStore 初始化
+var Matches MatchStore
+
+// InitMatchStore 按 MATCH_STORE 选择对局历史存储：
+//
+//	redis（默认）  保存在 repository.Rdb 中，多实例部署时所有实例共享
+//	sqlite         保存在 MATCH_DB_PATH 指定的本地文件中，只适用于单实例部署：
+//	               每个容器各有一份文件，其他实例既读不到也不会写入，房间被其他实例接管后结果会分散在多个文件中
+//
+// 需要在 InitRedis 之后调用
+func InitMatchStore() {
+	switch store := os.Getenv("MATCH_STORE"); store {
+	case "", "redis":
+		Matches = NewRedisMatchStore(Rdb)
+		log.Println("✅ 对局历史使用 Redis 存储")
+	case "sqlite":
+		dbPath := os.Getenv("MATCH_DB_PATH")
+		if dbPath == "" {
+			dbPath = "./data/matches.db"
+		}
+		store, err := NewSQLiteMatchStore(dbPath)
+		if err != nil {
+			log.Fatalf("对局历史数据库打开失败: %v", err)
+		}
+		Matches = store
+		log.Println("✅ 对局历史数据库已打开（仅限单实例部署）:", dbPath)
+	default:
+		log.Fatalf("未知的对局历史存储: %s", store)
+	}
+}
+
+// 表结构迁移，按顺序执行，已执行到第几条记录在 PRAGMA user_version 中
+var matchMigrations = []string{
+	`CREATE TABLE matches (
+		id           TEXT PRIMARY KEY,
+		game         TEXT NOT NULL,
+		room_id      TEXT NOT NULL,
+		variant      TEXT NOT NULL,
+		started_at   INTEGER NOT NULL,
+		ended_at     INTEGER NOT NULL,
+		duration_sec INTEGER NOT NULL,
+		winner       TEXT NOT NULL
+	);
+	CREATE TABLE match_players (
+		match_id  TEXT NOT NULL REFERENCES matches(id) ON DELETE CASCADE,
+		player_id TEXT NOT NULL,
+		seat      INTEGER NOT NULL,
+		rank      INTEGER NOT NULL,
+		score     INTEGER NOT NULL,
+		ai        INTEGER NOT NULL,
+		left_game INTEGER NOT NULL,
+		detail    TEXT,
+		PRIMARY KEY (match_id, player_id)
+	);
+	CREATE INDEX idx_match_players_player ON match_players(player_id);
+	CREATE INDEX idx_matches_ended_at ON matches(ended_at);`,
+
+	`ALTER TABLE matches ADD COLUMN rated INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE match_players ADD COLUMN rating_before INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE match_players ADD COLUMN rating_after INTEGER NOT NULL DEFAULT 0;
+	CREATE TABLE ratings (
+		player_id  TEXT PRIMARY KEY,
+		rating     INTEGER NOT NULL,
+		games      INTEGER NOT NULL,
+		wins       INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+	CREATE INDEX idx_ratings_rating ON ratings(rating);`,
+
+	`ALTER TABLE matches ADD COLUMN abandoned INTEGER NOT NULL DEFAULT 0;`,
+}
+
+// 执行尚未执行的迁移
+func migrateMatchDB(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("读取表结构版本失败: %w", err)
+	}
+	for ; version < len(matchMigrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("开启事务失败: %w", err)
+		}
+		if _, err := tx.Exec(matchMigrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("执行第 %d 条迁移失败: %w", version+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("更新表结构版本失败: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("提交迁移失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// SQLite 实现，只适用于单实例部署（见 InitMatchStore）
+type sqliteMatchStore struct {
+	db *sql.DB
+}
+
+var _ MatchStore = (*sqliteMatchStore)(nil)
+
+// NewSQLiteMatchStore 打开（或创建）SQLite 数据库文件并建表
+func NewSQLiteMatchStore(dbPath string) (MatchStore, error) {
+	if err := os.MkdirAll(path.Dir(dbPath), 0755); err != nil {
+		return nil, fmt.Errorf("创建数据库目录失败: %w", err)
+	}
+	db, err := sql.Open("sqlite", dbPath+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)")
+	if err != nil {
+		return nil, fmt.Errorf("打开数据库失败: %w", err)
+	}
+	// SQLite 同一时刻只有一个写者，单连接避免 database is locked
+	db.SetMaxOpenConns(1)
+	if err := migrateMatchDB(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("初始化表结构失败: %w", err)
+	}
+	return &sqliteMatchStore{db: db}, nil
+}
+
+func (s *sqliteMatchStore) SaveMatch(ctx context.Context, match *Match, rate RatingFunc) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("开启事务失败: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO matches
+		(id, game, room_id, variant, started_at, ended_at, duration_sec, winner, rated, abandoned)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		match.ID, match.Game, match.RoomID, match.Variant, match.StartedAt, match.EndedAt, match.DurationSec, match.Winner, match.Rated, match.Abandoned)
+	if err != nil {
+		return fmt.Errorf("写入对局失败: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// 已经保存过
+		return nil
+	}
+	if match.Rated && rate != nil {
+		if err := updateRatings(ctx, tx, match, rate); err != nil {
+			return err
+		}
+	}
+	for _, p := range match.Players {
+		var detail interface{}
+		if len(p.Detail) > 0 {
+			detail = string(p.Detail)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO match_players
+			(match_id, player_id, seat, rank, score, ai, left_game, detail, rating_before, rating_after)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			match.ID, p.PlayerID, p.Seat, p.Rank, p.Score, p.AI, p.Left, detail, p.RatingBefore, p.RatingAfter); err != nil {
+			return fmt.Errorf("写入对局玩家失败: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("提交事务失败: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteMatchStore) GetMatch(ctx context.Context, id string) (*Match, error) {
+	var m Match
+	err := s.db.QueryRowContext(ctx, `SELECT id, game, room_id, variant, started_at, ended_at, duration_sec, winner, rated, abandoned
+		FROM matches WHERE id = ?`, id).
+		Scan(&m.ID, &m.Game, &m.RoomID, &m.Variant, &m.StartedAt, &m.EndedAt, &m.DurationSec, &m.Winner, &m.Rated, &m.Abandoned)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMatchNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询对局失败: %w", err)
+	}
+	matches := []Match{m}
+	if err := s.loadPlayers(ctx, matches); err != nil {
+		return nil, err
+	}
+	return &matches[0], nil
+}
+
+func (s *sqliteMatchStore) PlayerMatches(ctx context.Context, playerID string, offset, limit int) ([]Match, int, error) {
+	var total int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM match_players WHERE player_id = ?`, playerID).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("查询对局数失败: %w", err)
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT m.id, m.game, m.room_id, m.variant, m.started_at, m.ended_at, m.duration_sec, m.winner, m.rated, m.abandoned
+		FROM matches m JOIN match_players p ON p.match_id = m.id
+		WHERE p.player_id = ?
+		ORDER BY m.ended_at DESC, m.id DESC
+		LIMIT ? OFFSET ?`, playerID, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("查询对局失败: %w", err)
+	}
+	matches := make([]Match, 0, limit)
+	for rows.Next() {
+		var m Match
+		if err := rows.Scan(&m.ID, &m.Game, &m.RoomID, &m.Variant, &m.StartedAt, &m.EndedAt, &m.DurationSec, &m.Winner, &m.Rated, &m.Abandoned); err != nil {
+			rows.Close()
+			return nil, 0, fmt.Errorf("读取对局失败: %w", err)
+		}
+		matches = append(matches, m)
+	}
+	// 只有一个连接，查询玩家前先释放
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, 0, fmt.Errorf("读取对局失败: %w", err)
+	}
+	if err := s.loadPlayers(ctx, matches); err != nil {
+		return nil, 0, err
+	}
+	return matches, total, nil
+}
+
+// 填充各局的玩家结果
+func (s *sqliteMatchStore) loadPlayers(ctx context.Context, matches []Match) error {
+	for i := range matches {
+		rows, err := s.db.QueryContext(ctx, `SELECT player_id, seat, rank, score, ai, left_game, detail, rating_before, rating_after
+			FROM match_players WHERE match_id = ? ORDER BY seat`, matches[i].ID)
+		if err != nil {
+			return fmt.Errorf("查询对局玩家失败: %w", err)
+		}
+		matches[i].Players = make([]MatchPlayer, 0)
+		for rows.Next() {
+			var p MatchPlayer
+			var detail sql.NullString
+			if err := rows.Scan(&p.PlayerID, &p.Seat, &p.Rank, &p.Score, &p.AI, &p.Left, &detail, &p.RatingBefore, &p.RatingAfter); err != nil {
+				rows.Close()
+				return fmt.Errorf("读取对局玩家失败: %w", err)
+			}
+			if detail.Valid {
+				p.Detail = json.RawMessage(detail.String)
+			}
+			matches[i].Players = append(matches[i].Players, p)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("读取对局玩家失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// 在保存对局的事务中读取当前等级分，计算并写入新的等级分
+func updateRatings(ctx context.Context, tx *sql.Tx, match *Match, rate RatingFunc) error {
+	current := make(map[string]Rating, len(match.Players))
+	for _, p := range match.Players {
+		var r Rating
+		err := tx.QueryRowContext(ctx, `SELECT player_id, rating, games, wins, updated_at FROM ratings WHERE player_id = ?`, p.PlayerID).
+			Scan(&r.PlayerID, &r.Rating, &r.Games, &r.Wins, &r.UpdatedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("查询等级分失败: %w", err)
+		}
+		current[p.PlayerID] = r
+	}
+	for _, r := range rate(match, current) {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO ratings (player_id, rating, games, wins, updated_at)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(player_id) DO UPDATE SET
+				rating = excluded.rating, games = excluded.games, wins = excluded.wins, updated_at = excluded.updated_at`,
+			r.PlayerID, r.Rating, r.Games, r.Wins, r.UpdatedAt); err != nil {
+			return fmt.Errorf("写入等级分失败: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *sqliteMatchStore) Ratings(ctx context.Context, playerIDs []string) (map[string]Rating, error) {
+	ratings := make(map[string]Rating, len(playerIDs))
+	if len(playerIDs) == 0 {
+		return ratings, nil
+	}
+	args := make([]interface{}, len(playerIDs))
+	for i, id := range playerIDs {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(playerIDs)), ",")
+	rows, err := s.db.QueryContext(ctx, `SELECT player_id, rating, games, wins, updated_at
+		FROM ratings WHERE player_id IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询等级分失败: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r Rating
+		if err := rows.Scan(&r.PlayerID, &r.Rating, &r.Games, &r.Wins, &r.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("读取等级分失败: %w", err)
+		}
+		ratings[r.PlayerID] = r
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("读取等级分失败: %w", err)
+	}
+	return ratings, nil
+}
+
+func (s *sqliteMatchStore) Leaderboard(ctx context.Context, offset, limit int) ([]Rating, int, error) {
+	var total int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM ratings`).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("查询排行人数失败: %w", err)
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT player_id, rating, games, wins, updated_at
+		FROM ratings ORDER BY rating DESC, games DESC, player_id
+		LIMIT ? OFFSET ?`, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("查询等级分排行失败: %w", err)
+	}
+	defer rows.Close()
+	ratings := make([]Rating, 0, limit)
+	for rows.Next() {
+		var r Rating
+		if err := rows.Scan(&r.PlayerID, &r.Rating, &r.Games, &r.Wins, &r.UpdatedAt); err != nil {
+			return nil, 0, fmt.Errorf("读取等级分失败: %w", err)
+		}
+		ratings = append(ratings, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("读取等级分失败: %w", err)
+	}
+	return ratings, total, nil
+}
+
+func (s *sqliteMatchStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,211 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Redis 实现：所有实例共享同一个 Redis，任何实例写入的对局和等级分其他实例都能读到。
+//
+//	match:<id>                   对局结果（JSON）
+//	match:player:<玩家>          玩家参与的对局，ZSET，分数为结束时间
+//	match:ratings                等级分，Hash，玩家 -> JSON
+//	match:leaderboard            等级分排行，ZSET，分数见 leaderboardScore
+//
+// 保存对局时 WATCH 对局和等级分，在一个 MULTI/EXEC 中写入对局、玩家索引和新的等级分
+
+// 并发保存对局冲突时的重试次数
+const saveMatchRetries = 5
+
+const (
+	matchRatingsKey     = "match:ratings"
+	matchLeaderboardKey = "match:leaderboard"
+)
+
+func matchKey(id string) string {
+	return fmt.Sprintf("match:%s", id)
+}
+
+func playerMatchesKey(playerID string) string {
+	return fmt.Sprintf("match:player:%s", playerID)
+}
+
+// 排行按等级分、计分对局数从高到低，再按玩家 ID 排序：分数取负后按从小到大排列，同分时 ZSET 按成员排序
+func leaderboardScore(r Rating) float64 {
+	return -float64(int64(r.Rating)*1_000_000 + int64(r.Games))
+}
+
+type redisMatchStore struct {
+	rdb *redis.Client
+}
+
+var _ MatchStore = (*redisMatchStore)(nil)
+
+// NewRedisMatchStore 使用 Redis 保存对局历史
+func NewRedisMatchStore(rdb *redis.Client) MatchStore {
+	return &redisMatchStore{rdb: rdb}
+}
+
+func (s *redisMatchStore) SaveMatch(ctx context.Context, match *Match, rate RatingFunc) error {
+	key := matchKey(match.ID)
+	txf := func(tx *redis.Tx) error {
+		n, err := tx.Exists(ctx, key).Result()
+		if err != nil {
+			return fmt.Errorf("查询对局失败: %w", err)
+		}
+		if n > 0 {
+			// 已经保存过
+			return nil
+		}
+		var ratings []Rating
+		if match.Rated && rate != nil {
+			playerIDs := make([]string, 0, len(match.Players))
+			for _, p := range match.Players {
+				playerIDs = append(playerIDs, p.PlayerID)
+			}
+			current, err := readRatings(ctx, tx, playerIDs)
+			if err != nil {
+				return err
+			}
+			ratings = rate(match, current)
+		}
+		data, err := json.Marshal(match)
+		if err != nil {
+			return fmt.Errorf("编码对局失败: %w", err)
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, data, 0)
+			for _, p := range match.Players {
+				pipe.ZAdd(ctx, playerMatchesKey(p.PlayerID), &redis.Z{Score: float64(match.EndedAt), Member: match.ID})
+			}
+			for _, r := range ratings {
+				rating, err := json.Marshal(r)
+				if err != nil {
+					return fmt.Errorf("编码等级分失败: %w", err)
+				}
+				pipe.HSet(ctx, matchRatingsKey, r.PlayerID, rating)
+				pipe.ZAdd(ctx, matchLeaderboardKey, &redis.Z{Score: leaderboardScore(r), Member: r.PlayerID})
+			}
+			return nil
+		})
+		return err
+	}
+	for i := 0; i < saveMatchRetries; i++ {
+		err := s.rdb.Watch(ctx, txf, key, matchRatingsKey)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("保存对局失败: %w", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("保存对局[%s]失败: 并发写入过多", match.ID)
+}
+
+func (s *redisMatchStore) GetMatch(ctx context.Context, id string) (*Match, error) {
+	data, err := s.rdb.Get(ctx, matchKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrMatchNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询对局失败: %w", err)
+	}
+	var m Match
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("解析对局失败: %w", err)
+	}
+	return &m, nil
+}
+
+func (s *redisMatchStore) PlayerMatches(ctx context.Context, playerID string, offset, limit int) ([]Match, int, error) {
+	key := playerMatchesKey(playerID)
+	pipe := s.rdb.Pipeline()
+	totalCmd := pipe.ZCard(ctx, key)
+	// 同一时间结束的对局按 ID 从大到小
+	idsCmd := pipe.ZRevRange(ctx, key, int64(offset), int64(offset+limit-1))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, 0, fmt.Errorf("查询对局失败: %w", err)
+	}
+	ids := idsCmd.Val()
+	matches := make([]Match, 0, len(ids))
+	if len(ids) == 0 {
+		return matches, int(totalCmd.Val()), nil
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = matchKey(id)
+	}
+	values, err := s.rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, 0, fmt.Errorf("读取对局失败: %w", err)
+	}
+	for i, v := range values {
+		data, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var m Match
+		if err := json.Unmarshal([]byte(data), &m); err != nil {
+			return nil, 0, fmt.Errorf("解析对局[%s]失败: %w", ids[i], err)
+		}
+		matches = append(matches, m)
+	}
+	return matches, int(totalCmd.Val()), nil
+}
+
+// 读取多名玩家的等级分，没有记录的玩家不在结果中
+func readRatings(ctx context.Context, rdb redis.Cmdable, playerIDs []string) (map[string]Rating, error) {
+	ratings := make(map[string]Rating, len(playerIDs))
+	if len(playerIDs) == 0 {
+		return ratings, nil
+	}
+	values, err := rdb.HMGet(ctx, matchRatingsKey, playerIDs...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("查询等级分失败: %w", err)
+	}
+	for i, v := range values {
+		data, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var r Rating
+		if err := json.Unmarshal([]byte(data), &r); err != nil {
+			return nil, fmt.Errorf("解析玩家[%s]的等级分失败: %w", playerIDs[i], err)
+		}
+		ratings[r.PlayerID] = r
+	}
+	return ratings, nil
+}
+
+func (s *redisMatchStore) Ratings(ctx context.Context, playerIDs []string) (map[string]Rating, error) {
+	return readRatings(ctx, s.rdb, playerIDs)
+}
+
+func (s *redisMatchStore) Leaderboard(ctx context.Context, offset, limit int) ([]Rating, int, error) {
+	pipe := s.rdb.Pipeline()
+	totalCmd := pipe.ZCard(ctx, matchLeaderboardKey)
+	idsCmd := pipe.ZRange(ctx, matchLeaderboardKey, int64(offset), int64(offset+limit-1))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, 0, fmt.Errorf("查询等级分排行失败: %w", err)
+	}
+	ratings := make([]Rating, 0, len(idsCmd.Val()))
+	current, err := readRatings(ctx, s.rdb, idsCmd.Val())
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, id := range idsCmd.Val() {
+		if r, ok := current[id]; ok {
+			ratings = append(ratings, r)
+		}
+	}
+	return ratings, int(totalCmd.Val()), nil
+}
+
+// Redis 连接由 repository.Rdb 管理，这里不需要关闭
+func (s *redisMatchStore) Close() error {
+	return nil
+}
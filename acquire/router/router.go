@@ -1,7 +1,9 @@
 package router
 
 import (
+	"expvar"
 	"go-game/controller"
+	"go-game/middleware"
 	"go-game/ws"
 
 	"github.com/gin-gonic/gin"
@@ -13,10 +15,74 @@ func InitRouter(r *gin.Engine) {
 	{
 		api.POST("/create", controller.CreateRoom)
 		api.POST("/delete", controller.DeleteRoom)
+		// 只有房主能调整 AI 座位，操作者取自登录用户
+		api.POST("/ai/add", middleware.AuthMiddleware(), controller.AddAI)
+		api.POST("/ai/remove", middleware.AuthMiddleware(), controller.RemoveAI)
+		api.POST("/ai/replace", middleware.AuthMiddleware(), controller.ReplaceWithAI)
 
 		api.GET("/list", controller.GetRoomList)
 	}
 
+	// 匹配
+	matchmaking := r.Group("/matchmaking")
+	{
+		matchmaking.POST("/join", middleware.AuthMiddleware(), controller.JoinQueue)
+		matchmaking.POST("/leave", middleware.AuthMiddleware(), controller.LeaveQueue)
+		matchmaking.GET("/status", middleware.AuthMiddleware(), controller.GetQueueStatus)
+	}
+
+	// 锦标赛
+	tournament := r.Group("/tournament")
+	{
+		tournament.POST("/create", middleware.AuthMiddleware(), controller.CreateTournament)
+		tournament.GET("/list", controller.GetTournamentList)
+		tournament.GET("/:tournamentID", controller.GetTournament)
+		tournament.GET("/:tournamentID/standings", controller.GetTournamentStandings)
+		tournament.POST("/:tournamentID/cancel", middleware.AuthMiddleware(), controller.CancelTournament)
+	}
+
+	// 每日挑战
+	challenge := r.Group("/challenge")
+	{
+		challenge.GET("/today", middleware.OptionalAuthMiddleware(), controller.GetTodayChallenge)
+		challenge.POST("/start", middleware.AuthMiddleware(), controller.StartChallenge)
+		challenge.GET("/leaderboard", controller.GetChallengeLeaderboard)
+	}
+
+	// 对局历史
+	history := r.Group("/history")
+	{
+		history.GET("/player/:playerID", controller.GetPlayerHistory)
+		history.GET("/match/:matchID", controller.GetMatch)
+	}
+
+	// 玩家统计
+	r.GET("/stats/:userID", controller.GetPlayerStats)
+
+	// 等级分
+	rating := r.Group("/rating")
+	{
+		rating.GET("/leaderboard", controller.GetLeaderboard)
+		rating.GET("/player/:playerID", controller.GetPlayerRating)
+	}
+
+	// 对局回放
+	replay := r.Group("/replay")
+	{
+		replay.GET("/list", controller.GetReplayList)
+		replay.GET("/game/:gameID", controller.GetReplay)
+		replay.GET("/game/:gameID/events", middleware.OptionalAuthMiddleware(), controller.GetReplayEvents)
+		replay.GET("/game/:gameID/timeline", controller.GetReplayTimeline)
+		replay.GET("/ws", middleware.OptionalAuthMiddleware(), ws.HandleReplayWebSocket)
+	}
+
 	// WebSocket 路由
-	r.GET("/ws", ws.HandleWebSocket)
+	r.GET("/ws", middleware.AuthMiddleware(), ws.HandleWebSocket)
+	// 大厅 WebSocket，推送匹配结果
+	r.GET("/lobby/ws", middleware.AuthMiddleware(), ws.HandleLobbyWebSocket)
+	// WebSocket 消息的 JSON Schema
+	r.GET("/protocol/schema", controller.GetProtocolSchema)
+
+	// 运行指标（房间回收统计等）
+	r.GET("/debug/vars", gin.WrapH(expvar.Handler()))
 }
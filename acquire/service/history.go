@@ -0,0 +1,47 @@
+package service
+
+import (
+	"go-game/repository"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// 规范化分页参数：page 从 1 开始
+func normalizePage(page, pageSize int) (int, int) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	return page, pageSize
+}
+
+// HistoryPage 一页对局历史
+type HistoryPage struct {
+	Matches  []repository.Match `json:"matches"`
+	Total    int                `json:"total"`
+	Page     int                `json:"page"`
+	PageSize int                `json:"pageSize"`
+}
+
+// GetPlayerHistory 玩家的对局历史，最近结束的在前
+func GetPlayerHistory(playerID string, page, pageSize int) (*HistoryPage, error) {
+	page, pageSize = normalizePage(page, pageSize)
+	matches, total, err := repository.Matches.PlayerMatches(repository.Ctx, playerID, (page-1)*pageSize, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	return &HistoryPage{Matches: matches, Total: total, Page: page, PageSize: pageSize}, nil
+}
+
+// GetMatch 单局对局结果
+func GetMatch(matchID string) (*repository.Match, error) {
+	return repository.Matches.GetMatch(repository.Ctx, matchID)
+}
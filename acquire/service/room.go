@@ -27,10 +27,16 @@ func CreateRoom(params dto.CreateRoomRequest) (string, error) {
 		GameStatus: dto.RoomStatusSetTile,
 		RoomStatus: false,
 		UserID:     params.UserID,
+		Private:    params.Private,
 	})
 	if err != nil {
 		return "", fmt.Errorf("初始化房间信息失败: %w", err)
 	}
+	if params.Seed != 0 {
+		if err := ws.SetGameSeed(roomID, params.Seed); err != nil {
+			return "", err
+		}
+	}
 
 	companyData := map[string]map[string]interface{}{
 		"Sackson": {
@@ -107,10 +113,17 @@ func CreateRoom(params dto.CreateRoomRequest) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("tile 初始化 Redis 写入失败: %w", err)
 	}
-	ws.Rooms[roomID] = []dto.PlayerConn{}
+	if err := ws.OpenRoom(roomID); err != nil {
+		return "", fmt.Errorf("启动房间失败: %w", err)
+	}
 
-	for i := 1; i <= params.AiCount; i++ {
-		ws.JoinRoomAsAI(roomID, fmt.Sprintf("ai_%03d", i))
+	err = ws.RunInRoom(roomID, func() {
+		for i := 1; i <= params.AiCount; i++ {
+			ws.JoinRoomAsAIWithDifficulty(roomID, fmt.Sprintf("ai_%03d", i), params.AiDifficulty)
+		}
+	})
+	if err != nil {
+		return "", fmt.Errorf("AI 加入房间失败: %w", err)
 	}
 
 	// if params.AiCount > 0 {
@@ -129,78 +142,52 @@ func CreateRoom(params dto.CreateRoomRequest) (string, error) {
 	return roomID, nil
 }
 
-func DeleteRoom(params dto.DeleteRoomRequest) error {
-	ctx := repository.Ctx
-	rdb := repository.Rdb
-
-	// 用 SCAN 查找所有以 room:{RoomID}: 开头的 key
-	prefix := fmt.Sprintf("room:%s:", params.RoomID)
-	var cursor uint64
-	var keysToDelete []string
-
-	for {
-		keys, cur, err := rdb.Scan(ctx, cursor, prefix+"*", 100).Result()
-		if err != nil {
-			return fmt.Errorf("扫描房间相关 key 失败: %w", err)
-		}
-		keysToDelete = append(keysToDelete, keys...)
-		cursor = cur
-		if cursor == 0 {
-			break
-		}
+func AddAI(params dto.AddAIRequest) (string, error) {
+	var aiID string
+	if err := ws.CallRoom(params.RoomID, "add_ai", params, &aiID); err != nil {
+		return "", err
 	}
+	return aiID, nil
+}
 
-	if len(keysToDelete) == 0 {
-		return fmt.Errorf("房间不存在或无相关数据")
-	}
+func RemoveAI(params dto.RemoveAIRequest) error {
+	return ws.CallRoom(params.RoomID, "remove_ai", params, nil)
+}
 
-	// 批量删除这些 key
-	if _, err := rdb.Del(ctx, keysToDelete...).Result(); err != nil {
-		return fmt.Errorf("删除房间相关 key 失败: %w", err)
+func ReplaceWithAI(params dto.ReplaceWithAIRequest) (string, error) {
+	var aiID string
+	if err := ws.CallRoom(params.RoomID, "replace_with_ai", params, &aiID); err != nil {
+		return "", err
 	}
-	delete(ws.Rooms, params.RoomID)
-
-	return nil
+	return aiID, nil
 }
 
-func GetRoomList() ([]dto.RoomInfo, error) {
-	rdb := repository.Rdb
-	var rooms []dto.RoomInfo
-	for roomID, roomConnInfo := range ws.Rooms {
-		roomPlayers := make([]dto.RoomPlayer, 0, len(roomConnInfo))
-		for _, player := range roomConnInfo {
-			roomPlayers = append(roomPlayers, dto.RoomPlayer{
-				PlayerID: player.PlayerID,
-				Online:   player.Online,
-			})
-		}
-
-		roomInfo, err := ws.GetRoomInfo(rdb, roomID)
-		if err != nil {
-			delete(ws.Rooms, roomID)
-			continue
-		}
-		room := dto.RoomInfo{
-			RoomID:     roomID,
-			UserID:     roomInfo.UserID,
-			MaxPlayers: roomInfo.MaxPlayers,
-			Status:     roomInfo.RoomStatus,
-			RoomPlayer: roomPlayers,
-		}
-		rooms = append(rooms, room)
+func DeleteRoom(params dto.DeleteRoomRequest) error {
+	if err := ws.DeleteRoomKeys(params.RoomID); err != nil {
+		return err
 	}
+	ws.CloseRoom(params.RoomID)
+	ws.RemoveSpectators(params.RoomID)
 
-	return rooms, nil
+	return nil
 }
 
-func GetOnlinePlayer() (int, error) {
-	onlinePlayer := 0
-	for _, room := range ws.Rooms {
-		for _, player := range room {
-			if player.Online {
-				onlinePlayer++
-			}
-		}
+// GetRoomList 符合筛选条件的房间列表。page、pageSize 都不传时返回全部房间
+func GetRoomList(filter dto.RoomFilter, page, pageSize int) (*dto.GetRoomList, error) {
+	rooms, err := ws.RoomList(filter)
+	if err != nil {
+		return nil, err
+	}
+	onlinePlayer, err := ws.OnlinePlayerCount()
+	if err != nil {
+		return nil, err
+	}
+	list := &dto.GetRoomList{Rooms: rooms, OnlinePlayer: onlinePlayer, Total: len(rooms)}
+	if page > 0 || pageSize > 0 {
+		list.Page, list.PageSize = normalizePage(page, pageSize)
+		start := min((list.Page-1)*list.PageSize, len(rooms))
+		end := min(start+list.PageSize, len(rooms))
+		list.Rooms = rooms[start:end]
 	}
-	return onlinePlayer, nil
+	return list, nil
 }
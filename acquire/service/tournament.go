@@ -0,0 +1,589 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-game/dto"
+	"go-game/repository"
+	"go-game/ws"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 锦标赛：组织者指定参赛玩家、轮数和每桌人数。每轮为每桌创建房间，只允许分到该桌的玩家入座，空位由 AI 补满；
+// 每桌对局结束时记录成绩，一轮全部结束后按积分重新分桌开始下一轮。超时没有打完的桌按当时局面结算，
+// 没有开局的桌（房间被回收或超时）记为全员弃权，保证锦标赛总能推进。
+// 锦标赛保存在 Redis 的 tournament:<id>，对局可能在任意实例上结束，更新时用 WATCH 保证一轮只推进一次
+const (
+	TournamentRunning   = "running"
+	TournamentFinished  = "finished"
+	TournamentCancelled = "cancelled"
+
+	tournamentIDsKey        = "tournament:ids"
+	tournamentRoomsKey      = "tournament:rooms" // roomID -> 锦标赛 ID
+	maxTournamentRounds     = 10
+	tournamentUpdateRetries = 10
+	tournamentCheckInterval = time.Minute
+)
+
+// 每桌从开桌起最多进行多久
+var tableTimeout = func() time.Duration {
+	value := os.Getenv("TOURNAMENT_TABLE_TIMEOUT")
+	if value == "" {
+		return 3 * time.Hour
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		log.Printf("⚠️ TOURNAMENT_TABLE_TIMEOUT 格式错误: %s，使用默认值 3h\n", value)
+		return 3 * time.Hour
+	}
+	return timeout
+}()
+
+var ErrTournamentNotFound = errors.New("锦标赛不存在")
+
+// 更新函数返回它表示不需要写回
+var errTournamentUnchanged = errors.New("锦标赛未变化")
+
+func tournamentKey(id string) string {
+	return fmt.Sprintf("tournament:%s", id)
+}
+
+// Tournament 一场锦标赛，Tables 包含所有已开始轮次的桌
+type Tournament struct {
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	Organizer    string            `json:"organizer"`
+	Game         string            `json:"game"`
+	Participants []string          `json:"participants"`
+	Rounds       int               `json:"rounds"`
+	TableSize    int               `json:"tableSize"`
+	AiDifficulty string            `json:"aiDifficulty,omitempty"`
+	Status       string            `json:"status"`
+	Round        int               `json:"round"` // 当前轮次，从 1 开始
+	Tables       []TournamentTable `json:"tables"`
+	CreatedAt    int64             `json:"createdAt"`
+}
+
+// TournamentTable 一轮中的一桌
+type TournamentTable struct {
+	Round    int           `json:"round"`
+	Table    int           `json:"table"` // 本轮第几桌，从 1 开始
+	RoomID   string        `json:"roomID,omitempty"`
+	Players  []string      `json:"players"`
+	Bots     int           `json:"bots"`
+	OpenedAt int64         `json:"openedAt,omitempty"` // 开桌时间（毫秒）
+	MatchID  string        `json:"matchID,omitempty"`
+	Finished bool          `json:"finished"`
+	Results  []TableResult `json:"results,omitempty"`
+}
+
+// TableResult 玩家在一桌中的成绩。积分为本桌人数减名次，中途离开的玩家不得分
+type TableResult struct {
+	PlayerID string `json:"playerID"`
+	Rank     int    `json:"rank"`
+	Score    int    `json:"score"` // Acquire 为总资产，Splendor 为声望分
+	Points   int    `json:"points"`
+	Left     bool   `json:"left,omitempty"`
+	Forfeit  bool   `json:"forfeit,omitempty"` // 本桌没有开局
+}
+
+// TournamentStanding 锦标赛排名：先比积分，再比各局分数之和，最后比获胜局数
+type TournamentStanding struct {
+	Rank     int    `json:"rank"`
+	PlayerID string `json:"playerID"`
+	Points   int    `json:"points"`
+	Score    int    `json:"score"`
+	Wins     int    `json:"wins"`
+	Games    int    `json:"games"`
+}
+
+// TournamentDetail 锦标赛及当前排名
+type TournamentDetail struct {
+	*Tournament
+	Standings []TournamentStanding `json:"standings"`
+}
+
+// TournamentSummary 锦标赛列表中的一项
+type TournamentSummary struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Organizer    string `json:"organizer"`
+	Status       string `json:"status"`
+	Round        int    `json:"round"`
+	Rounds       int    `json:"rounds"`
+	Participants int    `json:"participants"`
+	CreatedAt    int64  `json:"createdAt"`
+}
+
+// StartTournaments 对局结束时记录锦标赛成绩，并定期结算超时的桌，启动时调用
+func StartTournaments() {
+	ws.OnMatchEnded(recordTournamentResult)
+	ws.OnRoomDiscarded(forfeitTournamentRoom)
+	go func() {
+		ticker := time.NewTicker(tournamentCheckInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			closeExpiredTables()
+		}
+	}()
+}
+
+// CreateTournament 创建锦标赛并开始第一轮，第一轮随机分桌
+func CreateTournament(params dto.CreateTournamentRequest) (*TournamentDetail, error) {
+	if params.Game != "" && params.Game != ws.GameName {
+		return nil, fmt.Errorf("不支持的游戏类型: %s", params.Game)
+	}
+	if params.TableSize < ws.MinPlayers || params.TableSize > ws.MaxPlayers {
+		return nil, fmt.Errorf("每桌人数必须在 %d 到 %d 之间", ws.MinPlayers, ws.MaxPlayers)
+	}
+	if params.Rounds < 1 || params.Rounds > maxTournamentRounds {
+		return nil, fmt.Errorf("轮数必须在 1 到 %d 之间", maxTournamentRounds)
+	}
+	participants := make([]string, 0, len(params.Participants))
+	seen := make(map[string]bool)
+	for _, id := range params.Participants {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		if ws.IsAIPlayer(id) {
+			return nil, fmt.Errorf("参赛玩家不能是 AI: %s", id)
+		}
+		seen[id] = true
+		participants = append(participants, id)
+	}
+	if len(participants) < 2 {
+		return nil, fmt.Errorf("至少需要 2 名参赛玩家")
+	}
+
+	now := time.Now()
+	t := &Tournament{
+		ID:           fmt.Sprintf("%s_%s", now.Format("0102_150405"), RandString(4)),
+		Name:         params.Name,
+		Organizer:    params.UserID,
+		Game:         ws.GameName,
+		Participants: participants,
+		Rounds:       params.Rounds,
+		TableSize:    params.TableSize,
+		AiDifficulty: params.AiDifficulty,
+		Status:       TournamentRunning,
+		Round:        1,
+		CreatedAt:    now.UnixMilli(),
+	}
+	order := append([]string(nil), participants...)
+	rand.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+	t.Tables = seatRound(t, 1, order)
+
+	data, err := json.Marshal(t)
+	if err != nil {
+		return nil, fmt.Errorf("编码锦标赛失败: %w", err)
+	}
+	pipe := repository.Rdb.TxPipeline()
+	pipe.Set(repository.Ctx, tournamentKey(t.ID), data, 0)
+	pipe.SAdd(repository.Ctx, tournamentIDsKey, t.ID)
+	if _, err := pipe.Exec(repository.Ctx); err != nil {
+		return nil, fmt.Errorf("保存锦标赛失败: %w", err)
+	}
+	log.Printf("🏆 锦标赛 %s 已创建，%d 名玩家，%d 轮\n", t.ID, len(participants), t.Rounds)
+
+	openTables(t.ID)
+	return GetTournament(t.ID)
+}
+
+// GetTournament 锦标赛详情和当前排名
+func GetTournament(id string) (*TournamentDetail, error) {
+	t, err := loadTournament(id)
+	if err != nil {
+		return nil, err
+	}
+	return &TournamentDetail{Tournament: t, Standings: tournamentStandings(t)}, nil
+}
+
+// GetTournamentStandings 锦标赛当前排名
+func GetTournamentStandings(id string) ([]TournamentStanding, error) {
+	t, err := loadTournament(id)
+	if err != nil {
+		return nil, err
+	}
+	return tournamentStandings(t), nil
+}
+
+// ListTournaments 所有锦标赛，最近创建的在前
+func ListTournaments() ([]TournamentSummary, error) {
+	ids, err := repository.Rdb.SMembers(repository.Ctx, tournamentIDsKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("获取锦标赛列表失败: %w", err)
+	}
+	list := make([]TournamentSummary, 0, len(ids))
+	for _, id := range ids {
+		t, err := loadTournament(id)
+		if err != nil {
+			log.Printf("❌ 读取锦标赛 %s 失败: %v\n", id, err)
+			continue
+		}
+		list = append(list, TournamentSummary{
+			ID:           t.ID,
+			Name:         t.Name,
+			Organizer:    t.Organizer,
+			Status:       t.Status,
+			Round:        t.Round,
+			Rounds:       t.Rounds,
+			Participants: len(t.Participants),
+			CreatedAt:    t.CreatedAt,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt > list[j].CreatedAt })
+	return list, nil
+}
+
+// CancelTournament 组织者取消锦标赛，进行中的对局可以继续，但不再计入成绩
+func CancelTournament(id, userID string) error {
+	_, err := updateTournament(id, func(t *Tournament) error {
+		if t.Organizer != userID {
+			return fmt.Errorf("只有组织者可以取消锦标赛")
+		}
+		if t.Status != TournamentRunning {
+			return fmt.Errorf("锦标赛已结束")
+		}
+		t.Status = TournamentCancelled
+		return nil
+	})
+	return err
+}
+
+func loadTournament(id string) (*Tournament, error) {
+	data, err := repository.Rdb.Get(repository.Ctx, tournamentKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrTournamentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取锦标赛失败: %w", err)
+	}
+	var t Tournament
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("解析锦标赛失败: %w", err)
+	}
+	return &t, nil
+}
+
+// 读取、修改并写回锦标赛，其他实例同时修改时重试
+func updateTournament(id string, fn func(t *Tournament) error) (*Tournament, error) {
+	key := tournamentKey(id)
+	var t *Tournament
+	txf := func(tx *redis.Tx) error {
+		data, err := tx.Get(repository.Ctx, key).Bytes()
+		if err == redis.Nil {
+			return ErrTournamentNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("读取锦标赛失败: %w", err)
+		}
+		t = &Tournament{}
+		if err := json.Unmarshal(data, t); err != nil {
+			return fmt.Errorf("解析锦标赛失败: %w", err)
+		}
+		if err := fn(t); err != nil {
+			return err
+		}
+		if data, err = json.Marshal(t); err != nil {
+			return fmt.Errorf("编码锦标赛失败: %w", err)
+		}
+		_, err = tx.TxPipelined(repository.Ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(repository.Ctx, key, data, 0)
+			return nil
+		})
+		return err
+	}
+	for i := 0; i < tournamentUpdateRetries; i++ {
+		err := repository.Rdb.Watch(repository.Ctx, txf, key)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err == errTournamentUnchanged {
+			return t, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+	return nil, fmt.Errorf("更新锦标赛[%s]失败: 并发修改过多", id)
+}
+
+// 按顺序把玩家平均分到各桌，每桌人数相差不超过 1，空位由 AI 补满
+func seatRound(t *Tournament, round int, order []string) []TournamentTable {
+	count := (len(order) + t.TableSize - 1) / t.TableSize
+	base, extra := len(order)/count, len(order)%count
+	tables := make([]TournamentTable, 0, count)
+	start := 0
+	for i := 0; i < count; i++ {
+		size := base
+		if i < extra {
+			size++
+		}
+		tables = append(tables, TournamentTable{
+			Round:   round,
+			Table:   i + 1,
+			Players: append([]string(nil), order[start:start+size]...),
+			Bots:    t.TableSize - size,
+		})
+		start += size
+	}
+	return tables
+}
+
+// 为当前轮次还没有房间的桌创建房间，并通知玩家入座
+func openTables(id string) {
+	t, err := loadTournament(id)
+	if err != nil {
+		log.Println("❌", err)
+		return
+	}
+	if t.Status != TournamentRunning {
+		return
+	}
+	for i, table := range t.Tables {
+		if table.Round != t.Round || table.RoomID != "" {
+			continue
+		}
+		roomID, err := CreateRoom(dto.CreateRoomRequest{
+			MaxPlayers:   t.TableSize,
+			AiCount:      table.Bots,
+			AiDifficulty: t.AiDifficulty,
+			UserID:       table.Players[0],
+		})
+		if err != nil {
+			log.Printf("❌ 锦标赛 %s 第 %d 轮第 %d 桌创建房间失败: %v\n", id, table.Round, table.Table, err)
+			continue
+		}
+		if err := ws.ReserveSeats(roomID, table.Players); err != nil {
+			log.Println("❌", err)
+		}
+		if err := repository.Rdb.HSet(repository.Ctx, tournamentRoomsKey, roomID, id).Err(); err != nil {
+			log.Println("❌ 保存锦标赛房间失败:", err)
+		}
+		_, err = updateTournament(id, func(t *Tournament) error {
+			t.Tables[i].RoomID = roomID
+			t.Tables[i].OpenedAt = time.Now().UnixMilli()
+			return nil
+		})
+		if err != nil {
+			log.Println("❌ 保存锦标赛房间失败:", err)
+			continue
+		}
+		for _, playerID := range table.Players {
+			err := ws.NotifyLobby(playerID, ws.TournamentTableMessage{
+				Type:         "tournament_table",
+				TournamentID: id,
+				Round:        table.Round,
+				Table:        table.Table,
+				RoomID:       roomID,
+			})
+			if err != nil {
+				log.Println("❌ 推送锦标赛座位失败:", err)
+			}
+		}
+	}
+}
+
+// 锦标赛房间的对局结束：记录成绩，本轮全部结束时按排名分桌开始下一轮
+func recordTournamentResult(match *repository.Match) {
+	finishTable(match.RoomID, match.ID, func(t *Tournament, table TournamentTable) []TableResult {
+		return tableResults(match, t.TableSize)
+	})
+}
+
+// 锦标赛房间没有开局就被回收：本桌全员弃权
+func forfeitTournamentRoom(roomID string) {
+	finishTable(roomID, "", func(t *Tournament, table TournamentTable) []TableResult {
+		return forfeitResults(table, t.TableSize)
+	})
+}
+
+// 结束一桌并记录成绩，本轮全部结束时按排名分桌开始下一轮。桌已结束或不属于锦标赛时忽略
+func finishTable(roomID, matchID string, results func(t *Tournament, table TournamentTable) []TableResult) {
+	id, err := repository.Rdb.HGet(repository.Ctx, tournamentRoomsKey, roomID).Result()
+	if err == redis.Nil {
+		return
+	}
+	if err != nil {
+		log.Println("❌ 查询锦标赛房间失败:", err)
+		return
+	}
+
+	advanced := false
+	t, err := updateTournament(id, func(t *Tournament) error {
+		advanced = false
+		if t.Status != TournamentRunning {
+			return errTournamentUnchanged
+		}
+		idx := -1
+		for i, table := range t.Tables {
+			if table.RoomID == roomID && !table.Finished {
+				idx = i
+			}
+		}
+		if idx < 0 {
+			return errTournamentUnchanged
+		}
+		t.Tables[idx].Finished = true
+		t.Tables[idx].MatchID = matchID
+		t.Tables[idx].Results = results(t, t.Tables[idx])
+
+		for _, table := range t.Tables {
+			if table.Round == t.Round && !table.Finished {
+				return nil
+			}
+		}
+		if t.Round >= t.Rounds {
+			t.Status = TournamentFinished
+			return nil
+		}
+		order := make([]string, 0, len(t.Participants))
+		for _, s := range tournamentStandings(t) {
+			order = append(order, s.PlayerID)
+		}
+		t.Round++
+		t.Tables = append(t.Tables, seatRound(t, t.Round, order)...)
+		advanced = true
+		return nil
+	})
+	if err != nil {
+		log.Printf("❌ 记录锦标赛 %s 的成绩失败: %v\n", id, err)
+		return
+	}
+	repository.Rdb.HDel(repository.Ctx, tournamentRoomsKey, roomID)
+	switch {
+	case advanced:
+		log.Printf("🏆 锦标赛 %s 开始第 %d 轮\n", id, t.Round)
+		openTables(id)
+	case t.Status == TournamentFinished:
+		log.Printf("🏆 锦标赛 %s 已结束\n", id)
+	}
+}
+
+// 结算超过 TOURNAMENT_TABLE_TIMEOUT 还没有结束的桌：已开局的按当前局面结束，成绩由对局结束流程记录；
+// 没有开局或房间已不存在的记为全员弃权。各实例都会检查，重复结算同一桌时后到的会被忽略
+func closeExpiredTables() {
+	ids, err := repository.Rdb.SMembers(repository.Ctx, tournamentIDsKey).Result()
+	if err != nil {
+		log.Println("❌ 获取锦标赛列表失败:", err)
+		return
+	}
+	deadline := time.Now().Add(-tableTimeout).UnixMilli()
+	for _, id := range ids {
+		t, err := loadTournament(id)
+		if err != nil || t.Status != TournamentRunning {
+			continue
+		}
+		for _, table := range t.Tables {
+			if table.Round != t.Round || table.Finished || table.RoomID == "" || table.OpenedAt == 0 || table.OpenedAt > deadline {
+				continue
+			}
+			log.Printf("⏰ 锦标赛 %s 第 %d 轮第 %d 桌超时\n", id, table.Round, table.Table)
+			closeExpiredTable(table.RoomID)
+		}
+	}
+}
+
+func closeExpiredTable(roomID string) {
+	n, err := repository.Rdb.Exists(repository.Ctx, fmt.Sprintf("room:%s:roomInfo", roomID)).Result()
+	if err != nil {
+		log.Println("❌ 查询锦标赛房间失败:", err)
+		return
+	}
+	if n == 0 {
+		forfeitTournamentRoom(roomID)
+		return
+	}
+	var started bool
+	if err := ws.CallRoom(roomID, "abandon_game", nil, &started); err != nil {
+		log.Printf("❌ 结束超时的房间 %s 失败: %v\n", roomID, err)
+		return
+	}
+	if !started {
+		forfeitTournamentRoom(roomID)
+	}
+}
+
+// 一桌中真人玩家的成绩，名次包含 AI
+func tableResults(match *repository.Match, tableSize int) []TableResult {
+	results := make([]TableResult, 0, len(match.Players))
+	for _, p := range match.Players {
+		if p.AI {
+			continue
+		}
+		result := TableResult{PlayerID: p.PlayerID, Rank: p.Rank, Score: p.Score, Left: p.Left}
+		if !p.Left {
+			result.Points = max(tableSize-p.Rank, 0)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// 没有开局的桌：真人玩家都记为弃权，不得分
+func forfeitResults(table TournamentTable, tableSize int) []TableResult {
+	results := make([]TableResult, 0, len(table.Players))
+	for _, playerID := range table.Players {
+		results = append(results, TableResult{PlayerID: playerID, Rank: tableSize, Left: true, Forfeit: true})
+	}
+	return results
+}
+
+// 按积分、各局分数之和、获胜局数排名，都相同时名次并列
+func tournamentStandings(t *Tournament) []TournamentStanding {
+	byPlayer := make(map[string]*TournamentStanding, len(t.Participants))
+	standings := make([]*TournamentStanding, 0, len(t.Participants))
+	for _, id := range t.Participants {
+		s := &TournamentStanding{PlayerID: id}
+		byPlayer[id] = s
+		standings = append(standings, s)
+	}
+	for _, table := range t.Tables {
+		for _, r := range table.Results {
+			s, ok := byPlayer[r.PlayerID]
+			if !ok {
+				continue
+			}
+			s.Games++
+			s.Points += r.Points
+			s.Score += r.Score
+			if r.Rank == 1 && !r.Left {
+				s.Wins++
+			}
+		}
+	}
+	better := func(a, b *TournamentStanding) bool {
+		if a.Points != b.Points {
+			return a.Points > b.Points
+		}
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return a.Wins > b.Wins
+	}
+	sort.SliceStable(standings, func(i, j int) bool {
+		if better(standings[i], standings[j]) || better(standings[j], standings[i]) {
+			return better(standings[i], standings[j])
+		}
+		return standings[i].PlayerID < standings[j].PlayerID
+	})
+	list := make([]TournamentStanding, 0, len(standings))
+	for i, s := range standings {
+		s.Rank = i + 1
+		if i > 0 && !better(standings[i-1], s) {
+			s.Rank = list[i-1].Rank
+		}
+		list = append(list, *s)
+	}
+	return list
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"go-game/repository"
+	"reflect"
+	"testing"
+)
+
+func TestSeatRound(t *testing.T) {
+	tests := []struct {
+		name      string
+		players   []string
+		tableSize int
+		want      [][]string
+		bots      []int
+	}{
+		{
+			name:      "正好一桌",
+			players:   []string{"a", "b", "c", "d"},
+			tableSize: 4,
+			want:      [][]string{{"a", "b", "c", "d"}},
+			bots:      []int{0},
+		},
+		{
+			name:      "不满一桌用 AI 补满",
+			players:   []string{"a", "b", "c"},
+			tableSize: 4,
+			want:      [][]string{{"a", "b", "c"}},
+			bots:      []int{1},
+		},
+		{
+			name:      "多出的玩家分到前面的桌",
+			players:   []string{"a", "b", "c", "d", "e"},
+			tableSize: 4,
+			want:      [][]string{{"a", "b", "c"}, {"d", "e"}},
+			bots:      []int{1, 2},
+		},
+		{
+			name:      "各桌人数相差不超过 1",
+			players:   []string{"a", "b", "c", "d", "e", "f", "g"},
+			tableSize: 3,
+			want:      [][]string{{"a", "b", "c"}, {"d", "e"}, {"f", "g"}},
+			bots:      []int{0, 1, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tables := seatRound(&Tournament{TableSize: tt.tableSize}, 2, tt.players)
+			if len(tables) != len(tt.want) {
+				t.Fatalf("分成 %d 桌，期望 %d 桌", len(tables), len(tt.want))
+			}
+			for i, table := range tables {
+				if table.Round != 2 || table.Table != i+1 {
+					t.Fatalf("第 %d 桌的轮次和桌号为 %d/%d", i+1, table.Round, table.Table)
+				}
+				if !reflect.DeepEqual(table.Players, tt.want[i]) || table.Bots != tt.bots[i] {
+					t.Fatalf("第 %d 桌为 %v 加 %d 个 AI，期望 %v 加 %d 个 AI", i+1, table.Players, table.Bots, tt.want[i], tt.bots[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTableResults(t *testing.T) {
+	match := &repository.Match{Players: []repository.MatchPlayer{
+		{PlayerID: "u1", Rank: 2, Score: 9000},
+		{PlayerID: "ai_1", Rank: 1, Score: 12000, AI: true},
+		{PlayerID: "u2", Rank: 4, Score: 3000, Left: true},
+		{PlayerID: "u3", Rank: 3, Score: 5000},
+	}}
+	want := []TableResult{
+		{PlayerID: "u1", Rank: 2, Score: 9000, Points: 2},
+		{PlayerID: "u2", Rank: 4, Score: 3000, Left: true},
+		{PlayerID: "u3", Rank: 3, Score: 5000, Points: 1},
+	}
+	if got := tableResults(match, 4); !reflect.DeepEqual(got, want) {
+		t.Fatalf("tableResults = %+v，期望 %+v", got, want)
+	}
+}
+
+func TestForfeitResults(t *testing.T) {
+	table := TournamentTable{Players: []string{"u1", "u2"}, Bots: 1}
+	want := []TableResult{
+		{PlayerID: "u1", Rank: 3, Left: true, Forfeit: true},
+		{PlayerID: "u2", Rank: 3, Left: true, Forfeit: true},
+	}
+	if got := forfeitResults(table, 3); !reflect.DeepEqual(got, want) {
+		t.Fatalf("forfeitResults = %+v，期望 %+v", got, want)
+	}
+}
+
+func TestTournamentStandings(t *testing.T) {
+	tests := []struct {
+		name         string
+		participants []string
+		results      [][]TableResult // 每桌的成绩
+		want         []TournamentStanding
+	}{
+		{
+			name:         "按积分排名，没有成绩的玩家排在最后",
+			participants: []string{"a", "b", "c"},
+			results: [][]TableResult{
+				{{PlayerID: "a", Rank: 2, Points: 1}, {PlayerID: "b", Rank: 1, Points: 2}},
+			},
+			want: []TournamentStanding{
+				{Rank: 1, PlayerID: "b", Points: 2, Wins: 1, Games: 1},
+				{Rank: 2, PlayerID: "a", Points: 1, Games: 1},
+				{Rank: 3, PlayerID: "c"},
+			},
+		},
+		{
+			name:         "积分相同比分数之和",
+			participants: []string{"a", "b"},
+			results: [][]TableResult{
+				{{PlayerID: "a", Rank: 1, Points: 2, Score: 100}},
+				{{PlayerID: "b", Rank: 1, Points: 2, Score: 200}},
+			},
+			want: []TournamentStanding{
+				{Rank: 1, PlayerID: "b", Points: 2, Score: 200, Wins: 1, Games: 1},
+				{Rank: 2, PlayerID: "a", Points: 2, Score: 100, Wins: 1, Games: 1},
+			},
+		},
+		{
+			name:         "积分和分数都相同比获胜局数，中途离开不算获胜",
+			participants: []string{"a", "b"},
+			results: [][]TableResult{
+				{{PlayerID: "a", Rank: 1, Score: 100, Left: true}},
+				{{PlayerID: "b", Rank: 1, Score: 100}},
+			},
+			want: []TournamentStanding{
+				{Rank: 1, PlayerID: "b", Score: 100, Wins: 1, Games: 1},
+				{Rank: 2, PlayerID: "a", Score: 100, Games: 1},
+			},
+		},
+		{
+			name:         "完全相同时名次并列，按玩家 ID 排序",
+			participants: []string{"c", "b", "a"},
+			results: [][]TableResult{
+				{{PlayerID: "c", Rank: 1, Points: 2}, {PlayerID: "b", Rank: 1, Points: 2}, {PlayerID: "a", Rank: 3}},
+			},
+			want: []TournamentStanding{
+				{Rank: 1, PlayerID: "b", Points: 2, Wins: 1, Games: 1},
+				{Rank: 1, PlayerID: "c", Points: 2, Wins: 1, Games: 1},
+				{Rank: 3, PlayerID: "a", Games: 1},
+			},
+		},
+		{
+			name:         "弃权计入局数，不是参赛者的成绩忽略",
+			participants: []string{"a"},
+			results: [][]TableResult{
+				{{PlayerID: "a", Rank: 2, Left: true, Forfeit: true}, {PlayerID: "x", Rank: 1, Points: 1}},
+			},
+			want: []TournamentStanding{
+				{Rank: 1, PlayerID: "a", Games: 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tournament := &Tournament{Participants: tt.participants}
+			for i, results := range tt.results {
+				tournament.Tables = append(tournament.Tables, TournamentTable{Round: 1, Table: i + 1, Finished: true, Results: results})
+			}
+			if got := tournamentStandings(tournament); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("tournamentStandings = %+v，期望 %+v", got, tt.want)
+			}
+		})
+	}
+}
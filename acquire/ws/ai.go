@@ -8,8 +8,6 @@ import (
 	"sort"
 	"strings"
 	"time"
-
-	"golang.org/x/exp/rand"
 )
 
 var _ WriteOnlyConn = (*VirtualConn)(nil) // 编译期断言实现
@@ -20,12 +18,48 @@ func chooseTileForAI(roomID, playerID string) string {
 		return ""
 	}
 
+	rng, err := botRand(roomID)
+	if err != nil {
+		log.Println("❌", err)
+		return ""
+	}
+
+	difficulty := GetAIDifficulty(repository.Rdb, roomID, playerID)
+	// 简单 AI 随机出牌
+	if difficulty == AIDifficultyEasy {
+		return tiles[rng.IntN(len(tiles))]
+	}
+
 	allTiles, err := GetAllRoomTiles(repository.Rdb, roomID)
 	if err != nil {
 		log.Println("❌ 获取所有房间瓦片失败:", err)
 		return ""
 	}
 
+	// 困难 AI 优先扩张自己持股最多的公司
+	if difficulty == AIDifficultyHard {
+		playerStocks, err := GetPlayerStocks(repository.Rdb, repository.Ctx, roomID, playerID)
+		if err == nil {
+			best := ""
+			bestStock := 0
+			for _, tileID := range tiles {
+				for _, nID := range getAdjacentTileKeys(tileID) {
+					neighborTile, ok := allTiles[nID]
+					if !ok || neighborTile.Belong == "" || neighborTile.Belong == "Blank" {
+						continue
+					}
+					if playerStocks[neighborTile.Belong] > bestStock {
+						bestStock = playerStocks[neighborTile.Belong]
+						best = tileID
+					}
+				}
+			}
+			if best != "" {
+				return best
+			}
+		}
+	}
+
 	// 遍历 AI 玩家拥有的 tiles
 	for _, tileID := range tiles {
 		neighbors := getAdjacentTileKeys(tileID)
@@ -36,7 +70,7 @@ func chooseTileForAI(roomID, playerID string) string {
 		}
 	}
 
-	return tiles[rand.Intn(len(tiles))]
+	return tiles[rng.IntN(len(tiles))]
 }
 
 func shouldCreateCompany(roomID, playerID string) bool {
@@ -57,6 +91,13 @@ func chooseCompanyForAI(roomID string) string {
 			uncreated = append(uncreated, company)
 		}
 	}
+	// map 遍历顺序不固定，排序后同样的种子下 AI 的选择才相同
+	sort.Strings(uncreated)
+	rng, err := botRand(roomID)
+	if err != nil {
+		log.Println("❌", err)
+		return ""
+	}
 
 	// 优先级分类
 	priority1 := []string{"Continental", "Imperial"}
@@ -75,12 +116,12 @@ func chooseCompanyForAI(roomID string) string {
 
 	// 从高优先级到低依次尝试选择
 	if len(p1) > 0 {
-		return p1[rand.Intn(len(p1))]
+		return p1[rng.IntN(len(p1))]
 	}
 	if len(p2) > 0 {
-		return p2[rand.Intn(len(p2))]
+		return p2[rng.IntN(len(p2))]
 	}
-	return p3[rand.Intn(len(p3))]
+	return p3[rng.IntN(len(p3))]
 }
 
 func chooseStocksToBuyForAI(roomID, playerID string) map[string]interface{} {
@@ -122,16 +163,40 @@ func chooseStocksToBuyForAI(roomID, playerID string) map[string]interface{} {
 	if len(options) == 0 {
 		return map[string]interface{}{}
 	}
+	// 按名称排序，保证同样的种子下结果相同
+	sort.Slice(options, func(i, j int) bool { return options[i].Name < options[j].Name })
 
-	// 从便宜到贵排序（贪婪）
-	sort.Slice(options, func(i, j int) bool {
-		return options[i].Price < options[j].Price
-	})
+	// 每回合最多购买的股数
+	maxStock := 3
+	switch GetAIDifficulty(repository.Rdb, roomID, playerID) {
+	case AIDifficultyEasy:
+		// 简单 AI 随机买 1 股
+		rng, err := botRand(roomID)
+		if err != nil {
+			log.Println("❌", err)
+			return nil
+		}
+		rng.Shuffle(len(options), func(i, j int) { options[i], options[j] = options[j], options[i] })
+		maxStock = 1
+	case AIDifficultyHard:
+		// 困难 AI 集中持股，优先买自己已持有最多的公司
+		sort.Slice(options, func(i, j int) bool {
+			if playerStock[options[i].Name] != playerStock[options[j].Name] {
+				return playerStock[options[i].Name] > playerStock[options[j].Name]
+			}
+			return options[i].Price < options[j].Price
+		})
+	default:
+		// 从便宜到贵排序（贪婪）
+		sort.Slice(options, func(i, j int) bool {
+			return options[i].Price < options[j].Price
+		})
+	}
 
 	result := make(map[string]interface{})
 	stockCount := 0
 	for _, opt := range options {
-		maxCanBuy := min(3-stockCount, opt.Remain, money/opt.Price)
+		maxCanBuy := min(maxStock-stockCount, opt.Remain, money/opt.Price)
 		if maxCanBuy <= 0 {
 			continue
 		}
@@ -140,7 +205,7 @@ func chooseStocksToBuyForAI(roomID, playerID string) map[string]interface{} {
 		money -= maxCanBuy * opt.Price
 		stockCount += maxCanBuy
 
-		if stockCount >= 3 || money <= 0 {
+		if stockCount >= maxStock || money <= 0 {
 			break
 		}
 	}
@@ -368,67 +433,74 @@ func MaybeRunAIIfNeeded(roomID string, data []byte) bool {
 	go func() {
 		time.Sleep(5 * time.Second)
 
-		conn := &VirtualConn{PlayerID: currentPlayerID, RoomID: roomID}
-		rdb := repository.Rdb
+		// 回到房间 goroutine 内执行，避免与玩家消息并发修改房间数据
+		err := RunInRoom(roomID, func() {
+			conn := &VirtualConn{PlayerID: currentPlayerID, RoomID: roomID}
+			rdb := repository.Rdb
 
-		var aiMsg map[string]interface{}
+			var aiMsg map[string]interface{}
 
-		switch gameStatus {
-		case "setTile":
-			tile := chooseTileForAI(roomID, currentPlayerID)
-			if tile == "" {
-				log.Println("🤖 AI 未选择有效 tile")
+			switch gameStatus {
+			case "setTile":
+				tile := chooseTileForAI(roomID, currentPlayerID)
+				if tile == "" {
+					log.Println("🤖 AI 未选择有效 tile")
+					return
+				}
+				aiMsg = map[string]interface{}{
+					"type":    "place_tile",
+					"payload": tile,
+				}
+			case "createCompany":
+				company := chooseCompanyForAI(roomID)
+				if company == "" {
+					log.Println("🤖 AI 未选择有效公司")
+					return
+				}
+				aiMsg = map[string]interface{}{
+					"type":    "create_company",
+					"payload": company,
+				}
+			case "buyStock":
+				stocks := chooseStocksToBuyForAI(roomID, currentPlayerID)
+				aiMsg = map[string]interface{}{
+					"type":    "buy_stock",
+					"payload": stocks,
+				}
+			case "mergingSelection":
+				selection := chooseMergingSelectionForAI(roomID, currentPlayerID, mainCompany)
+				aiMsg = map[string]interface{}{
+					"type":    "merging_selection",
+					"payload": selection,
+				}
+			case "mergingSettle":
+				settle := chooseMergingSettleForAI(roomID, playerId)
+				aiMsg = map[string]interface{}{
+					"type":    "merging_settle",
+					"payload": settle,
+				}
+			case "end":
+				// 结束后是否再来一局由真人玩家投票决定
 				return
-			}
-			aiMsg = map[string]interface{}{
-				"type":    "place_tile",
-				"payload": tile,
-			}
-		case "createCompany":
-			company := chooseCompanyForAI(roomID)
-			if company == "" {
-				log.Println("🤖 AI 未选择有效公司")
+			default:
+				log.Printf("⚠️ 当前状态 %s 未定义 AI 行为", gameStatus)
 				return
 			}
-			aiMsg = map[string]interface{}{
-				"type":    "create_company",
-				"payload": company,
-			}
-		case "buyStock":
-			stocks := chooseStocksToBuyForAI(roomID, currentPlayerID)
-			aiMsg = map[string]interface{}{
-				"type":    "buy_stock",
-				"payload": stocks,
-			}
-		case "mergingSelection":
-			selection := chooseMergingSelectionForAI(roomID, currentPlayerID, mainCompany)
-			aiMsg = map[string]interface{}{
-				"type":    "merging_selection",
-				"payload": selection,
-			}
-		case "mergingSettle":
-			settle := chooseMergingSettleForAI(roomID, playerId)
-			aiMsg = map[string]interface{}{
-				"type":    "merging_settle",
-				"payload": settle,
-			}
-		case "end":
-			aiMsg = map[string]interface{}{
-				"type": "restart_game",
-			}
-		default:
-			log.Printf("⚠️ 当前状态 %s 未定义 AI 行为", gameStatus)
-			return
-		}
 
-		// 加入 playerID 然后交给 handler 执行
-		aiMsg["playerID"] = playerId
-		if handler, found := messageHandlers[aiMsg["type"].(string)]; found {
-			log.Printf("🤖 AI [%s] 执行操作: %s", playerId, aiMsg["type"])
-			handler(conn, rdb, roomID, playerId, aiMsg)
-			BroadcastToRoom(roomID)
-		} else {
-			log.Printf("❌ AI 未找到 handler 类型: %s", aiMsg["type"])
+			// 加入 playerID 然后交给 handler 执行
+			aiMsg["playerID"] = playerId
+			if handler, found := messageHandlers[aiMsg["type"].(string)]; found {
+				log.Printf("🤖 AI [%s] 执行操作: %s", playerId, aiMsg["type"])
+				if err := handler(conn, rdb, roomID, playerId, aiMsg); err != nil {
+					log.Printf("❌ AI [%s] 操作 %s 失败: %v", playerId, aiMsg["type"], err)
+				}
+				BroadcastToRoom(roomID)
+			} else {
+				log.Printf("❌ AI 未找到 handler 类型: %s", aiMsg["type"])
+			}
+		})
+		if err != nil {
+			log.Printf("❌ AI [%s] 行动失败: %v", currentPlayerID, err)
 		}
 	}()
 
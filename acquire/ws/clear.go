@@ -1,43 +1,247 @@
 package ws
 
 import (
+	"expvar"
 	"fmt"
+	"go-game/repository"
+	"log"
+	"os"
+	"strconv"
 	"time"
 )
 
-func ScheduleDailyRoomReset() {
-	for {
-		duration := durationUntilNext4AM()
-		fmt.Printf("距离下次清空还有：%v\n", duration)
+// 房间回收：长时间没有真人在线的房间，或结束后超过保留时长的房间，先结算、归档对局再删除全部 room:<id>:* key。
+// 每个实例都会运行回收协程，但只回收由自己持有租约的房间，同一房间不会被重复回收
+var (
+	roomIdleTimeout  = envDuration("ROOM_IDLE_TIMEOUT", 30*time.Minute) // 无真人在线多久后回收
+	roomEndRetention = envDuration("ROOM_END_RETENTION", 2*time.Hour)   // 已结束的房间保留多久
+	roomReapInterval = envDuration("ROOM_REAP_INTERVAL", time.Minute)   // 检查间隔
+)
+
+// 回收统计，通过 /debug/vars 查看
+var reaperMetrics = expvar.NewMap("room_reaper")
+
+const (
+	reapReasonIdle     = "idle"
+	reapReasonFinished = "finished"
+)
+
+var roomDiscardedHandlers []func(roomID string)
+
+// OnRoomDiscarded 注册房间回收时对局还没有开始的处理函数，启动时调用。
+// 开始过的对局在回收前会记录结果，通过 OnMatchEnded 通知，不会再调用这里的处理函数
+func OnRoomDiscarded(fn func(roomID string)) {
+	roomDiscardedHandlers = append(roomDiscardedHandlers, fn)
+}
 
-		time.Sleep(duration)
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("⚠️ 环境变量 %s=%q 无效，使用默认值 %v\n", name, v, def)
+		return def
+	}
+	return d
+}
+
+func idleSinceKey(roomID string) string {
+	return fmt.Sprintf("room:%s:idleSince", roomID)
+}
+
+func endedAtKey(roomID string) string {
+	return fmt.Sprintf("room:%s:endedAt", roomID)
+}
 
-		// 清空 Rooms
-		fmt.Println("⏰ 清空房间 Rooms")
-		clearRooms()
+// ScheduleRoomReaper 定期回收无人房间和已结束的房间
+func ScheduleRoomReaper() {
+	log.Printf("⏰ 房间回收已启动：空闲 %v，结束保留 %v，间隔 %v\n", roomIdleTimeout, roomEndRetention, roomReapInterval)
+	ticker := time.NewTicker(roomReapInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		reapRooms()
 	}
 }
 
-func durationUntilNext4AM() time.Duration {
+func reapRooms() {
+	roomIDs, err := ScanRoomIDs()
+	if err != nil {
+		log.Println("❌ 回收房间失败:", err)
+		reaperMetrics.Add("errors", 1)
+		return
+	}
+	reaperMetrics.Add("runs", 1)
+	reaped := map[string]int{}
+	for _, roomID := range roomIDs {
+		reason, err := reapReason(roomID)
+		if err != nil {
+			log.Printf("❌ 检查房间[%s]失败: %v\n", roomID, err)
+			reaperMetrics.Add("errors", 1)
+			continue
+		}
+		if reason == "" {
+			continue
+		}
+		ok, err := reapRoom(roomID)
+		if err != nil {
+			log.Printf("❌ 回收房间[%s]失败: %v\n", roomID, err)
+			reaperMetrics.Add("errors", 1)
+			continue
+		}
+		if ok {
+			reaped[reason]++
+			reaperMetrics.Add("reaped_"+reason, 1)
+		}
+	}
+	if len(reaped) > 0 {
+		log.Printf("🧹 已回收房间：空闲 %d 个，已结束 %d 个\n", reaped[reapReasonIdle], reaped[reapReasonFinished])
+	}
+}
+
+// 判断房间是否需要回收，返回回收原因，不需要回收时返回空字符串。
+// 空闲和结束的起始时间记在 Redis，实例重启或房间换实例运行后仍然连续计时
+func reapReason(roomID string) (string, error) {
 	now := time.Now()
-	next := time.Date(now.Year(), now.Month(), now.Day(), 4, 0, 0, 0, now.Location())
-
-	// 如果当前时间已过4点，则设置为第二天的4点
-	if now.After(next) {
-		next = next.Add(24 * time.Hour)
-	}
-	return next.Sub(now)
-}
-
-func clearRooms() {
-	// 清空 map 的方式
-	// for k := range Rooms {
-	// 	delete(Rooms, k)
-	// }
-	// err := repository.Rdb.FlushDB(repository.Ctx).Err() // 清空当前数据库
-	// if err != nil {
-	// 	fmt.Println("清空 Redis 失败:", err)
-	// } else {
-	// 	fmt.Println("✅ Redis 已清空")
-	// }
+
+	if isGameEnded(roomID) {
+		since, err := markSince(endedAtKey(roomID), now)
+		if err != nil {
+			return "", err
+		}
+		if now.Sub(since) >= roomEndRetention {
+			return reapReasonFinished, nil
+		}
+	} else if err := repository.Rdb.Del(repository.Ctx, endedAtKey(roomID)).Err(); err != nil {
+		// 再来一局后重新计时
+		return "", fmt.Errorf("清除结束时间失败: %w", err)
+	}
+
+	active, err := hasOnlineHuman(roomID)
+	if err != nil {
+		return "", err
+	}
+	if active {
+		if err := repository.Rdb.Del(repository.Ctx, idleSinceKey(roomID)).Err(); err != nil {
+			return "", fmt.Errorf("清除空闲时间失败: %w", err)
+		}
+		return "", nil
+	}
+	since, err := markSince(idleSinceKey(roomID), now)
+	if err != nil {
+		return "", err
+	}
+	if now.Sub(since) >= roomIdleTimeout {
+		return reapReasonIdle, nil
+	}
+	return "", nil
+}
+
+// 首次发现时写入当前时间，返回记录的起始时间
+func markSince(key string, now time.Time) (time.Time, error) {
+	if err := repository.Rdb.SetNX(repository.Ctx, key, now.Unix(), 0).Err(); err != nil {
+		return now, fmt.Errorf("记录时间失败: %w", err)
+	}
+	v, err := repository.Rdb.Get(repository.Ctx, key).Result()
+	if err != nil {
+		return now, fmt.Errorf("读取时间失败: %w", err)
+	}
+	sec, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return now, fmt.Errorf("解析时间失败: %w", err)
+	}
+	return time.Unix(sec, 0), nil
+}
+
+// 座位上是否有在线的真人玩家。没有实例运行的房间不可能有人连着，座位上残留的在线状态不算
+func hasOnlineHuman(roomID string) (bool, error) {
+	owner, err := lookupRoomOwner(roomID)
+	if err != nil {
+		return false, err
+	}
+	if owner == "" {
+		return false, nil
+	}
+	seats, err := GetSeats(roomID)
+	if err != nil {
+		return false, err
+	}
+	for _, seat := range seats {
+		if seat.Online && !IsAIPlayer(seat.PlayerID) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// 回收房间：只处理当前实例持有租约的房间，先结算并归档对局，归档失败时保留房间等待下次重试
+func reapRoom(roomID string) (bool, error) {
+	lease, err := acquireRoomOwner(roomID)
+	if err != nil {
+		return false, err
+	}
+	if leaseInstance(lease) != InstanceID {
+		return false, nil
+	}
+
+	var archiveErr error
+	started := false
+	archive := func() {
+		if started = isGameStarted(roomID); !started {
+			return
+		}
+		// 没有打完的对局按当前局面结算，否则排名、锦标赛和每日挑战都等不到结果
+		if !isGameEnded(roomID) {
+			logGameAbandoned(roomID)
+		}
+		archiveErr = archiveFinishedGame(roomID)
+	}
+	// 在房间 goroutine 内结算和归档，避免与正在处理的消息交错；房间没有在本实例运行时先启动
+	getOrCreateRoom(roomID, lease)
+	if err := RunInRoom(roomID, archive); err != nil {
+		return false, err
+	}
+	if archiveErr != nil {
+		return false, fmt.Errorf("归档对局失败: %w", archiveErr)
+	}
+
+	if err := DeleteRoomKeys(roomID); err != nil {
+		return false, err
+	}
+	CloseRoom(roomID)
+	RemoveSpectators(roomID)
+	if !started {
+		for _, fn := range roomDiscardedHandlers {
+			go fn(roomID)
+		}
+	}
+	return true, nil
+}
+
+// DeleteRoomKeys 删除房间在 Redis 中的全部 key（room:<id>:*），房间不存在时返回错误
+func DeleteRoomKeys(roomID string) error {
+	pattern := fmt.Sprintf("room:%s:*", roomID)
+	var cursor uint64
+	var keysToDelete []string
+	for {
+		keys, cur, err := repository.Rdb.Scan(repository.Ctx, cursor, pattern, 100).Result()
+		if err != nil {
+			return fmt.Errorf("扫描房间相关 key 失败: %w", err)
+		}
+		keysToDelete = append(keysToDelete, keys...)
+		cursor = cur
+		if cursor == 0 {
+			break
+		}
+	}
+
+	if len(keysToDelete) == 0 {
+		return fmt.Errorf("房间不存在或无相关数据")
+	}
+
+	if err := repository.Rdb.Del(repository.Ctx, keysToDelete...).Err(); err != nil {
+		return fmt.Errorf("删除房间相关 key 失败: %w", err)
+	}
+	return nil
 }
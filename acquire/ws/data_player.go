@@ -10,14 +10,14 @@ import (
 	"github.com/go-redis/redis/v8"
 )
 
-func SetPlayerInfoField(rdb *redis.Client, ctx context.Context, roomID, playerID, field string, value interface{}) error {
+func SetPlayerInfoField(rdb redis.Cmdable, ctx context.Context, roomID, playerID, field string, value interface{}) error {
 	playerInfoKey := fmt.Sprintf("room:%s:player:%s:info", roomID, playerID)
 	if err := rdb.HSet(ctx, playerInfoKey, field, value).Err(); err != nil {
 		return err
 	}
 	return nil
 }
-func GetPlayerInfoField(rdb *redis.Client, ctx context.Context, roomID, playerID, field string) (dto.PlayerInfo, error) {
+func GetPlayerInfoField(rdb redis.Cmdable, ctx context.Context, roomID, playerID, field string) (dto.PlayerInfo, error) {
 	playerInfoKey := fmt.Sprintf("room:%s:player:%s:info", roomID, playerID)
 	value, err := rdb.HGet(ctx, playerInfoKey, field).Result()
 	if err != nil {
@@ -35,7 +35,7 @@ func GetPlayerInfoField(rdb *redis.Client, ctx context.Context, roomID, playerID
 	return dto.PlayerInfo{}, nil
 }
 
-func AddPlayerMoney(rdb *redis.Client, ctx context.Context, roomID, playerID string, amount int) error {
+func AddPlayerMoney(rdb redis.Cmdable, ctx context.Context, roomID, playerID string, amount int) error {
 	playerInfoKey := fmt.Sprintf("room:%s:player:%s:info", roomID, playerID)
 	err := rdb.HIncrBy(ctx, playerInfoKey, "money", int64(amount)).Err()
 	if err != nil {
@@ -45,7 +45,7 @@ func AddPlayerMoney(rdb *redis.Client, ctx context.Context, roomID, playerID str
 }
 
 // 将玩家的牌组批量写入 Redis 列表（覆盖）
-func SetPlayerTiles(rdb *redis.Client, ctx context.Context, roomID, playerID string, tiles []string) error {
+func SetPlayerTiles(rdb redis.Cmdable, ctx context.Context, roomID, playerID string, tiles []string) error {
 	tileListKey := fmt.Sprintf("room:%s:player:%s:tiles", roomID, playerID)
 
 	// 删除旧的列表
@@ -71,7 +71,7 @@ func SetPlayerTiles(rdb *redis.Client, ctx context.Context, roomID, playerID str
 	return nil
 }
 
-func GetPlayerTiles(rdb *redis.Client, ctx context.Context, roomID, playerID string) ([]string, error) {
+func GetPlayerTiles(rdb redis.Cmdable, ctx context.Context, roomID, playerID string) ([]string, error) {
 	tileListKey := fmt.Sprintf("room:%s:player:%s:tiles", roomID, playerID)
 	tiles, err := rdb.LRange(ctx, tileListKey, 0, -1).Result()
 	if err != nil {
@@ -81,7 +81,7 @@ func GetPlayerTiles(rdb *redis.Client, ctx context.Context, roomID, playerID str
 }
 
 // AddPlayerTile 向指定玩家的 tile 列表中添加一个 tile
-func AddPlayerTile(rdb *redis.Client, ctx context.Context, roomID, playerID, tileKey string) error {
+func AddPlayerTile(rdb redis.Cmdable, ctx context.Context, roomID, playerID, tileKey string) error {
 	playerTileKey := fmt.Sprintf("room:%s:player:%s:tiles", roomID, playerID)
 	if err := rdb.RPush(ctx, playerTileKey, tileKey).Err(); err != nil {
 		log.Printf("❌ 向玩家 %s 添加 tile %s 失败: %v\n", playerID, tileKey, err)
@@ -92,7 +92,7 @@ func AddPlayerTile(rdb *redis.Client, ctx context.Context, roomID, playerID, til
 }
 
 // RemovePlayerTile 从指定玩家的 tile 列表中移除某个 tile
-func RemovePlayerTile(rdb *redis.Client, ctx context.Context, roomID, playerID, tileKey string) error {
+func RemovePlayerTile(rdb redis.Cmdable, ctx context.Context, roomID, playerID, tileKey string) error {
 	playerTileKey := fmt.Sprintf("room:%s:player:%s:tiles", roomID, playerID)
 	if err := rdb.LRem(ctx, playerTileKey, 1, tileKey).Err(); err != nil {
 		return fmt.Errorf("从玩家 %s 的 tile 列表移除失败: %w", playerID, err)
@@ -101,12 +101,17 @@ func RemovePlayerTile(rdb *redis.Client, ctx context.Context, roomID, playerID,
 }
 
 // GetPlayerStocks 读取玩家的所有股票及持股数，返回 map[companyID]stockCountStr
-func GetPlayerStocks(rdb *redis.Client, ctx context.Context, roomID, playerID string) (map[string]int, error) {
+func GetPlayerStocks(rdb redis.Cmdable, ctx context.Context, roomID, playerID string) (map[string]int, error) {
 	key := fmt.Sprintf("room:%s:player:%s:stocks", roomID, playerID)
 	result, err := rdb.HGetAll(ctx, key).Result()
 	if err != nil {
 		return nil, err
 	}
+	return parsePlayerStocks(result)
+}
+
+// 解析玩家股票 Hash
+func parsePlayerStocks(result map[string]string) (map[string]int, error) {
 	intMap := make(map[string]int)
 	for k, v := range result {
 		n, err := strconv.Atoi(v)
@@ -120,7 +125,7 @@ func GetPlayerStocks(rdb *redis.Client, ctx context.Context, roomID, playerID st
 }
 
 // SetPlayerStocks 设置玩家的股票信息，playerStocks 格式为 map[companyID]持股数量
-func SetPlayerStocks(rdb *redis.Client, ctx context.Context, roomID, playerID string, playerStocks map[string]int) error {
+func SetPlayerStocks(rdb redis.Cmdable, ctx context.Context, roomID, playerID string, playerStocks map[string]int) error {
 	key := fmt.Sprintf("room:%s:player:%s:stocks", roomID, playerID)
 	hashData := make(map[string]interface{})
 	for k, v := range playerStocks {
@@ -13,7 +13,7 @@ import (
 )
 
 // 判断玩家信息是否存在
-func IsPlayerInfoExists(rdb *redis.Client, ctx context.Context, roomID, playerID string) (bool, error) {
+func IsPlayerInfoExists(rdb redis.Cmdable, ctx context.Context, roomID, playerID string) (bool, error) {
 	playerInfoKey := fmt.Sprintf("room:%s:player:%s:info", roomID, playerID)
 	exists, err := rdb.Exists(ctx, playerInfoKey).Result()
 	if err != nil {
@@ -23,12 +23,22 @@ func IsPlayerInfoExists(rdb *redis.Client, ctx context.Context, roomID, playerID
 }
 
 // GetRoomInfo 获取房间的全部信息（Hash）
-func GetRoomInfo(rdb *redis.Client, roomID string) (*entities.RoomInfo, error) {
+func GetRoomInfo(rdb redis.Cmdable, roomID string) (*entities.RoomInfo, error) {
 	roomKey := fmt.Sprintf("room:%s:roomInfo", roomID)
 	roomInfoMap, err := rdb.HGetAll(repository.Ctx, roomKey).Result()
 	if err != nil {
 		return nil, fmt.Errorf("❌ 获取房间信息失败: %w", err)
 	}
+	return parseRoomInfo(roomInfoMap)
+}
+
+// 两个游戏共用的代码读取房间信息的统一入口
+func roomInfoOf(roomID string) (*entities.RoomInfo, error) {
+	return GetRoomInfo(repository.Rdb, roomID)
+}
+
+// 解析 roomInfo Hash
+func parseRoomInfo(roomInfoMap map[string]string) (*entities.RoomInfo, error) {
 	if len(roomInfoMap) == 0 {
 		return nil, fmt.Errorf("房间信息为空")
 	}
@@ -42,6 +52,7 @@ func GetRoomInfo(rdb *redis.Client, roomID string) (*entities.RoomInfo, error) {
 	roomInfo.RoomStatus = roomStatus
 	roomInfo.GameStatus = dto.RoomStatus(roomInfoMap["gameStatus"])
 	roomInfo.UserID = roomInfoMap["userID"]
+	roomInfo.Private, _ = strconv.ParseBool(roomInfoMap["private"])
 	// 字符串转 int
 	maxPlayersStr := roomInfoMap["maxPlayers"]
 	if maxPlayersStr != "" {
@@ -56,7 +67,7 @@ func GetRoomInfo(rdb *redis.Client, roomID string) (*entities.RoomInfo, error) {
 }
 
 // SetRoomInfo 设置房间的全部信息（Hash）
-func SetRoomInfo(rdb *redis.Client, ctx context.Context, roomID string, info entities.RoomInfo) error {
+func SetRoomInfo(rdb redis.Cmdable, ctx context.Context, roomID string, info entities.RoomInfo) error {
 	roomKey := fmt.Sprintf("room:%s:roomInfo", roomID)
 	roomStatus := strconv.FormatBool(info.RoomStatus)
 
@@ -65,16 +76,17 @@ func SetRoomInfo(rdb *redis.Client, ctx context.Context, roomID string, info ent
 		"roomStatus": roomStatus,
 		"maxPlayers": strconv.Itoa(info.MaxPlayers),
 		"userID":     info.UserID,
+		"private":    strconv.FormatBool(info.Private),
 	}
 
 	if err := rdb.HSet(ctx, roomKey, data).Err(); err != nil {
 		return fmt.Errorf("❌ 设置房间信息失败: %w", err)
 	}
-
+	markRoomChanged(roomID, lobbyRoomUpdated)
 	return nil
 }
 
-func SetGameStatus(rdb *redis.Client, roomID string, status dto.RoomStatus) error {
+func SetGameStatus(rdb redis.Cmdable, roomID string, status dto.RoomStatus) error {
 	roomInfoKey := fmt.Sprintf("room:%s:roomInfo", roomID)
 	err := rdb.HSet(repository.Ctx, roomInfoKey, "gameStatus", string(status)).Err()
 	if err != nil {
@@ -85,7 +97,7 @@ func SetGameStatus(rdb *redis.Client, roomID string, status dto.RoomStatus) erro
 	return nil
 }
 
-func SetRoomStatus(rdb *redis.Client, roomID string, status bool) error {
+func SetRoomStatus(rdb redis.Cmdable, roomID string, status bool) error {
 	roomInfoKey := fmt.Sprintf("room:%s:roomInfo", roomID)
 	statusStr := strconv.FormatBool(status) // 将 bool 转为字符串 "true"/"false"
 
@@ -93,11 +105,12 @@ func SetRoomStatus(rdb *redis.Client, roomID string, status bool) error {
 	if err != nil {
 		return fmt.Errorf("更新房间状态失败: %w", err)
 	}
+	markRoomChanged(roomID, lobbyRoomUpdated)
 	return nil
 }
 
 // SetCurrentPlayer 设置当前玩家
-func SetCurrentPlayer(rdb *redis.Client, ctx context.Context, roomID, playerID string) error {
+func SetCurrentPlayer(rdb redis.Cmdable, ctx context.Context, roomID, playerID string) error {
 	key := fmt.Sprintf("room:%s:currentPlayer", roomID)
 	if err := rdb.Set(ctx, key, playerID, 0).Err(); err != nil {
 		return fmt.Errorf("设置当前玩家失败: %w", err)
@@ -107,7 +120,7 @@ func SetCurrentPlayer(rdb *redis.Client, ctx context.Context, roomID, playerID s
 }
 
 // GetCurrentPlayer 获取当前玩家
-func GetCurrentPlayer(rdb *redis.Client, ctx context.Context, roomID string) (string, error) {
+func GetCurrentPlayer(rdb redis.Cmdable, ctx context.Context, roomID string) (string, error) {
 	key := fmt.Sprintf("room:%s:currentPlayer", roomID)
 	playerID, err := rdb.Get(ctx, key).Result()
 	if err != nil {
@@ -118,3 +131,25 @@ func GetCurrentPlayer(rdb *redis.Client, ctx context.Context, roomID string) (st
 	}
 	return playerID, nil
 }
+
+// SetFirstPlayer 设置本局的起始玩家
+func SetFirstPlayer(rdb redis.Cmdable, ctx context.Context, roomID, playerID string) error {
+	key := fmt.Sprintf("room:%s:firstPlayer", roomID)
+	if err := rdb.Set(ctx, key, playerID, 0).Err(); err != nil {
+		return fmt.Errorf("设置起始玩家失败: %w", err)
+	}
+	return nil
+}
+
+// GetFirstPlayer 获取本局的起始玩家
+func GetFirstPlayer(rdb redis.Cmdable, ctx context.Context, roomID string) (string, error) {
+	key := fmt.Sprintf("room:%s:firstPlayer", roomID)
+	playerID, err := rdb.Get(ctx, key).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return "", nil
+		}
+		return "", fmt.Errorf("获取起始玩家失败: %w", err)
+	}
+	return playerID, nil
+}
@@ -0,0 +1,170 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-game/dto"
+	"go-game/repository"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 座位持久化在 room:<id>:seats，服务重启或其他实例接管房间时据此恢复，大厅列表也从这里读取
+
+func seatsKey(roomID string) string {
+	return fmt.Sprintf("room:%s:seats", roomID)
+}
+
+// 房间已删除时不再写入，避免删除房间后又留下座位数据
+var saveSeatsScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return redis.call("SET", KEYS[2], ARGV[1])
+end
+return 0`)
+
+func saveSeats(roomID string, players []dto.PlayerConn) {
+	seats := make([]dto.RoomPlayer, 0, len(players))
+	for _, pc := range players {
+		seats = append(seats, dto.RoomPlayer{
+			PlayerID: pc.PlayerID,
+			Online:   pc.Online,
+		})
+	}
+	data, err := json.Marshal(seats)
+	if err != nil {
+		log.Println("❌ 编码座位失败:", err)
+		return
+	}
+	keys := []string{fmt.Sprintf("room:%s:roomInfo", roomID), seatsKey(roomID)}
+	// 房间正在执行操作时座位随操作一起提交
+	if room := getRoom(roomID); room != nil {
+		if tx := room.tx.Load(); tx != nil {
+			tx.queue(func(ctx context.Context, pipe redis.Pipeliner) {
+				saveSeatsScript.Eval(ctx, pipe, keys, data)
+			})
+			markRoomChanged(roomID, lobbyRoomUpdated)
+			return
+		}
+	}
+	if err := saveSeatsScript.Run(repository.Ctx, repository.Rdb, keys, data).Err(); err != nil && err != redis.Nil {
+		log.Printf("❌ 保存房间[%s]座位失败: %v\n", roomID, err)
+		return
+	}
+	markRoomChanged(roomID, lobbyRoomUpdated)
+}
+
+// GetSeats 读取持久化的座位，没有座位时返回空列表
+func GetSeats(roomID string) ([]dto.RoomPlayer, error) {
+	data, err := repository.Rdb.Get(repository.Ctx, seatsKey(roomID)).Result()
+	if err == redis.Nil {
+		return []dto.RoomPlayer{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取房间[%s]座位失败: %w", roomID, err)
+	}
+	var seats []dto.RoomPlayer
+	if err := json.Unmarshal([]byte(data), &seats); err != nil {
+		return nil, fmt.Errorf("解析房间[%s]座位失败: %w", roomID, err)
+	}
+	return seats, nil
+}
+
+// 恢复座位：AI 重新接上虚拟连接，真人玩家等待重连
+func loadSeats(roomID string) []dto.PlayerConn {
+	seats, err := GetSeats(roomID)
+	if err != nil {
+		log.Println("❌", err)
+		return []dto.PlayerConn{}
+	}
+	players := make([]dto.PlayerConn, 0, len(seats))
+	for _, seat := range seats {
+		pc := dto.PlayerConn{PlayerID: seat.PlayerID}
+		if IsAIPlayer(seat.PlayerID) {
+			pc.Conn = &VirtualConn{PlayerID: seat.PlayerID, RoomID: roomID}
+			pc.Online = true
+		}
+		players = append(players, pc)
+	}
+	return players
+}
+
+// ScanRoomIDs 获取 Redis 中所有房间 ID（按创建时间排序）
+func ScanRoomIDs() ([]string, error) {
+	var roomIDs []string
+	var cursor uint64
+	for {
+		keys, cur, err := repository.Rdb.Scan(repository.Ctx, cursor, "room:*:roomInfo", 100).Result()
+		if err != nil {
+			return nil, fmt.Errorf("扫描房间失败: %w", err)
+		}
+		for _, key := range keys {
+			roomIDs = append(roomIDs, strings.TrimSuffix(strings.TrimPrefix(key, "room:"), ":roomInfo"))
+		}
+		cursor = cur
+		if cursor == 0 {
+			break
+		}
+	}
+	sort.Strings(roomIDs)
+	return roomIDs, nil
+}
+
+// 座位上的玩家 ID：房间在当前实例运行时读取内存中的座位，否则读取持久化的座位（如清理无人房间时）
+func seatPlayerIDs(roomID string) ([]string, error) {
+	if room := getRoom(roomID); room != nil {
+		players := room.Players()
+		ids := make([]string, 0, len(players))
+		for _, pc := range players {
+			ids = append(ids, pc.PlayerID)
+		}
+		return ids, nil
+	}
+	seats, err := GetSeats(roomID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(seats))
+	for _, seat := range seats {
+		ids = append(ids, seat.PlayerID)
+	}
+	return ids, nil
+}
+
+// 指定座位的房间（如锦标赛）只允许名单中的玩家入座，名单保存在 room:<id>:reserved
+
+func reservedSeatsKey(roomID string) string {
+	return fmt.Sprintf("room:%s:reserved", roomID)
+}
+
+// ReserveSeats 房间只允许这些玩家入座，AI 由服务端加入，不受限制
+func ReserveSeats(roomID string, playerIDs []string) error {
+	if len(playerIDs) == 0 {
+		return nil
+	}
+	members := make([]interface{}, 0, len(playerIDs))
+	for _, id := range playerIDs {
+		members = append(members, id)
+	}
+	if err := repository.Rdb.SAdd(repository.Ctx, reservedSeatsKey(roomID), members...).Err(); err != nil {
+		return fmt.Errorf("保存房间[%s]座位名单失败: %w", roomID, err)
+	}
+	return nil
+}
+
+// 玩家能否入座：房间没有名单时都可以
+func seatReservedFor(roomID, playerID string) bool {
+	n, err := repository.Rdb.SCard(repository.Ctx, reservedSeatsKey(roomID)).Result()
+	if err != nil {
+		log.Println("❌ 读取座位名单失败:", err)
+		return true
+	}
+	if n == 0 {
+		return true
+	}
+	ok, err := repository.Rdb.SIsMember(repository.Ctx, reservedSeatsKey(roomID), playerID).Result()
+	return err != nil || ok
+}
@@ -10,7 +10,7 @@ import (
 	"github.com/go-redis/redis/v8"
 )
 
-func SetMergeSettleData(ctx context.Context, rdb *redis.Client, roomID string, data map[string]dto.SettleData) error {
+func SetMergeSettleData(ctx context.Context, rdb redis.Cmdable, roomID string, data map[string]dto.SettleData) error {
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("序列化 SettleData map 失败: %w", err)
@@ -23,7 +23,7 @@ func SetMergeSettleData(ctx context.Context, rdb *redis.Client, roomID string, d
 	return nil
 }
 
-func GetMergeSettleData(ctx context.Context, rdb *redis.Client, roomID string) (map[string]dto.SettleData, error) {
+func GetMergeSettleData(ctx context.Context, rdb redis.Cmdable, roomID string) (map[string]dto.SettleData, error) {
 	key := fmt.Sprintf("room:%s:merge_settle_temp", roomID)
 
 	result, err := rdb.Get(ctx, key).Result()
@@ -33,7 +33,10 @@ func GetMergeSettleData(ctx context.Context, rdb *redis.Client, roomID string) (
 		}
 		return nil, fmt.Errorf("从 Redis 获取数据失败: %w", err)
 	}
+	return parseMergeSettleData(result)
+}
 
+func parseMergeSettleData(result string) (map[string]dto.SettleData, error) {
 	var data map[string]dto.SettleData
 	if err := json.Unmarshal([]byte(result), &data); err != nil {
 		return nil, fmt.Errorf("反序列化 SettleData map 失败: %w", err)
@@ -42,7 +45,7 @@ func GetMergeSettleData(ctx context.Context, rdb *redis.Client, roomID string) (
 	return data, nil
 }
 
-func SetMergingSelection(rdb *redis.Client, ctx context.Context, roomID string, company entities.MergingSelection) error {
+func SetMergingSelection(rdb redis.Cmdable, ctx context.Context, roomID string, company entities.MergingSelection) error {
 	key := fmt.Sprintf("room:%s:merge_selection_temp", roomID)
 
 	// 将结构体序列化为 JSON
@@ -60,7 +63,7 @@ func SetMergingSelection(rdb *redis.Client, ctx context.Context, roomID string,
 }
 
 // GetMergeOtherCompanies 从Redis获取合并的其他公司列表
-func GetMergingSelection(rdb *redis.Client, ctx context.Context, roomID string) (entities.MergingSelection, error) {
+func GetMergingSelection(rdb redis.Cmdable, ctx context.Context, roomID string) (entities.MergingSelection, error) {
 	key := fmt.Sprintf("room:%s:merge_selection_temp", roomID)
 
 	var selection entities.MergingSelection
@@ -73,7 +76,11 @@ func GetMergingSelection(rdb *redis.Client, ctx context.Context, roomID string)
 		}
 		return selection, fmt.Errorf("❌ 获取合并选择失败: %w", err)
 	}
+	return parseMergingSelection(data)
+}
 
+func parseMergingSelection(data string) (entities.MergingSelection, error) {
+	var selection entities.MergingSelection
 	// 反序列化 JSON 到结构体
 	if err := json.Unmarshal([]byte(data), &selection); err != nil {
 		return selection, fmt.Errorf("❌ 解析合并选择 JSON 失败: %w", err)
@@ -83,7 +90,7 @@ func GetMergingSelection(rdb *redis.Client, ctx context.Context, roomID string)
 }
 
 // SetLastTileKey 保存刚才放置的tile
-func SetLastTileKey(rdb *redis.Client, ctx context.Context, roomID, playerID, tileKey string) error {
+func SetLastTileKey(rdb redis.Cmdable, ctx context.Context, roomID, playerID, tileKey string) error {
 	createTileKey := fmt.Sprintf("room:%s:last_tile_key_temp", roomID)
 	if err := rdb.Set(ctx, createTileKey, tileKey, 0).Err(); err != nil {
 		return fmt.Errorf("保存触发创建公司tile编号失败: %w", err)
@@ -92,7 +99,7 @@ func SetLastTileKey(rdb *redis.Client, ctx context.Context, roomID, playerID, ti
 }
 
 // GetLastTileKey 获取刚才放置的tile
-func GetLastTileKey(rdb *redis.Client, ctx context.Context, roomID string) (string, error) {
+func GetLastTileKey(rdb redis.Cmdable, ctx context.Context, roomID string) (string, error) {
 	createTileKey := fmt.Sprintf("room:%s:last_tile_key_temp", roomID)
 	tileKey, err := rdb.Get(ctx, createTileKey).Result()
 	if err != nil {
@@ -102,7 +109,7 @@ func GetLastTileKey(rdb *redis.Client, ctx context.Context, roomID string) (stri
 }
 
 // SetMergeMainCompany 设置合并的主公司名称
-func SetMergeMainCompany(rdb *redis.Client, ctx context.Context, roomID string, company string) error {
+func SetMergeMainCompany(rdb redis.Cmdable, ctx context.Context, roomID string, company string) error {
 	mainCompanyNameKey := fmt.Sprintf("room:%s:merge_main_company_temp", roomID)
 	if err := rdb.Set(ctx, mainCompanyNameKey, company, 0).Err(); err != nil {
 		return fmt.Errorf("设置合并主公司失败: %w", err)
@@ -111,7 +118,7 @@ func SetMergeMainCompany(rdb *redis.Client, ctx context.Context, roomID string,
 }
 
 // GetMergeMainCompany 从Redis获取合并的主公司名称
-func GetMergeMainCompany(rdb *redis.Client, ctx context.Context, roomID string) (string, error) {
+func GetMergeMainCompany(rdb redis.Cmdable, ctx context.Context, roomID string) (string, error) {
 	mainCompanyKey := fmt.Sprintf("room:%s:merge_main_company_temp", roomID)
 
 	// 从Redis获取主公司名称
@@ -11,7 +11,7 @@ import (
 )
 
 // GetTileFromRedis 获取指定房间的某个 tile 信息
-func GetTileFromRedis(rdb *redis.Client, ctx context.Context, roomID, tileKey string) (dto.Tile, error) {
+func GetTileFromRedis(rdb redis.Cmdable, ctx context.Context, roomID, tileKey string) (dto.Tile, error) {
 	redisKey := fmt.Sprintf("room:%s:tiles", roomID)
 	tileData, err := rdb.HGet(ctx, redisKey, tileKey).Result()
 	if err == redis.Nil {
@@ -23,13 +23,13 @@ func GetTileFromRedis(rdb *redis.Client, ctx context.Context, roomID, tileKey st
 	// 解析为结构体
 	var tile dto.Tile
 	if err := json.Unmarshal([]byte(tileData), &tile); err != nil {
-		return dto.Tile{}, fmt.Errorf("❌ 解析 Tile JSON 失败:", err)
+		return dto.Tile{}, fmt.Errorf("❌ 解析 Tile JSON 失败: %w", err)
 	}
 	return tile, nil
 }
 
 // UpdateTileValue 用于将某个 tile 对象整体写入 Redis（覆盖旧值）
-func UpdateTileValue(rdb *redis.Client, roomID string, tileKey string, updatedTile dto.Tile) error {
+func UpdateTileValue(rdb redis.Cmdable, roomID string, tileKey string, updatedTile dto.Tile) error {
 	// 编码为 JSON 字符串
 	updatedTileBytes, err := json.Marshal(updatedTile)
 	if err != nil {
@@ -46,9 +46,7 @@ func UpdateTileValue(rdb *redis.Client, roomID string, tileKey string, updatedTi
 }
 
 // 获取房间所有 tile 信息（key 为 tileID，value 为 Tile struct）
-func GetAllRoomTiles(rdb *redis.Client, roomID string) (map[string]dto.Tile, error) {
-	tileMap := make(map[string]dto.Tile)
-
+func GetAllRoomTiles(rdb redis.Cmdable, roomID string) (map[string]dto.Tile, error) {
 	// Redis Hash Key
 	key := fmt.Sprintf("room:%s:tiles", roomID)
 
@@ -58,7 +56,12 @@ func GetAllRoomTiles(rdb *redis.Client, roomID string) (map[string]dto.Tile, err
 		return nil, fmt.Errorf("获取房间牌堆失败: %w", err)
 	}
 
-	// 解码每个 tile 的 JSON 字符串
+	return parseRoomTiles(roomTiles), nil
+}
+
+// 解码每个 tile 的 JSON 字符串
+func parseRoomTiles(roomTiles map[string]string) map[string]dto.Tile {
+	tileMap := make(map[string]dto.Tile)
 	for tileID, value := range roomTiles {
 		var tileInfo dto.Tile
 		if err := json.Unmarshal([]byte(value), &tileInfo); err != nil {
@@ -66,11 +69,10 @@ func GetAllRoomTiles(rdb *redis.Client, roomID string) (map[string]dto.Tile, err
 		}
 		tileMap[tileID] = tileInfo
 	}
-
-	return tileMap, nil
+	return tileMap
 }
 
-func SetAllRoomTiles(rdb *redis.Client, roomID string, tiles map[string]dto.Tile) error {
+func SetAllRoomTiles(rdb redis.Cmdable, roomID string, tiles map[string]dto.Tile) error {
 	// 构建 Redis Hash 数据
 	hashData := make(map[string]interface{})
 	for tileID, tile := range tiles {
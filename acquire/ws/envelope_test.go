@@ -0,0 +1,133 @@
+package ws
+
+import (
+	"encoding/json"
+	"go-game/repository"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// 记录发出的消息
+type recordConn struct {
+	nopConn
+	sent [][]byte
+}
+
+func (c *recordConn) WriteMessage(_ int, data []byte) error {
+	c.sent = append(c.sent, data)
+	return nil
+}
+
+func TestHandleEnvelopeDedup(t *testing.T) {
+	type send struct {
+		playerID string
+		msg      string
+		executed bool // 是否应该执行命令
+	}
+	tests := []struct {
+		name  string
+		sends []send
+		calls int
+	}{
+		{
+			name: "重发同一 requestId 只执行一次",
+			sends: []send{
+				{"p0", `{"type":"test_ok","requestId":"r1"}`, true},
+				{"p0", `{"type":"test_ok","requestId":"r1"}`, false},
+			},
+			calls: 1,
+		},
+		{
+			name: "不同 requestId 各执行一次",
+			sends: []send{
+				{"p0", `{"type":"test_ok","requestId":"r1"}`, true},
+				{"p0", `{"type":"test_ok","requestId":"r2"}`, true},
+			},
+			calls: 2,
+		},
+		{
+			name: "没有 requestId 每次都执行",
+			sends: []send{
+				{"p0", `{"type":"test_ok"}`, true},
+				{"p0", `{"type":"test_ok"}`, true},
+			},
+			calls: 2,
+		},
+		{
+			name: "失败的命令重发时返回同样的错误",
+			sends: []send{
+				{"p0", `{"type":"test_fail","requestId":"r1"}`, true},
+				{"p0", `{"type":"test_fail","requestId":"r1"}`, false},
+			},
+			calls: 1,
+		},
+		{
+			name: "不同玩家的 requestId 互不影响",
+			sends: []send{
+				{"p0", `{"type":"test_ok","requestId":"r1"}`, true},
+				{"p1", `{"type":"test_ok","requestId":"r1"}`, true},
+			},
+			calls: 2,
+		},
+		{
+			name: "解码失败不执行也不保存结果",
+			sends: []send{
+				{"p0", `{"type":"test_ok","requestId":"r1"`, false},
+				{"p0", `{"type":"test_ok","requestId":"r1"}`, true},
+			},
+			calls: 1,
+		},
+	}
+
+	calls := 0
+	messageHandlers["test_ok"] = func(ReadWriteConn, redis.Cmdable, string, string, map[string]interface{}) error {
+		calls++
+		return nil
+	}
+	messageHandlers["test_fail"] = func(ReadWriteConn, redis.Cmdable, string, string, map[string]interface{}) error {
+		calls++
+		return commandError(CodeRejected, "不能这样做")
+	}
+	t.Cleanup(func() {
+		delete(messageHandlers, "test_ok")
+		delete(messageHandlers, "test_fail")
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			repository.Rdb = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+			calls = 0
+
+			var replies []string
+			for i, s := range tt.sends {
+				conn := &recordConn{}
+				_, executed := handleEnvelope(conn, "room1", s.playerID, []byte(s.msg))
+				if executed != s.executed {
+					t.Fatalf("第 %d 条消息执行 = %v，期望 %v", i+1, executed, s.executed)
+				}
+				if len(conn.sent) != 1 {
+					t.Fatalf("第 %d 条消息回复了 %d 条", i+1, len(conn.sent))
+				}
+				if !json.Valid(conn.sent[0]) {
+					t.Fatalf("回复不是 JSON: %s", conn.sent[0])
+				}
+				replies = append(replies, string(conn.sent[0]))
+			}
+			if calls != tt.calls {
+				t.Fatalf("命令执行了 %d 次，期望 %d 次", calls, tt.calls)
+			}
+			// 重发的消息收到和第一次相同的回复
+			for i, s := range tt.sends {
+				if s.executed || i == 0 {
+					continue
+				}
+				if replies[i] != replies[0] {
+					t.Fatalf("重发的回复 %s，第一次为 %s", replies[i], replies[0])
+				}
+			}
+		})
+	}
+}
@@ -2,28 +2,20 @@ package ws
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"go-game/dto"
+	"go-game/middleware"
 	"go-game/repository"
 	"log"
-	"sync"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/websocket"
 )
 
-// 房间内的所有连接（简化版）
-var Rooms = make(map[string][]dto.PlayerConn)
-var roomLock sync.Mutex
-
-func SwitchToNextPlayer(rdb *redis.Client, ctx context.Context, roomID, currentID string) error {
-	roomLock.Lock()
-	defer roomLock.Unlock()
-
-	players, ok := Rooms[roomID]
-	if !ok || len(players) == 0 {
+func SwitchToNextPlayer(rdb redis.Cmdable, ctx context.Context, roomID, currentID string) error {
+	players := playersOf(roomID)
+	if len(players) == 0 {
 		return fmt.Errorf("房间 %s 没有玩家", roomID)
 	}
 
@@ -44,31 +36,39 @@ func SwitchToNextPlayer(rdb *redis.Client, ctx context.Context, roomID, currentI
 	nextIndex := (currentIndex + 1) % len(players)
 	nextPlayerID := players[nextIndex].PlayerID
 
+	// 回合结束，记录各玩家的资产
+	recordNetWorth(roomID, currentID)
+
 	// 设置当前玩家
 	if err := SetCurrentPlayer(rdb, ctx, roomID, nextPlayerID); err != nil {
 		return fmt.Errorf("切换当前玩家失败: %w", err)
 	}
+	recordGameEvent(roomID, "turn_started", map[string]string{"playerID": nextPlayerID})
 
 	log.Printf("✅ 已将当前玩家切换为: %s\n", nextPlayerID)
 	return nil
 }
 
 // 玩家断开连接后，从房间中移除该连接
-func cleanupOnDisconnect(roomID, playerID string, conn *websocket.Conn) {
-	roomLock.Lock()
-	defer roomLock.Unlock()
-
+func cleanupOnDisconnect(roomID, playerID string, conn WriteOnlyConn) {
+	room := getRoom(roomID)
+	if room == nil {
+		return
+	}
 	// 遍历查找玩家，并标记为离线
-	for i, pc := range Rooms[roomID] {
-		if pc.PlayerID == playerID {
-			if pc.Conn == conn {
-				Rooms[roomID][i].Online = false
-				Rooms[roomID][i].Conn = nil // 连接置空，方便回收
-				log.Printf("玩家 %s 标记为离线\n", playerID)
+	room.updatePlayers(func(players []dto.PlayerConn) []dto.PlayerConn {
+		for i, pc := range players {
+			if pc.PlayerID == playerID {
+				if pc.Conn == conn {
+					players[i].Online = false
+					players[i].Conn = nil // 连接置空，方便回收
+					log.Printf("玩家 %s 标记为离线\n", playerID)
+				}
+				break
 			}
-			break
 		}
-	}
+		return players
+	})
 
 	roomInfo, err := GetRoomInfo(repository.Rdb, roomID)
 	if err != nil {
@@ -82,19 +82,49 @@ func cleanupOnDisconnect(roomID, playerID string, conn *websocket.Conn) {
 }
 
 // 消息处理函数类型
-type messageHandler func(conn ReadWriteConn, rdb *redis.Client, roomID, playerID string, msgMap map[string]interface{})
+type messageHandler func(conn ReadWriteConn, rdb redis.Cmdable, roomID, playerID string, msgMap map[string]interface{}) error
 
 // 消息处理函数映射
 var messageHandlers = map[string]messageHandler{
 	"ready":             handleReadyMessage,
+	"place_tile":        atomicAction(handlePlaceTileMessage),
+	"create_company":    atomicAction(handleCreateCompanyMessage),
+	"merging_settle":    atomicAction(handleMergingSettleMessage),
+	"buy_stock":         atomicAction(handleBuyStockMessage),
+	"merging_selection": atomicAction(handleMergingSelectionMessage),
+	"game_end":          recordGameEnd(atomicAction(handleGameEndMessage)),
+	"play_audio":        handlePlayAudioMessage,
+	"restart_game":      handleRestartGameMessage,
+	"rematch":           handleRematchMessage,
+	"vote":              handleVoteMessage,
+	"add_ai":            handleAddAIMessage,
+	"remove_ai":         handleRemoveAIMessage,
+	"replace_with_ai":   handleReplaceWithAIMessage,
+	"leave_room":        handleLeaveRoomMessage,
+	"forfeit":           handleForfeitMessage,
+	"kick_player":       handleKickPlayerMessage,
+	"chat":              handleChatMessage,
+	"mute_player":       handleMutePlayerMessage,
+	"unmute_player":     handleUnmutePlayerMessage,
+	"resync":            handleResyncMessage,
+}
+
+// 玩家操作，未经 atomicAction 包装。回放对局日志时按日志中的操作类型重新执行
+var gameCommands = map[string]messageHandler{
 	"place_tile":        handlePlaceTileMessage,
 	"create_company":    handleCreateCompanyMessage,
 	"merging_settle":    handleMergingSettleMessage,
 	"buy_stock":         handleBuyStockMessage,
 	"merging_selection": handleMergingSelectionMessage,
 	"game_end":          handleGameEndMessage,
-	"play_audio":        handlePlayAudioMessage,
-	"restart_game":      handleRestartGameMessage,
+}
+
+// 不改变游戏状态的消息，处理后不需要全量同步
+var noSyncMessages = map[string]bool{
+	"chat":          true,
+	"mute_player":   true,
+	"unmute_player": true,
+	"resync":        true,
 }
 
 // 持续监听客户端消息，并将其广播给房间内其他玩家
@@ -109,32 +139,58 @@ type ReadWriteConn interface {
 	ReadMessage() (messageType int, p []byte, err error)
 }
 
-// 修改listenAndBroadcastMessages签名，接收读写接口
-func listenAndBroadcastMessages(conn ReadWriteConn, roomID, playerID string) {
+// 持续读取客户端消息，转发给房间所属实例处理
+func listenAndForwardMessages(lc *localClient) {
 	for {
-		_, msg, err := conn.ReadMessage()
+		_, msg, err := lc.conn.ReadMessage()
 		if err != nil {
 			log.Println("读取消息失败:", err)
 			break
 		}
-		msgMap := make(map[string]interface{})
-		msgMap["playerID"] = playerID
-		if err := json.Unmarshal(msg, &msgMap); err != nil {
-			log.Println("消息解析失败:", err)
-			continue
+		if err := lc.forward(msg); err != nil {
+			log.Println("❌ 转发消息失败:", err)
 		}
-		if msgType, ok := msgMap["type"].(string); ok {
-			if handler, found := messageHandlers[msgType]; found {
-				handler(conn, repository.Rdb, roomID, playerID, msgMap)
-				BroadcastToRoom(roomID)
-			} else {
-				log.Printf("⚠️ 未知的消息类型: %s", msgType)
-			}
+	}
+}
+
+// 处理玩家消息并广播，在房间 goroutine 内执行
+func dispatchMessage(conn ReadWriteConn, roomID, playerID string, msg []byte) {
+	msgType, handled := handleEnvelope(conn, roomID, playerID, msg)
+	if handled && !noSyncMessages[msgType] {
+		BroadcastToRoom(roomID)
+	}
+}
+
+// 玩家或观战者加入房间，在房间 goroutine 内执行
+func joinRoom(conn ReadWriteConn, roomID, playerID, role string) {
+	// 观战者只接收公开信息
+	if role == RoleSpectator {
+		if err := joinAsSpectator(roomID, playerID, conn); err != nil {
+			sendErrorMessage(conn, err.Error())
+			conn.Close()
+			return
 		}
+		if err := syncSpectator(roomID, conn); err != nil {
+			log.Println("❌ 同步观战数据失败:", err)
+		}
+		return
+	}
+
+	if !seatReservedFor(roomID, playerID) {
+		sendErrorMessage(conn, "该房间的座位已指定")
+		conn.Close()
+		return
 	}
+	if !validateAndJoinRoom(roomID, playerID, conn) {
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"error","message":"房间已满"}`))
+		conn.Close()
+		return
+	}
+	BroadcastToRoom(roomID)
+	sendChatHistory(conn, roomID)
 }
 
-// WebSocket 主入口（处理每个连接）
+// WebSocket 主入口（处理每个连接）：/ws?roomID=...&token=...，需要登录
 func HandleWebSocket(c *gin.Context) {
 	conn, err := upgradeConnection(c)
 	if err != nil {
@@ -148,21 +204,40 @@ func HandleWebSocket(c *gin.Context) {
 		log.Println("缺少 roomID")
 		return
 	}
-	// 获取玩家 ID（从前端传来的 userId）
-	playerID := c.Query("userID")
-	if playerID == "" {
-		log.Println("缺少 userID")
+	// 玩家 ID 取自握手时鉴权的用户，房主操作等权限检查都以它为准；兼容仍然带 userID 的旧客户端
+	playerID := middleware.CurrentUser(c)
+	if userID := c.Query("userID"); userID != "" && userID != playerID {
+		sendErrorMessage(conn, "userID 与登录用户不一致")
+		return
+	}
+
+	if _, err := GetRoomInfo(repository.Rdb, roomID); err != nil {
+		sendErrorMessage(conn, "房间不存在")
 		return
 	}
 
-	// 尝试加入房间
-	ok := validateAndJoinRoom(roomID, playerID, conn)
+	role := RolePlayer
+	if c.Query("role") == RoleSpectator {
+		role = RoleSpectator
+	}
+
+	protocol, ok := acceptProtocol(conn, c.Query("protocol"))
 	if !ok {
-		conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"error","message":"房间已满"}`))
 		return
 	}
-	BroadcastToRoom(roomID)
-	// 离开时清理资源
-	defer cleanupOnDisconnect(roomID, playerID, conn)
-	listenAndBroadcastMessages(conn, roomID, playerID)
+
+	// 房间可能由其他实例运行，加入和后续消息都交给房间所属实例处理
+	lc := registerLocalClient(roomID, playerID, role, protocol, conn)
+	// 离开时通知房间所属实例清理
+	defer unregisterLocalClient(lc)
+	if _, err := lc.ensureJoined(); err != nil {
+		log.Printf("❌ 玩家 %s 加入房间 %s 失败: %v\n", playerID, roomID, err)
+		return
+	}
+
+	if role == RoleSpectator {
+		listenSpectatorMessages(conn)
+		return
+	}
+	listenAndForwardMessages(lc)
 }
@@ -5,33 +5,25 @@ import (
 	"fmt"
 	"go-game/dto"
 	"go-game/repository"
-	"go-game/utils"
 	"log"
-	"math/rand/v2"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/websocket"
 )
 
-func handlePlayAudioMessage(conn ReadWriteConn, rdb *redis.Client, roomID string, playerID string, msgMap map[string]interface{}) {
-	audioType, ok := msgMap["payload"].(string)
-	if !ok {
-		log.Println("❌ 消息格式错误")
-		return
+func handlePlayAudioMessage(conn ReadWriteConn, rdb redis.Cmdable, roomID string, playerID string, msgMap map[string]interface{}) error {
+	audioType, err := payloadOf[PlayAudioPayload](msgMap)
+	if err != nil {
+		return err
 	}
 
-	msg := map[string]interface{}{
-		"type":    "audio",
-		"message": audioType,
-	}
-	data, err := json.Marshal(msg)
+	data, err := json.Marshal(AudioMessage{Type: "audio", Message: string(audioType)})
 	if err != nil {
-		log.Println("❌ 编码 JSON 失败:", err)
-		return
+		return fmt.Errorf("编码 JSON 失败: %w", err)
 	}
 
-	for _, pc := range Rooms[roomID] {
+	for _, pc := range playersOf(roomID) {
 		if pc.Online && pc.Conn != nil {
 			err := pc.Conn.WriteMessage(websocket.TextMessage, data)
 			if err != nil {
@@ -39,62 +31,118 @@ func handlePlayAudioMessage(conn ReadWriteConn, rdb *redis.Client, roomID string
 			}
 		}
 	}
+	return nil
+}
+
+// 校验当前游戏状态是否允许发起或执行该投票，在原子操作中调用时读取操作中的数据
+func checkVoteAllowed(roomID, kind string) error {
+	roomInfo, err := GetRoomInfo(stateRdb(roomID), roomID)
+	if err != nil {
+		return fmt.Errorf("获取房间信息失败: %w", err)
+	}
+	ended := roomInfo.GameStatus == dto.RoomStatusEnd
+	switch kind {
+	case VoteKindRestart:
+		if roomInfo.GameStatus == dto.RoomStatusWaiting {
+			return fmt.Errorf("游戏尚未开始")
+		}
+		if ended {
+			return fmt.Errorf("游戏已结束，请发起再来一局")
+		}
+	case VoteKindRematch:
+		if !ended {
+			return fmt.Errorf("游戏尚未结束，无法再来一局")
+		}
+	default:
+		return fmt.Errorf("未知的投票类型: %s", kind)
+	}
+	return nil
 }
 
-func handleRestartGameMessage(conn ReadWriteConn, rdb *redis.Client, roomID string, playerID string, msgMap map[string]interface{}) {
+// 执行通过的投票：重开沿用本局起始玩家，再来一局轮换起始玩家（上一局在提交后归档，见 settleVote）
+func applyVote(roomID, kind string) error {
+	firstPlayer, err := GetFirstPlayer(stateRdb(roomID), repository.Ctx, roomID)
+	if err != nil {
+		return err
+	}
+	if kind == VoteKindRematch {
+		firstPlayer = nextSeatPlayer(roomID, firstPlayer)
+	}
+	return resetGameState(roomID, firstPlayer)
+}
+
+// 座位顺序中 playerID 的下一位，找不到时返回第一个座位
+func nextSeatPlayer(roomID, playerID string) string {
+	players := playersOf(roomID)
+	if len(players) == 0 {
+		return ""
+	}
+	for i, pc := range players {
+		if pc.PlayerID == playerID {
+			return players[(i+1)%len(players)].PlayerID
+		}
+	}
+	return players[0].PlayerID
+}
+
+// 保留座位，重置棋盘、公司和所有玩家数据，由 firstPlayer 开始新的一局
+func resetGameState(roomID, firstPlayer string) error {
+	rdb := stateRdb(roomID)
+	if err := newGameSeed(roomID); err != nil {
+		return err
+	}
+	if err := rdb.Del(repository.Ctx, timelineKey(roomID)).Err(); err != nil {
+		return fmt.Errorf("清除总资产走势失败: %w", err)
+	}
 	// 重置上次落子
-	if err := SetLastTileKey(rdb, repository.Ctx, roomID, playerID, ""); err != nil {
-		log.Println("❌ 设置最后放置的 tile 失败:", err)
-		return
+	if err := SetLastTileKey(rdb, repository.Ctx, roomID, firstPlayer, ""); err != nil {
+		return err
 	}
-	// 重置游戏状态
-	SetGameStatus(rdb, roomID, dto.RoomStatusSetTile)
 	// 重置tiles
 	tile, err := GetAllRoomTiles(rdb, roomID)
 	if err != nil {
-		log.Println("❌ 获取所有 tile 失败:", err)
-		return
+		return fmt.Errorf("获取所有 tile 失败: %w", err)
 	}
 	for tileKey, tileInfo := range tile {
 		tileInfo.Belong = ""
 		tile[tileKey] = tileInfo
 	}
-	err = SetAllRoomTiles(rdb, roomID, tile)
-	if err != nil {
-		log.Println("❌ 重置 tile 失败:", err)
-		return
+	if err := SetAllRoomTiles(rdb, roomID, tile); err != nil {
+		return fmt.Errorf("重置 tile 失败: %w", err)
 	}
 
-	for _, pc := range Rooms[roomID] {
+	// 先收回所有手牌，再重新发牌
+	for _, pc := range playersOf(roomID) {
+		if err := SetPlayerTiles(rdb, repository.Ctx, roomID, pc.PlayerID, []string{}); err != nil {
+			return err
+		}
+	}
+
+	companyIDs, err := getCompanyIDs(roomID)
+	if err != nil {
+		return fmt.Errorf("获取公司ID失败: %w", err)
+	}
+	for _, pc := range playersOf(roomID) {
 		playerID := pc.PlayerID
-		// 2. 设置初始资金
-		err = SetPlayerInfoField(repository.Rdb, repository.Ctx, roomID, playerID, "money", 6000)
+		// 设置初始资金
+		err = SetPlayerInfoField(rdb, repository.Ctx, roomID, playerID, "money", 6000)
 		if err != nil {
 			log.Println("设置玩家信息失败:", err)
 		}
 
-		allTiles, err := generateAvailableTiles(roomID)
+		playerTiles, err := drawTiles(roomID, 5)
 		if err != nil {
-			log.Println(err)
+			return err
 		}
-		rand.Shuffle(len(allTiles), func(i, j int) { allTiles[i], allTiles[j] = allTiles[j], allTiles[i] })
-		playerTiles := utils.SafeSlice(allTiles, 5)
-		err = SetPlayerTiles(repository.Rdb, repository.Ctx, roomID, playerID, playerTiles)
-		if err != nil {
-			log.Println(err)
+		if err := SetPlayerTiles(rdb, repository.Ctx, roomID, playerID, playerTiles); err != nil {
+			return err
 		}
-		companyIDs, err := getCompanyIDs(roomID)
-		if err != nil {
-			log.Println("获取公司ID失败:", err)
-			return
-		}
-		// 3.2 初始化玩家股票为0
+		// 初始化玩家股票为0
 		playerStocks := make(map[string]int)
 		for _, company := range companyIDs {
 			playerStocks[company] = 0
 		}
-		err = SetPlayerStocks(repository.Rdb, repository.Ctx, roomID, playerID, playerStocks)
-		if err != nil {
+		if err := SetPlayerStocks(rdb, repository.Ctx, roomID, playerID, playerStocks); err != nil {
 			log.Println("写入玩家股票失败:", err)
 		}
 	}
@@ -147,14 +195,20 @@ func handleRestartGameMessage(conn ReadWriteConn, rdb *redis.Client, roomID stri
 	for id, data := range companyData {
 		companyKey := fmt.Sprintf("room:%s:company:%s", roomID, id)
 		if _, err := rdb.HSet(repository.Ctx, companyKey, data).Result(); err != nil {
-			return
+			return fmt.Errorf("重置公司[%s]失败: %w", id, err)
 		}
 		rdb.SAdd(repository.Ctx, fmt.Sprintf("room:%s:company_ids", roomID), id)
 	}
+	// 新的一局写入新的对局日志
 	startKey := fmt.Sprintf("room:%s:game_start_time", roomID)
-	repository.Rdb.Set(repository.Ctx, startKey, time.Now().Format("20060102_150405"), 0)
+	rdb.Set(repository.Ctx, startKey, time.Now().Format("20060102_150405"), 0)
 
-	time.Sleep(2 * time.Second)
-
-	BroadcastToRoom(roomID)
+	if err := SetFirstPlayer(rdb, repository.Ctx, roomID, firstPlayer); err != nil {
+		return err
+	}
+	if err := SetCurrentPlayer(rdb, repository.Ctx, roomID, firstPlayer); err != nil {
+		return err
+	}
+	// 重置游戏状态
+	return SetGameStatus(rdb, roomID, dto.RoomStatusSetTile)
 }
@@ -1,21 +1,189 @@
 package ws
 
 import (
+	"encoding/json"
+	"fmt"
 	"go-game/dto"
+	"go-game/repository"
 	"log"
+	"sort"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
-func handleGameEndMessage(conn ReadWriteConn, rdb *redis.Client, roomID string, playerID string, msgMap map[string]interface{}) {
-	err := SetGameStatus(rdb, roomID, dto.RoomStatusEnd)
+// 对局结束后的排名
+type Standing struct {
+	Rank     int    `json:"rank"`
+	PlayerID string `json:"playerID"`
+	Money    int    `json:"money"`
+	Total    int    `json:"total"` // 现金 + 股票市值
+}
+
+// 公司达到该规模即可宣布结束
+const endChainSize = 41
+
+// 公司达到该规模后不会被并购
+const safeChainSize = 11
+
+// 当前玩家在自己的回合宣布结束，排名和对局结果在提交成功后由 recordGameEnd 写入
+func handleGameEndMessage(conn ReadWriteConn, rdb redis.Cmdable, roomID string, playerID string, msgMap map[string]interface{}) error {
+	if err := checkGameEnd(rdb, roomID, playerID); err != nil {
+		return err
+	}
+	if err := SetGameStatus(rdb, roomID, dto.RoomStatusEnd); err != nil {
+		return fmt.Errorf("设置游戏状态失败: %w", err)
+	}
+	return nil
+}
+
+// 校验是否满足结束条件：有公司达到 41 块，或棋盘上的公司都已安全（11 块及以上）
+func checkGameEnd(rdb redis.Cmdable, roomID string, playerID string) error {
+	roomInfo, err := GetRoomInfo(rdb, roomID)
+	if err != nil {
+		return fmt.Errorf("获取房间信息失败: %w", err)
+	}
+	if roomInfo.GameStatus == dto.RoomStatusEnd {
+		return fmt.Errorf("游戏已结束")
+	}
+	currentPlayer, err := GetCurrentPlayer(rdb, repository.Ctx, roomID)
+	if err != nil {
+		return fmt.Errorf("获取当前玩家失败: %w", err)
+	}
+	if currentPlayer != playerID {
+		return fmt.Errorf("不是当前玩家的回合")
+	}
+
+	tiles, err := GetAllRoomTiles(rdb, roomID)
 	if err != nil {
-		log.Println("Error setting game status:", err)
-		return
+		return fmt.Errorf("获取所有 tile 失败: %w", err)
+	}
+	chainSizes := make(map[string]int)
+	for _, tile := range tiles {
+		if tile.Belong != "" && tile.Belong != "Blank" {
+			chainSizes[tile.Belong]++
+		}
+	}
+	if len(chainSizes) == 0 {
+		return fmt.Errorf("棋盘上还没有公司，不能结束游戏")
+	}
+	allSafe := true
+	for _, size := range chainSizes {
+		if size >= endChainSize {
+			return nil
+		}
+		if size < safeChainSize {
+			allSafe = false
+		}
 	}
+	if !allSafe {
+		return fmt.Errorf("没有公司达到 %d 块，且仍有公司不足 %d 块，不能结束游戏", endChainSize, safeChainSize)
+	}
+	return nil
+}
+
+// 结束请求提交成功后记录排名和对局结果
+func recordGameEnd(h messageHandler) messageHandler {
+	return func(conn ReadWriteConn, rdb redis.Cmdable, roomID string, playerID string, msgMap map[string]interface{}) error {
+		if err := h(conn, rdb, roomID, playerID, msgMap); err != nil {
+			return err
+		}
+		logGameEnded(roomID)
+		log.Println("✅ 对局日志:", gameLogKey(gameLogID(roomID)))
+		return nil
+	}
+}
 
-	logPath := getGameLogFilePath(roomID)
-	log.Println("✅ 游戏日志保存于:", logPath)
+// 超时没有打完的对局按当前局面结算并结束。返回对局是否开始过，开始过的对局由结束流程记录结果
+func callAbandonGame(roomID string, _ json.RawMessage) (interface{}, error) {
+	if !isGameStarted(roomID) {
+		return false, nil
+	}
+	if !isGameEnded(roomID) {
+		if err := SetGameStatus(stateRdb(roomID), roomID, dto.RoomStatusEnd); err != nil {
+			return nil, fmt.Errorf("设置游戏状态失败: %w", err)
+		}
+		logGameAbandoned(roomID)
+		BroadcastToRoom(roomID)
+	}
+	return true, nil
+}
+
+// 按总资产计算当前排名
+func calcStandings(roomID string) ([]Standing, error) {
+	companyInfoMap, err := GetCompanyInfo(stateRdb(roomID), roomID)
+	if err != nil {
+		return nil, fmt.Errorf("获取公司信息失败: %w", err)
+	}
+	playerIDs, err := seatPlayerIDs(roomID)
+	if err != nil {
+		return nil, err
+	}
+	standings := make([]Standing, 0, len(playerIDs))
+	for _, playerID := range playerIDs {
+		playerStocks, err := GetPlayerStocks(stateRdb(roomID), repository.Ctx, roomID, playerID)
+		if err != nil {
+			return nil, err
+		}
+		playerInfo, err := GetPlayerInfoField(stateRdb(roomID), repository.Ctx, roomID, playerID, "money")
+		if err != nil {
+			return nil, err
+		}
+		standings = append(standings, Standing{
+			PlayerID: playerID,
+			Money:    playerInfo.Money,
+			Total:    CalculateTotalValue(playerStocks, companyInfoMap) + playerInfo.Money,
+		})
+	}
+	sort.SliceStable(standings, func(i, j int) bool {
+		return standings[i].Total > standings[j].Total
+	})
+	for i := range standings {
+		standings[i].Rank = i + 1
+		if i > 0 && standings[i].Total == standings[i-1].Total {
+			standings[i].Rank = standings[i-1].Rank
+		}
+	}
+	return standings, nil
+}
+
+// 归档已结束的对局：日志已经在 Redis 中，这里把最终排名写入 game_logs:standings
+func archiveFinishedGame(roomID string) error {
+	gameID, data, err := finishedGameArchive(roomID)
+	if err != nil {
+		return err
+	}
+	return saveGameArchive(gameID, data)
+}
+
+// 按房间当前数据生成对局的归档内容，返回对局 ID 和编码后的最终排名
+func finishedGameArchive(roomID string) (string, []byte, error) {
+	standings, err := calcStandings(roomID)
+	if err != nil {
+		return "", nil, err
+	}
+	gameID := gameLogID(roomID)
+	data, err := json.Marshal(map[string]interface{}{
+		"roomID":     roomID,
+		"archivedAt": time.Now().Format("2006-01-02 15:04:05"),
+		"log":        gameID,
+		"standings":  standings,
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("序列化排名失败: %w", err)
+	}
+	return gameID, data, nil
+}
+
+func saveGameArchive(gameID string, data []byte) error {
+	if err := repository.Rdb.HSet(repository.Ctx, archivedStandingsKey, gameID, data).Err(); err != nil {
+		return fmt.Errorf("写入排名失败: %w", err)
+	}
+	log.Println("✅ 对局已归档:", gameID)
+	return nil
+}
 
-	BroadcastToRoom(roomID)
+// 对局历史中记录的得分：总资产
+func standingScore(s Standing) int {
+	return s.Total
 }
@@ -0,0 +1,286 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"go-game/dto"
+	"go-game/repository"
+	"log"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 对局日志回放校验：对局结束后在沙盒房间中按日志重新执行每条玩家操作。
+// 对局数据只在内存中读写（从记录中保存的原始数据开始，见 stateTx），随机数由同样的种子和计数产生，
+// 每条操作产生的事件和之后的对局状态都必须与日志一致。不一致说明规则依赖了日志之外的输入
+// （全局随机数、时间、map 遍历顺序等），或者日志本身有缺失
+
+// 沙盒房间 ID 的前缀，沙盒房间不写对局日志、不记录战绩
+const replayRoomPrefix = "replay:"
+
+var replaySeq atomic.Int64
+
+// 回放校验的结果计数：verified 一致，diverged 不一致，skipped 日志中没有原始数据，errors 读取失败
+var replayMetrics = expvar.NewMap("game_replay")
+
+var errReplayNoKeys = errors.New("对局日志中没有对局数据的原始内容，无法回放")
+
+// stateSnapshot 对局数据 key 的原始内容，key 为 room:<id>: 之后的部分，不存在的 key 不保存
+type stateSnapshot map[string]json.RawMessage
+
+func isReplayRoom(roomID string) bool {
+	return strings.HasPrefix(roomID, replayRoomPrefix)
+}
+
+// 回放时丢弃所有消息的连接
+type replayConn struct{}
+
+func (replayConn) WriteMessage(int, []byte) error { return nil }
+
+func (replayConn) ReadMessage() (int, []byte, error) {
+	return 0, nil, fmt.Errorf("回放连接不可读")
+}
+
+func (replayConn) Close() error { return nil }
+
+// 读取房间当前的对局数据 key
+func snapshotState(roomID string) (stateSnapshot, error) {
+	ctx := repository.Ctx
+	keys := roomStateKeys(roomID)
+	pipe := stateRdb(roomID).Pipeline()
+	cmds := make([]redis.Cmder, len(keys))
+	for i, key := range keys {
+		cmds[i] = readStateKey(ctx, pipe, key, stateKeyType(roomID, key))
+	}
+	pipe.Exec(ctx)
+
+	prefix := "room:" + roomID + ":"
+	snapshot := make(stateSnapshot, len(keys))
+	for i, key := range keys {
+		if err := cmds[i].Err(); err != nil && err != redis.Nil {
+			return nil, fmt.Errorf("读取房间数据失败: %w", err)
+		}
+		v := parseStateKey(stateKeyType(roomID, key), cmds[i])
+		if !v.exists() {
+			continue
+		}
+		data, err := json.Marshal(v.snapshot())
+		if err != nil {
+			return nil, fmt.Errorf("编码房间数据失败: %w", err)
+		}
+		snapshot[strings.TrimPrefix(key, prefix)] = data
+	}
+	return snapshot, nil
+}
+
+// 按类型编码：string 为字符串，hash 为对象，list 为数组，set 为排好序的数组
+func (v *stateValue) snapshot() interface{} {
+	switch v.kind {
+	case stateString:
+		return v.str
+	case stateHash:
+		return v.hash
+	case stateList:
+		return v.list
+	default:
+		members := make([]string, 0, len(v.set))
+		for m := range v.set {
+			members = append(members, m)
+		}
+		sort.Strings(members)
+		return members
+	}
+}
+
+// 从快照还原一个 key
+func restoreStateValue(kind string, data json.RawMessage) (*stateValue, error) {
+	v := parseStateKey(kind, nil)
+	switch kind {
+	case stateString:
+		v.isSet = true
+		return v, json.Unmarshal(data, &v.str)
+	case stateHash:
+		return v, json.Unmarshal(data, &v.hash)
+	case stateList:
+		return v, json.Unmarshal(data, &v.list)
+	default:
+		var members []string
+		if err := json.Unmarshal(data, &members); err != nil {
+			return nil, err
+		}
+		for _, m := range members {
+			v.set[m] = struct{}{}
+		}
+		return v, nil
+	}
+}
+
+// 回放用的事务：对局数据全部来自快照，快照中没有的 key 视为不存在，改动不写回 Redis
+func newReplayTx(roomID string, snapshot stateSnapshot) (*stateTx, error) {
+	tx := &stateTx{roomID: roomID, values: make(map[string]*stateValue, len(snapshot))}
+	tx.stateCmds = stateCmds{Cmdable: repository.Rdb, tx: tx}
+	for name, data := range snapshot {
+		key := fmt.Sprintf("room:%s:%s", roomID, name)
+		kind := stateKeyType(roomID, key)
+		if kind == "" {
+			return nil, fmt.Errorf("未知的对局数据 key: %s", name)
+		}
+		v, err := restoreStateValue(kind, data)
+		if err != nil {
+			return nil, fmt.Errorf("解析对局数据[%s]失败: %w", name, err)
+		}
+		tx.values[key] = v
+	}
+	return tx, nil
+}
+
+// 对局结束后在后台回放校验对局日志，结果写入日志和 game_replay 计数
+func verifyGameLog(gameID string) {
+	records, err := readGameLog(gameID)
+	if err != nil {
+		log.Println("❌ 读取对局日志失败:", err)
+		replayMetrics.Add("errors", 1)
+		return
+	}
+	go func() {
+		err := replayGameLog(records)
+		switch {
+		case err == nil:
+			replayMetrics.Add("verified", 1)
+			log.Println("✅ 对局日志回放一致:", gameID)
+		case errors.Is(err, errReplayNoKeys):
+			replayMetrics.Add("skipped", 1)
+			log.Printf("⚠️ 对局日志 %s 无法回放: %v\n", gameID, err)
+		default:
+			replayMetrics.Add("diverged", 1)
+			log.Printf("❌ 对局日志 %s 回放不一致: %v\n", gameID, err)
+		}
+	}()
+}
+
+// 在沙盒房间中重新执行对局日志，返回第一处与日志不一致的地方
+func replayGameLog(records []GameLogRecord) error {
+	roomID := fmt.Sprintf("%s%d", replayRoomPrefix, replaySeq.Add(1))
+	room := &Room{
+		ID:   roomID,
+		cmds: make(chan func(), roomCommandBuffer),
+		quit: make(chan struct{}),
+	}
+	roomsMu.Lock()
+	replayRooms[roomID] = room
+	roomsMu.Unlock()
+	go room.run()
+	defer func() {
+		roomsMu.Lock()
+		delete(replayRooms, roomID)
+		roomsMu.Unlock()
+		room.stop()
+		// 操作中写到 Redis 的非对局数据（如开局时间），没有时返回错误，忽略即可
+		DeleteRoomKeys(roomID)
+	}()
+
+	// 规则代码 panic 时房间 goroutine 会恢复，err 保持初始值
+	err := fmt.Errorf("回放中断")
+	if !room.Call(func() { err = replayRecords(room, records) }) {
+		return fmt.Errorf("回放房间已关闭")
+	}
+	return err
+}
+
+// 依次处理日志记录：带原始数据的记录从记录中的数据重新开始，操作记录重新执行，之后的对局状态与日志比较
+func replayRecords(room *Room, records []GameLogRecord) error {
+	defer room.tx.Store(nil)
+	var want interface{}
+	replaying := false
+	for _, record := range records {
+		var err error
+		if want, err = applyGameRecord(want, record); err != nil {
+			return err
+		}
+		switch {
+		case record.Keys != nil:
+			if err := resetReplayRoom(room, record); err != nil {
+				return err
+			}
+			replaying = true
+		case !replaying:
+			continue
+		case record.Type == GameLogCommand:
+			if err := replayCommand(room, record); err != nil {
+				return err
+			}
+		}
+		got, err := gameStateDoc(room.ID)
+		if err != nil {
+			return fmt.Errorf("第 %d 条记录: %w", record.Seq, err)
+		}
+		if ops := diffJSON("", want, got, nil); len(ops) > 0 {
+			return fmt.Errorf("第 %d 条记录之后对局状态不一致: %s %s，共 %d 处", record.Seq, ops[0].Op, ops[0].Path, len(ops))
+		}
+		// 线上每次变化之后都会广播，广播中对派生数据的更新也要重现
+		BroadcastToRoom(room.ID)
+	}
+	if !replaying {
+		return errReplayNoKeys
+	}
+	return nil
+}
+
+// 按记录中的座位和原始数据重置沙盒房间
+func resetReplayRoom(room *Room, record GameLogRecord) error {
+	tx, err := newReplayTx(room.ID, record.Keys)
+	if err != nil {
+		return fmt.Errorf("第 %d 条记录: %w", record.Seq, err)
+	}
+	seats := make([]dto.PlayerConn, 0, len(record.Players))
+	for _, playerID := range record.Players {
+		seats = append(seats, dto.PlayerConn{PlayerID: playerID})
+	}
+	room.mu.Lock()
+	room.players = seats
+	room.mu.Unlock()
+	room.tx.Store(tx)
+	return nil
+}
+
+// 重新执行一条操作，产生的事件必须与日志中的一致
+func replayCommand(room *Room, record GameLogRecord) error {
+	handler, ok := gameCommands[record.Command]
+	if !ok {
+		return fmt.Errorf("第 %d 条记录: 未知的操作 %s", record.Seq, record.Command)
+	}
+	msgMap := map[string]interface{}{"type": record.Command}
+	if record.Payload != nil {
+		var payload interface{}
+		if err := json.Unmarshal(record.Payload, &payload); err != nil {
+			return fmt.Errorf("第 %d 条记录: 解析操作内容失败: %w", record.Seq, err)
+		}
+		msgMap["payload"] = payload
+	}
+
+	discardGameEvents(room.ID)
+	if err := handler(replayConn{}, stateRdb(room.ID), room.ID, record.PlayerID, msgMap); err != nil {
+		return fmt.Errorf("第 %d 条记录: 重新执行 %s 失败: %w", record.Seq, record.Command, err)
+	}
+	room.gameLog.mu.Lock()
+	events := room.gameLog.events
+	room.gameLog.events = nil
+	room.gameLog.mu.Unlock()
+
+	if len(events) != len(record.Events) {
+		return fmt.Errorf("第 %d 条记录: 重新执行 %s 产生 %d 个事件，日志中为 %d 个", record.Seq, record.Command, len(events), len(record.Events))
+	}
+	for i, event := range events {
+		if event.Type != record.Events[i].Type || !bytes.Equal(event.Data, record.Events[i].Data) {
+			return fmt.Errorf("第 %d 条记录: 第 %d 个事件不一致: %s %s，日志中为 %s %s", record.Seq, i+1,
+				event.Type, event.Data, record.Events[i].Type, record.Events[i].Data)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-game/dto"
+	"go-game/repository"
+	"log"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/websocket"
+)
+
+// 玩家离开房间的原因
+const (
+	LeaveReasonLeave   = "leave"
+	LeaveReasonKick    = "kick"
+	LeaveReasonForfeit = "forfeit"
+)
+
+// 通知房间内所有在线玩家（包括离开者本人）有玩家离开
+func notifyPlayerLeft(players []dto.PlayerConn, playerID, reason string) {
+	data, err := json.Marshal(PlayerLeftMessage{
+		Type:     "player_left",
+		PlayerID: playerID,
+		Reason:   reason,
+	})
+	if err != nil {
+		log.Println("❌ 编码 JSON 失败:", err)
+		return
+	}
+	for _, pc := range players {
+		if pc.Online && pc.Conn != nil {
+			if err := pc.Conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				log.Printf("❌ 向玩家 %s 发送离开通知失败: %v\n", pc.PlayerID, err)
+			}
+		}
+	}
+}
+
+// 认输：股票退回银行，手牌退回牌堆，并退出待处理的并购结算
+func forfeitPlayerAssets(rdb redis.Cmdable, roomID, playerID string) error {
+	roomInfo, err := GetRoomInfo(rdb, roomID)
+	if err != nil {
+		return err
+	}
+	if roomInfo.GameStatus == dto.RoomStatusMergingSettle {
+		// 以"不卖不换"的方式完成该玩家的结算，必要时会触发并购收尾
+		err := handleMergingSettleMessage(nil, rdb, roomID, playerID, map[string]interface{}{
+			"payload": []interface{}{},
+		})
+		if err != nil {
+			log.Printf("❌ 完成玩家[%s]的并购结算失败: %v\n", playerID, err)
+		}
+	}
+	// 公司剩余股数在广播时按玩家持股重新计算，删除持股即退回银行；
+	// 可用 tile 按房间内玩家手牌计算，删除手牌即退回牌堆
+	return deletePlayerData(roomID, playerID)
+}
+
+// RemovePlayerFromRoom 将玩家移出房间。游戏进行中离开视为认输，并重建出牌顺序。
+// 座位、玩家数据、回合和房主的改动在一个原子操作中提交，失败时座位恢复原样
+func RemovePlayerFromRoom(roomID, playerID, reason string) error {
+	started := isGameStarted(roomID)
+
+	room := getRoom(roomID)
+	if room == nil {
+		return fmt.Errorf("房间[%s]不存在", roomID)
+	}
+	players := room.Players()
+	index := -1
+	for i, pc := range players {
+		if pc.PlayerID == playerID {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return fmt.Errorf("房间中没有玩家 %s", playerID)
+	}
+	leaving := players[index]
+	// 离开的是当前玩家时，由下一位玩家接手
+	nextPlayerID := ""
+	if len(players) > 1 {
+		nextPlayerID = players[(index+1)%len(players)].PlayerID
+	}
+	remaining := make([]dto.PlayerConn, 0, len(players)-1)
+	remaining = append(remaining, players[:index]...)
+	remaining = append(remaining, players[index+1:]...)
+	if started && reason == LeaveReasonLeave {
+		reason = LeaveReasonForfeit
+	}
+
+	// 操作开始时按原来的座位读入对局数据，离开者的 key 也在事务中
+	err := runAtomicAction(roomID, nil, func() error {
+		rdb := stateRdb(roomID)
+		room.updatePlayers(func([]dto.PlayerConn) []dto.PlayerConn {
+			return remaining
+		})
+		if started {
+			if err := forfeitPlayerAssets(rdb, roomID, playerID); err != nil {
+				return err
+			}
+			if err := handleTurnAfterLeave(rdb, roomID, playerID, nextPlayerID, len(remaining)); err != nil {
+				return err
+			}
+		} else {
+			if err := deletePlayerData(roomID, playerID); err != nil {
+				return err
+			}
+			if err := SetRoomStatus(rdb, roomID, false); err != nil {
+				log.Println("❌ 设置房间状态失败:", err)
+			}
+		}
+		if err := transferHostIfNeeded(rdb, roomID, playerID, remaining); err != nil {
+			log.Println("❌ 转移房主失败:", err)
+		}
+		return nil
+	})
+	if err != nil {
+		// 把离开者放回原来的位置
+		room.updatePlayers(func(current []dto.PlayerConn) []dto.PlayerConn {
+			i := index
+			if i > len(current) {
+				i = len(current)
+			}
+			return append(current[:i], append([]dto.PlayerConn{leaving}, current[i:]...)...)
+		})
+		return err
+	}
+
+	if started {
+		logPlayerLeft(roomID, playerID, reason)
+		if len(remaining) < 2 {
+			logGameEnded(roomID)
+		}
+	}
+	notifyPlayerLeft(append(remaining, leaving), playerID, reason)
+	log.Printf("玩家 %s 离开房间 %s（%s）\n", playerID, roomID, reason)
+	return nil
+}
+
+// 离开后调整回合：人数不足直接结束游戏，当前玩家/起始玩家离开则由下一位接替
+func handleTurnAfterLeave(rdb redis.Cmdable, roomID, playerID, nextPlayerID string, remainingCount int) error {
+	if remainingCount < 2 {
+		return SetGameStatus(rdb, roomID, dto.RoomStatusEnd)
+	}
+
+	firstPlayer, err := GetFirstPlayer(rdb, repository.Ctx, roomID)
+	if err != nil {
+		return err
+	}
+	if firstPlayer == playerID {
+		if err := SetFirstPlayer(rdb, repository.Ctx, roomID, nextPlayerID); err != nil {
+			return err
+		}
+	}
+
+	currentPlayer, err := GetCurrentPlayer(rdb, repository.Ctx, roomID)
+	if err != nil {
+		return err
+	}
+	if currentPlayer != playerID {
+		return nil
+	}
+	if err := SetCurrentPlayer(rdb, repository.Ctx, roomID, nextPlayerID); err != nil {
+		return err
+	}
+
+	roomInfo, err := GetRoomInfo(rdb, roomID)
+	if err != nil {
+		return err
+	}
+	// 并购结算要等其他股东完成，其余状态下一位玩家从放置 tile 开始
+	if roomInfo.GameStatus != dto.RoomStatusMergingSettle && roomInfo.GameStatus != dto.RoomStatusEnd {
+		if err := SetLastTileKey(rdb, repository.Ctx, roomID, nextPlayerID, ""); err != nil {
+			return err
+		}
+		return SetGameStatus(rdb, roomID, dto.RoomStatusSetTile)
+	}
+	return nil
+}
+
+// 房主离开时把房主转给下一位真人玩家
+func transferHostIfNeeded(rdb redis.Cmdable, roomID, playerID string, remaining []dto.PlayerConn) error {
+	roomInfo, err := GetRoomInfo(rdb, roomID)
+	if err != nil {
+		return err
+	}
+	if roomInfo.UserID != playerID {
+		return nil
+	}
+	for _, pc := range remaining {
+		if !IsAIPlayer(pc.PlayerID) {
+			roomInfo.UserID = pc.PlayerID
+			return SetRoomInfo(rdb, repository.Ctx, roomID, *roomInfo)
+		}
+	}
+	return nil
+}
+
+func handleLeaveRoomMessage(conn ReadWriteConn, rdb redis.Cmdable, roomID, playerID string, msgMap map[string]interface{}) error {
+	if err := RemovePlayerFromRoom(roomID, playerID, LeaveReasonLeave); err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
+}
+
+func handleForfeitMessage(conn ReadWriteConn, rdb redis.Cmdable, roomID, playerID string, msgMap map[string]interface{}) error {
+	if !isGameStarted(roomID) {
+		return commandError(CodeRejected, "游戏尚未开始，无法认输")
+	}
+	return handleLeaveRoomMessage(conn, rdb, roomID, playerID, msgMap)
+}
+
+func handleKickPlayerMessage(conn ReadWriteConn, rdb redis.Cmdable, roomID, playerID string, msgMap map[string]interface{}) error {
+	targetID, err := payloadOf[KickPlayerPayload](msgMap)
+	if err != nil {
+		return err
+	}
+	if targetID == "" {
+		return commandError(CodeBadPayload, "缺少玩家 ID")
+	}
+	return KickPlayer(roomID, playerID, string(targetID))
+}
+
+// KickPlayer 房主将玩家踢出房间
+func KickPlayer(roomID, operatorID, targetID string) error {
+	if err := checkRoomHost(roomID, operatorID); err != nil {
+		return err
+	}
+	if operatorID == targetID {
+		return fmt.Errorf("不能踢出自己")
+	}
+	targetConn, _ := GetConn(roomID, targetID)
+	if err := RemovePlayerFromRoom(roomID, targetID, LeaveReasonKick); err != nil {
+		return err
+	}
+	if targetConn != nil {
+		targetConn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-game/dto"
+	"go-game/repository"
+	"log"
+	"sync"
+	"time"
+)
+
+// 大厅房间列表：房间创建、座位或状态变化、删除时推送给订阅了房间列表的大厅连接。
+// 变化在发生的实例上合并，lobbyFlushDelay 后读取一次房间信息，再经集群广播给所有实例，
+// 各实例按连接的筛选条件投递
+const (
+	lobbyRoomCreated = "room_created"
+	lobbyRoomUpdated = "room_updated"
+	lobbyRoomClosed  = "room_closed"
+	lobbyFlushDelay  = 500 * time.Millisecond
+)
+
+var errRoomGone = errors.New("房间不存在")
+
+// RoomListMessage 订阅房间列表后先发送一次符合条件的全部房间
+type RoomListMessage struct {
+	Type         string         `json:"type"`
+	Rooms        []dto.RoomInfo `json:"rooms"`
+	OnlinePlayer int            `json:"onlinePlayer"`
+}
+
+// RoomEventMessage 房间创建、变化或删除，room_closed 不带 room。
+// 房间变化后不再符合筛选条件时也发送 room_closed，重新符合时发送 room_updated
+type RoomEventMessage struct {
+	Type         string        `json:"type"`
+	RoomID       string        `json:"roomID"`
+	Room         *dto.RoomInfo `json:"room,omitempty"`
+	OnlinePlayer int           `json:"onlinePlayer"`
+}
+
+// RoomListItem 房间列表中的一个房间，座位、等级分和观战人数
+func RoomListItem(roomID string) (*dto.RoomInfo, error) {
+	n, err := repository.Rdb.Exists(repository.Ctx, fmt.Sprintf("room:%s:roomInfo", roomID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("获取房间信息失败: %w", err)
+	}
+	if n == 0 {
+		return nil, errRoomGone
+	}
+	roomInfo, err := roomInfoOf(roomID)
+	if err != nil {
+		return nil, err
+	}
+	seats, err := GetSeats(roomID)
+	if err != nil {
+		return nil, err
+	}
+	fillSeatRatings(roomID, seats)
+	return &dto.RoomInfo{
+		RoomID:         roomID,
+		UserID:         roomInfo.UserID,
+		MaxPlayers:     roomInfo.MaxPlayers,
+		Status:         roomInfo.RoomStatus,
+		Private:        roomInfo.Private,
+		RoomPlayer:     seats,
+		SpectatorCount: GetSpectatorCount(roomID),
+	}, nil
+}
+
+// RoomList 符合筛选条件的房间。房间和座位都从 Redis 读取，服务重启后进行中的房间仍然可见，
+// 多实例部署时各实例看到的列表一致
+func RoomList(filter dto.RoomFilter) ([]dto.RoomInfo, error) {
+	roomIDs, err := ScanRoomIDs()
+	if err != nil {
+		return nil, err
+	}
+	rooms := make([]dto.RoomInfo, 0, len(roomIDs))
+	for _, roomID := range roomIDs {
+		room, err := RoomListItem(roomID)
+		if errors.Is(err, errRoomGone) {
+			continue
+		}
+		if err != nil {
+			log.Printf("❌ 读取房间 %s 失败: %v\n", roomID, err)
+			continue
+		}
+		if filter.Match(*room) {
+			rooms = append(rooms, *room)
+		}
+	}
+	return rooms, nil
+}
+
+// OnlinePlayerCount 所有房间中在线的玩家数（含 AI）
+func OnlinePlayerCount() (int, error) {
+	roomIDs, err := ScanRoomIDs()
+	if err != nil {
+		return 0, err
+	}
+	online := 0
+	for _, roomID := range roomIDs {
+		seats, err := GetSeats(roomID)
+		if err != nil {
+			return 0, err
+		}
+		for _, seat := range seats {
+			if seat.Online {
+				online++
+			}
+		}
+	}
+	return online, nil
+}
+
+// 房间列表中显示玩家的等级分，查询失败时不显示
+func fillSeatRatings(roomID string, seats []dto.RoomPlayer) {
+	playerIDs := make([]string, 0, len(seats))
+	for _, seat := range seats {
+		playerIDs = append(playerIDs, seat.PlayerID)
+	}
+	ratings, err := SeatRatings(roomID, playerIDs)
+	if err != nil {
+		log.Println("❌ 获取等级分失败:", err)
+		return
+	}
+	for i := range seats {
+		seats[i].Rating = ratings[seats[i].PlayerID]
+	}
+}
+
+var (
+	roomChanges   = make(map[string]string) // roomID -> 待推送的事件
+	roomChangesMu sync.Mutex
+)
+
+// 记录房间变化，lobbyFlushDelay 内的多次变化合并为一次推送。删除优先于创建，创建优先于更新
+func markRoomChanged(roomID, event string) {
+	if isReplayRoom(roomID) {
+		return
+	}
+	roomChangesMu.Lock()
+	defer roomChangesMu.Unlock()
+	switch roomChanges[roomID] {
+	case lobbyRoomClosed:
+		return
+	case lobbyRoomCreated:
+		if event == lobbyRoomUpdated {
+			return
+		}
+	}
+	roomChanges[roomID] = event
+}
+
+// 定期推送合并后的房间变化，随集群一起启动
+func lobbyLoop() {
+	ticker := time.NewTicker(lobbyFlushDelay)
+	defer ticker.Stop()
+	for range ticker.C {
+		flushRoomChanges()
+	}
+}
+
+func flushRoomChanges() {
+	roomChangesMu.Lock()
+	changes := roomChanges
+	roomChanges = make(map[string]string)
+	roomChangesMu.Unlock()
+	if len(changes) == 0 {
+		return
+	}
+
+	online, err := OnlinePlayerCount()
+	if err != nil {
+		log.Println("❌ 获取在线玩家失败:", err)
+	}
+	for roomID, event := range changes {
+		msg := RoomEventMessage{Type: event, RoomID: roomID, OnlinePlayer: online}
+		if event != lobbyRoomClosed {
+			room, err := RoomListItem(roomID)
+			switch {
+			case errors.Is(err, errRoomGone):
+				msg.Type = lobbyRoomClosed
+			case err != nil:
+				log.Printf("❌ 读取房间 %s 失败: %v\n", roomID, err)
+				continue
+			default:
+				msg.Room = room
+			}
+		}
+		data, err := json.Marshal(msg)
+		if err != nil {
+			log.Println("❌ 编码 JSON 失败:", err)
+			continue
+		}
+		broadcastToInstances(clusterEnvelope{
+			Kind:   clusterKindLobbyRoom,
+			RoomID: roomID,
+			Data:   string(data),
+		})
+	}
+}
+
+// 按筛选条件投递给当前实例上订阅了房间列表的大厅连接
+func deliverRoomEvent(data []byte) {
+	var msg RoomEventMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		log.Println("❌ 解析房间事件失败:", err)
+		return
+	}
+	closed, err := json.Marshal(RoomEventMessage{Type: lobbyRoomClosed, RoomID: msg.RoomID, OnlinePlayer: msg.OnlinePlayer})
+	if err != nil {
+		log.Println("❌ 编码 JSON 失败:", err)
+		return
+	}
+
+	lobbyClientsMu.Lock()
+	defer lobbyClientsMu.Unlock()
+	for _, conns := range lobbyClients {
+		for _, lc := range conns {
+			if lc.filter == nil {
+				continue
+			}
+			if msg.Room != nil && lc.filter.Match(*msg.Room) {
+				lc.visible[msg.RoomID] = true
+				lc.write(data)
+				continue
+			}
+			// 只通知之前看得到这个房间的连接
+			if lc.visible[msg.RoomID] {
+				delete(lc.visible, msg.RoomID)
+				lc.write(closed)
+			}
+		}
+	}
+}
+
+// 订阅房间列表，之后按筛选条件推送房间变化。先登记再读取当前列表，避免漏掉期间的变化
+func subscribeRooms(lc *lobbyConn, filter dto.RoomFilter) error {
+	lobbyClientsMu.Lock()
+	lc.filter = &filter
+	lc.visible = make(map[string]bool)
+	lobbyClientsMu.Unlock()
+
+	rooms, err := RoomList(filter)
+	if err != nil {
+		return err
+	}
+	online, err := OnlinePlayerCount()
+	if err != nil {
+		return err
+	}
+	lobbyClientsMu.Lock()
+	for _, room := range rooms {
+		lc.visible[room.RoomID] = true
+	}
+	lobbyClientsMu.Unlock()
+	return writeJSON(lc.conn, RoomListMessage{Type: "room_list", Rooms: rooms, OnlinePlayer: online})
+}
+
+func unsubscribeRooms(lc *lobbyConn) {
+	lobbyClientsMu.Lock()
+	lc.filter = nil
+	lc.visible = nil
+	lobbyClientsMu.Unlock()
+}
@@ -0,0 +1,115 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"go-game/repository"
+	"log"
+	"time"
+)
+
+// 对局结束时把结果写入对局历史数据库（repository.Matches），对局 ID 与对局日志相同。
+// 之后通知 OnMatchEnded 注册的处理函数，如锦标赛据此记录成绩、安排下一轮
+
+// 目前只有标准规则
+const rulesVariant = "standard"
+
+const saveMatchTimeout = 5 * time.Second
+
+var matchEndedHandlers []func(*repository.Match)
+
+// OnMatchEnded 注册对局结束后的处理函数，启动时调用。处理函数在新的 goroutine 中执行，不占用房间 goroutine
+func OnMatchEnded(fn func(*repository.Match)) {
+	matchEndedHandlers = append(matchEndedHandlers, fn)
+}
+
+var gameStartedHandlers []func(roomID string)
+
+// OnGameStarted 注册开局时的处理函数，启动时调用。处理函数在房间 goroutine 中同步执行，玩家看到牌局之前完成，应尽快返回
+func OnGameStarted(fn func(roomID string)) {
+	gameStartedHandlers = append(gameStartedHandlers, fn)
+}
+
+func notifyGameStarted(roomID string) {
+	for _, fn := range gameStartedHandlers {
+		fn(roomID)
+	}
+}
+
+// 保存已结束对局的结果。座位和开局时间以对局日志中的 game_started 为准，中途离开的玩家也会记录
+func saveMatchResult(roomID string, standings []Standing, abandoned bool) {
+	gameID := gameLogID(roomID)
+	endedAt := time.Now()
+	match := &repository.Match{
+		ID:        gameID,
+		Game:      gameName,
+		RoomID:    roomID,
+		Variant:   rulesVariant,
+		StartedAt: endedAt.UnixMilli(),
+		EndedAt:   endedAt.UnixMilli(),
+		Abandoned: abandoned,
+	}
+
+	var seats []string
+	records, err := readGameLog(gameID)
+	if err != nil {
+		log.Println("❌ 读取对局日志失败:", err)
+	}
+	for _, record := range records {
+		if record.Type == GameLogStarted {
+			match.StartedAt = record.Time
+			seats = record.Players
+			break
+		}
+	}
+	if seats == nil {
+		for _, s := range standings {
+			seats = append(seats, s.PlayerID)
+		}
+	}
+	match.DurationSec = (match.EndedAt - match.StartedAt) / 1000
+
+	finished := make(map[string]Standing, len(standings))
+	for _, s := range standings {
+		finished[s.PlayerID] = s
+	}
+	for seat, playerID := range seats {
+		player := repository.MatchPlayer{PlayerID: playerID, Seat: seat, AI: IsAIPlayer(playerID)}
+		if s, ok := finished[playerID]; ok {
+			player.Rank = s.Rank
+			player.Score = standingScore(s)
+			if player.Detail, err = json.Marshal(s); err != nil {
+				log.Println("❌ 编码对局结果失败:", err)
+			}
+		} else {
+			player.Rank = len(standings) + 1
+			player.Left = true
+		}
+		match.Players = append(match.Players, player)
+	}
+	if len(standings) > 0 {
+		match.Winner = standings[0].PlayerID
+	}
+	// 中途放弃的对局不计入等级分
+	if match.Rated = !abandoned && isRatedMatch(match); match.Rated {
+		// AI 以所选难度的固定等级分参与计算
+		for i, p := range match.Players {
+			if p.AI {
+				match.Players[i].RatingBefore = botRating(roomID, p.PlayerID)
+			}
+		}
+	}
+
+	if repository.Matches != nil {
+		ctx, cancel := context.WithTimeout(repository.Ctx, saveMatchTimeout)
+		defer cancel()
+		if err := repository.Matches.SaveMatch(ctx, match, rateMatch); err != nil {
+			log.Printf("❌ 保存对局 %s 的结果失败: %v\n", match.ID, err)
+		} else {
+			log.Println("✅ 对局结果已保存:", match.ID)
+		}
+	}
+	for _, fn := range matchEndedHandlers {
+		go fn(match)
+	}
+}
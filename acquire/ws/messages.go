@@ -0,0 +1,198 @@
+package ws
+
+import (
+	"encoding/json"
+	"go-game/dto"
+	"go-game/entities"
+	"reflect"
+	"strconv"
+)
+
+const gameName = "acquire"
+
+// GameName 游戏类型，匹配队列据此校验请求
+const GameName = gameName
+
+// 每局的人数范围
+const (
+	MinPlayers = 2
+	MaxPlayers = 6
+)
+
+// 客户端各类消息的 payload。分发时按消息类型解码，格式不对直接回复 bad_payload，不会进入处理函数
+
+// PlaceTilePayload place_tile：要放置的 tile，如 "5C"
+type PlaceTilePayload string
+
+// CreateCompanyPayload create_company：要创建的公司
+type CreateCompanyPayload string
+
+// MergingSelectionPayload merging_selection：并购时留下的公司
+type MergingSelectionPayload string
+
+// MergingSettlePayload merging_settle：各被并购公司股票的卖出、兑换数量
+type MergingSettlePayload []dto.MergingSettleItem
+
+// BuyStockPayload buy_stock：公司 -> 购买股数
+type BuyStockPayload map[string]int
+
+// PlayAudioPayload play_audio：音效类型
+type PlayAudioPayload string
+
+// VotePayload vote：是否同意
+type VotePayload bool
+
+// KickPlayerPayload kick_player：要踢出的玩家 ID
+type KickPlayerPayload string
+
+// ChatPayload chat：聊天内容
+type ChatPayload string
+
+// MutePayload mute_player / unmute_player：玩家 ID，或 {"playerID": "...", "seconds": 60}
+type MutePayload struct {
+	PlayerID string `json:"playerID"`
+	Seconds  int    `json:"seconds"`
+}
+
+func (p *MutePayload) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &p.PlayerID); err == nil {
+		return nil
+	}
+	var v struct {
+		PlayerID string      `json:"playerID"`
+		Seconds  json.Number `json:"seconds"` // 兼容字符串形式的秒数
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	p.PlayerID = v.PlayerID
+	if v.Seconds != "" {
+		seconds, err := strconv.Atoi(v.Seconds.String())
+		if err != nil {
+			return err
+		}
+		p.Seconds = seconds
+	}
+	return nil
+}
+
+func (MutePayload) jsonSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": "string"},
+			map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"playerID": map[string]interface{}{"type": "string"},
+					"seconds":  map[string]interface{}{"type": []string{"integer", "string"}},
+				},
+			},
+		},
+	}
+}
+
+// AISeatPayload add_ai / remove_ai / replace_with_ai：玩家 ID，或 {"playerID": "...", "difficulty": "hard"}
+type AISeatPayload struct {
+	PlayerID   string `json:"playerID"`
+	Difficulty string `json:"difficulty"`
+}
+
+func (p *AISeatPayload) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &p.PlayerID); err == nil {
+		return nil
+	}
+	type plain AISeatPayload
+	return json.Unmarshal(data, (*plain)(p))
+}
+
+func (AISeatPayload) jsonSchema() map[string]interface{} {
+	type plain AISeatPayload
+	return map[string]interface{}{
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": "string"},
+			schemaOf(typeOf[plain](), false),
+		},
+	}
+}
+
+// 消息类型 -> payload 类型，没有 payload 的消息为 nil
+var messagePayloads = map[string]*payloadSpec{
+	"ready":             nil,
+	"place_tile":        payloadAs[PlaceTilePayload](),
+	"create_company":    payloadAs[CreateCompanyPayload](),
+	"merging_settle":    payloadAs[MergingSettlePayload](),
+	"buy_stock":         payloadAs[BuyStockPayload](),
+	"merging_selection": payloadAs[MergingSelectionPayload](),
+	"game_end":          nil,
+	"play_audio":        payloadAs[PlayAudioPayload](),
+	"restart_game":      nil,
+	"rematch":           nil,
+	"vote":              payloadAs[VotePayload](),
+	"add_ai":            optionalPayloadAs[AISeatPayload](),
+	"remove_ai":         payloadAs[AISeatPayload](),
+	"replace_with_ai":   payloadAs[AISeatPayload](),
+	"leave_room":        nil,
+	"forfeit":           nil,
+	"kick_player":       payloadAs[KickPlayerPayload](),
+	"chat":              payloadAs[ChatPayload](),
+	"mute_player":       payloadAs[MutePayload](),
+	"unmute_player":     payloadAs[MutePayload](),
+	"resync":            nil,
+}
+
+// SyncMessage sync：玩家视角的完整同步数据，公开数据 + 自己的现金、股票和手牌
+type SyncMessage struct {
+	Type       string          `json:"type"`
+	Seq        int64           `json:"seq,omitempty"`      // 同步流序号，由 sendSync 填写
+	Result     map[string]int  `json:"result"`             // 各玩家总资产
+	Timeline   []NetWorthPoint `json:"timeline,omitempty"` // 总资产走势，仅对局结束后发送
+	PlayerID   string          `json:"playerId"`
+	PlayerData SyncPlayerData  `json:"playerData"`
+	RoomData   SyncRoomData    `json:"roomData"`
+	TempData   SyncTempData    `json:"tempData"`
+}
+
+type SyncPlayerData struct {
+	Info   map[string]string `json:"info"`
+	Stocks map[string]int    `json:"stocks"`
+	Tiles  []string          `json:"tiles"`
+}
+
+// SyncRoomData 房间内所有人（包括观战者）都能看到的数据
+type SyncRoomData struct {
+	CompanyInfo   map[string]entities.CompanyInfo `json:"companyInfo"`
+	CurrentPlayer string                          `json:"currentPlayer"`
+	RoomInfo      *entities.RoomInfo              `json:"roomInfo"`
+	Tiles         map[string]dto.Tile             `json:"tiles"`
+	Version       int64                           `json:"version"` // 客户端操作时带上，用于拒绝过期操作
+}
+
+// SyncTempData 并购等多步操作的中间数据
+type SyncTempData struct {
+	LastTileKey          string                    `json:"last_tile_key"`
+	MergeMainCompanyTemp string                    `json:"merge_main_company_temp"`
+	MergeSelectionTemp   entities.MergingSelection `json:"merge_selection_temp"`
+	MergeSettleData      map[string]dto.SettleData `json:"mergeSettleData"`
+}
+
+// SpectatorSyncMessage sync：观战者看到的同步数据，不包含任何玩家的手牌和现金
+type SpectatorSyncMessage struct {
+	Type     string                     `json:"type"`
+	Role     string                     `json:"role"`
+	Result   map[string]int             `json:"result"`
+	Timeline []NetWorthPoint            `json:"timeline,omitempty"` // 总资产走势，仅对局结束后发送
+	Players  map[string]SpectatorPlayer `json:"players"`
+	RoomData SyncRoomData               `json:"roomData"`
+	TempData SyncTempData               `json:"tempData"`
+}
+
+type SpectatorPlayer struct {
+	Online    bool           `json:"online"`
+	Stocks    map[string]int `json:"stocks"`
+	TileCount int            `json:"tileCount"`
+}
+
+// 本游戏特有的服务端消息，通用消息见 protocol.go
+var gameOutboundMessages = map[string][]reflect.Type{
+	"sync": {typeOf[SyncMessage](), typeOf[SpectatorSyncMessage]()},
+}
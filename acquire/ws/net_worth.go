@@ -0,0 +1,135 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-game/dto"
+	"go-game/repository"
+	"log"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 总资产走势：每位玩家的回合结束时记录一次各玩家的现金、股票市值和总资产（对局日志中的 net_worth 事件），
+// 对局结束时再以最终排名补上最后一个点。结束后的 sync 消息和回放接口都会返回完整走势
+
+// NetWorth 玩家某一时刻的资产
+type NetWorth struct {
+	Cash   int `json:"cash"`
+	Stocks int `json:"stocks"` // 按当前股价计算的股票市值
+	Total  int `json:"total"`
+}
+
+// NetWorthPoint 走势中的一个点
+type NetWorthPoint struct {
+	Turn     int                 `json:"turn"`
+	PlayerID string              `json:"playerID,omitempty"` // 刚结束回合的玩家，最终结算时为空
+	Time     int64               `json:"time"`               // 毫秒时间戳
+	Final    bool                `json:"final,omitempty"`
+	Players  map[string]NetWorth `json:"players"`
+}
+
+// 回合结束时记录各玩家的资产
+func recordNetWorth(roomID, playerID string) {
+	state, err := loadRoomState(roomID, connPlayerIDs(playersOf(roomID)))
+	if err != nil {
+		log.Println("❌ 读取房间数据失败:", err)
+		return
+	}
+	state.refreshCompanies()
+	recordGameEvent(roomID, "net_worth", map[string]interface{}{
+		"playerID": playerID,
+		"players":  state.netWorths(),
+	})
+}
+
+// 从对局日志中整理总资产走势
+func netWorthTimeline(records []GameLogRecord) []NetWorthPoint {
+	timeline := make([]NetWorthPoint, 0)
+	for _, record := range records {
+		for _, event := range record.Events {
+			switch event.Type {
+			case "net_worth":
+				var data struct {
+					PlayerID string              `json:"playerID"`
+					Players  map[string]NetWorth `json:"players"`
+				}
+				if err := json.Unmarshal(event.Data, &data); err != nil {
+					continue
+				}
+				timeline = append(timeline, NetWorthPoint{
+					Turn:     len(timeline) + 1,
+					PlayerID: data.PlayerID,
+					Time:     record.Time,
+					Players:  data.Players,
+				})
+			case "game_ended":
+				var data struct {
+					Standings []Standing `json:"standings"`
+				}
+				if err := json.Unmarshal(event.Data, &data); err != nil {
+					continue
+				}
+				players := make(map[string]NetWorth, len(data.Standings))
+				for _, s := range data.Standings {
+					players[s.PlayerID] = NetWorth{Cash: s.Money, Stocks: s.Total - s.Money, Total: s.Total}
+				}
+				timeline = append(timeline, NetWorthPoint{
+					Turn:    len(timeline) + 1,
+					Time:    record.Time,
+					Final:   true,
+					Players: players,
+				})
+			}
+		}
+	}
+	return timeline
+}
+
+func timelineKey(roomID string) string {
+	return fmt.Sprintf("room:%s:timeline", roomID)
+}
+
+// 对局结束后读取本局的总资产走势，随 sync 消息发送。结束后第一次读取时从对局日志整理一次，
+// 写入 room:<id>:timeline，之后的广播直接读取；新的一局开始时删除（见 resetGameState）
+func (s *roomState) loadTimeline() {
+	if s.RoomInfo == nil || s.RoomInfo.GameStatus != dto.RoomStatusEnd || isReplayRoom(s.RoomID) {
+		return
+	}
+	ctx := repository.Ctx
+	key := timelineKey(s.RoomID)
+	data, err := repository.Rdb.Get(ctx, key).Bytes()
+	if err == nil && json.Unmarshal(data, &s.Timeline) == nil {
+		return
+	}
+	if err != nil && err != redis.Nil {
+		log.Println("❌ 读取总资产走势失败:", err)
+	}
+
+	records, err := readGameLog(gameLogID(s.RoomID))
+	if err != nil {
+		log.Println("❌ 读取对局日志失败:", err)
+		return
+	}
+	s.Timeline = netWorthTimeline(records)
+	// 结束记录还没写入时不缓存，下次读取再整理
+	if n := len(s.Timeline); n == 0 || !s.Timeline[n-1].Final {
+		return
+	}
+	if data, err = json.Marshal(s.Timeline); err != nil {
+		log.Println("❌ 编码总资产走势失败:", err)
+		return
+	}
+	if err := repository.Rdb.Set(ctx, key, data, 0).Err(); err != nil {
+		log.Println("❌ 保存总资产走势失败:", err)
+	}
+}
+
+// NetWorthTimeline 已结束对局的总资产走势
+func NetWorthTimeline(gameID string) ([]NetWorthPoint, error) {
+	_, records, err := LoadReplay(gameID)
+	if err != nil {
+		return nil, err
+	}
+	return netWorthTimeline(records), nil
+}
@@ -7,12 +7,12 @@ import (
 	"go-game/repository"
 	"go-game/utils"
 	"log"
-	"math/rand/v2"
+	"sort"
 )
 
 func generateAvailableTiles(roomID string) ([]string, error) {
 	ctx := repository.Ctx
-	rdb := repository.Rdb
+	rdb := stateRdb(roomID)
 
 	// 获取所有 tile 的占用信息
 	tileKey := fmt.Sprintf("room:%s:tiles", roomID)
@@ -22,7 +22,7 @@ func generateAvailableTiles(roomID string) ([]string, error) {
 	}
 
 	playerTiles := make(map[string]struct{})
-	for _, pc := range Rooms[roomID] {
+	for _, pc := range playersOf(roomID) {
 		tiles, err := GetPlayerTiles(rdb, ctx, roomID, pc.PlayerID)
 		if err != nil {
 			log.Printf("❌ 获取玩家 %s 的 tiles 失败: %v\n", pc.PlayerID, err)
@@ -53,10 +53,26 @@ func generateAvailableTiles(roomID string) ([]string, error) {
 	return available, nil
 }
 
+// 从牌堆中随机抽取 n 张 tile，不足 n 张时全部抽出
+func drawTiles(roomID string, n int) ([]string, error) {
+	allTiles, err := generateAvailableTiles(roomID)
+	if err != nil {
+		return nil, err
+	}
+	// 按 ID 排序后再洗牌，同样的随机数抽到同样的 tile
+	sort.Strings(allTiles)
+	r, err := gameRand(roomID)
+	if err != nil {
+		return nil, err
+	}
+	r.Shuffle(len(allTiles), func(i, j int) { allTiles[i], allTiles[j] = allTiles[j], allTiles[i] })
+	return utils.SafeSlice(allTiles, n), nil
+}
+
 // 初始化玩家数据
 func InitPlayerData(roomID string, playerID string) error {
 	// 1. 检查玩家数据是否已存在
-	exists, err := IsPlayerInfoExists(repository.Rdb, repository.Ctx, roomID, playerID)
+	exists, err := IsPlayerInfoExists(stateRdb(roomID), repository.Ctx, roomID, playerID)
 	if err != nil {
 		log.Println(err)
 		return err
@@ -65,20 +81,17 @@ func InitPlayerData(roomID string, playerID string) error {
 		return fmt.Errorf("玩家数据已存在")
 	}
 	// 2. 设置初始资金
-	err = SetPlayerInfoField(repository.Rdb, repository.Ctx, roomID, playerID, "money", 6000)
+	err = SetPlayerInfoField(stateRdb(roomID), repository.Ctx, roomID, playerID, "money", 6000)
 	if err != nil {
 		log.Println("设置玩家信息失败:", err)
 	}
 
 	// 2. 随机抽取起始 Tiles（比如每人 5 个）
-	allTiles, err := generateAvailableTiles(roomID)
+	playerTiles, err := drawTiles(roomID, 5)
 	if err != nil {
 		return err
 	}
-	rand.Shuffle(len(allTiles), func(i, j int) { allTiles[i], allTiles[j] = allTiles[j], allTiles[i] })
-
-	playerTiles := utils.SafeSlice(allTiles, 5)
-	err = SetPlayerTiles(repository.Rdb, repository.Ctx, roomID, playerID, playerTiles)
+	err = SetPlayerTiles(stateRdb(roomID), repository.Ctx, roomID, playerID, playerTiles)
 	if err != nil {
 		log.Println(err)
 	}
@@ -94,7 +107,7 @@ func InitPlayerData(roomID string, playerID string) error {
 	for _, company := range companyIDs {
 		playerStocks[company] = 0
 	}
-	err = SetPlayerStocks(repository.Rdb, repository.Ctx, roomID, playerID, playerStocks)
+	err = SetPlayerStocks(stateRdb(roomID), repository.Ctx, roomID, playerID, playerStocks)
 	if err != nil {
 		log.Println("写入玩家股票失败:", err)
 	}
@@ -0,0 +1,248 @@
+package ws
+
+import (
+	"encoding/json"
+	"log"
+	"reflect"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+)
+
+// 协议版本，客户端连接 /ws 时通过 protocol 参数声明：
+//
+//	1  不带 protocol 参数的旧客户端：每次都收到完整的 sync，命令成功时不回复 ack
+//	2  消息信封（见 envelope.go）：命令回复 ack/error，sync 之后收到增量 patch（见 sync_delta.go）
+//
+// 不在支持范围内的版本在连接时回复 unsupported_protocol 错误并断开。
+// 各消息的结构见 GET /protocol/schema，由下面的消息类型生成
+const (
+	ProtocolVersion    = 2
+	MinProtocolVersion = 1
+)
+
+// 解析客户端声明的协议版本，未声明时按最早的版本处理
+func negotiateProtocol(param string) (int, error) {
+	if param == "" {
+		return MinProtocolVersion, nil
+	}
+	version, err := strconv.Atoi(param)
+	if err != nil {
+		return 0, commandError(CodeUnsupportedProtocol, "协议版本格式错误: %s", param)
+	}
+	if version < MinProtocolVersion || version > ProtocolVersion {
+		return 0, commandError(CodeUnsupportedProtocol, "不支持的协议版本 %d，服务器支持 %d-%d", version, MinProtocolVersion, ProtocolVersion)
+	}
+	return version, nil
+}
+
+// 连接使用的协议版本。房主实例上的玩家连接都是 remoteConn；AI 的虚拟连接等按最新版本处理
+func connProtocol(conn interface{}) int {
+	switch c := conn.(type) {
+	case remoteConn:
+		if c.Protocol == 0 {
+			// 旧版本实例转发来的连接
+			return MinProtocolVersion
+		}
+		return c.Protocol
+	case *deferredCloseConn:
+		return connProtocol(c.ReadWriteConn)
+	}
+	return ProtocolVersion
+}
+
+// HelloMessage 协议协商成功后发给客户端的第一条消息（协议 2 起）
+type HelloMessage struct {
+	Type        string `json:"type"`
+	Game        string `json:"game"`
+	Protocol    int    `json:"protocol"` // 本连接使用的版本
+	MinProtocol int    `json:"minProtocol"`
+	MaxProtocol int    `json:"maxProtocol"`
+}
+
+// AckReply 命令执行成功
+type AckReply struct {
+	Type      string `json:"type"`
+	For       string `json:"for"` // 命令的消息类型
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// ErrorReply 命令执行失败，或连接、加入房间时的错误提示
+type ErrorReply struct {
+	Type      string `json:"type"`
+	For       string `json:"for,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+	Code      string `json:"code,omitempty"`
+	Message   string `json:"message"`
+	Version   *int64 `json:"version,omitempty"` // code 为 conflict 时的最新房间版本号
+}
+
+// PatchMessage 相对上一条同步数据的增量
+type PatchMessage struct {
+	Type string    `json:"type"`
+	Seq  int64     `json:"seq"`
+	Ops  []patchOp `json:"ops"`
+}
+
+// ChatBroadcastMessage 房间内的新聊天消息
+type ChatBroadcastMessage struct {
+	Type    string      `json:"type"`
+	Message ChatMessage `json:"message"`
+}
+
+// ChatHistoryMessage 进入房间时补发的聊天记录
+type ChatHistoryMessage struct {
+	Type     string        `json:"type"`
+	Messages []ChatMessage `json:"messages"`
+}
+
+// PlayerMutedMessage 玩家被禁言，seconds <= 0 表示直到解除禁言
+type PlayerMutedMessage struct {
+	Type     string `json:"type"`
+	PlayerID string `json:"playerID"`
+	Seconds  int    `json:"seconds"`
+}
+
+// PlayerUnmutedMessage 玩家被解除禁言
+type PlayerUnmutedMessage struct {
+	Type     string `json:"type"`
+	PlayerID string `json:"playerID"`
+}
+
+// PlayerLeftMessage 玩家离开房间
+type PlayerLeftMessage struct {
+	Type     string `json:"type"`
+	PlayerID string `json:"playerID"`
+	Reason   string `json:"reason"`
+}
+
+// VoteMessage 投票发起或有人投票后的投票状态
+type VoteMessage struct {
+	Type string    `json:"type"`
+	Vote *roomVote `json:"vote"`
+}
+
+// VoteResultMessage 投票结束
+type VoteResultMessage struct {
+	Type   string `json:"type"`
+	Kind   string `json:"kind"`
+	Passed bool   `json:"passed"`
+	Reason string `json:"reason"`
+}
+
+// AudioMessage 播放音效
+type AudioMessage struct {
+	Type    string `json:"type"`
+	Message string `json:"message"` // 音效类型
+}
+
+// ServerRestartMessage 服务器即将重启，客户端稍后重连
+type ServerRestartMessage struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// 服务端发出的消息类型 -> 消息结构，同一类型有多种结构时（如玩家和观战者的 sync）都列出
+func outboundMessages() map[string][]reflect.Type {
+	messages := map[string][]reflect.Type{
+		"hello":            {typeOf[HelloMessage]()},
+		"ack":              {typeOf[AckReply]()},
+		"error":            {typeOf[ErrorReply]()},
+		"patch":            {typeOf[PatchMessage]()},
+		"chat":             {typeOf[ChatBroadcastMessage]()},
+		"chat_history":     {typeOf[ChatHistoryMessage]()},
+		"player_muted":     {typeOf[PlayerMutedMessage]()},
+		"player_unmuted":   {typeOf[PlayerUnmutedMessage]()},
+		"player_left":      {typeOf[PlayerLeftMessage]()},
+		"vote":             {typeOf[VoteMessage]()},
+		"vote_result":      {typeOf[VoteResultMessage]()},
+		"audio":            {typeOf[AudioMessage]()},
+		"server_restart":   {typeOf[ServerRestartMessage]()},
+		"replay_info":      {typeOf[ReplayInfoMessage]()},
+		"replay_state":     {typeOf[ReplayStateMessage]()},
+		"match_found":      {typeOf[MatchFoundMessage]()},
+		"tournament_table": {typeOf[TournamentTableMessage]()},
+		"room_list":        {typeOf[RoomListMessage]()},
+		"room_created":     {typeOf[RoomEventMessage]()},
+		"room_updated":     {typeOf[RoomEventMessage]()},
+		"room_closed":      {typeOf[RoomEventMessage]()},
+	}
+	for msgType, types := range gameOutboundMessages {
+		messages[msgType] = types
+	}
+	return messages
+}
+
+// ProtocolSchema 客户端与服务端之间所有 websocket 消息的 JSON Schema
+func ProtocolSchema() map[string]interface{} {
+	inbound := make(map[string]interface{}, len(messagePayloads))
+	for msgType, spec := range messagePayloads {
+		properties := map[string]interface{}{
+			"type":      map[string]interface{}{"const": msgType},
+			"requestId": map[string]interface{}{"type": "string"},
+			"version":   map[string]interface{}{"type": "integer"},
+		}
+		required := []string{"type"}
+		if spec != nil {
+			properties["payload"] = schemaOf(spec.typ, false)
+			if !spec.optional {
+				required = append(required, "payload")
+			}
+		}
+		inbound[msgType] = map[string]interface{}{
+			"type":       "object",
+			"properties": properties,
+			"required":   required,
+		}
+	}
+
+	outbound := make(map[string]interface{})
+	for msgType, types := range outboundMessages() {
+		if len(types) == 1 {
+			outbound[msgType] = messageSchema(msgType, types[0], true)
+			continue
+		}
+		variants := make([]interface{}, 0, len(types))
+		for _, t := range types {
+			variants = append(variants, messageSchema(msgType, t, true))
+		}
+		outbound[msgType] = map[string]interface{}{"oneOf": variants}
+	}
+
+	return map[string]interface{}{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"game":        gameName,
+		"protocol":    ProtocolVersion,
+		"minProtocol": MinProtocolVersion,
+		"inbound":     inbound,
+		"outbound":    outbound,
+	}
+}
+
+// 协商连接的协议版本：不支持时回复错误并断开，协议 2 起先发送 hello
+func acceptProtocol(conn *Client, param string) (int, bool) {
+	protocol, err := negotiateProtocol(param)
+	if err != nil {
+		data, _ := json.Marshal(ErrorReply{
+			Type:    "error",
+			Code:    CodeUnsupportedProtocol,
+			Message: err.Error(),
+		})
+		conn.WriteMessage(websocket.TextMessage, data)
+		conn.closeWith(websocket.CloseProtocolError, "unsupported protocol")
+		return 0, false
+	}
+	if protocol >= 2 {
+		data, _ := json.Marshal(HelloMessage{
+			Type:        "hello",
+			Game:        gameName,
+			Protocol:    protocol,
+			MinProtocol: MinProtocolVersion,
+			MaxProtocol: ProtocolVersion,
+		})
+		if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			log.Println("❌ 发送 hello 失败:", err)
+		}
+	}
+	return protocol, true
+}
@@ -0,0 +1,217 @@
+package ws
+
+import (
+	"fmt"
+	"go-game/dto"
+	"log"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+)
+
+// 房间命令队列长度
+const roomCommandBuffer = 64
+
+// Room 每个房间由单独的 goroutine 串行处理所有命令（玩家消息、AI 行动、定时器、断线），
+// 同一房间内的游戏逻辑不会并发执行，不同房间之间互不阻塞。
+// 多实例部署时只有持有房间租约的实例运行房间 goroutine，见 cluster.go
+type Room struct {
+	ID    string
+	lease string // 启动时持有的房间租约
+	cmds  chan func()
+	quit  chan struct{}
+	once  sync.Once
+
+	// 座位只由房间 goroutine 修改，其他 goroutine（如 HTTP 接口）通过 Players 读取快照
+	mu      sync.RWMutex
+	players []dto.PlayerConn
+
+	streams syncStreams   // 各玩家的增量同步状态
+	gameLog gameLogWriter // 对局日志
+
+	tx atomic.Pointer[stateTx] // 正在执行的玩家操作
+}
+
+// 当前实例上运行中的房间，以及正在回放对局日志的沙盒房间（不持有租约，不对外可见）
+var (
+	rooms       = make(map[string]*Room)
+	replayRooms = make(map[string]*Room)
+	roomsMu     sync.RWMutex
+)
+
+// 启动房间 goroutine，座位从 Redis 恢复（服务重启、其他实例接管房间时）
+func newRoom(roomID, lease string) *Room {
+	room := &Room{
+		ID:      roomID,
+		lease:   lease,
+		cmds:    make(chan func(), roomCommandBuffer),
+		quit:    make(chan struct{}),
+		players: loadSeats(roomID),
+	}
+	go room.run()
+	if len(room.players) > 0 {
+		log.Printf("♻️ 房间 %s 恢复 %d 个座位\n", roomID, len(room.players))
+		// 同步一次，轮到 AI 时 AI 会继续行动
+		room.Do(func() { BroadcastToRoom(roomID) })
+	}
+	return room
+}
+
+func (r *Room) run() {
+	for {
+		select {
+		case fn := <-r.cmds:
+			r.exec(fn)
+		case <-r.quit:
+			return
+		}
+	}
+}
+
+// 单条命令 panic 不影响房间继续处理后续命令
+func (r *Room) exec(fn func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("❌ 房间 %s 处理命令 panic: %v\n%s", r.ID, err, debug.Stack())
+		}
+	}()
+	fn()
+}
+
+// Do 投递命令后立即返回，房间已关闭时返回 false
+func (r *Room) Do(fn func()) bool {
+	select {
+	case r.cmds <- fn:
+		return true
+	case <-r.quit:
+		return false
+	}
+}
+
+// Call 投递命令并等待执行完成。不能在房间 goroutine 内调用，否则会死锁
+func (r *Room) Call(fn func()) bool {
+	done := make(chan struct{})
+	if !r.Do(func() {
+		defer close(done)
+		fn()
+	}) {
+		return false
+	}
+	select {
+	case <-done:
+		return true
+	case <-r.quit:
+		return false
+	}
+}
+
+func (r *Room) stop() {
+	r.once.Do(func() { close(r.quit) })
+}
+
+// Players 获取座位快照
+func (r *Room) Players() []dto.PlayerConn {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]dto.PlayerConn(nil), r.players...)
+}
+
+// 修改座位并持久化，只能在房间 goroutine 内调用；fn 内不能再读取房间座位
+func (r *Room) updatePlayers(fn func(players []dto.PlayerConn) []dto.PlayerConn) {
+	r.mu.Lock()
+	r.players = fn(append([]dto.PlayerConn(nil), r.players...))
+	players := append([]dto.PlayerConn(nil), r.players...)
+	r.mu.Unlock()
+	saveSeats(r.ID, players)
+}
+
+func getRoom(roomID string) *Room {
+	roomsMu.RLock()
+	defer roomsMu.RUnlock()
+	if room, ok := rooms[roomID]; ok {
+		return room
+	}
+	return replayRooms[roomID]
+}
+
+// 获取房间，不存在时启动一个新的房间 goroutine
+func getOrCreateRoom(roomID, lease string) *Room {
+	roomsMu.Lock()
+	defer roomsMu.Unlock()
+	room, ok := rooms[roomID]
+	if !ok {
+		room = newRoom(roomID, lease)
+		rooms[roomID] = room
+		resetSpectators(roomID)
+	}
+	return room
+}
+
+// OpenRoom 创建房间时获取房间租约并启动房间 goroutine
+func OpenRoom(roomID string) error {
+	lease, err := acquireRoomOwner(roomID)
+	if err != nil {
+		return err
+	}
+	if leaseInstance(lease) != InstanceID {
+		return fmt.Errorf("房间[%s]已由实例 %s 运行", roomID, leaseInstance(lease))
+	}
+	getOrCreateRoom(roomID, lease)
+	markRoomChanged(roomID, lobbyRoomCreated)
+	return nil
+}
+
+// CloseRoom 房间删除时调用：停止房间 goroutine、释放租约，并通知所有实例断开该房间的连接
+func CloseRoom(roomID string) {
+	if room := getRoom(roomID); room != nil {
+		closeLocalRoom(roomID)
+		releaseRoomOwner(roomID, room.lease)
+	}
+	broadcastToInstances(clusterEnvelope{Kind: clusterKindRoomClosed, RoomID: roomID})
+	markRoomChanged(roomID, lobbyRoomClosed)
+}
+
+// 停止当前实例上的房间 goroutine
+func closeLocalRoom(roomID string) {
+	roomsMu.Lock()
+	room, ok := rooms[roomID]
+	delete(rooms, roomID)
+	roomsMu.Unlock()
+	if ok {
+		room.stop()
+	}
+}
+
+// RoomIDs 获取所有运行中的房间 ID
+func RoomIDs() []string {
+	roomsMu.RLock()
+	defer roomsMu.RUnlock()
+	ids := make([]string, 0, len(rooms))
+	for id := range rooms {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// RoomPlayers 获取房间座位快照，房间不存在时返回 nil
+func RoomPlayers(roomID string) []dto.PlayerConn {
+	room := getRoom(roomID)
+	if room == nil {
+		return nil
+	}
+	return room.Players()
+}
+
+// 房间内代码读取座位的统一入口
+func playersOf(roomID string) []dto.PlayerConn {
+	return RoomPlayers(roomID)
+}
+
+// RunInRoom 在房间 goroutine 内执行 fn 并等待完成，供 HTTP 接口、连接协程和定时器使用
+func RunInRoom(roomID string, fn func()) error {
+	room := getRoom(roomID)
+	if room == nil || !room.Call(fn) {
+		return fmt.Errorf("房间[%s]不存在", roomID)
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-game/dto"
+	"go-game/entities"
+	"go-game/repository"
+	"go-game/utils"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 一次广播用到的全部房间数据。先用一个 pipeline 读出房间和所有玩家的数据，再用一个 pipeline 读出各公司，
+// 之后每个玩家、观战者看到的同步消息都在内存中生成，不再按人逐个查询 Redis。
+// 编码为 JSON 即对局日志中的对局状态
+type roomState struct {
+	RoomID           string                          `json:"-"`
+	Version          int64                           `json:"-"`
+	RoomInfo         *entities.RoomInfo              `json:"roomInfo"`
+	CurrentPlayer    string                          `json:"currentPlayer"`
+	LastTile         string                          `json:"lastTile"`
+	Companies        map[string]entities.CompanyInfo `json:"companies"`
+	Tiles            map[string]dto.Tile             `json:"tiles"`
+	MergeMainCompany string                          `json:"mergeMainCompany"`
+	MergingSelection entities.MergingSelection       `json:"mergingSelection"`
+	MergeSettleData  map[string]dto.SettleData       `json:"mergeSettleData"`
+	Players          map[string]*playerState         `json:"players"`
+
+	Timeline []NetWorthPoint `json:"-"` // 对局结束后由 loadTimeline 读取
+}
+
+// 单个玩家的数据
+type playerState struct {
+	Info   map[string]string `json:"info"`
+	Stocks map[string]int    `json:"stocks"`
+	Tiles  []string          `json:"tiles"`
+}
+
+// 对局数据 key（room:<id>: 之后的部分）及其类型，玩家操作通过 stateTx 读写这些 key
+var roomStateKeyTypes = map[string]string{
+	"roomInfo":                stateHash,
+	"tiles":                   stateHash,
+	"company_ids":             stateSet,
+	"currentPlayer":           stateString,
+	"firstPlayer":             stateString,
+	"last_tile_key_temp":      stateString,
+	"merge_main_company_temp": stateString,
+	"merge_selection_temp":    stateString,
+	"merge_settle_temp":       stateString,
+	"seed":                    stateString,
+	"rng":                     stateString,
+}
+
+// 每个玩家的对局数据 key（room:<id>:player:<玩家>: 之后的部分）
+var playerStateKeyTypes = map[string]string{
+	"info":   stateHash,
+	"stocks": stateHash,
+	"tiles":  stateList,
+}
+
+// 游戏中的公司，对应 room:<id>:company:<公司> 的 Hash
+var companyNames = []string{"Sackson", "Tower", "American", "Festival", "Worldwide", "Continental", "Imperial"}
+
+// key 属于房间的对局数据时返回其类型，否则返回空字符串
+func stateKeyType(roomID, key string) string {
+	name, ok := strings.CutPrefix(key, "room:"+roomID+":")
+	if !ok {
+		return ""
+	}
+	if kind, ok := roomStateKeyTypes[name]; ok {
+		return kind
+	}
+	if strings.HasPrefix(name, "company:") {
+		return stateHash
+	}
+	if rest, ok := strings.CutPrefix(name, "player:"); ok {
+		if i := strings.LastIndex(rest, ":"); i > 0 {
+			return playerStateKeyTypes[rest[i+1:]]
+		}
+	}
+	return ""
+}
+
+// 玩家操作开始时读入的对局数据 key：房间级的 key、各公司和房间内各玩家的 key
+func roomStateKeys(roomID string) []string {
+	keys := make([]string, 0, len(roomStateKeyTypes)+len(companyNames)+len(playerStateKeyTypes)*MaxPlayers)
+	for name := range roomStateKeyTypes {
+		keys = append(keys, fmt.Sprintf("room:%s:%s", roomID, name))
+	}
+	for _, company := range companyNames {
+		keys = append(keys, fmt.Sprintf("room:%s:company:%s", roomID, company))
+	}
+	for _, pc := range playersOf(roomID) {
+		for name := range playerStateKeyTypes {
+			keys = append(keys, fmt.Sprintf("room:%s:player:%s:%s", roomID, pc.PlayerID, name))
+		}
+	}
+	return keys
+}
+
+// 读取房间数据和 playerIDs 中各玩家的数据
+func loadRoomState(roomID string, playerIDs []string) (*roomState, error) {
+	ctx := repository.Ctx
+	key := func(suffix string) string {
+		return fmt.Sprintf("room:%s:%s", roomID, suffix)
+	}
+
+	pipe := stateRdb(roomID).Pipeline()
+	companyIDsCmd := pipe.SMembers(ctx, key("company_ids"))
+	roomInfoCmd := pipe.HGetAll(ctx, key("roomInfo"))
+	currentPlayerCmd := pipe.Get(ctx, key("currentPlayer"))
+	lastTileCmd := pipe.Get(ctx, key("last_tile_key_temp"))
+	tilesCmd := pipe.HGetAll(ctx, key("tiles"))
+	mainCompanyCmd := pipe.Get(ctx, key("merge_main_company_temp"))
+	selectionCmd := pipe.Get(ctx, key("merge_selection_temp"))
+	settleCmd := pipe.Get(ctx, key("merge_settle_temp"))
+	versionCmd := pipe.Get(ctx, roomVersionKey(roomID))
+	type playerCmds struct {
+		info   *redis.StringStringMapCmd
+		stocks *redis.StringStringMapCmd
+		tiles  *redis.StringSliceCmd
+	}
+	playerCmdMap := make(map[string]playerCmds, len(playerIDs))
+	for _, playerID := range playerIDs {
+		playerCmdMap[playerID] = playerCmds{
+			info:   pipe.HGetAll(ctx, key("player:"+playerID+":info")),
+			stocks: pipe.HGetAll(ctx, key("player:"+playerID+":stocks")),
+			tiles:  pipe.LRange(ctx, key("player:"+playerID+":tiles"), 0, -1),
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("❌ Redis pipeline 执行失败: %w", err)
+	}
+
+	roomInfo, err := parseRoomInfo(roomInfoCmd.Val())
+	if err != nil {
+		return nil, fmt.Errorf("❌ 获取房间信息失败: %w", err)
+	}
+	state := &roomState{
+		RoomID:           roomID,
+		RoomInfo:         roomInfo,
+		CurrentPlayer:    currentPlayerCmd.Val(),
+		LastTile:         lastTileCmd.Val(),
+		Tiles:            parseRoomTiles(tilesCmd.Val()),
+		MergeMainCompany: mainCompanyCmd.Val(),
+		MergeSettleData:  map[string]dto.SettleData{},
+		Players:          make(map[string]*playerState, len(playerIDs)),
+	}
+	if versionCmd.Err() != redis.Nil {
+		if state.Version, err = versionCmd.Int64(); err != nil {
+			return nil, fmt.Errorf("获取房间版本号失败: %w", err)
+		}
+	}
+	if selectionCmd.Err() != redis.Nil {
+		if state.MergingSelection, err = parseMergingSelection(selectionCmd.Val()); err != nil {
+			return nil, fmt.Errorf("❌ 获取合并选择信息失败: %w", err)
+		}
+	}
+	if settleCmd.Err() != redis.Nil {
+		if state.MergeSettleData, err = parseMergeSettleData(settleCmd.Val()); err != nil {
+			return nil, fmt.Errorf("❌ 获取合并结算信息失败: %w", err)
+		}
+	}
+	for playerID, cmds := range playerCmdMap {
+		stocks, err := parsePlayerStocks(cmds.stocks.Val())
+		if err != nil {
+			return nil, fmt.Errorf("❌ 获取玩家[%s]股票信息失败: %w", playerID, err)
+		}
+		state.Players[playerID] = &playerState{
+			Info:   cmds.info.Val(),
+			Stocks: stocks,
+			Tiles:  cmds.tiles.Val(),
+		}
+	}
+
+	// 公司 ID 要等第一轮结果才知道
+	pipe = stateRdb(roomID).Pipeline()
+	companyCmds := make(map[string]*redis.StringStringMapCmd)
+	for _, companyID := range companyIDsCmd.Val() {
+		companyCmds[companyID] = pipe.HGetAll(ctx, key("company:"+companyID))
+	}
+	if len(companyCmds) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, fmt.Errorf("❌ 获取公司信息失败: %w", err)
+		}
+	}
+	state.Companies = make(map[string]entities.CompanyInfo, len(companyCmds))
+	for companyID, cmd := range companyCmds {
+		state.Companies[companyID] = parseCompanyInfo(cmd.Val())
+	}
+	return state, nil
+}
+
+// 对局日志中的对局状态：房间和所有座位上玩家的数据，公司信息按棋盘重新计算
+func gameStateDoc(roomID string) (interface{}, error) {
+	playerIDs, err := seatPlayerIDs(roomID)
+	if err != nil {
+		return nil, err
+	}
+	state, err := loadRoomState(roomID, playerIDs)
+	if err != nil {
+		return nil, err
+	}
+	state.refreshCompanies()
+	return toJSONValue(state)
+}
+
+// 回放中某一步的同步消息：viewerID 为空时是观战视角，否则是该玩家的视角
+func replaySync(state *roomState, players []string, viewerID string) interface{} {
+	if viewerID != "" {
+		return state.playerSync(viewerID, state.totals())
+	}
+	seats := make([]dto.PlayerConn, 0, len(players))
+	for _, playerID := range players {
+		seats = append(seats, dto.PlayerConn{PlayerID: playerID, Online: true})
+	}
+	return buildSpectatorSync(state, seats, state.totals())
+}
+
+// 只有本人能看到的事件，其他人看到的版本：抽到的 tile 只保留玩家
+func redactEvent(event GameEvent, viewerID string) GameEvent {
+	if event.Type != "tile_drawn" {
+		return event
+	}
+	playerID := eventPlayerID(event)
+	if playerID == viewerID {
+		return event
+	}
+	data, _ := json.Marshal(map[string]string{"playerID": playerID})
+	return GameEvent{Type: event.Type, Data: data}
+}
+
+// 座位上的玩家 ID
+func connPlayerIDs(players []dto.PlayerConn) []string {
+	ids := make([]string, 0, len(players))
+	for _, pc := range players {
+		ids = append(ids, pc.PlayerID)
+	}
+	return ids
+}
+
+// 按棋盘和玩家持股重新计算各公司的规模、剩余股数和股价，有变化时返回 true
+func (s *roomState) refreshCompanies() bool {
+	allTileMap := make(map[string]int)
+	for _, tile := range s.Tiles {
+		if tile.Belong != "" && tile.Belong != "Blank" {
+			allTileMap[tile.Belong]++
+		}
+	}
+	allStockMap := make(map[string]int)
+	for _, player := range s.Players {
+		for stockID, stockCount := range player.Stocks {
+			allStockMap[stockID] += stockCount
+		}
+	}
+
+	changed := false
+	for companyName, info := range s.Companies {
+		stockInfo := utils.GetStockInfo(companyName, allTileMap[companyName])
+		updated := info
+		updated.StockTotal = 25 - allStockMap[companyName]
+		updated.Tiles = allTileMap[companyName]
+		updated.StockPrice = stockInfo.Price
+		if !reflect.DeepEqual(updated, info) {
+			s.Companies[companyName] = updated
+			changed = true
+		}
+	}
+	return changed
+}
+
+// 各玩家的现金和股票市值
+func (s *roomState) netWorths() map[string]NetWorth {
+	result := make(map[string]NetWorth)
+	for playerID, player := range s.Players {
+		money, ok := player.Info["money"]
+		if !ok {
+			continue
+		}
+		playerMoney, err := strconv.Atoi(money)
+		if err != nil {
+			continue
+		}
+		stocks := CalculateTotalValue(player.Stocks, s.Companies)
+		result[playerID] = NetWorth{Cash: playerMoney, Stocks: stocks, Total: stocks + playerMoney}
+	}
+	return result
+}
+
+// 各玩家的总资产（现金 + 股票市值）
+func (s *roomState) totals() map[string]int {
+	result := make(map[string]int)
+	for playerID, worth := range s.netWorths() {
+		result[playerID] = worth.Total
+	}
+	return result
+}
+
+// 房间内所有人（包括观战者）都能看到的数据
+func (s *roomState) roomData() SyncRoomData {
+	return SyncRoomData{
+		CompanyInfo:   s.Companies,
+		CurrentPlayer: s.CurrentPlayer,
+		RoomInfo:      s.RoomInfo,
+		Tiles:         s.Tiles,
+		Version:       s.Version,
+	}
+}
+
+func (s *roomState) tempData() SyncTempData {
+	return SyncTempData{
+		LastTileKey:          s.LastTile,
+		MergeMainCompanyTemp: s.MergeMainCompany,
+		MergeSelectionTemp:   s.MergingSelection,
+		MergeSettleData:      s.MergeSettleData,
+	}
+}
+
+// 玩家视角的同步消息：公开数据 + 自己的现金、股票和手牌
+func (s *roomState) playerSync(playerID string, result map[string]int) SyncMessage {
+	player, ok := s.Players[playerID]
+	if !ok {
+		player = &playerState{Info: map[string]string{}, Stocks: map[string]int{}, Tiles: []string{}}
+	}
+	return SyncMessage{
+		Type:     "sync",
+		Result:   result,
+		Timeline: s.Timeline,
+		PlayerID: playerID,
+		PlayerData: SyncPlayerData{
+			Info:   player.Info,
+			Stocks: player.Stocks,
+			Tiles:  player.Tiles,
+		},
+		RoomData: s.roomData(),
+		TempData: s.tempData(),
+	}
+}
@@ -0,0 +1,124 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-game/dto"
+	"go-game/entities"
+	"go-game/repository"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// 统计发往 Redis 的命令数和往返次数，一个 pipeline 算一次往返
+type countHook struct {
+	cmds  int64
+	trips int64
+}
+
+func (h *countHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	atomic.AddInt64(&h.cmds, 1)
+	atomic.AddInt64(&h.trips, 1)
+	return ctx, nil
+}
+
+func (h *countHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (h *countHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	atomic.AddInt64(&h.cmds, int64(len(cmds)))
+	atomic.AddInt64(&h.trips, 1)
+	return ctx, nil
+}
+
+func (h *countHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+// 丢弃所有消息的连接
+type nopConn struct{}
+
+func (nopConn) WriteMessage(int, []byte) error { return nil }
+
+func (nopConn) ReadMessage() (int, []byte, error) { return 0, nil, fmt.Errorf("连接已关闭") }
+
+func (nopConn) Close() error { return nil }
+
+// 在 miniredis 上准备一个进行中的房间，players 个玩家全部在线
+func setupBenchRoom(tb testing.TB, players int) (string, *countHook) {
+	mr := miniredis.RunT(tb)
+	repository.Rdb = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	rdb := repository.Rdb
+	ctx := repository.Ctx
+	roomID := "bench"
+
+	SetRoomInfo(rdb, ctx, roomID, entities.RoomInfo{MaxPlayers: players, GameStatus: dto.RoomStatusSetTile, UserID: "p0"})
+	for _, company := range companyNames {
+		rdb.HSet(ctx, fmt.Sprintf("room:%s:company:%s", roomID, company), map[string]interface{}{
+			"name":       company,
+			"stockTotal": 25,
+			"tiles":      0,
+			"stockPrice": 200,
+		})
+		rdb.SAdd(ctx, fmt.Sprintf("room:%s:company_ids", roomID), company)
+	}
+	for col := 1; col <= 12; col++ {
+		for row := 'A'; row <= 'I'; row++ {
+			id := fmt.Sprintf("%d%c", col, row)
+			data, _ := json.Marshal(dto.Tile{ID: id})
+			rdb.HSet(ctx, fmt.Sprintf("room:%s:tiles", roomID), id, data)
+		}
+	}
+
+	OpenRoom(roomID)
+	seats := make([]dto.PlayerConn, players)
+	for i := range seats {
+		seats[i] = dto.PlayerConn{PlayerID: fmt.Sprintf("p%d", i), Conn: nopConn{}, Online: true}
+	}
+	RunInRoom(roomID, func() {
+		getRoom(roomID).updatePlayers(func([]dto.PlayerConn) []dto.PlayerConn { return seats })
+	})
+	for _, pc := range seats {
+		if err := InitPlayerData(roomID, pc.PlayerID); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	SetCurrentPlayer(rdb, ctx, roomID, "p0")
+
+	hook := &countHook{}
+	rdb.AddHook(hook)
+	return roomID, hook
+}
+
+// 一次广播发往 Redis 的命令数和往返次数：
+// go test ./ws -run '^$' -bench Broadcast
+//
+// 广播改为每次读取一次房间数据（loadRoomState）前后，在同一台机器上用 -benchtime=2000x 测得：
+//
+//	players  逐字段读取（改动前）               一次读取（改动后）
+//	2        64 次往返  68 条命令   1.81 ms/op    3 次往返  23 条命令  0.64 ms/op
+//	4        104 次往返 112 条命令  3.10 ms/op    3 次往返  29 条命令  0.86 ms/op
+//	6        144 次往返 156 条命令  4.50 ms/op    3 次往返  35 条命令  1.06 ms/op
+//
+// 之后增量同步等改动让每次广播多做了一些事，当前的耗时和命令数以运行结果为准
+func BenchmarkBroadcast(b *testing.B) {
+	for _, players := range []int{2, 4, 6} {
+		b.Run(fmt.Sprintf("players=%d", players), func(b *testing.B) {
+			roomID, hook := setupBenchRoom(b, players)
+			RunInRoom(roomID, func() { BroadcastToRoom(roomID) }) // 第一次广播会写回公司数据
+			atomic.StoreInt64(&hook.cmds, 0)
+			atomic.StoreInt64(&hook.trips, 0)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				RunInRoom(roomID, func() { BroadcastToRoom(roomID) })
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(&hook.cmds))/float64(b.N), "cmds/op")
+			b.ReportMetric(float64(atomic.LoadInt64(&hook.trips))/float64(b.N), "roundtrips/op")
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package ws
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// 由 Go 类型生成 JSON Schema（draft 2020-12），消息结构以代码中的类型为准，不再单独维护文档。
+// 只覆盖消息中用到的类型：结构体、map、切片、指针和基本类型
+
+// 自定义了 JSON 解析的类型（如同时接受字符串和对象的 payload）自己提供 schema
+type schemaProvider interface {
+	jsonSchema() map[string]interface{}
+}
+
+var (
+	schemaProviderType = reflect.TypeOf((*schemaProvider)(nil)).Elem()
+	rawMessageType     = reflect.TypeOf(json.RawMessage{})
+)
+
+// 生成类型 t 的 schema。outbound 为 true 时，没有 omitempty 的字段总会出现在消息中，标为必填
+func schemaOf(t reflect.Type, outbound bool) map[string]interface{} {
+	if reflect.PtrTo(t).Implements(schemaProviderType) {
+		return reflect.New(t).Interface().(schemaProvider).jsonSchema()
+	}
+	if t == rawMessageType {
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return map[string]interface{}{
+			"anyOf": []interface{}{schemaOf(t.Elem(), outbound), map[string]interface{}{"type": "null"}},
+		}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), outbound)}
+	case reflect.Map:
+		// map 的 key 编码为 JSON 对象的字段名
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), outbound)}
+	case reflect.Struct:
+		return structSchema(t, outbound)
+	}
+	// interface{} 等任意值
+	return map[string]interface{}{}
+}
+
+func structSchema(t reflect.Type, outbound bool) map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := make([]string, 0)
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "-" || (!field.IsExported() && !field.Anonymous) {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			// 匿名嵌入的结构体字段提升到外层，与 encoding/json 一致
+			if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+				addFields(field.Type)
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = schemaOf(field.Type, outbound)
+			if outbound && !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// 消息的 schema：type 字段固定为消息类型
+func messageSchema(msgType string, t reflect.Type, outbound bool) map[string]interface{} {
+	schema := schemaOf(t, outbound)
+	if properties, ok := schema["properties"].(map[string]interface{}); ok {
+		properties["type"] = map[string]interface{}{"const": msgType}
+	}
+	return schema
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
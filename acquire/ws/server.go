@@ -0,0 +1,99 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// RestoreRooms 启动时恢复 Redis 中的房间：没有实例运行的房间由当前实例接管，座位从 Redis 恢复，
+// 玩家重连后回到原来的座位。上一个进程异常退出时租约要等过期后才能接管，所以过期后再恢复一次
+func RestoreRooms() {
+	restoreRooms()
+	time.AfterFunc(ownerLeaseTTL+clusterTick, restoreRooms)
+}
+
+func restoreRooms() {
+	roomIDs, err := ScanRoomIDs()
+	if err != nil {
+		log.Println("❌ 恢复房间失败:", err)
+		return
+	}
+	restored := 0
+	for _, roomID := range roomIDs {
+		if getRoom(roomID) != nil {
+			continue
+		}
+		lease, err := acquireRoomOwner(roomID)
+		if err != nil {
+			log.Println("❌ 恢复房间失败:", err)
+			continue
+		}
+		// 由其他实例运行
+		if leaseInstance(lease) != InstanceID {
+			continue
+		}
+		if _, err := ownedRoom(roomID); err != nil {
+			log.Printf("❌ 恢复房间 %s 失败: %v\n", roomID, err)
+			continue
+		}
+		restored++
+	}
+	if restored > 0 {
+		log.Printf("✅ 已恢复 %d 个房间\n", restored)
+	}
+}
+
+// Shutdown 优雅停机：通知客户端服务器正在重启并断开连接，等待各房间处理完已收到的消息，
+// 最后释放房间租约，让其他实例（或重启后的自己）可以立即接管
+func Shutdown(ctx context.Context) {
+	data, err := json.Marshal(ServerRestartMessage{
+		Type:    "server_restart",
+		Message: "服务器正在重启，请稍后重新连接",
+	})
+	if err != nil {
+		log.Println("❌ 编码 JSON 失败:", err)
+	}
+	for _, lc := range localClientsOf("") {
+		lc.conn.WriteMessage(websocket.TextMessage, data)
+		lc.conn.closeWith(websocket.CloseServiceRestart, "server restart")
+	}
+	closeLobbyClients(data)
+	waitLocalClientsClosed(ctx)
+
+	for _, roomID := range RoomIDs() {
+		room := getRoom(roomID)
+		if room == nil {
+			continue
+		}
+		// 命令按顺序执行，标记命令执行时之前的命令都已处理完
+		done := make(chan struct{})
+		if room.Do(func() { close(done) }) {
+			select {
+			case <-done:
+			case <-ctx.Done():
+				log.Printf("⚠️ 房间 %s 未能在停机前处理完消息\n", roomID)
+			}
+		}
+		closeLocalRoom(roomID)
+		releaseRoomOwner(roomID, room.lease)
+	}
+	log.Println("✅ 所有房间已停止")
+}
+
+// 等待连接把剩余消息发完并退出
+func waitLocalClientsClosed(ctx context.Context) {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for len(localClientsOf("")) > 0 {
+		select {
+		case <-ctx.Done():
+			log.Println("⚠️ 等待连接关闭超时")
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-game/dto"
+	"go-game/repository"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 连接角色，通过 WebSocket 的 role 参数指定
+const (
+	RolePlayer    = "player"
+	RoleSpectator = "spectator"
+)
+
+// 观战者连接，只接收公开信息，不能操作。连接登记在房间所属实例上，
+// 观战人数另存在 Redis 的 room:<id>:spectators，任何实例的房间列表都能读到
+var Spectators = make(map[string][]dto.PlayerConn)
+
+func spectatorsKey(roomID string) string {
+	return fmt.Sprintf("room:%s:spectators", roomID)
+}
+
+// 观战列表由各观战者的连接协程增删，单独加锁
+var spectatorLock sync.Mutex
+
+// 观战画面延迟（秒），通过 SPECTATOR_DELAY 配置，防止观战者实时场外指导
+var spectatorDelay = func() time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv("SPECTATOR_DELAY"))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}()
+
+// 以观战者身份加入房间
+func joinAsSpectator(roomID, spectatorID string, conn WriteOnlyConn) error {
+	roomInfo, err := GetRoomInfo(repository.Rdb, roomID)
+	if err != nil {
+		return fmt.Errorf("房间不存在")
+	}
+	if roomInfo.Private {
+		return fmt.Errorf("私人房间不允许观战")
+	}
+
+	spectatorLock.Lock()
+	defer spectatorLock.Unlock()
+
+	for _, pc := range playersOf(roomID) {
+		if pc.PlayerID == spectatorID {
+			return fmt.Errorf("你已是房间内的玩家")
+		}
+	}
+	for i, pc := range Spectators[roomID] {
+		if pc.PlayerID == spectatorID {
+			Spectators[roomID][i].Conn = conn
+			return nil
+		}
+	}
+	Spectators[roomID] = append(Spectators[roomID], dto.PlayerConn{
+		PlayerID: spectatorID,
+		Conn:     conn,
+		Online:   true,
+	})
+	if err := repository.Rdb.SAdd(repository.Ctx, spectatorsKey(roomID), spectatorID).Err(); err != nil {
+		log.Println("❌ 保存观战者失败:", err)
+	}
+	log.Printf("观战者 %s 进入房间 %s\n", spectatorID, roomID)
+	markRoomChanged(roomID, lobbyRoomUpdated)
+	return nil
+}
+
+// 观战者断开连接后移出观战列表
+func leaveSpectator(roomID, spectatorID string, conn WriteOnlyConn) {
+	spectatorLock.Lock()
+	defer spectatorLock.Unlock()
+
+	for i, pc := range Spectators[roomID] {
+		if pc.PlayerID == spectatorID && pc.Conn == conn {
+			Spectators[roomID] = append(Spectators[roomID][:i], Spectators[roomID][i+1:]...)
+			if err := repository.Rdb.SRem(repository.Ctx, spectatorsKey(roomID), spectatorID).Err(); err != nil {
+				log.Println("❌ 移除观战者失败:", err)
+			}
+			log.Printf("观战者 %s 离开房间 %s\n", spectatorID, roomID)
+			markRoomChanged(roomID, lobbyRoomUpdated)
+			break
+		}
+	}
+	if len(Spectators[roomID]) == 0 {
+		delete(Spectators, roomID)
+	}
+}
+
+// GetSpectatorCount 获取房间观战人数，观战者可能连在任意实例上，从 Redis 读取
+func GetSpectatorCount(roomID string) int {
+	n, err := repository.Rdb.SCard(repository.Ctx, spectatorsKey(roomID)).Result()
+	if err != nil {
+		log.Println("❌ 获取观战人数失败:", err)
+		return 0
+	}
+	return int(n)
+}
+
+// 当前实例上是否有该房间的观战连接，只在房间所属实例上有意义
+func hasLocalSpectators(roomID string) bool {
+	spectatorLock.Lock()
+	defer spectatorLock.Unlock()
+	return len(Spectators[roomID]) > 0
+}
+
+// 房间在当前实例上启动时清空观战人数，之前实例上的观战者需要重新连接
+func resetSpectators(roomID string) {
+	if err := repository.Rdb.Del(repository.Ctx, spectatorsKey(roomID)).Err(); err != nil {
+		log.Println("❌ 清空观战者失败:", err)
+	}
+}
+
+// RemoveSpectators 断开房间内所有观战者（房间删除时调用）
+func RemoveSpectators(roomID string) {
+	spectatorLock.Lock()
+	spectators := Spectators[roomID]
+	delete(Spectators, roomID)
+	spectatorLock.Unlock()
+	resetSpectators(roomID)
+
+	for _, pc := range spectators {
+		pc.Conn.Close()
+	}
+}
+
+// 观战者发来的消息一律拒绝，读取只用于感知断线
+func listenSpectatorMessages(conn ReadWriteConn) {
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			log.Println("读取消息失败:", err)
+			break
+		}
+		sendErrorMessage(conn, "观战中无法操作")
+	}
+}
+
+// 观战者看到的同步消息：不包含任何玩家的手牌和现金
+func buildSpectatorSync(state *roomState, seats []dto.PlayerConn, result map[string]int) SpectatorSyncMessage {
+	players := make(map[string]SpectatorPlayer)
+	for _, pc := range seats {
+		player, ok := state.Players[pc.PlayerID]
+		if !ok {
+			continue
+		}
+		players[pc.PlayerID] = SpectatorPlayer{
+			Online:    pc.Online,
+			Stocks:    player.Stocks,
+			TileCount: len(player.Tiles),
+		}
+	}
+	return SpectatorSyncMessage{
+		Type:     "sync",
+		Role:     RoleSpectator,
+		Result:   result,
+		Timeline: state.Timeline,
+		Players:  players,
+		RoomData: state.roomData(),
+		TempData: state.tempData(),
+	}
+}
+
+// 延迟发送的一条观战消息
+type delayedSend struct {
+	at   time.Time
+	send func()
+}
+
+// 各房间等待发送的观战消息，按生成顺序排队。房间有消息排队时由一个 goroutine 依次到点发送，
+// 先生成的消息一定先发出；各自起定时器时，同一时刻到期的定时器执行顺序不确定，观战者可能先收到新的数据
+var (
+	spectatorQueues   = make(map[string][]delayedSend)
+	spectatorQueuesMu sync.Mutex
+)
+
+// 配置了观战延迟时延后执行，数据在调用时就已生成
+func afterSpectatorDelay(roomID string, send func()) {
+	if spectatorDelay <= 0 {
+		send()
+		return
+	}
+	spectatorQueuesMu.Lock()
+	defer spectatorQueuesMu.Unlock()
+	pending, running := spectatorQueues[roomID]
+	spectatorQueues[roomID] = append(pending, delayedSend{at: time.Now().Add(spectatorDelay), send: send})
+	if !running {
+		go runSpectatorQueue(roomID)
+	}
+}
+
+// 按顺序发送房间排队的观战消息，队列发完后退出
+func runSpectatorQueue(roomID string) {
+	for {
+		spectatorQueuesMu.Lock()
+		pending := spectatorQueues[roomID]
+		if len(pending) == 0 {
+			delete(spectatorQueues, roomID)
+			spectatorQueuesMu.Unlock()
+			return
+		}
+		next := pending[0]
+		spectatorQueues[roomID] = pending[1:]
+		spectatorQueuesMu.Unlock()
+
+		time.Sleep(time.Until(next.at))
+		next.send()
+	}
+}
+
+// 向刚进入的观战者单独发送当前数据，不触发整个房间的广播
+func syncSpectator(roomID string, conn WriteOnlyConn) error {
+	players := playersOf(roomID)
+	state, err := loadRoomState(roomID, connPlayerIDs(players))
+	if err != nil {
+		return err
+	}
+	state.refreshCompanies()
+	state.loadTimeline()
+	data, err := json.Marshal(buildSpectatorSync(state, players, state.totals()))
+	if err != nil {
+		return fmt.Errorf("❌ 编码 JSON 失败: %w", err)
+	}
+	afterSpectatorDelay(roomID, func() {
+		if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			log.Println("❌ 发送观战数据失败:", err)
+		}
+	})
+	return nil
+}
+
+// 向所有观战者发送消息
+func broadcastToSpectators(roomID string, msg interface{}) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Println("❌ 编码 JSON 失败:", err)
+		return
+	}
+	afterSpectatorDelay(roomID, func() {
+		spectatorLock.Lock()
+		spectators := append([]dto.PlayerConn(nil), Spectators[roomID]...)
+		spectatorLock.Unlock()
+
+		for _, pc := range spectators {
+			if err := pc.Conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				log.Println("观战广播失败，移除连接:", pc.PlayerID)
+				pc.Conn.Close()
+			}
+		}
+	})
+}
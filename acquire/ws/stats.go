@@ -0,0 +1,105 @@
+package ws
+
+import (
+	"encoding/json"
+	"math"
+)
+
+// 玩家统计：从已结束对局的日志汇总，每局的统计随对局索引缓存（见 finishedGames）
+
+// PlayerStats Acquire 玩家的统计数据
+type PlayerStats struct {
+	PlayerID         string  `json:"playerID"`
+	Games            int     `json:"games"`
+	Wins             int     `json:"wins"`
+	Finished         int     `json:"finished"`         // 坚持到结束的对局数，中途离开的不计
+	AvgNetWorth      int     `json:"avgNetWorth"`      // 结束时的平均总资产（现金 + 股票市值）
+	BestNetWorth     int     `json:"bestNetWorth"`     // 单局最高总资产
+	MergersTriggered int     `json:"mergersTriggered"` // 由该玩家放置 tile 引发的并购次数
+	ChainsFounded    int     `json:"chainsFounded"`
+	BonusesEarned    int     `json:"bonusesEarned"` // 并购时获得的大股东红利合计
+	AvgBonuses       float64 `json:"avgBonuses"`
+}
+
+// 一名玩家在一局中的统计，建立对局索引时从日志整理一次
+type playerGameStats struct {
+	MergersTriggered int
+	ChainsFounded    int
+	BonusesEarned    int
+}
+
+// 从一局的日志整理开局时各座位玩家的统计
+func collectGameStats(summary ReplaySummary, records []GameLogRecord) map[string]*playerGameStats {
+	stats := make(map[string]*playerGameStats, len(summary.Players))
+	for _, playerID := range summary.Players {
+		stats[playerID] = &playerGameStats{}
+	}
+	for _, record := range records {
+		merged := false
+		for _, event := range record.Events {
+			switch event.Type {
+			case "merger_resolved":
+				// 并购在放置 tile（或选择主公司）的操作中结算
+				merged = true
+				var data struct {
+					Dividends map[string]int `json:"dividends"`
+				}
+				if err := json.Unmarshal(event.Data, &data); err == nil {
+					for playerID, bonus := range data.Dividends {
+						if s, ok := stats[playerID]; ok {
+							s.BonusesEarned += bonus
+						}
+					}
+				}
+			case "chain_founded":
+				if s, ok := stats[eventPlayerID(event)]; ok {
+					s.ChainsFounded++
+				}
+			}
+		}
+		if s, ok := stats[record.PlayerID]; ok && merged {
+			s.MergersTriggered++
+		}
+	}
+	return stats
+}
+
+// PlayerStatsOf 汇总玩家在所有已结束对局中的统计数据
+func PlayerStatsOf(playerID string) (*PlayerStats, error) {
+	stats := &PlayerStats{PlayerID: playerID}
+	netWorth := 0
+	err := forEachFinishedGame(func(game *finishedGame) {
+		gameStats, ok := game.Stats[playerID]
+		if !ok {
+			return
+		}
+		stats.Games++
+		var standings []Standing
+		if err := json.Unmarshal(game.Summary.Standings, &standings); err == nil {
+			for _, s := range standings {
+				if s.PlayerID != playerID {
+					continue
+				}
+				stats.Finished++
+				netWorth += s.Total
+				stats.BestNetWorth = max(stats.BestNetWorth, s.Total)
+				if s.Rank == 1 {
+					stats.Wins++
+				}
+			}
+		}
+		stats.MergersTriggered += gameStats.MergersTriggered
+		stats.ChainsFounded += gameStats.ChainsFounded
+		stats.BonusesEarned += gameStats.BonusesEarned
+	})
+	if err != nil {
+		return nil, err
+	}
+	if stats.Finished > 0 {
+		stats.AvgNetWorth = int(math.Round(float64(netWorth) / float64(stats.Finished)))
+	}
+	if stats.Games > 0 {
+		stats.AvgBonuses = math.Round(float64(stats.BonusesEarned)/float64(stats.Games)*10) / 10
+	}
+	return stats, nil
+}
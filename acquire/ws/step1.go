@@ -2,7 +2,6 @@ package ws
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"go-game/dto"
 	"go-game/entities"
@@ -18,7 +17,7 @@ import (
 )
 
 // PlaceTile 用于处理将棋子放置到棋盘上：修改 tile 的 belong 字段并更新 Redis，同时从玩家手牌中移除该 tile。
-func placeTile(rdb *redis.Client, ctx context.Context, roomID, playerID, tileKey string) error {
+func placeTile(rdb redis.Cmdable, ctx context.Context, roomID, playerID, tileKey string) error {
 	// Step 1：下棋
 	if err := UpdateTileValue(rdb, roomID, tileKey, dto.Tile{ID: tileKey, Belong: "Blank"}); err != nil {
 		return fmt.Errorf("❌ 写入 tile 出错: %w", err)
@@ -34,12 +33,13 @@ func placeTile(rdb *redis.Client, ctx context.Context, roomID, playerID, tileKey
 		return err
 	}
 
+	recordGameEvent(roomID, "tile_placed", map[string]string{"playerID": playerID, "tile": tileKey})
 	log.Printf("✅ 玩家 %s 放置棋子 %s 成功\n", playerID, tileKey)
 	return nil
 }
 
 func handleMergeProcess(
-	rdb *redis.Client,
+	rdb redis.Cmdable,
 	roomID string,
 	mainHotel string,
 	otherHotel []string,
@@ -59,7 +59,7 @@ func handleMergeProcess(
 		}
 		var holders []holder
 
-		for _, pc := range Rooms[roomID] {
+		for _, pc := range playersOf(roomID) {
 			playerID := pc.PlayerID
 			// 获取该玩家所有股票
 			stockMap, err := GetPlayerStocks(rdb, repository.Ctx, roomID, playerID)
@@ -143,13 +143,19 @@ func handleMergeProcess(
 
 		for playerID, money := range dividends {
 			if err := AddPlayerMoney(rdb, repository.Ctx, roomID, playerID, money); err != nil {
-				log.Println("❌ 累加红利失败:", err)
+				return fmt.Errorf("累加红利失败: %w", err)
 			}
 		}
 		tempSettleData[hotel] = dto.SettleData{
 			Hoders:    currentCompanyHoders,
 			Dividends: dividends,
 		}
+		recordGameEvent(roomID, "merger_resolved", map[string]interface{}{
+			"mainCompany": mainHotel,
+			"acquired":    hotel,
+			"tiles":       tileCount,
+			"dividends":   dividends,
+		})
 	}
 	// 保存主公司到redis
 	err := SetMergeMainCompany(rdb, repository.Ctx, roomID, mainHotel)
@@ -163,13 +169,13 @@ func handleMergeProcess(
 	// Step 6：设置状态为“并购清算”
 	err = SetGameStatus(rdb, roomID, dto.RoomStatusMergingSettle)
 	if err != nil {
-		log.Println("❌ 设置房间状态失败:", err)
+		return fmt.Errorf("设置房间状态失败: %w", err)
 	}
 	log.Printf("✅ 完成酒店[%s]并入[%s]的红利计算和状态更新\n", otherHotel, mainHotel)
 	return nil
 }
 
-func HandlePostTilePlacement(rdb *redis.Client, ctx context.Context, roomID, playerID string) error {
+func HandlePostTilePlacement(rdb redis.Cmdable, ctx context.Context, roomID, playerID string) error {
 	// 第一步：获取公司信息
 	companyInfo, err := GetCompanyInfo(rdb, roomID)
 	if err != nil {
@@ -193,12 +199,12 @@ func HandlePostTilePlacement(rdb *redis.Client, ctx context.Context, roomID, pla
 
 	// 切换玩家
 	if err := SwitchToNextPlayer(rdb, repository.Ctx, roomID, playerID); err != nil {
-		log.Println("切换玩家失败:", err)
+		return fmt.Errorf("切换玩家失败: %w", err)
 	}
 	return nil
 }
 
-func handleMergingLogic(rdb *redis.Client, roomID string, playerID string, hotelSet map[string]struct{}) error {
+func handleMergingLogic(rdb redis.Cmdable, roomID string, playerID string, hotelSet map[string]struct{}) error {
 	// 统计每个酒店的 tile 数量
 	companyInfo, err := GetCompanyInfo(rdb, roomID)
 	if err != nil {
@@ -214,13 +220,14 @@ func handleMergingLogic(rdb *redis.Client, roomID string, playerID string, hotel
 			maxCount = tileCount
 		}
 	}
-	// 找出最大 tile 数量的酒店
+	// 找出最大 tile 数量的酒店，排序后保存，回放时得到同样的顺序
 	var topHotels []string
 	for hotel, count := range hotelTileCount {
 		if count == maxCount {
 			topHotels = append(topHotels, hotel)
 		}
 	}
+	sort.Strings(topHotels)
 
 	if len(topHotels) > 1 {
 		for _, hotel := range topHotels {
@@ -233,10 +240,11 @@ func handleMergingLogic(rdb *redis.Client, roomID string, playerID string, hotel
 			}
 			otherHotel = append(otherHotel, key)
 		}
+		sort.Strings(otherHotel)
 		if len(otherHotel) == 0 && maxCount >= 11 {
 			err = SetGameStatus(rdb, roomID, dto.RoomStatusBuyStock)
 			if err != nil {
-				log.Println("❌ 设置房间状态失败:", err)
+				return fmt.Errorf("设置房间状态失败: %w", err)
 			}
 			log.Println("没有其他可以合并的公司")
 			return nil
@@ -264,10 +272,11 @@ func handleMergingLogic(rdb *redis.Client, roomID string, playerID string, hotel
 			}
 			otherHotel = append(otherHotel, key)
 		}
+		sort.Strings(otherHotel)
 		if len(otherHotel) == 0 {
 			err = SetGameStatus(rdb, roomID, dto.RoomStatusBuyStock)
 			if err != nil {
-				log.Println("❌ 设置房间状态失败:", err)
+				return fmt.Errorf("设置房间状态失败: %w", err)
 			}
 			log.Println("没有其他可以合并的公司")
 			return nil
@@ -281,7 +290,7 @@ func handleMergingLogic(rdb *redis.Client, roomID string, playerID string, hotel
 }
 
 // 检查是否有创建、并购、扩建规则触发
-func checkTileTriggerRules(rdb *redis.Client, roomID string, playerID string, tileKey string) error {
+func checkTileTriggerRules(rdb redis.Cmdable, roomID string, playerID string, tileKey string) error {
 	adjTiles := getAdjacentTileKeys(tileKey)
 	companySet := make(map[string]struct{})
 	blankTileCount := 0
@@ -323,10 +332,9 @@ func checkTileTriggerRules(rdb *redis.Client, roomID string, playerID string, ti
 		for _, tileKeyBlank := range connectedTiles {
 			// 写回 Redis
 			if err := UpdateTileValue(rdb, roomID, tileKeyBlank, dto.Tile{ID: tileKeyBlank, Belong: company}); err != nil {
-				log.Printf("❌ 更新 tile %s 失败: %v", tileKeyBlank, err)
-			} else {
-				log.Printf("✅ 成功更新 tile %s 的归属为 %s", tileKeyBlank, company)
+				return fmt.Errorf("更新 tile %s 失败: %w", tileKeyBlank, err)
 			}
+			log.Printf("✅ 成功更新 tile %s 的归属为 %s", tileKeyBlank, company)
 		}
 
 		companyKey := fmt.Sprintf("room:%s:company:%s", roomID, company)
@@ -357,10 +365,14 @@ func checkTileTriggerRules(rdb *redis.Client, roomID string, playerID string, ti
 			return fmt.Errorf("写回公司数据失败: %w", err)
 		}
 		log.Println("✅ 公司数据已更新:", companyData)
+		recordGameEvent(roomID, "chain_grown", map[string]interface{}{
+			"company": company,
+			"tiles":   companyData.Tiles,
+		})
 
-		err = HandlePostTilePlacement(repository.Rdb, repository.Ctx, roomID, playerID)
+		err = HandlePostTilePlacement(rdb, repository.Ctx, roomID, playerID)
 		if err != nil {
-			log.Println("处理玩家放置 tile 后逻辑失败:", err)
+			return fmt.Errorf("处理玩家放置 tile 后逻辑失败: %w", err)
 		}
 		return nil
 	}
@@ -380,7 +392,7 @@ func checkTileTriggerRules(rdb *redis.Client, roomID string, playerID string, ti
 		if !flag {
 			err = SetGameStatus(rdb, roomID, dto.RoomStatusBuyStock)
 			if err != nil {
-				log.Println("❌ 设置房间状态失败:", err)
+				return fmt.Errorf("设置房间状态失败: %w", err)
 			}
 			log.Println("没有可以创建的公司")
 			return nil
@@ -388,93 +400,77 @@ func checkTileTriggerRules(rdb *redis.Client, roomID string, playerID string, ti
 
 		log.Println("⚠️ 触发创建公司规则！创建一个酒店:")
 		// Step 1: 修改房间状态为“创建公司状态”
-		SetGameStatus(rdb, roomID, dto.RoomStatusCreateCompany)
-		return nil
+		return SetGameStatus(rdb, roomID, dto.RoomStatusCreateCompany)
 	}
 
-	err := HandlePostTilePlacement(repository.Rdb, repository.Ctx, roomID, playerID)
+	err := HandlePostTilePlacement(rdb, repository.Ctx, roomID, playerID)
 	if err != nil {
-		log.Println("处理玩家放置 tile 后逻辑失败:", err)
+		return fmt.Errorf("处理玩家放置 tile 后逻辑失败: %w", err)
 	}
 	return nil
 }
 
 // 处理玩家放置 tile 消息
-func handlePlaceTileMessage(conn ReadWriteConn, rdb *redis.Client, roomID string, playerID string, msgMap map[string]interface{}) {
+func handlePlaceTileMessage(conn ReadWriteConn, rdb redis.Cmdable, roomID string, playerID string, msgMap map[string]interface{}) error {
 	currentPlayer, err := GetCurrentPlayer(rdb, repository.Ctx, roomID)
 	if err != nil {
-		log.Println("❌ 获取当前玩家失败:", err)
-		return
+		return fmt.Errorf("获取当前玩家失败: %w", err)
 	}
 	if currentPlayer != playerID {
-		log.Println("❌ 不是当前玩家的回合")
-		return
+		return fmt.Errorf("不是当前玩家的回合")
 	}
 
 	roomInfo, err := GetRoomInfo(rdb, roomID)
 	if err != nil {
-		log.Println("❌ 获取房间信息失败:", err)
-		return
+		return fmt.Errorf("获取房间信息失败: %w", err)
 	}
 	if roomInfo.GameStatus != dto.RoomStatusSetTile {
-		log.Println("❌ 不是放置 tile 的状态")
-		return
+		return fmt.Errorf("不是放置 tile 的状态")
 	}
 
-	tileKey, ok := msgMap["payload"].(string)
-	if !ok {
-		log.Println("无效的 payload")
-		return
+	payload, err := payloadOf[PlaceTilePayload](msgMap)
+	if err != nil {
+		return err
 	}
+	tileKey := string(payload)
 	// Step1: 放置棋子
-	err = placeTile(repository.Rdb, repository.Ctx, roomID, playerID, tileKey)
+	err = placeTile(rdb, repository.Ctx, roomID, playerID, tileKey)
 	if err != nil {
-		log.Println("放置棋子失败", tileKey)
-		return
+		return fmt.Errorf("放置棋子 %s 失败: %w", tileKey, err)
 	}
 	// Step2: 检查 创建公司/并购公司
-	err = checkTileTriggerRules(repository.Rdb, roomID, playerID, tileKey)
-	if err != nil {
-		log.Println(err)
-		return
-	}
+	return checkTileTriggerRules(rdb, roomID, playerID, tileKey)
 }
 
-func handleMergingSelectionMessage(conn ReadWriteConn, rdb *redis.Client, roomID string, playerID string, msgMap map[string]interface{}) {
+func handleMergingSelectionMessage(conn ReadWriteConn, rdb redis.Cmdable, roomID string, playerID string, msgMap map[string]interface{}) error {
 	currentPlayer, err := GetCurrentPlayer(rdb, repository.Ctx, roomID)
 	if err != nil {
-		log.Println("❌ 获取当前玩家失败:", err)
-		return
+		return fmt.Errorf("获取当前玩家失败: %w", err)
 	}
 	if currentPlayer != playerID {
-		log.Println("❌ 不是当前玩家的回合")
-		return
+		return fmt.Errorf("不是当前玩家的回合")
 	}
 
 	roomInfo, err := GetRoomInfo(rdb, roomID)
 	if err != nil {
-		log.Println("❌ 获取房间信息失败:", err)
-		return
+		return fmt.Errorf("获取房间信息失败: %w", err)
 	}
 	if roomInfo.GameStatus != dto.RoomStatusMergingSelection {
-		log.Println("❌ 不是 merging_selection 的状态")
-		return
+		return fmt.Errorf("不是 merging_selection 的状态")
 	}
-	maincompany, ok := msgMap["payload"].(string)
-	if !ok {
-		log.Println("❌ 留下的公司格式错误")
-		return
+	payload, err := payloadOf[MergingSelectionPayload](msgMap)
+	if err != nil {
+		return err
 	}
+	maincompany := string(payload)
 
 	mergeSelectionTemp, err := GetMergingSelection(rdb, repository.Ctx, roomID)
 	if err != nil {
-		log.Println("❌ 获取合并选择失败:", err)
-		return
+		return fmt.Errorf("获取合并选择失败: %w", err)
 	}
 	companyInfo, err := GetCompanyInfo(rdb, roomID)
 	if err != nil {
-		log.Println("❌ 获取公司信息失败:", err)
-		return
+		return fmt.Errorf("获取公司信息失败: %w", err)
 	}
 
 	for _, company := range mergeSelectionTemp.MainCompany {
@@ -502,26 +498,23 @@ func handleMergingSelectionMessage(conn ReadWriteConn, rdb *redis.Client, roomID
 
 	err = handleMergeProcess(rdb, roomID, maincompany, mergeSelectionTemp.OtherCompany, hotelTileCount)
 	if err != nil {
-		log.Println("❌ 处理合并过程失败:", err)
-		return
+		return fmt.Errorf("处理合并过程失败: %w", err)
 	}
+	return nil
 }
 
-func handleMergingSettleMessage(conn ReadWriteConn, rdb *redis.Client, roomID string, playerID string, msgMap map[string]interface{}) {
+func handleMergingSettleMessage(conn ReadWriteConn, rdb redis.Cmdable, roomID string, playerID string, msgMap map[string]interface{}) error {
 	roomInfo, err := GetRoomInfo(rdb, roomID)
 	if err != nil {
-		log.Println("❌ 获取房间信息失败:", err)
-		return
+		return fmt.Errorf("获取房间信息失败: %w", err)
 	}
 	if roomInfo.GameStatus != dto.RoomStatusMergingSettle {
-		log.Println("❌ 不是合并 的状态")
-		return
+		return fmt.Errorf("不是合并 的状态")
 	}
 
 	mergeSettleData, err := GetMergeSettleData(repository.Ctx, rdb, roomID)
 	if err != nil {
-		log.Printf("❌ 获取合并数据失败: %v\n", err)
-		return
+		return fmt.Errorf("获取合并数据失败: %w", err)
 	}
 
 	playerInHoder := false
@@ -534,15 +527,13 @@ func handleMergingSettleMessage(conn ReadWriteConn, rdb *redis.Client, roomID st
 		}
 	}
 	if !playerInHoder {
-		log.Println("❌ 玩家不在任何合并中")
-		return
+		return fmt.Errorf("玩家不在任何合并中")
 	}
 	lockKey := fmt.Sprintf("lock:merge_settle:%s", roomID)
 	lockValue := uuid.NewString()
 	locked, err := rdb.SetNX(repository.Ctx, lockKey, lockValue, 5*time.Second).Result()
 	if err != nil || !locked {
-		log.Printf("⚠️ 玩家[%s]尝试结算但加锁失败，可能有人在操作中...\n", playerID)
-		return
+		return fmt.Errorf("玩家[%s]尝试结算但加锁失败，可能有人在操作中", playerID)
 	}
 	defer func() {
 		val, err := rdb.Get(repository.Ctx, lockKey).Result()
@@ -551,38 +542,24 @@ func handleMergingSettleMessage(conn ReadWriteConn, rdb *redis.Client, roomID st
 		}
 	}()
 
-	payloadRaw := msgMap["payload"]
-
-	// 将 interface{} 编码成 JSON
-	payloadBytes, err := json.Marshal(payloadRaw)
+	settleActions, err := payloadOf[MergingSettlePayload](msgMap)
 	if err != nil {
-		log.Println("❌ payload 编码失败:", err)
-		return
-	}
-
-	// 反序列化为结构体切片
-	var settleActions []dto.MergingSettleItem
-	if err := json.Unmarshal(payloadBytes, &settleActions); err != nil {
-		log.Println("❌ payload 反序列化失败:", err)
-		return
+		return err
 	}
 
 	companyInfo, err := GetCompanyInfo(rdb, roomID)
 	if err != nil {
-		log.Println("❌ 获取公司信息失败:", err)
-		return
+		return fmt.Errorf("获取公司信息失败: %w", err)
 	}
 
 	stockMap, err := GetPlayerStocks(rdb, repository.Ctx, roomID, playerID)
 	if err != nil {
-		log.Printf("❌ 获取玩家[%s]股票失败: %v\n", playerID, err)
-		return
+		return fmt.Errorf("获取玩家[%s]股票失败: %w", playerID, err)
 	}
 
 	mergeMainCompany, err := GetMergeMainCompany(rdb, repository.Ctx, roomID)
 	if err != nil {
-		log.Printf("❌ 获取合并主公司失败: %v\n", err)
-		return
+		return fmt.Errorf("获取合并主公司失败: %w", err)
 	}
 
 	for _, item := range settleActions {
@@ -599,8 +576,7 @@ func handleMergingSettleMessage(conn ReadWriteConn, rdb *redis.Client, roomID st
 			stockMap[item.Company] -= sellAmount
 			money := sellAmount * companyData.StockPrice
 			if err := AddPlayerMoney(rdb, repository.Ctx, roomID, playerID, money); err != nil {
-				log.Printf("❌ 扣除玩家[%s]股票失败: %v\n", playerID, err)
-				return
+				return fmt.Errorf("扣除玩家[%s]股票失败: %w", playerID, err)
 			}
 		}
 
@@ -613,9 +589,13 @@ func handleMergingSettleMessage(conn ReadWriteConn, rdb *redis.Client, roomID st
 
 	err = SetPlayerStocks(rdb, repository.Ctx, roomID, playerID, stockMap)
 	if err != nil {
-		log.Printf("❌ 保存玩家[%s]股票失败: %v\n", playerID, err)
-		return
+		return fmt.Errorf("保存玩家[%s]股票失败: %w", playerID, err)
 	}
+	recordGameEvent(roomID, "stocks_settled", map[string]interface{}{
+		"playerID":    playerID,
+		"mainCompany": mergeMainCompany,
+		"actions":     settleActions,
+	})
 
 	allHodersCleared := true
 	// 移除 Hoders 中的 playerID
@@ -638,8 +618,7 @@ func handleMergingSettleMessage(conn ReadWriteConn, rdb *redis.Client, roomID st
 	if allHodersCleared {
 		lastTile, err := GetLastTileKey(rdb, repository.Ctx, roomID)
 		if err != nil {
-			log.Printf("❌ 获取当前创建公司 tile key 失败: %v\n", err)
-			return
+			return fmt.Errorf("获取当前创建公司 tile key 失败: %w", err)
 		}
 
 		connTile := getConnectedTiles(rdb, roomID, lastTile)
@@ -650,8 +629,7 @@ func handleMergingSettleMessage(conn ReadWriteConn, rdb *redis.Client, roomID st
 
 		tileMap, err := GetAllRoomTiles(rdb, roomID)
 		if err != nil {
-			log.Printf("❌ 获取房间 tile 信息失败: %v\n", err)
-			return
+			return fmt.Errorf("获取房间 tile 信息失败: %w", err)
 		}
 
 		for key, tile := range tileMap {
@@ -671,82 +649,80 @@ func handleMergingSettleMessage(conn ReadWriteConn, rdb *redis.Client, roomID st
 
 		err = SetAllRoomTiles(rdb, roomID, tileMap)
 		if err != nil {
-			log.Printf("❌ 保存房间 tile 信息失败: %v\n", err)
-			return
+			return fmt.Errorf("保存房间 tile 信息失败: %w", err)
 		}
 		if err != nil {
-			log.Printf("❌ 获取最后一个 tile key 失败: %v\n", err)
-			return
+			return fmt.Errorf("获取最后一个 tile key 失败: %w", err)
 		}
 		adj := getAdjacentTileKeys(lastTile)
 		for _, key := range adj {
 			tile, err := GetTileFromRedis(rdb, repository.Ctx, roomID, key)
 			if err != nil {
-				log.Printf("❌ 获取 tileBelong 失败: %v\n", err)
-				return
+				return fmt.Errorf("获取 tileBelong 失败: %w", err)
 			}
 			if tile.Belong == "Blank" {
 				tile.Belong = mergeMainCompany
 				err = UpdateTileValue(rdb, roomID, key, tile)
 				if err != nil {
-					log.Printf("❌ 更新 tileBelong 失败: %v\n", err)
-					return
+					return fmt.Errorf("更新 tileBelong 失败: %w", err)
 				}
 			}
 		}
 
 		err = SetGameStatus(rdb, roomID, dto.RoomStatusBuyStock)
 		if err != nil {
-			log.Printf("❌ 设置游戏状态失败: %v\n", err)
-			return
+			return fmt.Errorf("设置游戏状态失败: %w", err)
 		}
 		if err := SetMergeSettleData(repository.Ctx, rdb, roomID, map[string]dto.SettleData{}); err != nil {
-			log.Printf("❌ 保存结算数据失败: %v\n", err)
-			return
+			return fmt.Errorf("保存结算数据失败: %w", err)
+		}
+		acquired := make([]string, 0, len(mergeSettleData))
+		for company := range mergeSettleData {
+			acquired = append(acquired, company)
 		}
+		sort.Strings(acquired)
+		recordGameEvent(roomID, "merger_completed", map[string]interface{}{
+			"mainCompany": mergeMainCompany,
+			"acquired":    acquired,
+		})
 	} else {
 		// 保存结果
 		if err := SetMergeSettleData(repository.Ctx, rdb, roomID, mergeSettleData); err != nil {
-			log.Printf("❌ 保存结算数据失败: %v\n", err)
-			return
+			return fmt.Errorf("保存结算数据失败: %w", err)
 		}
 	}
+	return nil
 }
 
-func handleCreateCompanyMessage(conn ReadWriteConn, rdb *redis.Client, roomID string, playerID string, msgMap map[string]interface{}) {
+func handleCreateCompanyMessage(conn ReadWriteConn, rdb redis.Cmdable, roomID string, playerID string, msgMap map[string]interface{}) error {
 	currentPlayer, err := GetCurrentPlayer(rdb, repository.Ctx, roomID)
 	if err != nil {
-		log.Println("❌ 获取当前玩家失败:", err)
-		return
+		return fmt.Errorf("获取当前玩家失败: %w", err)
 	}
 	if currentPlayer != playerID {
-		log.Println("❌ 不是当前玩家的回合")
-		return
+		return fmt.Errorf("不是当前玩家的回合")
 	}
 
 	roomInfo, err := GetRoomInfo(rdb, roomID)
 	if err != nil {
-		log.Println("❌ 获取房间信息失败:", err)
-		return
+		return fmt.Errorf("获取房间信息失败: %w", err)
 	}
 	if roomInfo.GameStatus != dto.RoomStatusCreateCompany {
-		log.Println("❌ 不是创建公司的状态")
-		return
+		return fmt.Errorf("不是创建公司的状态")
 	}
 
-	company, ok := msgMap["payload"].(string)
-	if !ok {
-		log.Println("❌ 无效的 payload")
-		return
+	payload, err := payloadOf[CreateCompanyPayload](msgMap)
+	if err != nil {
+		return err
 	}
+	company := string(payload)
 	log.Println("✅ 收到 create_company 消息，目标 company:", company)
 
 	// Step 1: 取出 createTileKey
 	createTileKey := fmt.Sprintf("room:%s:last_tile_key_temp", roomID)
 	tileKey, err := rdb.Get(repository.Ctx, createTileKey).Result()
 	if err != nil {
-		log.Println("❌ 获取 createTileKey 失败:", err)
-		return
+		return fmt.Errorf("获取 createTileKey 失败: %w", err)
 	}
 	log.Println("✅ 创建公司使用的 tileKey:", tileKey)
 
@@ -756,12 +732,10 @@ func handleCreateCompanyMessage(conn ReadWriteConn, rdb *redis.Client, roomID st
 	// 获取公司 Hash 数据
 	companyMap, err := rdb.HGetAll(repository.Ctx, companyKey).Result()
 	if err != nil {
-		log.Println("❌ 获取公司 Hash 数据失败:", err)
-		return
+		return fmt.Errorf("获取公司 Hash 数据失败: %w", err)
 	}
 	if len(companyMap) == 0 {
-		log.Println("❌ 公司 Hash 数据为空")
-		return
+		return fmt.Errorf("公司 Hash 数据为空")
 	}
 
 	var companyData dto.Company
@@ -772,8 +746,7 @@ func handleCreateCompanyMessage(conn ReadWriteConn, rdb *redis.Client, roomID st
 	}
 	decoder, _ := mapstructure.NewDecoder(decoderConfig)
 	if err := decoder.Decode(companyMap); err != nil {
-		log.Println("❌ 公司数据解析失败:", err)
-		return
+		return fmt.Errorf("公司数据解析失败: %w", err)
 	}
 	// 统计公司 tiles 数量
 	connectedTiles := getConnectedTiles(rdb, roomID, tileKey)
@@ -787,16 +760,14 @@ func handleCreateCompanyMessage(conn ReadWriteConn, rdb *redis.Client, roomID st
 	}
 
 	if err := rdb.HSet(repository.Ctx, companyKey, companyUpdateMap).Err(); err != nil {
-		log.Println("❌ 写回公司数据失败:", err)
-		return
+		return fmt.Errorf("写回公司数据失败: %w", err)
 	}
 
 	log.Println("✅ 公司数据已更新:", companyData)
 
 	tileMap, err := GetAllRoomTiles(rdb, roomID)
 	if err != nil {
-		log.Println("❌ 获取房间所有 tile 数据失败:", err)
-		return
+		return fmt.Errorf("获取房间所有 tile 数据失败: %w", err)
 	}
 
 	for _, tileKey := range connectedTiles {
@@ -811,21 +782,24 @@ func handleCreateCompanyMessage(conn ReadWriteConn, rdb *redis.Client, roomID st
 
 		// 写回 Redis
 		if err := UpdateTileValue(rdb, roomID, tileKey, tile); err != nil {
-			log.Printf("❌ 更新 tile %s 失败: %v", tileKey, err)
-		} else {
-			log.Printf("✅ 成功更新 tile %s 的归属为 %s", tileKey, company)
+			return fmt.Errorf("更新 tile %s 失败: %w", tileKey, err)
 		}
+		log.Printf("✅ 成功更新 tile %s 的归属为 %s", tileKey, company)
 	}
 	// Step 3: 增加玩家的股票数据
 	playerStockKey := fmt.Sprintf("room:%s:player:%s:stocks", roomID, playerID)
 	if err := rdb.HIncrBy(repository.Ctx, playerStockKey, company, 1).Err(); err != nil {
-		log.Println("❌ 增加玩家股票失败:", err)
-		return
+		return fmt.Errorf("增加玩家股票失败: %w", err)
 	}
 	log.Println("✅ 玩家获得 1 股", company, "股票")
+	recordGameEvent(roomID, "chain_founded", map[string]interface{}{
+		"playerID": playerID,
+		"company":  company,
+		"tiles":    companyData.Tiles,
+	})
 
 	// Step 4: 清除 createTileKey
 	// _ = rdb.Del(repository.Ctx, createTileKey).Err()
 	// Step 5:🔥 清除玩家的 tile
-	SetGameStatus(rdb, roomID, dto.RoomStatusBuyStock)
+	return SetGameStatus(rdb, roomID, dto.RoomStatusBuyStock)
 }
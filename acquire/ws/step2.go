@@ -13,7 +13,7 @@ import (
 )
 
 // UpdateCompanyStockAndTiles 更新公司数据（stockTotal 减少）
-func UpdateCompanyStockAndTiles(rdb *redis.Client, roomID string, company string) error {
+func UpdateCompanyStockAndTiles(rdb redis.Cmdable, roomID string, company string) error {
 	companyKey := fmt.Sprintf("room:%s:company:%s", roomID, company)
 
 	companyMap, err := rdb.HGetAll(repository.Ctx, companyKey).Result()
@@ -52,7 +52,7 @@ func UpdateCompanyStockAndTiles(rdb *redis.Client, roomID string, company string
 }
 
 // UpdatePlayerStockAndMoney 更新玩家数据
-func UpdatePlayerStockAndMoney(rdb *redis.Client, ctx context.Context, roomID string, playerID string, company string, stockCount int, totalPrice int) error {
+func UpdatePlayerStockAndMoney(rdb redis.Cmdable, ctx context.Context, roomID string, playerID string, company string, stockCount int, totalPrice int) error {
 	// 获取当前金额
 	playerInfo, err := GetPlayerInfoField(rdb, ctx, roomID, playerID, "money")
 	if err != nil {
@@ -99,41 +99,25 @@ type BuyStockRequest struct {
 	Stocks map[string]float64 `json:"stocks"`
 }
 
-func handleBuyStockMessage(conn ReadWriteConn, rdb *redis.Client, roomID string, playerID string, msgMap map[string]interface{}) {
+func handleBuyStockMessage(conn ReadWriteConn, rdb redis.Cmdable, roomID string, playerID string, msgMap map[string]interface{}) error {
 	currentPlayer, err := GetCurrentPlayer(rdb, repository.Ctx, roomID)
 	if err != nil {
-		log.Println("❌ 获取当前玩家失败:", err)
-		return
+		return fmt.Errorf("获取当前玩家失败: %w", err)
 	}
 	if currentPlayer != playerID {
-		log.Println("❌ 不是当前玩家的回合")
-		return
+		return fmt.Errorf("不是当前玩家的回合")
 	}
 
 	roomInfo, err := GetRoomInfo(rdb, roomID)
 	if err != nil {
-		log.Println("❌ 获取房间信息失败:", err)
-		return
+		return fmt.Errorf("获取房间信息失败: %w", err)
 	}
 	if roomInfo.GameStatus != dto.RoomStatusBuyStock {
-		log.Println("❌ 不是 buyStock 的状态")
-		return
+		return fmt.Errorf("不是 buyStock 的状态")
 	}
-	payloadMap, ok := msgMap["payload"].(map[string]interface{})
-	if !ok {
-		log.Println("❌ 股票数据格式错误")
-		return
-	}
-
-	stocks := make(map[string]int)
-
-	for k, v := range payloadMap {
-		// 判断 v 是不是 float64，再转换成 int
-		if f, ok := v.(float64); ok {
-			stocks[k] = int(f)
-		} else {
-			log.Printf("⚠️ 股票值类型错误: key=%s val=%v type=%T\n", k, v, v)
-		}
+	stocks, err := payloadOf[BuyStockPayload](msgMap)
+	if err != nil {
+		return err
 	}
 
 	totalPrice := 0
@@ -146,8 +130,7 @@ func handleBuyStockMessage(conn ReadWriteConn, rdb *redis.Client, roomID string,
 		companyKey := fmt.Sprintf("room:%s:company:%s", roomID, company)
 		priceStr, err := rdb.HGet(repository.Ctx, companyKey, "stockPrice").Result()
 		if err != nil {
-			log.Println("❌ 获取股价失败:", company, err)
-			return
+			return fmt.Errorf("获取公司[%s]股价失败: %w", company, err)
 		}
 		price, _ := strconv.Atoi(priceStr)
 		priceMap[company] = price
@@ -159,8 +142,7 @@ func handleBuyStockMessage(conn ReadWriteConn, rdb *redis.Client, roomID string,
 		count := countVal
 		for i := 0; i < count; i++ {
 			if err := UpdateCompanyStockAndTiles(rdb, roomID, company); err != nil {
-				log.Println("❌ 更新公司失败:", err)
-				return
+				return fmt.Errorf("更新公司失败: %w", err)
 			}
 		}
 	}
@@ -169,24 +151,30 @@ func handleBuyStockMessage(conn ReadWriteConn, rdb *redis.Client, roomID string,
 	for company, countVal := range stocks {
 		count := countVal
 		if err := UpdatePlayerStockAndMoney(rdb, repository.Ctx, roomID, playerID, company, count, priceMap[company]*count); err != nil {
-			log.Println("❌ 更新玩家失败:", err)
-			return
+			return fmt.Errorf("更新玩家失败: %w", err)
 		}
 	}
 
-	err = GiveRandomTileToPlayer(repository.Rdb, repository.Ctx, roomID, playerID)
+	recordGameEvent(roomID, "stocks_bought", map[string]interface{}{
+		"playerID": playerID,
+		"stocks":   stocks,
+		"cost":     totalPrice,
+	})
+
+	err = GiveRandomTileToPlayer(rdb, repository.Ctx, roomID, playerID)
 	if err != nil {
-		log.Println("发牌失败:", err)
+		return fmt.Errorf("发牌失败: %w", err)
 	}
 	// 切换玩家
 	if err := SwitchToNextPlayer(rdb, repository.Ctx, roomID, playerID); err != nil {
-		log.Println("切换玩家失败:", err)
+		return fmt.Errorf("切换玩家失败: %w", err)
 	}
 	// 最后设置房间状态为 setTile
 	err = SetGameStatus(rdb, roomID, dto.RoomStatusSetTile)
 	if err != nil {
-		log.Println("❌ 设置房间状态失败:", err)
+		return fmt.Errorf("设置房间状态失败: %w", err)
 	}
 
 	log.Println("✅ 玩家购买股票成功")
+	return nil
 }
@@ -0,0 +1,195 @@
+package ws
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func decodeJSON(t *testing.T, data string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(data), &v); err != nil {
+		t.Fatalf("解析 %s 失败: %v", data, err)
+	}
+	return v
+}
+
+func TestDiffJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to   string
+		want []patchOp
+	}{
+		{
+			name: "相同",
+			from: `{"a":1,"b":[1,2]}`,
+			to:   `{"a":1,"b":[1,2]}`,
+			want: nil,
+		},
+		{
+			name: "修改字段",
+			from: `{"a":1,"b":"x"}`,
+			to:   `{"a":2,"b":"x"}`,
+			want: []patchOp{{Op: "replace", Path: "/a", Value: json.RawMessage(`2`)}},
+		},
+		{
+			name: "先删除再按字段名添加",
+			from: `{"b":1,"c":2}`,
+			to:   `{"a":true,"b":1,"d":null}`,
+			want: []patchOp{
+				{Op: "remove", Path: "/c"},
+				{Op: "add", Path: "/a", Value: json.RawMessage(`true`)},
+				{Op: "add", Path: "/d", Value: json.RawMessage(`null`)},
+			},
+		},
+		{
+			name: "嵌套对象",
+			from: `{"players":{"p0":{"money":6000,"tiles":["1A"]}}}`,
+			to:   `{"players":{"p0":{"money":5400,"tiles":["1A"]}}}`,
+			want: []patchOp{{Op: "replace", Path: "/players/p0/money", Value: json.RawMessage(`5400`)}},
+		},
+		{
+			name: "数组长度不变逐个元素比较",
+			from: `[1,{"x":1},3]`,
+			to:   `[1,{"x":2},4]`,
+			want: []patchOp{
+				{Op: "replace", Path: "/1/x", Value: json.RawMessage(`2`)},
+				{Op: "replace", Path: "/2", Value: json.RawMessage(`4`)},
+			},
+		},
+		{
+			name: "数组长度变化整体替换",
+			from: `{"log":["a"]}`,
+			to:   `{"log":["a","b"]}`,
+			want: []patchOp{{Op: "replace", Path: "/log", Value: json.RawMessage(`["a","b"]`)}},
+		},
+		{
+			name: "类型变化",
+			from: `{"a":{"x":1}}`,
+			to:   `{"a":[1]}`,
+			want: []patchOp{{Op: "replace", Path: "/a", Value: json.RawMessage(`[1]`)}},
+		},
+		{
+			name: "字段名转义",
+			from: `{"a/b":1,"c~d":1}`,
+			to:   `{"a/b":2,"c~d":2}`,
+			want: []patchOp{
+				{Op: "replace", Path: "/a~1b", Value: json.RawMessage(`2`)},
+				{Op: "replace", Path: "/c~0d", Value: json.RawMessage(`2`)},
+			},
+		},
+		{
+			name: "整体替换",
+			from: `1`,
+			to:   `"x"`,
+			want: []patchOp{{Op: "replace", Path: "", Value: json.RawMessage(`"x"`)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to := decodeJSON(t, tt.from), decodeJSON(t, tt.to)
+			got := diffJSON("", from, to, nil)
+			if !reflect.DeepEqual(got, tt.want) {
+				gotData, _ := json.Marshal(got)
+				wantData, _ := json.Marshal(tt.want)
+				t.Fatalf("diffJSON = %s，期望 %s", gotData, wantData)
+			}
+			// 生成的操作应用到 from 上应该得到 to
+			patched, err := applyPatch(from, got)
+			if err != nil {
+				t.Fatalf("applyPatch 失败: %v", err)
+			}
+			if !reflect.DeepEqual(patched, decodeJSON(t, tt.to)) {
+				t.Fatalf("applyPatch 结果 %v，期望 %s", patched, tt.to)
+			}
+		})
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		ops     []patchOp
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "数组末尾添加",
+			doc:  `{"a":[1]}`,
+			ops:  []patchOp{{Op: "add", Path: "/a/-", Value: json.RawMessage(`2`)}},
+			want: `{"a":[1,2]}`,
+		},
+		{
+			name: "数组中间插入",
+			doc:  `[1,3]`,
+			ops:  []patchOp{{Op: "add", Path: "/1", Value: json.RawMessage(`2`)}},
+			want: `[1,2,3]`,
+		},
+		{
+			name: "删除数组元素",
+			doc:  `{"a":[1,2,3]}`,
+			ops:  []patchOp{{Op: "remove", Path: "/a/0"}},
+			want: `{"a":[2,3]}`,
+		},
+		{
+			name: "按顺序应用多个操作",
+			doc:  `{"a":1}`,
+			ops: []patchOp{
+				{Op: "add", Path: "/b", Value: json.RawMessage(`{}`)},
+				{Op: "add", Path: "/b/c", Value: json.RawMessage(`"x"`)},
+				{Op: "remove", Path: "/a"},
+			},
+			want: `{"b":{"c":"x"}}`,
+		},
+		{
+			name: "转义的字段名",
+			doc:  `{"a/b":{"c~d":1}}`,
+			ops:  []patchOp{{Op: "replace", Path: "/a~1b/c~0d", Value: json.RawMessage(`2`)}},
+			want: `{"a/b":{"c~d":2}}`,
+		},
+		{
+			name:    "字段不存在",
+			doc:     `{"a":1}`,
+			ops:     []patchOp{{Op: "replace", Path: "/b/c", Value: json.RawMessage(`1`)}},
+			wantErr: true,
+		},
+		{
+			name:    "数组下标越界",
+			doc:     `[1]`,
+			ops:     []patchOp{{Op: "replace", Path: "/1", Value: json.RawMessage(`2`)}},
+			wantErr: true,
+		},
+		{
+			name:    "路径不是对象或数组",
+			doc:     `{"a":1}`,
+			ops:     []patchOp{{Op: "replace", Path: "/a/b", Value: json.RawMessage(`2`)}},
+			wantErr: true,
+		},
+		{
+			name:    "值不是 JSON",
+			doc:     `{"a":1}`,
+			ops:     []patchOp{{Op: "replace", Path: "/a", Value: json.RawMessage(`x`)}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyPatch(decodeJSON(t, tt.doc), tt.ops)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("期望出错，得到 %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyPatch 失败: %v", err)
+			}
+			if !reflect.DeepEqual(got, decodeJSON(t, tt.want)) {
+				t.Fatalf("applyPatch 结果 %v，期望 %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-game/repository"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 投票类型
+const (
+	VoteKindRestart = "restart" // 中途重开
+	VoteKindRematch = "rematch" // 结束后再来一局
+)
+
+// 投票有效时间，超时未全部同意视为未通过
+const voteTimeout = 30 * time.Second
+
+// 房间内进行中的投票
+type roomVote struct {
+	ID        string   `json:"id"`
+	Kind      string   `json:"kind"`
+	Proposer  string   `json:"proposer"`
+	Deadline  int64    `json:"deadline"` // 毫秒时间戳
+	Approvals []string `json:"approvals"`
+	Voters    []string `json:"voters"`
+}
+
+func getRoomVote(roomID string) (*roomVote, error) {
+	key := fmt.Sprintf("room:%s:vote", roomID)
+	data, err := repository.Rdb.Get(repository.Ctx, key).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("获取投票失败: %w", err)
+	}
+	var vote roomVote
+	if err := json.Unmarshal([]byte(data), &vote); err != nil {
+		return nil, fmt.Errorf("解析投票失败: %w", err)
+	}
+	return &vote, nil
+}
+
+func setRoomVote(roomID string, vote *roomVote) error {
+	key := fmt.Sprintf("room:%s:vote", roomID)
+	data, err := json.Marshal(vote)
+	if err != nil {
+		return fmt.Errorf("序列化投票失败: %w", err)
+	}
+	// 比截止时间多留一点，超时回调负责清理和通知
+	ttl := time.Until(time.UnixMilli(vote.Deadline)) + 5*time.Second
+	if err := repository.Rdb.Set(repository.Ctx, key, data, ttl).Err(); err != nil {
+		return fmt.Errorf("保存投票失败: %w", err)
+	}
+	return nil
+}
+
+func clearRoomVote(roomID string) {
+	repository.Rdb.Del(repository.Ctx, fmt.Sprintf("room:%s:vote", roomID))
+}
+
+// 需要表决的玩家：当前在线的真人玩家，AI 不参与投票
+func onlineHumanPlayers(roomID string) []string {
+	voters := make([]string, 0)
+	for _, pc := range playersOf(roomID) {
+		if pc.Online && !IsAIPlayer(pc.PlayerID) {
+			voters = append(voters, pc.PlayerID)
+		}
+	}
+	return voters
+}
+
+// 发起投票，发起人默认同意
+func proposeVote(roomID, playerID, kind string) error {
+	if err := checkVoteAllowed(roomID, kind); err != nil {
+		return err
+	}
+	if !StringInSlice(playerID, onlineHumanPlayers(roomID)) {
+		return fmt.Errorf("只有房间内的玩家可以发起投票")
+	}
+	existing, err := getRoomVote(roomID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("已有进行中的投票")
+	}
+
+	vote := &roomVote{
+		ID:        strconv.FormatInt(time.Now().UnixNano(), 10),
+		Kind:      kind,
+		Proposer:  playerID,
+		Deadline:  time.Now().Add(voteTimeout).UnixMilli(),
+		Approvals: []string{playerID},
+	}
+	// 超时回调同样交给房间 goroutine 处理
+	time.AfterFunc(voteTimeout, func() {
+		if err := RunInRoom(roomID, func() { expireVote(roomID, vote.ID) }); err != nil {
+			log.Println("❌", err)
+		}
+	})
+	log.Printf("🗳️ 玩家 %s 在房间 %s 发起投票: %s\n", playerID, roomID, kind)
+	return settleVote(roomID, vote)
+}
+
+// 对进行中的投票表决，任意一人反对即不通过
+func castVote(roomID, playerID string, approve bool) error {
+	vote, err := getRoomVote(roomID)
+	if err != nil {
+		return err
+	}
+	if vote == nil {
+		return fmt.Errorf("当前没有进行中的投票")
+	}
+	if !StringInSlice(playerID, onlineHumanPlayers(roomID)) {
+		return fmt.Errorf("你不能参与本次投票")
+	}
+	// 投票期间对局可能已经结束或重新开始，投票内容不再适用时直接作废
+	if err := checkVoteAllowed(roomID, vote.Kind); err != nil {
+		clearRoomVote(roomID)
+		notifyVoteResult(roomID, vote, false, err.Error())
+		return err
+	}
+	if !approve {
+		clearRoomVote(roomID)
+		notifyVoteResult(roomID, vote, false, "rejected")
+		return nil
+	}
+	if !StringInSlice(playerID, vote.Approvals) {
+		vote.Approvals = append(vote.Approvals, playerID)
+	}
+	return settleVote(roomID, vote)
+}
+
+// 在线真人全部同意则执行投票内容，否则保存进度并通知房间
+func settleVote(roomID string, vote *roomVote) error {
+	vote.Voters = onlineHumanPlayers(roomID)
+	for _, voter := range vote.Voters {
+		if !StringInSlice(voter, vote.Approvals) {
+			if err := setRoomVote(roomID, vote); err != nil {
+				return err
+			}
+			sendToRoom(roomID, VoteMessage{Type: "vote", Vote: vote})
+			return nil
+		}
+	}
+
+	clearRoomVote(roomID)
+	// 再来一局先按上一局的数据生成归档，提交成功后再写入，重置失败时不留下归档
+	var archiveID string
+	var archive []byte
+	if vote.Kind == VoteKindRematch {
+		var err error
+		if archiveID, archive, err = finishedGameArchive(roomID); err != nil {
+			log.Println("❌ 归档对局失败:", err)
+		}
+	}
+	// 重开会改写整局数据，同样原子提交并递增版本号，重开前的操作会因版本落后被拒绝。
+	// 执行前按操作中的数据重新检查，发起投票之后对局状态可能已经变化
+	err := runAtomicAction(roomID, nil, func() error {
+		if err := checkVoteAllowed(roomID, vote.Kind); err != nil {
+			return err
+		}
+		return applyVote(roomID, vote.Kind)
+	})
+	if err != nil {
+		notifyVoteResult(roomID, vote, false, err.Error())
+		return err
+	}
+	if archive != nil {
+		if err := saveGameArchive(archiveID, archive); err != nil {
+			log.Println("❌ 归档对局失败:", err)
+		}
+	}
+	logGameStarted(roomID)
+	notifyGameStarted(roomID)
+	notifyVoteResult(roomID, vote, true, "")
+	return nil
+}
+
+// 投票超时：仍是同一次投票时宣布未通过
+func expireVote(roomID, voteID string) {
+	vote, err := getRoomVote(roomID)
+	if err != nil {
+		log.Println("❌", err)
+		return
+	}
+	if vote == nil || vote.ID != voteID {
+		return
+	}
+	clearRoomVote(roomID)
+	notifyVoteResult(roomID, vote, false, "timeout")
+}
+
+func notifyVoteResult(roomID string, vote *roomVote, passed bool, reason string) {
+	log.Printf("🗳️ 房间 %s 投票 %s 结束: passed=%v %s\n", roomID, vote.Kind, passed, reason)
+	sendToRoom(roomID, VoteResultMessage{
+		Type:   "vote_result",
+		Kind:   vote.Kind,
+		Passed: passed,
+		Reason: reason,
+	})
+}
+
+func handleRestartGameMessage(conn ReadWriteConn, rdb redis.Cmdable, roomID string, playerID string, msgMap map[string]interface{}) error {
+	return proposeVote(roomID, playerID, VoteKindRestart)
+}
+
+func handleRematchMessage(conn ReadWriteConn, rdb redis.Cmdable, roomID string, playerID string, msgMap map[string]interface{}) error {
+	return proposeVote(roomID, playerID, VoteKindRematch)
+}
+
+func handleVoteMessage(conn ReadWriteConn, rdb redis.Cmdable, roomID string, playerID string, msgMap map[string]interface{}) error {
+	approve, err := payloadOf[VotePayload](msgMap)
+	if err != nil {
+		return err
+	}
+	return castVote(roomID, playerID, bool(approve))
+}
@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"go-game/dto"
+	"go-game/middleware"
+	"go-game/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JoinQueue 当前用户加入匹配队列，匹配成功后通过大厅连接 /lobby/ws 推送 match_found
+func JoinQueue(c *gin.Context) {
+	var req dto.JoinQueueRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少必要字段"})
+		return
+	}
+	req.UserID = middleware.CurrentUser(c)
+	ticket, err := service.JoinQueue(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status_code": http.StatusOK,
+		"message":     "已加入匹配队列",
+		"data":        ticket,
+	})
+}
+
+// LeaveQueue 当前用户退出匹配队列
+func LeaveQueue(c *gin.Context) {
+	if err := service.LeaveQueue(middleware.CurrentUser(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "退出匹配队列失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status_code": http.StatusOK,
+		"message":     "已退出匹配队列",
+	})
+}
+
+// GetQueueStatus 当前用户的匹配状态
+func GetQueueStatus(c *gin.Context) {
+	status, err := service.GetQueueStatus(middleware.CurrentUser(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "获取匹配状态失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status_code": http.StatusOK,
+		"message":     "获取成功",
+		"data":        status,
+	})
+}
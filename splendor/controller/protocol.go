@@ -0,0 +1,17 @@
+package controller
+
+import (
+	"go-game/ws"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetProtocolSchema websocket 消息的 JSON Schema，客户端据此生成类型或校验消息
+func GetProtocolSchema(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status_code": http.StatusOK,
+		"message":     "获取成功",
+		"data":        ws.ProtocolSchema(),
+	})
+}
@@ -0,0 +1,39 @@
+package controller
+
+import (
+	"go-game/service"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetLeaderboard 等级分排行：/rating/leaderboard?page=1&pageSize=20
+func GetLeaderboard(c *gin.Context) {
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("pageSize"))
+	leaderboard, err := service.GetLeaderboard(page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "获取排行失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status_code": http.StatusOK,
+		"message":     "获取成功",
+		"data":        leaderboard,
+	})
+}
+
+// GetPlayerRating 玩家的等级分
+func GetPlayerRating(c *gin.Context) {
+	rating, err := service.GetPlayerRating(c.Param("playerID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "获取等级分失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status_code": http.StatusOK,
+		"message":     "获取成功",
+		"data":        rating,
+	})
+}
@@ -0,0 +1,22 @@
+package controller
+
+import (
+	"go-game/ws"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetPlayerStats 玩家在已结束对局中的统计数据
+func GetPlayerStats(c *gin.Context) {
+	stats, err := ws.PlayerStatsOf(c.Param("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "获取统计数据失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status_code": http.StatusOK,
+		"message":     "获取成功",
+		"data":        stats,
+	})
+}
@@ -7,12 +7,13 @@ const (
 )
 
 type NormalCard struct {
-	ID     int            `json:"id"`     // 卡牌ID
-	Level  int            `json:"level"`  // 1/2/3
-	Bonus  string         `json:"bonus"`  // 折扣颜色：emerald, diamond, sapphire, onyx, ruby
-	Points int            `json:"points"` // 荣誉分
-	Cost   map[string]int `json:"cost"`   // 五色费用
-	State  int            `json:"state"`  // 0: 未被选中, 1: 已被选中
+	ID     int            `json:"id"`              // 卡牌ID
+	Level  int            `json:"level"`           // 1/2/3
+	Bonus  string         `json:"bonus"`           // 折扣颜色：emerald, diamond, sapphire, onyx, ruby
+	Points int            `json:"points"`          // 荣誉分
+	Cost   map[string]int `json:"cost"`            // 五色费用
+	State  int            `json:"state"`           // 0: 未被选中, 1: 已被选中
+	Blind  bool           `json:"blind,omitempty"` // 从牌堆盲抽预留，其他人只能看到等级
 }
 
 type NobleCard struct {
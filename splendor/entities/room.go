@@ -17,6 +17,7 @@ type RoomInfo struct {
 	GameStatus RoomStatus `json:"gameStatus"`
 	MaxPlayers int        `json:"maxPlayers"`
 	UserID     string     `json:"userID"`
+	Private    bool       `json:"private"` // 私人房间不允许观战
 }
 
 type RoomStatus string
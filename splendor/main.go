@@ -1,9 +1,16 @@
 package main
 
 import (
+	"context"
 	"go-game/repository"
 	"go-game/router"
+	"go-game/service"
 	"go-game/ws"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -12,9 +19,15 @@ import (
 
 func main() {
 	repository.InitRedis()
+	repository.InitMatchStore()
+	ws.StartCluster()
+	ws.RestoreRooms()
+	service.StartMatchmaker()
+	service.StartTournaments()
+	service.StartDailyChallenge()
 
 	r := gin.Default()
-	go ws.ScheduleDailyRoomReset()
+	go ws.ScheduleRoomReaper()
 	// 设置 CORS 中间件，允许所有域名、所有方法、所有 header
 	r.Use(cors.New(cors.Config{
 		AllowAllOrigins: true, // 允许所有来源
@@ -26,5 +39,27 @@ func main() {
 
 	router.InitRouter(r)
 
-	r.Run(":8000")
+	srv := &http.Server{Addr: ":8000", Handler: r}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("服务启动失败: %v", err)
+		}
+	}()
+
+	// 收到退出信号后优雅停机，docker 默认 10 秒后强制结束
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	log.Println("⏳ 正在停机...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Println("❌ 关闭 HTTP 服务失败:", err)
+	}
+	ws.Shutdown(ctx)
+	if err := repository.Matches.Close(); err != nil {
+		log.Println("❌ 关闭对局历史数据库失败:", err)
+	}
+	log.Println("✅ 服务已停止")
 }
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"go-game/utils"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContextUserID 鉴权通过后，当前用户 ID 在 gin.Context 中的键
+const ContextUserID = "userID"
+
+// AuthMiddleware 校验 access token，并把其中的用户 ID 写入上下文。
+// token 放在 Authorization: Bearer <token>，websocket 握手无法设置请求头时可以用 ?token= 传递
+func AuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := requestToken(c)
+		if token == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+			c.Abort()
+			return
+		}
+		authenticate(c, token)
+	}
+}
+
+// OptionalAuthMiddleware 带 token 时和 AuthMiddleware 一样校验，不带 token 时以匿名身份继续
+func OptionalAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token := requestToken(c); token != "" {
+			authenticate(c, token)
+			return
+		}
+		c.Next()
+	}
+}
+
+func requestToken(c *gin.Context) string {
+	if token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "); token != "" {
+		return token
+	}
+	return c.Query("token")
+}
+
+// 校验 token 并写入用户 ID，失败时中止请求
+func authenticate(c *gin.Context, token string) {
+	claims, err := utils.ParseAccessToken(token)
+	if err != nil || claims.UserID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "登录已失效"})
+		c.Abort()
+		return
+	}
+	c.Set(ContextUserID, claims.UserID)
+	c.Next()
+}
+
+// CurrentUser 鉴权中间件写入的用户 ID，未经过鉴权时为空
+func CurrentUser(c *gin.Context) string {
+	return c.GetString(ContextUserID)
+}
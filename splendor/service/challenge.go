@@ -0,0 +1,338 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-game/dto"
+	"go-game/repository"
+	"go-game/ws"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 每日挑战：每天凌晨 4 点换一个随机种子和 AI 阵容，所有人用同一个种子（Acquire 的 tile 袋、Splendor 的牌堆顺序）
+// 对同样的 AI，每人每天只能挑战一次（开局时才算一次），成绩进入当天的排行。
+// 挑战以 challenge:<日期> 保存在 Redis，多个实例同时换日时只有第一个写入的生效
+const (
+	challengeResetHour = 4
+	challengeDateFmt   = "2006-01-02"
+	challengeTTL       = 8 * 24 * time.Hour // 保留最近一周的排行
+	challengeRoomsKey  = "challenge:rooms"  // roomID -> 日期|userID
+)
+
+var ErrChallengeNotFound = errors.New("当天没有每日挑战")
+
+func challengeKey(date string) string {
+	return fmt.Sprintf("challenge:%s", date)
+}
+
+func challengeAttemptsKey(date string) string {
+	return fmt.Sprintf("challenge:%s:attempts", date)
+}
+
+func challengeScoresKey(date string) string {
+	return fmt.Sprintf("challenge:%s:scores", date)
+}
+
+func challengeResultsKey(date string) string {
+	return fmt.Sprintf("challenge:%s:results", date)
+}
+
+// DailyChallenge 一天的挑战，种子不对外公开
+type DailyChallenge struct {
+	Date         string `json:"date"`
+	Game         string `json:"game"`
+	Seed         uint64 `json:"-"`
+	Bots         int    `json:"bots"`
+	AiDifficulty string `json:"aiDifficulty"`
+	EndsAt       int64  `json:"endsAt"`              // 下次换日时间（毫秒）
+	Attempted    bool   `json:"attempted,omitempty"` // 查询的玩家今天是否已挑战
+}
+
+// ChallengeResult 玩家一次挑战的成绩
+type ChallengeResult struct {
+	Rank       int    `json:"rank,omitempty"` // 在当天排行中的名次
+	UserID     string `json:"userID"`
+	Score      int    `json:"score"`    // Acquire 为总资产，Splendor 为声望分
+	GameRank   int    `json:"gameRank"` // 在对局中的名次
+	Left       bool   `json:"left,omitempty"`
+	MatchID    string `json:"matchID"`
+	FinishedAt int64  `json:"finishedAt"`
+}
+
+// ChallengeLeaderboard 一天的挑战排行
+type ChallengeLeaderboard struct {
+	Date     string            `json:"date"`
+	Players  []ChallengeResult `json:"players"`
+	Total    int               `json:"total"`
+	Page     int               `json:"page"`
+	PageSize int               `json:"pageSize"`
+}
+
+// 挑战属于哪一天：凌晨 4 点前算前一天
+func challengeDate(t time.Time) string {
+	return t.Add(-challengeResetHour * time.Hour).Format(challengeDateFmt)
+}
+
+// t 之后的下一次换日时间
+func nextChallengeReset(t time.Time) time.Time {
+	reset := time.Date(t.Year(), t.Month(), t.Day(), challengeResetHour, 0, 0, 0, t.Location())
+	if !reset.After(t) {
+		reset = reset.AddDate(0, 0, 1)
+	}
+	return reset
+}
+
+// StartDailyChallenge 生成当天的挑战，每天 4 点换日，并在挑战房间开局时记一次挑战、对局结束时记录成绩
+func StartDailyChallenge() {
+	ws.OnGameStarted(spendChallengeAttempt)
+	ws.OnMatchEnded(recordChallengeResult)
+	ws.OnRoomDiscarded(discardChallengeRoom)
+	if _, err := rolloverChallenge(time.Now()); err != nil {
+		log.Println("❌ 生成每日挑战失败:", err)
+	}
+	go func() {
+		for {
+			time.Sleep(time.Until(nextChallengeReset(time.Now())))
+			challenge, err := rolloverChallenge(time.Now())
+			if err != nil {
+				log.Println("❌ 生成每日挑战失败:", err)
+				continue
+			}
+			log.Printf("📅 每日挑战已换日: %s，AI %d 个（%s）\n", challenge.Date, challenge.Bots, challenge.AiDifficulty)
+		}
+	}()
+}
+
+// 生成 now 所在那天的挑战，已经生成过时返回已有的
+func rolloverChallenge(now time.Time) (*DailyChallenge, error) {
+	date := challengeDate(now)
+	seed := rand.Uint64() | 1 // 0 表示不指定种子
+	lineup := []string{ws.AIDifficultyNormal, ws.AIDifficultyHard}
+	challenge := map[string]interface{}{
+		"seed":         strconv.FormatUint(seed, 10),
+		"bots":         1 + int(seed>>1%3),
+		"aiDifficulty": lineup[seed>>3%2],
+	}
+	ctx := repository.Ctx
+	key := challengeKey(date)
+	_, err := repository.Rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for field, value := range challenge {
+			pipe.HSetNX(ctx, key, field, value)
+		}
+		pipe.Expire(ctx, key, challengeTTL)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("保存每日挑战失败: %w", err)
+	}
+	return loadChallenge(date)
+}
+
+func loadChallenge(date string) (*DailyChallenge, error) {
+	values, err := repository.Rdb.HGetAll(repository.Ctx, challengeKey(date)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("读取每日挑战失败: %w", err)
+	}
+	if len(values) == 0 {
+		return nil, ErrChallengeNotFound
+	}
+	day, err := time.ParseInLocation(challengeDateFmt, date, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("日期格式错误: %w", err)
+	}
+	challenge := &DailyChallenge{
+		Date:         date,
+		Game:         ws.GameName,
+		AiDifficulty: values["aiDifficulty"],
+		EndsAt:       nextChallengeReset(day.Add(challengeResetHour * time.Hour)).UnixMilli(),
+	}
+	challenge.Seed, _ = strconv.ParseUint(values["seed"], 10, 64)
+	bots, _ := strconv.Atoi(values["bots"])
+	challenge.Bots = min(max(bots, ws.MinPlayers-1), ws.MaxPlayers-1)
+	return challenge, nil
+}
+
+// GetTodayChallenge 当天的挑战，带 userID 时返回该玩家是否已挑战
+func GetTodayChallenge(userID string) (*DailyChallenge, error) {
+	challenge, err := rolloverChallenge(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if userID != "" {
+		attempted, err := repository.Rdb.SIsMember(repository.Ctx, challengeAttemptsKey(challenge.Date), userID).Result()
+		if err != nil {
+			return nil, fmt.Errorf("读取挑战记录失败: %w", err)
+		}
+		challenge.Attempted = attempted
+	}
+	return challenge, nil
+}
+
+// StartChallenge 开始当天的挑战，创建只有该玩家能入座的房间。每人每天一次，开局时才算一次，
+// 房间创建失败或没有开局就被回收不占用次数
+func StartChallenge(userID string) (string, error) {
+	if ws.IsAIPlayer(userID) {
+		return "", fmt.Errorf("AI 不能参加每日挑战")
+	}
+	challenge, err := rolloverChallenge(time.Now())
+	if err != nil {
+		return "", err
+	}
+	attempted, err := repository.Rdb.SIsMember(repository.Ctx, challengeAttemptsKey(challenge.Date), userID).Result()
+	if err != nil {
+		return "", fmt.Errorf("读取挑战记录失败: %w", err)
+	}
+	if attempted {
+		return "", fmt.Errorf("今天已经挑战过了，明天 %d 点后再来", challengeResetHour)
+	}
+
+	roomID, err := CreateRoom(dto.CreateRoomRequest{
+		MaxPlayers:   challenge.Bots + 1,
+		AiCount:      challenge.Bots,
+		AiDifficulty: challenge.AiDifficulty,
+		UserID:       userID,
+		Private:      true, // 不允许观战，避免其他人提前看到牌局
+		Seed:         challenge.Seed,
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := ws.ReserveSeats(roomID, []string{userID}); err != nil {
+		log.Println("❌", err)
+	}
+	// 日志中有种子和全部发牌，换日之前不公开回放，避免还没挑战的玩家提前看到
+	if err := ws.HideReplays(roomID, time.UnixMilli(challenge.EndsAt)); err != nil {
+		log.Println("❌", err)
+	}
+	if err := repository.Rdb.HSet(repository.Ctx, challengeRoomsKey, roomID, challenge.Date+"|"+userID).Err(); err != nil {
+		log.Println("❌ 保存挑战房间失败:", err)
+	}
+	log.Printf("📅 玩家 %s 开始 %s 的每日挑战，房间 %s\n", userID, challenge.Date, roomID)
+	return roomID, nil
+}
+
+// GetChallengeLeaderboard 某天的挑战排行，分数高的在前，date 为空时为当天
+func GetChallengeLeaderboard(date string, page, pageSize int) (*ChallengeLeaderboard, error) {
+	if date == "" {
+		date = challengeDate(time.Now())
+	} else if _, err := time.Parse(challengeDateFmt, date); err != nil {
+		return nil, ErrChallengeNotFound
+	}
+	page, pageSize = normalizePage(page, pageSize)
+	offset := (page - 1) * pageSize
+	ctx := repository.Ctx
+	total, err := repository.Rdb.ZCard(ctx, challengeScoresKey(date)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("读取挑战排行失败: %w", err)
+	}
+	userIDs, err := repository.Rdb.ZRevRange(ctx, challengeScoresKey(date), int64(offset), int64(offset+pageSize-1)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("读取挑战排行失败: %w", err)
+	}
+	players := make([]ChallengeResult, 0, len(userIDs))
+	if len(userIDs) > 0 {
+		values, err := repository.Rdb.HMGet(ctx, challengeResultsKey(date), userIDs...).Result()
+		if err != nil {
+			return nil, fmt.Errorf("读取挑战成绩失败: %w", err)
+		}
+		for i, value := range values {
+			result := ChallengeResult{UserID: userIDs[i]}
+			if data, ok := value.(string); ok {
+				if err := json.Unmarshal([]byte(data), &result); err != nil {
+					log.Println("❌ 解析挑战成绩失败:", err)
+				}
+			}
+			result.Rank = offset + i + 1
+			players = append(players, result)
+		}
+	}
+	return &ChallengeLeaderboard{Date: date, Players: players, Total: int(total), Page: page, PageSize: pageSize}, nil
+}
+
+// 挑战房间开局：记一次挑战。玩家当天已经在别的挑战房间开过局时，这个房间不计成绩
+func spendChallengeAttempt(roomID string) {
+	ctx := repository.Ctx
+	value, err := repository.Rdb.HGet(ctx, challengeRoomsKey, roomID).Result()
+	if err == redis.Nil {
+		return
+	}
+	if err != nil {
+		log.Println("❌ 查询挑战房间失败:", err)
+		return
+	}
+	date, userID, _ := strings.Cut(value, "|")
+	attemptsKey := challengeAttemptsKey(date)
+	added, err := repository.Rdb.SAdd(ctx, attemptsKey, userID).Result()
+	if err != nil {
+		log.Println("❌ 保存挑战记录失败:", err)
+		return
+	}
+	repository.Rdb.Expire(ctx, attemptsKey, challengeTTL)
+	if added == 0 {
+		repository.Rdb.HDel(ctx, challengeRoomsKey, roomID)
+		log.Printf("⚠️ 玩家 %s 今天已经挑战过，房间 %s 不计成绩\n", userID, roomID)
+	}
+}
+
+// 挑战房间没有开局就被回收，不再等待成绩
+func discardChallengeRoom(roomID string) {
+	if err := repository.Rdb.HDel(repository.Ctx, challengeRoomsKey, roomID).Err(); err != nil {
+		log.Println("❌ 删除挑战房间失败:", err)
+	}
+}
+
+// 挑战房间的对局结束：记录玩家成绩，同一房间只记录第一局。中途离开的玩家不进入排行
+func recordChallengeResult(match *repository.Match) {
+	ctx := repository.Ctx
+	value, err := repository.Rdb.HGet(ctx, challengeRoomsKey, match.RoomID).Result()
+	if err == redis.Nil {
+		return
+	}
+	if err != nil {
+		log.Println("❌ 查询挑战房间失败:", err)
+		return
+	}
+	repository.Rdb.HDel(ctx, challengeRoomsKey, match.RoomID)
+	date, userID, _ := strings.Cut(value, "|")
+
+	for _, p := range match.Players {
+		if p.PlayerID != userID {
+			continue
+		}
+		result := ChallengeResult{
+			UserID:     userID,
+			Score:      p.Score,
+			GameRank:   p.Rank,
+			Left:       p.Left,
+			MatchID:    match.ID,
+			FinishedAt: match.EndedAt,
+		}
+		data, err := json.Marshal(result)
+		if err != nil {
+			log.Println("❌ 编码挑战成绩失败:", err)
+			return
+		}
+		_, err = repository.Rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, challengeResultsKey(date), userID, data)
+			pipe.Expire(ctx, challengeResultsKey(date), challengeTTL)
+			if !p.Left {
+				pipe.ZAdd(ctx, challengeScoresKey(date), &redis.Z{Score: float64(p.Score), Member: userID})
+				pipe.Expire(ctx, challengeScoresKey(date), challengeTTL)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Println("❌ 保存挑战成绩失败:", err)
+			return
+		}
+		log.Printf("📅 玩家 %s 完成 %s 的每日挑战，得分 %d\n", userID, date, p.Score)
+		return
+	}
+}
@@ -0,0 +1,367 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-game/dto"
+	"go-game/repository"
+	"go-game/ws"
+	"log"
+	"os"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 匹配队列：玩家按人数和可选的等级分范围排队，所有实例共用 Redis 中的队列，
+// 由持有 mm:lock 的一个实例定时撮合。凑齐人数后自动创建房间，等待超过 MATCHMAKING_BOT_WAIT
+// 仍未凑齐时用 AI 补满，结果通过大厅连接推送 match_found
+const (
+	queueKey        = "mm:queue"   // 有序集合，按入队时间排序
+	queueTicketsKey = "mm:tickets" // userID -> 排队信息
+	matcherLockKey  = "mm:lock"
+	matcherTick     = time.Second
+	matcherLockTTL  = 5 * time.Second
+	matchedTTL      = 5 * time.Minute // 匹配结果保留时间，大厅连接没收到推送时可以查询
+)
+
+func matchedKey(userID string) string {
+	return fmt.Sprintf("mm:matched:%s", userID)
+}
+
+// QueueTicket 玩家的排队信息
+type QueueTicket struct {
+	UserID       string `json:"userID"`
+	Players      int    `json:"players"`
+	MinRating    int    `json:"minRating,omitempty"`
+	MaxRating    int    `json:"maxRating,omitempty"`
+	Rating       int    `json:"rating"`
+	AiDifficulty string `json:"aiDifficulty,omitempty"`
+	Since        int64  `json:"since"` // 入队时间（毫秒）
+}
+
+// 是否接受对方的等级分
+func (t *QueueTicket) accepts(other *QueueTicket) bool {
+	return (t.MinRating == 0 || other.Rating >= t.MinRating) &&
+		(t.MaxRating == 0 || other.Rating <= t.MaxRating)
+}
+
+// QueueStatus 玩家的匹配状态
+type QueueStatus struct {
+	Queued bool         `json:"queued"`
+	Ticket *QueueTicket `json:"ticket,omitempty"`
+	RoomID string       `json:"roomID,omitempty"` // 最近匹配到的房间
+}
+
+// 等待多久后用 AI 补满，0 表示不补
+var botWait = func() time.Duration {
+	value := os.Getenv("MATCHMAKING_BOT_WAIT")
+	if value == "" {
+		return 30 * time.Second
+	}
+	wait, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("⚠️ MATCHMAKING_BOT_WAIT 格式错误: %s，使用默认值 30s\n", value)
+		return 30 * time.Second
+	}
+	return wait
+}()
+
+// JoinQueue 加入匹配队列，已在队列中时更新条件并保留原来的排队时间
+func JoinQueue(params dto.JoinQueueRequest) (*QueueTicket, error) {
+	if params.Game != "" && params.Game != ws.GameName {
+		return nil, fmt.Errorf("不支持的游戏类型: %s", params.Game)
+	}
+	if params.Players < ws.MinPlayers || params.Players > ws.MaxPlayers {
+		return nil, fmt.Errorf("人数必须在 %d 到 %d 之间", ws.MinPlayers, ws.MaxPlayers)
+	}
+	if params.MinRating < 0 || params.MaxRating < 0 ||
+		(params.MaxRating > 0 && params.MinRating > params.MaxRating) {
+		return nil, fmt.Errorf("等级分范围无效")
+	}
+
+	ticket := &QueueTicket{
+		UserID:       params.UserID,
+		Players:      params.Players,
+		MinRating:    params.MinRating,
+		MaxRating:    params.MaxRating,
+		Rating:       ws.InitialRating,
+		AiDifficulty: params.AiDifficulty,
+		Since:        time.Now().UnixMilli(),
+	}
+	if rating, err := GetPlayerRating(params.UserID); err != nil {
+		log.Println("❌ 获取等级分失败:", err)
+	} else {
+		ticket.Rating = rating.Rating
+	}
+	if old, err := getQueueTicket(params.UserID); err != nil {
+		return nil, err
+	} else if old != nil {
+		ticket.Since = old.Since
+	}
+
+	data, err := json.Marshal(ticket)
+	if err != nil {
+		return nil, fmt.Errorf("编码排队信息失败: %w", err)
+	}
+	pipe := repository.Rdb.TxPipeline()
+	pipe.HSet(repository.Ctx, queueTicketsKey, ticket.UserID, data)
+	pipe.ZAdd(repository.Ctx, queueKey, &redis.Z{Score: float64(ticket.Since), Member: ticket.UserID})
+	pipe.Del(repository.Ctx, matchedKey(ticket.UserID))
+	if _, err := pipe.Exec(repository.Ctx); err != nil {
+		return nil, fmt.Errorf("加入匹配队列失败: %w", err)
+	}
+	return ticket, nil
+}
+
+// LeaveQueue 退出匹配队列
+func LeaveQueue(userID string) error {
+	pipe := repository.Rdb.TxPipeline()
+	pipe.HDel(repository.Ctx, queueTicketsKey, userID)
+	pipe.ZRem(repository.Ctx, queueKey, userID)
+	if _, err := pipe.Exec(repository.Ctx); err != nil {
+		return fmt.Errorf("退出匹配队列失败: %w", err)
+	}
+	return nil
+}
+
+// GetQueueStatus 玩家是否在排队，以及最近匹配到的房间
+func GetQueueStatus(userID string) (*QueueStatus, error) {
+	ticket, err := getQueueTicket(userID)
+	if err != nil {
+		return nil, err
+	}
+	status := &QueueStatus{Queued: ticket != nil, Ticket: ticket}
+	roomID, err := repository.Rdb.Get(repository.Ctx, matchedKey(userID)).Result()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("获取匹配结果失败: %w", err)
+	}
+	status.RoomID = roomID
+	return status, nil
+}
+
+func getQueueTicket(userID string) (*QueueTicket, error) {
+	data, err := repository.Rdb.HGet(repository.Ctx, queueTicketsKey, userID).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("获取排队信息失败: %w", err)
+	}
+	var ticket QueueTicket
+	if err := json.Unmarshal(data, &ticket); err != nil {
+		return nil, fmt.Errorf("解析排队信息失败: %w", err)
+	}
+	return &ticket, nil
+}
+
+// 按入队时间从早到晚返回队列中的玩家
+func queuedTickets() ([]*QueueTicket, error) {
+	userIDs, err := repository.Rdb.ZRange(repository.Ctx, queueKey, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("获取匹配队列失败: %w", err)
+	}
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+	values, err := repository.Rdb.HMGet(repository.Ctx, queueTicketsKey, userIDs...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("获取排队信息失败: %w", err)
+	}
+	tickets := make([]*QueueTicket, 0, len(values))
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			// 排队信息已删除（退出队列），顺便清理
+			repository.Rdb.ZRem(repository.Ctx, queueKey, userIDs[i])
+			continue
+		}
+		var ticket QueueTicket
+		if err := json.Unmarshal([]byte(data), &ticket); err != nil {
+			log.Printf("❌ 解析 %s 的排队信息失败: %v\n", userIDs[i], err)
+			continue
+		}
+		tickets = append(tickets, &ticket)
+	}
+	return tickets, nil
+}
+
+// StartMatchmaker 启动撮合循环，多个实例中只有持有锁的一个实际撮合
+func StartMatchmaker() {
+	go func() {
+		ticker := time.NewTicker(matcherTick)
+		defer ticker.Stop()
+		for range ticker.C {
+			if holdMatcherLock() {
+				matchQueue()
+			}
+		}
+	}()
+}
+
+var renewMatcherLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+func holdMatcherLock() bool {
+	ok, err := repository.Rdb.SetNX(repository.Ctx, matcherLockKey, ws.InstanceID, matcherLockTTL).Result()
+	if err != nil {
+		log.Println("❌ 获取匹配锁失败:", err)
+		return false
+	}
+	if ok {
+		return true
+	}
+	n, err := renewMatcherLockScript.Run(repository.Ctx, repository.Rdb,
+		[]string{matcherLockKey}, ws.InstanceID, matcherLockTTL.Milliseconds()).Int()
+	if err != nil {
+		log.Println("❌ 续期匹配锁失败:", err)
+		return false
+	}
+	return n == 1
+}
+
+// 从最早排队的玩家开始，依次找人数相同、等级分互相接受的玩家组成一局
+func matchQueue() {
+	tickets, err := queuedTickets()
+	if err != nil {
+		log.Println("❌ 撮合失败:", err)
+		return
+	}
+	now := time.Now().UnixMilli()
+	matched := make(map[string]bool)
+	for i, first := range tickets {
+		if matched[first.UserID] {
+			continue
+		}
+		group := []*QueueTicket{first}
+		for _, t := range tickets[i+1:] {
+			if len(group) == first.Players {
+				break
+			}
+			if matched[t.UserID] || t.Players != first.Players || !compatible(group, t) {
+				continue
+			}
+			group = append(group, t)
+		}
+		if !groupReady(group, first.Players, now) {
+			continue
+		}
+		for _, t := range group {
+			matched[t.UserID] = true
+		}
+		createMatchedRoom(group)
+	}
+}
+
+// 人数已满，或者最早排队的玩家等待超过 botWait 可以用 AI 补满
+func groupReady(group []*QueueTicket, players int, now int64) bool {
+	if len(group) == players {
+		return true
+	}
+	waited := time.Duration(now-group[0].Since) * time.Millisecond
+	return botWait > 0 && waited >= botWait
+}
+
+func compatible(group []*QueueTicket, ticket *QueueTicket) bool {
+	for _, t := range group {
+		if !t.accepts(ticket) || !ticket.accepts(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// 把玩家移出队列并创建房间，空位由 AI 补满。移出时已退出队列的玩家不再加入
+func createMatchedRoom(group []*QueueTicket) {
+	players := group[0].Players
+	humans := make([]*QueueTicket, 0, len(group))
+	for _, t := range group {
+		// 取出时读取最新的排队信息，撮合之后玩家可能退出队列或修改了条件
+		pipe := repository.Rdb.TxPipeline()
+		get := pipe.HGet(repository.Ctx, queueTicketsKey, t.UserID)
+		pipe.HDel(repository.Ctx, queueTicketsKey, t.UserID)
+		pipe.ZRem(repository.Ctx, queueKey, t.UserID)
+		if _, err := pipe.Exec(repository.Ctx); err != nil && err != redis.Nil {
+			log.Println("❌ 移出匹配队列失败:", err)
+			continue
+		}
+		data, err := get.Bytes()
+		if err != nil {
+			continue
+		}
+		var ticket QueueTicket
+		if err := json.Unmarshal(data, &ticket); err != nil {
+			log.Println("❌ 解析排队信息失败:", err)
+			continue
+		}
+		humans = append(humans, &ticket)
+	}
+	if len(humans) == 0 {
+		return
+	}
+	// 剩下的玩家必须仍然能组成一局，否则放回队列等下次撮合
+	for i, t := range humans {
+		if t.Players != players || !compatible(humans[:i], t) {
+			requeue(humans)
+			return
+		}
+	}
+	if !groupReady(humans, players, time.Now().UnixMilli()) {
+		requeue(humans)
+		return
+	}
+
+	roomID, err := CreateRoom(dto.CreateRoomRequest{
+		MaxPlayers:   players,
+		AiCount:      players - len(humans),
+		AiDifficulty: humans[0].AiDifficulty,
+		UserID:       humans[0].UserID,
+	})
+	if err != nil {
+		log.Println("❌ 匹配创建房间失败:", err)
+		requeue(humans)
+		return
+	}
+
+	userIDs := make([]string, 0, len(humans))
+	for _, t := range humans {
+		userIDs = append(userIDs, t.UserID)
+	}
+	if err := ws.ReserveSeats(roomID, userIDs); err != nil {
+		log.Println("❌", err)
+	}
+	log.Printf("🎯 匹配成功: 房间 %s，玩家 %v，AI %d 个\n", roomID, userIDs, players-len(humans))
+	for _, userID := range userIDs {
+		if err := repository.Rdb.Set(repository.Ctx, matchedKey(userID), roomID, matchedTTL).Err(); err != nil {
+			log.Println("❌ 保存匹配结果失败:", err)
+		}
+		err := ws.NotifyLobby(userID, ws.MatchFoundMessage{
+			Type:    "match_found",
+			RoomID:  roomID,
+			Players: userIDs,
+			Bots:    players - len(humans),
+		})
+		if err != nil {
+			log.Println("❌ 推送匹配结果失败:", err)
+		}
+	}
+}
+
+// 创建房间失败或不能组成一局，按原来的排队时间放回队列
+func requeue(tickets []*QueueTicket) {
+	for _, t := range tickets {
+		data, err := json.Marshal(t)
+		if err != nil {
+			continue
+		}
+		pipe := repository.Rdb.TxPipeline()
+		pipe.HSet(repository.Ctx, queueTicketsKey, t.UserID, data)
+		pipe.ZAdd(repository.Ctx, queueKey, &redis.Z{Score: float64(t.Since), Member: t.UserID})
+		if _, err := pipe.Exec(repository.Ctx); err != nil {
+			log.Println("❌ 放回匹配队列失败:", err)
+		}
+	}
+}
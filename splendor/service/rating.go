@@ -0,0 +1,47 @@
+package service
+
+import (
+	"go-game/repository"
+	"go-game/ws"
+)
+
+// LeaderboardEntry 排行中的一名玩家
+type LeaderboardEntry struct {
+	Rank int `json:"rank"`
+	repository.Rating
+}
+
+// LeaderboardPage 一页等级分排行
+type LeaderboardPage struct {
+	Players  []LeaderboardEntry `json:"players"`
+	Total    int                `json:"total"`
+	Page     int                `json:"page"`
+	PageSize int                `json:"pageSize"`
+}
+
+// GetLeaderboard 等级分排行，分数高的在前
+func GetLeaderboard(page, pageSize int) (*LeaderboardPage, error) {
+	page, pageSize = normalizePage(page, pageSize)
+	offset := (page - 1) * pageSize
+	ratings, total, err := repository.Matches.Leaderboard(repository.Ctx, offset, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	players := make([]LeaderboardEntry, 0, len(ratings))
+	for i, r := range ratings {
+		players = append(players, LeaderboardEntry{Rank: offset + i + 1, Rating: r})
+	}
+	return &LeaderboardPage{Players: players, Total: total, Page: page, PageSize: pageSize}, nil
+}
+
+// GetPlayerRating 玩家的等级分，还没有计分对局时为初始分
+func GetPlayerRating(playerID string) (*repository.Rating, error) {
+	ratings, err := repository.Matches.Ratings(repository.Ctx, []string{playerID})
+	if err != nil {
+		return nil, err
+	}
+	if r, ok := ratings[playerID]; ok {
+		return &r, nil
+	}
+	return &repository.Rating{PlayerID: playerID, Rating: ws.InitialRating}, nil
+}
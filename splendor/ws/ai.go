@@ -5,15 +5,180 @@ import (
 	"go-game/entities"
 	"go-game/repository"
 	"log"
+	"sort"
 	"strings"
 	"time"
 )
 
 var _ WriteOnlyConn = (*VirtualConn)(nil) // 编译期断言实现
 
-func chooseActionForAI(roomID, playerID string) string {
+// 普通宝石颜色（不含黄金）
+var gemColors = []string{"Blue", "Green", "Red", "White", "Black"}
 
-	return ""
+// 卡牌对 AI 的价值：分数优先，困难 AI 额外考虑贵族需要的颜色
+func cardValueForAI(card entities.NormalCard, difficulty string, nobleNeed map[string]int) int {
+	value := card.Points * 10
+	if difficulty == AIDifficultyHard {
+		value += nobleNeed[card.Bonus] * 3
+	}
+	return value
+}
+
+// 距离买下卡牌还差的宝石数
+func missingGemsForCard(card entities.NormalCard, playerGems, cardCount map[string]int) map[string]int {
+	missing := make(map[string]int)
+	for color, need := range card.Cost {
+		if lack := need - cardCount[color] - playerGems[color]; lack > 0 {
+			missing[color] = lack
+		}
+	}
+	return missing
+}
+
+func chooseActionForAI(roomID, playerID string) map[string]interface{} {
+	difficulty := GetAIDifficulty(roomID, playerID)
+	rng, err := botRand(roomID)
+	if err != nil {
+		log.Println("❌", err)
+		return nil
+	}
+
+	allCards, err := GetAllNormalCards(roomID)
+	if err != nil {
+		log.Println("❌ 获取所有卡牌失败:", err)
+		return nil
+	}
+	playerGems, err := GetPlayerGem(roomID, playerID)
+	if err != nil {
+		log.Println("❌ 获取玩家宝石失败:", err)
+		return nil
+	}
+	playerCards, err := GetPlayerNormalCard(roomID, playerID)
+	if err != nil {
+		log.Println("❌ 获取玩家卡牌失败:", err)
+		return nil
+	}
+	reserveCards, err := GetPlayerReserveCards(roomID, playerID)
+	if err != nil {
+		log.Println("❌ 获取玩家预留卡失败:", err)
+		return nil
+	}
+	roomGems, err := GetGemCounts(roomID)
+	if err != nil {
+		log.Println("❌ 获取宝石数量失败:", err)
+		return nil
+	}
+
+	cardCount := make(map[string]int)
+	for _, c := range playerCards {
+		cardCount[c.Bonus]++
+	}
+
+	// 贵族还差的颜色
+	nobleNeed := make(map[string]int)
+	if allNobles, err := GetAllNobleCards(roomID); err == nil {
+		for _, noble := range allNobles {
+			if noble.State != entities.CardStateRevealed {
+				continue
+			}
+			for color, need := range noble.Cost {
+				if lack := need - cardCount[color]; lack > 0 {
+					nobleNeed[color] += lack
+				}
+			}
+		}
+	}
+
+	candidates := make([]entities.NormalCard, 0)
+	for _, card := range allCards {
+		if card.State == entities.CardStateRevealed {
+			candidates = append(candidates, card)
+		}
+	}
+	// map 遍历顺序不固定，排序后同样的种子下 AI 的选择才相同
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].ID < candidates[j].ID })
+	candidates = append(candidates, reserveCards...)
+
+	// 1. 能买就买
+	affordable := make([]entities.NormalCard, 0)
+	for _, card := range candidates {
+		if _, ok := calcCardPayment(card.Cost, playerGems, cardCount); ok {
+			affordable = append(affordable, card)
+		}
+	}
+	if len(affordable) > 0 {
+		var pick entities.NormalCard
+		if difficulty == AIDifficultyEasy {
+			pick = affordable[rng.IntN(len(affordable))]
+		} else {
+			sort.Slice(affordable, func(i, j int) bool {
+				vi := cardValueForAI(affordable[i], difficulty, nobleNeed)
+				vj := cardValueForAI(affordable[j], difficulty, nobleNeed)
+				if vi != vj {
+					return vi > vj
+				}
+				return affordable[i].Level < affordable[j].Level
+			})
+			pick = affordable[0]
+		}
+		return map[string]interface{}{
+			"type":    "buy_card",
+			"payload": BuyCardPayload(pick.ID),
+		}
+	}
+
+	// 2. 选一张目标卡牌，按缺少的颜色拿宝石
+	colors := make([]string, 0, len(gemColors))
+	for _, color := range gemColors {
+		if roomGems[color] > 0 {
+			colors = append(colors, color)
+		}
+	}
+	if difficulty == AIDifficultyEasy {
+		rng.Shuffle(len(colors), func(i, j int) { colors[i], colors[j] = colors[j], colors[i] })
+	} else if len(candidates) > 0 {
+		sort.Slice(candidates, func(i, j int) bool {
+			mi, mj := 0, 0
+			for _, n := range missingGemsForCard(candidates[i], playerGems, cardCount) {
+				mi += n
+			}
+			for _, n := range missingGemsForCard(candidates[j], playerGems, cardCount) {
+				mj += n
+			}
+			// 缺得越少越好，同等条件下分数高的优先
+			vi := mi*10 - cardValueForAI(candidates[i], difficulty, nobleNeed)
+			vj := mj*10 - cardValueForAI(candidates[j], difficulty, nobleNeed)
+			return vi < vj
+		})
+		missing := missingGemsForCard(candidates[0], playerGems, cardCount)
+		sort.SliceStable(colors, func(i, j int) bool {
+			return missing[colors[i]] > missing[colors[j]]
+		})
+	}
+
+	if len(colors) > 0 {
+		take := make(GetGemPayload)
+		for i := 0; i < len(colors) && i < 3; i++ {
+			take[colors[i]] = 1
+		}
+		return map[string]interface{}{
+			"type":    "get_gem",
+			"payload": take,
+		}
+	}
+
+	// 3. 没有宝石可拿时预留一张卡
+	if len(reserveCards) < 3 && roomGems["Gold"] > 0 {
+		for _, card := range allCards {
+			if card.State == entities.CardStateRevealed {
+				return map[string]interface{}{
+					"type":    "preserve_card",
+					"payload": PreserveCardPayload{CardID: card.ID},
+				}
+			}
+		}
+	}
+	return nil
 }
 
 func IsAIPlayer(playerID string) bool {
@@ -27,13 +192,19 @@ func MaybeRunAIIfNeeded(roomID string, data []byte) bool {
 		return false
 	}
 
+	// 只有发给当前 AI 自己的同步消息才触发，避免同一回合重复行动
+	playerId, ok := msg["playerId"].(string)
+	if !ok || playerId == "" {
+		return false
+	}
+
 	// 提取当前玩家
 	roomData, ok := msg["roomData"].(map[string]interface{})
 	if !ok {
 		return false
 	}
 	currentPlayerID, ok := roomData["currentPlayer"].(string)
-	if !ok || currentPlayerID == "" {
+	if !ok || currentPlayerID == "" || currentPlayerID != playerId {
 		return false
 	}
 
@@ -60,39 +231,42 @@ func MaybeRunAIIfNeeded(roomID string, data []byte) bool {
 	go func() {
 		time.Sleep(3 * time.Second)
 
-		conn := &VirtualConn{PlayerID: currentPlayerID, RoomID: roomID}
-		rdb := repository.Rdb
+		// 回到房间 goroutine 内执行，避免与玩家消息并发修改房间数据
+		err := RunInRoom(roomID, func() {
+			conn := &VirtualConn{PlayerID: currentPlayerID, RoomID: roomID}
+			rdb := repository.Rdb
 
-		var aiMsg map[string]interface{}
+			var aiMsg map[string]interface{}
 
-		switch gameStatus {
-		case "playing":
-			tile := chooseActionForAI(roomID, currentPlayerID)
-			if tile == "" {
-				log.Println("🤖 AI 未选择有效 tile")
+			switch gameStatus {
+			case entities.RoomStatusPlaying, entities.RoomStatusLastTurn:
+				aiMsg = chooseActionForAI(roomID, currentPlayerID)
+				if aiMsg == nil {
+					log.Println("🤖 AI 没有可执行的操作")
+					return
+				}
+			case entities.RoomStatusEnd:
+				// 结束后是否再来一局由真人玩家投票决定
+				return
+			default:
+				log.Printf("⚠️ 当前状态 %s 未定义 AI 行为", gameStatus)
 				return
 			}
-			aiMsg = map[string]interface{}{
-				"type":    "place_tile",
-				"payload": tile,
-			}
-		case "end":
-			aiMsg = map[string]interface{}{
-				"type": "restart_game",
-			}
-		default:
-			log.Printf("⚠️ 当前状态 %s 未定义 AI 行为", gameStatus)
-			return
-		}
 
-		// 加入 playerID 然后交给 handler 执行
-		aiMsg["playerID"] = currentPlayerID
-		if handler, found := messageHandlers[aiMsg["type"].(string)]; found {
-			log.Printf("🤖 AI [%s] 执行操作: %s", currentPlayerID, aiMsg["type"])
-			handler(conn, rdb, roomID, currentPlayerID, aiMsg)
-			BroadcastToRoom(roomID)
-		} else {
-			log.Printf("❌ AI 未找到 handler 类型: %s", aiMsg["type"])
+			// 加入 playerID 然后交给 handler 执行
+			aiMsg["playerID"] = currentPlayerID
+			if handler, found := messageHandlers[aiMsg["type"].(string)]; found {
+				log.Printf("🤖 AI [%s] 执行操作: %s", currentPlayerID, aiMsg["type"])
+				if err := handler(conn, rdb, roomID, currentPlayerID, aiMsg); err != nil {
+					log.Printf("❌ AI [%s] 操作 %s 失败: %v", currentPlayerID, aiMsg["type"], err)
+				}
+				BroadcastToRoom(roomID)
+			} else {
+				log.Printf("❌ AI 未找到 handler 类型: %s", aiMsg["type"])
+			}
+		})
+		if err != nil {
+			log.Printf("❌ AI [%s] 行动失败: %v", currentPlayerID, err)
 		}
 	}()
 
@@ -1,14 +1,59 @@
 package ws
 
 import (
+	"encoding/json"
+	"fmt"
 	"go-game/dto"
+	"go-game/entities"
+	"go-game/repository"
 	"log"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// AI 难度
+const (
+	AIDifficultyEasy   = "easy"
+	AIDifficultyNormal = "normal"
+	AIDifficultyHard   = "hard"
 )
 
+// 规范化难度参数，未知或为空时使用 normal
+func normalizeAIDifficulty(difficulty string) string {
+	switch difficulty {
+	case AIDifficultyEasy, AIDifficultyHard:
+		return difficulty
+	default:
+		return AIDifficultyNormal
+	}
+}
+
+// SetAIDifficulty 保存 AI 玩家难度
+func SetAIDifficulty(roomID, playerID, difficulty string) error {
+	key := fmt.Sprintf("room:%s:ai_difficulty", roomID)
+	if err := repository.Rdb.HSet(repository.Ctx, key, playerID, normalizeAIDifficulty(difficulty)).Err(); err != nil {
+		return fmt.Errorf("设置 AI 难度失败: %w", err)
+	}
+	return nil
+}
+
+// GetAIDifficulty 获取 AI 玩家难度，未设置时返回 normal
+func GetAIDifficulty(roomID, playerID string) string {
+	key := fmt.Sprintf("room:%s:ai_difficulty", roomID)
+	difficulty, err := repository.Rdb.HGet(repository.Ctx, key, playerID).Result()
+	if err != nil {
+		return AIDifficultyNormal
+	}
+	return normalizeAIDifficulty(difficulty)
+}
+
 func JoinRoomAsAI(roomID, playerID string) bool {
-	roomLock.Lock()
-	defer roomLock.Unlock()
+	return JoinRoomAsAIWithDifficulty(roomID, playerID, AIDifficultyNormal)
+}
 
+func JoinRoomAsAIWithDifficulty(roomID, playerID, difficulty string) bool {
 	roomInfo, err := GetRoomInfo(roomID)
 	if err != nil {
 		log.Println("❌ 获取房间信息失败:", err)
@@ -16,21 +61,329 @@ func JoinRoomAsAI(roomID, playerID string) bool {
 	}
 
 	maxPlayers := roomInfo.MaxPlayers
+	room := getRoom(roomID)
+	if room == nil {
+		log.Printf("房间 %s 不存在，AI %s 无法加入\n", roomID, playerID)
+		return false
+	}
 
 	// 判断房间人数是否已满
-	if len(Rooms[roomID]) >= maxPlayers {
+	if len(room.Players()) >= maxPlayers {
 		log.Printf("房间 %s 已满，AI %s 无法加入\n", roomID, playerID)
 		return false
 	}
 
 	InitPlayerData(roomID, playerID)
+	if err := SetAIDifficulty(roomID, playerID, difficulty); err != nil {
+		log.Println("❌", err)
+	}
 	// 加入房间，虚拟连接
-	Rooms[roomID] = append(Rooms[roomID], dto.PlayerConn{
-		PlayerID: playerID,
-		Conn:     &VirtualConn{PlayerID: playerID, RoomID: roomID},
-		Online:   true,
+	room.updatePlayers(func(players []dto.PlayerConn) []dto.PlayerConn {
+		return append(players, dto.PlayerConn{
+			PlayerID: playerID,
+			Conn:     &VirtualConn{PlayerID: playerID, RoomID: roomID},
+			Online:   true,
+		})
 	})
 
-	log.Printf("AI 玩家 %s 加入房间 %s\n", playerID, roomID)
+	log.Printf("AI 玩家 %s(%s) 加入房间 %s\n", playerID, normalizeAIDifficulty(difficulty), roomID)
 	return true
 }
+
+// 生成房间内未被占用的 AI 玩家 ID（ai_001、ai_002 ...）
+func nextAIPlayerID(roomID string) string {
+	used := make(map[int]struct{})
+	for _, pc := range playersOf(roomID) {
+		if !IsAIPlayer(pc.PlayerID) {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimPrefix(pc.PlayerID, "ai_")); err == nil {
+			used[n] = struct{}{}
+		}
+	}
+	for i := 1; ; i++ {
+		if _, ok := used[i]; !ok {
+			return fmt.Sprintf("ai_%03d", i)
+		}
+	}
+}
+
+// 校验操作者是否为房主
+func checkRoomHost(roomID, operatorID string) error {
+	roomInfo, err := GetRoomInfo(roomID)
+	if err != nil {
+		return fmt.Errorf("获取房间信息失败: %w", err)
+	}
+	if roomInfo.UserID != operatorID {
+		return fmt.Errorf("只有房主可以执行该操作")
+	}
+	return nil
+}
+
+// 游戏开始后房间不再处于 waiting 状态
+func isGameStarted(roomID string) bool {
+	roomInfo, err := roomInfoOf(roomID)
+	return err == nil && roomInfo.GameStatus != entities.RoomStatusWaiting
+}
+
+func isGameEnded(roomID string) bool {
+	roomInfo, err := roomInfoOf(roomID)
+	return err == nil && roomInfo.GameStatus == entities.RoomStatusEnd
+}
+
+// 获取玩家在 Redis 中的全部 key（room:{roomID}:player:{playerID}:*）
+func scanPlayerKeys(roomID, playerID string) ([]string, error) {
+	pattern := fmt.Sprintf("room:%s:player:%s:*", roomID, playerID)
+	var cursor uint64
+	var keys []string
+	for {
+		batch, cur, err := repository.Rdb.Scan(repository.Ctx, cursor, pattern, 100).Result()
+		if err != nil {
+			return nil, fmt.Errorf("扫描玩家数据失败: %w", err)
+		}
+		keys = append(keys, batch...)
+		cursor = cur
+		if cursor == 0 {
+			break
+		}
+	}
+	return keys, nil
+}
+
+// 删除玩家在房间中的全部数据，房间正在执行操作时随操作一起提交
+func deletePlayerData(roomID, playerID string) error {
+	keys, err := scanPlayerKeys(roomID, playerID)
+	if err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		if err := stateRdb(roomID).Del(repository.Ctx, keys...).Err(); err != nil {
+			return fmt.Errorf("删除玩家数据失败: %w", err)
+		}
+	}
+	repository.Rdb.HDel(repository.Ctx, fmt.Sprintf("room:%s:ai_difficulty", roomID), playerID)
+	return nil
+}
+
+// 将玩家数据整体迁移给新的玩家 ID（用于 AI 接管座位）
+func renamePlayerData(roomID, oldID, newID string) error {
+	keys, err := scanPlayerKeys(roomID, oldID)
+	if err != nil {
+		return err
+	}
+	oldPrefix := fmt.Sprintf("room:%s:player:%s:", roomID, oldID)
+	newPrefix := fmt.Sprintf("room:%s:player:%s:", roomID, newID)
+	for _, key := range keys {
+		newKey := newPrefix + strings.TrimPrefix(key, oldPrefix)
+		if err := repository.Rdb.Rename(repository.Ctx, key, newKey).Err(); err != nil {
+			return fmt.Errorf("迁移玩家数据[%s]失败: %w", key, err)
+		}
+	}
+
+	currentPlayer, err := GetCurrentPlayer(repository.Rdb, repository.Ctx, roomID)
+	if err != nil {
+		return err
+	}
+	if currentPlayer == oldID {
+		if err := SetCurrentPlayer(repository.Rdb, repository.Ctx, roomID, newID); err != nil {
+			return err
+		}
+	}
+	firstPlayer, err := GetFirstPlayer(repository.Rdb, repository.Ctx, roomID)
+	if err != nil {
+		return err
+	}
+	if firstPlayer == oldID {
+		if err := SetFirstPlayer(repository.Rdb, repository.Ctx, roomID, newID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddAIPlayer 房主向房间添加一个指定难度的 AI，返回 AI 玩家 ID
+func AddAIPlayer(roomID, operatorID, difficulty string) (string, error) {
+	if err := checkRoomHost(roomID, operatorID); err != nil {
+		return "", err
+	}
+	if isGameStarted(roomID) {
+		return "", fmt.Errorf("游戏已开始，无法添加 AI")
+	}
+
+	aiID := nextAIPlayerID(roomID)
+	if !JoinRoomAsAIWithDifficulty(roomID, aiID, difficulty) {
+		return "", fmt.Errorf("房间已满")
+	}
+	tryStartGame(roomID)
+	return aiID, nil
+}
+
+// RemoveAIPlayer 房主在游戏开始前移除 AI
+func RemoveAIPlayer(roomID, operatorID, aiID string) error {
+	if err := checkRoomHost(roomID, operatorID); err != nil {
+		return err
+	}
+	if !IsAIPlayer(aiID) {
+		return fmt.Errorf("玩家 %s 不是 AI", aiID)
+	}
+	if isGameStarted(roomID) {
+		return fmt.Errorf("游戏已开始，无法移除 AI")
+	}
+
+	room := getRoom(roomID)
+	if room == nil {
+		return fmt.Errorf("房间[%s]不存在", roomID)
+	}
+	removed := false
+	room.updatePlayers(func(players []dto.PlayerConn) []dto.PlayerConn {
+		for i, pc := range players {
+			if pc.PlayerID == aiID {
+				removed = true
+				return append(players[:i], players[i+1:]...)
+			}
+		}
+		return players
+	})
+	if !removed {
+		return fmt.Errorf("房间中没有 AI %s", aiID)
+	}
+
+	if err := deletePlayerData(roomID, aiID); err != nil {
+		return err
+	}
+	log.Printf("AI 玩家 %s 已移出房间 %s\n", aiID, roomID)
+	return nil
+}
+
+// ReplacePlayerWithAI 游戏进行中由 AI 接管已离线玩家的座位，返回 AI 玩家 ID
+func ReplacePlayerWithAI(roomID, operatorID, playerID, difficulty string) (string, error) {
+	if err := checkRoomHost(roomID, operatorID); err != nil {
+		return "", err
+	}
+	if !isGameStarted(roomID) {
+		return "", fmt.Errorf("游戏尚未开始，请直接添加 AI")
+	}
+
+	room := getRoom(roomID)
+	if room == nil {
+		return "", fmt.Errorf("房间[%s]不存在", roomID)
+	}
+	var seat *dto.PlayerConn
+	for _, pc := range room.Players() {
+		if pc.PlayerID == playerID {
+			seat = &pc
+			break
+		}
+	}
+	if seat == nil {
+		return "", fmt.Errorf("房间中没有玩家 %s", playerID)
+	}
+	if IsAIPlayer(playerID) {
+		return "", fmt.Errorf("玩家 %s 已经是 AI", playerID)
+	}
+	if seat.Online {
+		return "", fmt.Errorf("玩家 %s 仍在线，无法替换", playerID)
+	}
+
+	aiID := nextAIPlayerID(roomID)
+	if err := renamePlayerData(roomID, playerID, aiID); err != nil {
+		return "", err
+	}
+	if err := SetAIDifficulty(roomID, aiID, difficulty); err != nil {
+		return "", err
+	}
+	room.updatePlayers(func(players []dto.PlayerConn) []dto.PlayerConn {
+		for i, pc := range players {
+			if pc.PlayerID == playerID {
+				players[i] = dto.PlayerConn{
+					PlayerID: aiID,
+					Conn:     &VirtualConn{PlayerID: aiID, RoomID: roomID},
+					Online:   true,
+				}
+				break
+			}
+		}
+		return players
+	})
+	log.Printf("AI 玩家 %s 接管了 %s 在房间 %s 的座位\n", aiID, playerID, roomID)
+	logPlayerReplaced(roomID, playerID, aiID)
+	return aiID, nil
+}
+
+// 从消息中解析 AI 座位参数，payload 可以省略
+func parseAISeatPayload(msgMap map[string]interface{}) (AISeatPayload, error) {
+	if msgMap["payload"] == nil {
+		return AISeatPayload{}, nil
+	}
+	return payloadOf[AISeatPayload](msgMap)
+}
+
+func handleAddAIMessage(conn ReadWriteConn, rdb redis.Cmdable, roomID, playerID string, msgMap map[string]interface{}) error {
+	payload, err := parseAISeatPayload(msgMap)
+	if err != nil {
+		return err
+	}
+	_, err = AddAIPlayer(roomID, playerID, payload.Difficulty)
+	return err
+}
+
+func handleRemoveAIMessage(conn ReadWriteConn, rdb redis.Cmdable, roomID, playerID string, msgMap map[string]interface{}) error {
+	payload, err := parseAISeatPayload(msgMap)
+	if err != nil {
+		return err
+	}
+	return RemoveAIPlayer(roomID, playerID, payload.PlayerID)
+}
+
+func handleReplaceWithAIMessage(conn ReadWriteConn, rdb redis.Cmdable, roomID, playerID string, msgMap map[string]interface{}) error {
+	payload, err := parseAISeatPayload(msgMap)
+	if err != nil {
+		return err
+	}
+	_, err = ReplacePlayerWithAI(roomID, playerID, payload.PlayerID, payload.Difficulty)
+	return err
+}
+
+// HTTP 接口的 AI 座位操作，由房间所属实例在房间 goroutine 内执行（见 CallRoom）
+func callAddAI(roomID string, args json.RawMessage) (interface{}, error) {
+	var params dto.AddAIRequest
+	if err := json.Unmarshal(args, &params); err != nil {
+		return nil, fmt.Errorf("参数格式错误: %w", err)
+	}
+	aiID, err := AddAIPlayer(roomID, params.UserID, params.Difficulty)
+	if err != nil {
+		return nil, err
+	}
+	BroadcastToRoom(roomID)
+	return aiID, nil
+}
+
+func callRemoveAI(roomID string, args json.RawMessage) (interface{}, error) {
+	var params dto.RemoveAIRequest
+	if err := json.Unmarshal(args, &params); err != nil {
+		return nil, fmt.Errorf("参数格式错误: %w", err)
+	}
+	if err := RemoveAIPlayer(roomID, params.UserID, params.AiID); err != nil {
+		return nil, err
+	}
+	BroadcastToRoom(roomID)
+	return nil, nil
+}
+
+func callReplaceWithAI(roomID string, args json.RawMessage) (interface{}, error) {
+	var params dto.ReplaceWithAIRequest
+	if err := json.Unmarshal(args, &params); err != nil {
+		return nil, fmt.Errorf("参数格式错误: %w", err)
+	}
+	aiID, err := ReplacePlayerWithAI(roomID, params.UserID, params.PlayerID, params.Difficulty)
+	if err != nil {
+		return nil, err
+	}
+	BroadcastToRoom(roomID)
+	return aiID, nil
+}
+
+// AI 玩家按难度的固定等级分
+func botRating(roomID, playerID string) int {
+	return botRatings[GetAIDifficulty(roomID, playerID)]
+}
@@ -0,0 +1,238 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-game/repository"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	chatHistorySize = 50               // 每个房间保留的聊天记录条数
+	chatMaxLength   = 200              // 单条消息最大字数
+	chatRateLimit   = 5                // 限流窗口内最多发送条数
+	chatRateWindow  = 10 * time.Second // 限流窗口
+)
+
+// 敏感词，通过 CHAT_BLOCKED_WORDS 配置（逗号分隔），命中的部分替换为 *
+var chatBlockedWords = func() *regexp.Regexp {
+	words := make([]string, 0)
+	for _, w := range strings.Split(os.Getenv("CHAT_BLOCKED_WORDS"), ",") {
+		if w = strings.TrimSpace(w); w != "" {
+			words = append(words, regexp.QuoteMeta(w))
+		}
+	}
+	if len(words) == 0 {
+		return nil
+	}
+	return regexp.MustCompile("(?i)" + strings.Join(words, "|"))
+}()
+
+// 聊天消息
+type ChatMessage struct {
+	PlayerID string `json:"playerID"`
+	Text     string `json:"text"`
+	Time     int64  `json:"time"` // 毫秒时间戳
+}
+
+// 过滤敏感词
+func filterChatText(text string) string {
+	if chatBlockedWords == nil {
+		return text
+	}
+	return chatBlockedWords.ReplaceAllStringFunc(text, func(word string) string {
+		return strings.Repeat("*", utf8.RuneCountInString(word))
+	})
+}
+
+// 计数和设置过期时间在一个脚本中完成，不会留下没有过期时间、永远限流的计数；
+// 已有的计数没有过期时间时同样补上
+var chatRateScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if redis.call("PTTL", KEYS[1]) < 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return count`)
+
+// 是否超出发送频率，窗口内第一条消息时设置过期时间
+func isChatRateLimited(roomID, playerID string) (bool, error) {
+	key := fmt.Sprintf("room:%s:chat_rate:%s", roomID, playerID)
+	count, err := chatRateScript.Run(repository.Ctx, repository.Rdb, []string{key}, chatRateWindow.Milliseconds()).Int64()
+	if err != nil {
+		return false, fmt.Errorf("聊天限流计数失败: %w", err)
+	}
+	return count > chatRateLimit, nil
+}
+
+// 玩家是否被禁言
+func isChatMuted(roomID, playerID string) bool {
+	key := fmt.Sprintf("room:%s:chat_mute:%s", roomID, playerID)
+	exists, err := repository.Rdb.Exists(repository.Ctx, key).Result()
+	return err == nil && exists > 0
+}
+
+// 追加聊天记录，只保留最近 chatHistorySize 条
+func appendChatHistory(roomID string, msg ChatMessage) error {
+	key := fmt.Sprintf("room:%s:chat", roomID)
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("序列化聊天消息失败: %w", err)
+	}
+	pipe := repository.Rdb.TxPipeline()
+	pipe.RPush(repository.Ctx, key, data)
+	pipe.LTrim(repository.Ctx, key, -chatHistorySize, -1)
+	if _, err := pipe.Exec(repository.Ctx); err != nil {
+		return fmt.Errorf("保存聊天记录失败: %w", err)
+	}
+	return nil
+}
+
+// GetChatHistory 获取房间最近的聊天记录
+func GetChatHistory(roomID string) ([]ChatMessage, error) {
+	key := fmt.Sprintf("room:%s:chat", roomID)
+	values, err := repository.Rdb.LRange(repository.Ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("获取聊天记录失败: %w", err)
+	}
+	messages := make([]ChatMessage, 0, len(values))
+	for _, v := range values {
+		var msg ChatMessage
+		if err := json.Unmarshal([]byte(v), &msg); err != nil {
+			log.Println("❌ 解析聊天记录失败:", err)
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// 玩家进入或重连时补发聊天记录
+func sendChatHistory(conn WriteOnlyConn, roomID string) {
+	messages, err := GetChatHistory(roomID)
+	if err != nil {
+		log.Println("❌", err)
+		return
+	}
+	data, err := json.Marshal(ChatHistoryMessage{Type: "chat_history", Messages: messages})
+	if err != nil {
+		log.Println("❌ 编码 JSON 失败:", err)
+		return
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		log.Println("❌ 发送聊天记录失败:", err)
+	}
+}
+
+func handleChatMessage(conn ReadWriteConn, rdb redis.Cmdable, roomID, playerID string, msgMap map[string]interface{}) error {
+	payload, err := payloadOf[ChatPayload](msgMap)
+	if err != nil {
+		return err
+	}
+	text := strings.TrimSpace(string(payload))
+	if text == "" {
+		return commandError(CodeBadPayload, "消息不能为空")
+	}
+	if utf8.RuneCountInString(text) > chatMaxLength {
+		return commandError(CodeBadPayload, "消息不能超过 %d 个字", chatMaxLength)
+	}
+	if isChatMuted(roomID, playerID) {
+		return commandError(CodeRejected, "你已被房主禁言")
+	}
+	limited, err := isChatRateLimited(roomID, playerID)
+	if err != nil {
+		return err
+	}
+	if limited {
+		return commandError(CodeRateLimited, "发送太频繁，请稍后再试")
+	}
+
+	msg := ChatMessage{
+		PlayerID: playerID,
+		Text:     filterChatText(text),
+		Time:     time.Now().UnixMilli(),
+	}
+	if err := appendChatHistory(roomID, msg); err != nil {
+		log.Println("❌", err)
+	}
+	sendToRoom(roomID, ChatBroadcastMessage{Type: "chat", Message: msg})
+	return nil
+}
+
+// MutePlayer 房主禁言玩家，seconds <= 0 表示直到解除禁言
+func MutePlayer(roomID, operatorID, targetID string, seconds int) error {
+	if err := checkRoomHost(roomID, operatorID); err != nil {
+		return err
+	}
+	if operatorID == targetID {
+		return fmt.Errorf("不能禁言自己")
+	}
+	key := fmt.Sprintf("room:%s:chat_mute:%s", roomID, targetID)
+	var ttl time.Duration
+	if seconds > 0 {
+		ttl = time.Duration(seconds) * time.Second
+	}
+	if err := repository.Rdb.Set(repository.Ctx, key, operatorID, ttl).Err(); err != nil {
+		return fmt.Errorf("禁言失败: %w", err)
+	}
+	return nil
+}
+
+// UnmutePlayer 房主解除禁言
+func UnmutePlayer(roomID, operatorID, targetID string) error {
+	if err := checkRoomHost(roomID, operatorID); err != nil {
+		return err
+	}
+	key := fmt.Sprintf("room:%s:chat_mute:%s", roomID, targetID)
+	if err := repository.Rdb.Del(repository.Ctx, key).Err(); err != nil {
+		return fmt.Errorf("解除禁言失败: %w", err)
+	}
+	return nil
+}
+
+// 解析禁言参数，payload 可以是字符串（玩家 ID）或 {playerID, seconds}
+func parseMutePayload(msgMap map[string]interface{}) (MutePayload, error) {
+	payload, err := payloadOf[MutePayload](msgMap)
+	if err != nil {
+		return payload, err
+	}
+	if payload.PlayerID == "" {
+		return payload, commandError(CodeBadPayload, "缺少玩家 ID")
+	}
+	return payload, nil
+}
+
+func handleMutePlayerMessage(conn ReadWriteConn, rdb redis.Cmdable, roomID, playerID string, msgMap map[string]interface{}) error {
+	payload, err := parseMutePayload(msgMap)
+	if err != nil {
+		return err
+	}
+	if err := MutePlayer(roomID, playerID, payload.PlayerID, payload.Seconds); err != nil {
+		return err
+	}
+	sendToRoom(roomID, PlayerMutedMessage{
+		Type:     "player_muted",
+		PlayerID: payload.PlayerID,
+		Seconds:  payload.Seconds,
+	})
+	return nil
+}
+
+func handleUnmutePlayerMessage(conn ReadWriteConn, rdb redis.Cmdable, roomID, playerID string, msgMap map[string]interface{}) error {
+	payload, err := parseMutePayload(msgMap)
+	if err != nil {
+		return err
+	}
+	if err := UnmutePlayer(roomID, playerID, payload.PlayerID); err != nil {
+		return err
+	}
+	sendToRoom(roomID, PlayerUnmutedMessage{Type: "player_unmuted", PlayerID: payload.PlayerID})
+	return nil
+}
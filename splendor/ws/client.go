@@ -0,0 +1,152 @@
+package ws
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeWait      = 10 * time.Second    // 单次写超时
+	pongWait       = 60 * time.Second    // 超过该时间没有收到任何数据（含 pong）视为断线
+	pingPeriod     = (pongWait * 9) / 10 // 心跳间隔，必须小于 pongWait
+	maxMessageSize = 64 * 1024           // 客户端单条消息大小上限
+	sendBufferSize = 256                 // 发送队列长度，写满视为慢消费者
+)
+
+var (
+	errClientClosed = errors.New("连接已关闭")
+	errSlowConsumer = errors.New("发送队列已满")
+)
+
+type outboundMessage struct {
+	messageType int
+	data        []byte
+}
+
+// Client 真实客户端连接。
+// gorilla/websocket 同一时刻只允许一个写者，所有写操作先进入发送队列，由 writePump 协程串行写出；
+// 读操作只在连接协程中进行，并通过读超时 + ping/pong 及时发现半开连接
+type Client struct {
+	conn *websocket.Conn
+	send chan outboundMessage
+	done chan struct{}
+	once sync.Once
+
+	closeFrame []byte // 关闭时发送的关闭帧
+}
+
+var _ ReadWriteConn = (*Client)(nil) // 编译期断言实现
+
+func newClient(conn *websocket.Conn) *Client {
+	c := &Client{
+		conn: conn,
+		send: make(chan outboundMessage, sendBufferSize),
+		done: make(chan struct{}),
+	}
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	go c.writePump()
+	return c
+}
+
+// WriteMessage 放入发送队列后立即返回，不会阻塞房间 goroutine。
+// 队列写满说明客户端长时间没有消费，直接断开，由读协程完成离线处理
+func (c *Client) WriteMessage(messageType int, data []byte) error {
+	select {
+	case <-c.done:
+		return errClientClosed
+	default:
+	}
+	select {
+	case c.send <- outboundMessage{messageType: messageType, data: data}:
+		return nil
+	default:
+		log.Printf("⚠️ 客户端 %s 发送队列已满，断开连接\n", c.conn.RemoteAddr())
+		c.kill()
+		return errSlowConsumer
+	}
+}
+
+// ReadMessage 读取客户端消息，收到任何消息都刷新读超时
+func (c *Client) ReadMessage() (int, []byte, error) {
+	messageType, data, err := c.conn.ReadMessage()
+	if err == nil {
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	}
+	return messageType, data, err
+}
+
+// Close 先发完队列中已有的消息再关闭连接
+func (c *Client) Close() error {
+	c.closeWith(websocket.CloseNormalClosure, "")
+	return nil
+}
+
+// 带关闭码关闭，如停机时用 CloseServiceRestart 提示客户端重连
+func (c *Client) closeWith(code int, text string) {
+	c.once.Do(func() {
+		c.closeFrame = websocket.FormatCloseMessage(code, text)
+		close(c.done)
+	})
+}
+
+// 立即关闭底层连接，正在阻塞的读写都会马上返回错误
+func (c *Client) kill() {
+	c.once.Do(func() { close(c.done) })
+	c.conn.Close()
+}
+
+func (c *Client) write(messageType int, data []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.conn.WriteMessage(messageType, data)
+}
+
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.kill()
+	}()
+
+	for {
+		select {
+		case msg := <-c.send:
+			if err := c.write(msg.messageType, msg.data); err != nil {
+				log.Println("❌ 发送消息失败:", err)
+				return
+			}
+		case <-ticker.C:
+			if err := c.write(websocket.PingMessage, nil); err != nil {
+				log.Println("❌ 发送心跳失败:", err)
+				return
+			}
+		case <-c.done:
+			c.flush()
+			return
+		}
+	}
+}
+
+// 关闭前发送队列中剩余的消息和关闭帧
+func (c *Client) flush() {
+	for {
+		select {
+		case msg := <-c.send:
+			if err := c.write(msg.messageType, msg.data); err != nil {
+				return
+			}
+		default:
+			if c.closeFrame != nil {
+				c.write(websocket.CloseMessage, c.closeFrame)
+			}
+			return
+		}
+	}
+}
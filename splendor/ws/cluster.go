@@ -0,0 +1,674 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-game/dto"
+	"go-game/repository"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/websocket"
+)
+
+// 多实例部署：
+//   - 每个房间由持有租约 room:<id>:owner 的实例运行房间 goroutine（游戏逻辑、AI、定时器）
+//   - 客户端可以连到任意实例，连接所在实例把消息转发给房主实例，房主实例再把要发给该客户端的消息发回来
+//   - 实例之间通过 Redis pub/sub 通信，房主就是自己时直接在进程内处理。pub/sub 不区分 DB，
+//     频道名带上游戏类型，避免同一个 Redis 上的其他游戏服务收到
+//   - 房主实例宕机后租约过期，由仍有客户端连接的实例接管，客户端在新房主上重新加入
+const (
+	ownerLeaseTTL    = 15 * time.Second           // 房间租约、实例存活标记的有效期
+	clusterTick      = 5 * time.Second            // 续租、检查房主变化和实例存活的间隔
+	clusterCallWait  = 5 * time.Second            // 转发房间操作后等待房主回复的时间
+	clusterBroadcast = gameName + ":ws:broadcast" // 本游戏所有实例都订阅的频道
+)
+
+// 集群消息类型
+const (
+	clusterKindJoin       = "join"        // 连接所在实例 -> 房主：玩家/观战者加入
+	clusterKindMessage    = "message"     // 连接所在实例 -> 房主：玩家发来的消息
+	clusterKindDisconnect = "disconnect"  // 连接所在实例 -> 房主：连接断开
+	clusterKindCall       = "call"        // 任意实例 -> 房主：HTTP 接口的房间操作
+	clusterKindDeliver    = "deliver"     // 房主 -> 连接所在实例：发给客户端的消息
+	clusterKindClose      = "close"       // 房主 -> 连接所在实例：关闭客户端连接
+	clusterKindReply      = "reply"       // 房主 -> 调用方：房间操作结果
+	clusterKindRoomClosed = "room_closed" // 广播：房间已删除
+	clusterKindLobby      = "lobby"       // 广播：推送给玩家大厅连接的消息
+	clusterKindLobbyRoom  = "lobby_room"  // 广播：房间列表变化，推送给订阅了房间列表的大厅连接
+)
+
+type clusterEnvelope struct {
+	Kind     string `json:"kind"`
+	From     string `json:"from"`
+	RoomID   string `json:"roomID"`
+	PlayerID string `json:"playerID,omitempty"`
+	ConnID   string `json:"connID,omitempty"`
+	Role     string `json:"role,omitempty"`
+	Protocol int    `json:"protocol,omitempty"` // 客户端的协议版本，见 protocol.go
+	Data     string `json:"data,omitempty"`
+	Op       string `json:"op,omitempty"`
+	ReqID    string `json:"reqID,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// InstanceID 当前实例 ID，通过 INSTANCE_ID 配置，默认为 主机名-进程号
+var InstanceID = func() string {
+	if id := os.Getenv("INSTANCE_ID"); id != "" {
+		return id
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}()
+
+var clusterSeq uint64
+
+func nextClusterID() string {
+	return fmt.Sprintf("%s-%d", InstanceID, atomic.AddUint64(&clusterSeq, 1))
+}
+
+func instanceChannel(instance string) string {
+	return gameName + ":ws:instance:" + instance
+}
+
+func instanceAliveKey(instance string) string {
+	return fmt.Sprintf("instance:%s:alive", instance)
+}
+
+func roomOwnerKey(roomID string) string {
+	return fmt.Sprintf("room:%s:owner", roomID)
+}
+
+// 租约内容为 实例ID|序号，同一实例重新获得租约时内容也会变化，
+// 连接所在实例据此判断是否需要在房主上重新加入
+func leaseInstance(lease string) string {
+	if i := strings.LastIndex(lease, "|"); i >= 0 {
+		return lease[:i]
+	}
+	return lease
+}
+
+// 只续自己的租约
+var renewOwnerScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// 只释放自己的租约
+var releaseOwnerScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// 获取房间当前租约，没有实例持有租约时由当前实例接管
+func acquireRoomOwner(roomID string) (string, error) {
+	key := roomOwnerKey(roomID)
+	for {
+		lease := fmt.Sprintf("%s|%d", InstanceID, atomic.AddUint64(&clusterSeq, 1))
+		ok, err := repository.Rdb.SetNX(repository.Ctx, key, lease, ownerLeaseTTL).Result()
+		if err != nil {
+			return "", fmt.Errorf("获取房间租约失败: %w", err)
+		}
+		if ok {
+			log.Printf("🏠 实例 %s 接管房间 %s\n", InstanceID, roomID)
+			return lease, nil
+		}
+		owner, err := repository.Rdb.Get(repository.Ctx, key).Result()
+		if err == redis.Nil {
+			// 租约恰好过期，重新抢占
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("获取房间所属实例失败: %w", err)
+		}
+		return owner, nil
+	}
+}
+
+// 查询房间当前租约，不会接管
+func lookupRoomOwner(roomID string) (string, error) {
+	owner, err := repository.Rdb.Get(repository.Ctx, roomOwnerKey(roomID)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return owner, err
+}
+
+func renewRoomOwner(roomID, lease string) bool {
+	n, err := renewOwnerScript.Run(repository.Ctx, repository.Rdb,
+		[]string{roomOwnerKey(roomID)}, lease, ownerLeaseTTL.Milliseconds()).Int()
+	if err != nil {
+		log.Println("❌ 房间续租失败:", err)
+		return false
+	}
+	return n == 1
+}
+
+func releaseRoomOwner(roomID, lease string) {
+	if err := releaseOwnerScript.Run(repository.Ctx, repository.Rdb,
+		[]string{roomOwnerKey(roomID)}, lease).Err(); err != nil && err != redis.Nil {
+		log.Println("❌ 释放房间租约失败:", err)
+	}
+}
+
+func instanceAlive(instance string) bool {
+	n, err := repository.Rdb.Exists(repository.Ctx, instanceAliveKey(instance)).Result()
+	// Redis 出错时不判定为宕机，避免误把玩家标记离线
+	return err != nil || n > 0
+}
+
+// 当前实例持有租约的房间，需要时启动房间 goroutine
+func ownedRoom(roomID string) (*Room, error) {
+	lease, err := lookupRoomOwner(roomID)
+	if err != nil {
+		return nil, err
+	}
+	if leaseInstance(lease) != InstanceID {
+		return nil, fmt.Errorf("房间[%s]不由当前实例运行", roomID)
+	}
+	if room := getRoom(roomID); room != nil {
+		if room.lease == lease {
+			return room, nil
+		}
+		// 租约曾经丢失后又重新获得，旧的座位已经失效
+		closeLocalRoom(roomID)
+	}
+	n, err := repository.Rdb.Exists(repository.Ctx, fmt.Sprintf("room:%s:roomInfo", roomID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		releaseRoomOwner(roomID, lease)
+		return nil, fmt.Errorf("房间[%s]不存在", roomID)
+	}
+	return getOrCreateRoom(roomID, lease), nil
+}
+
+// 发给指定实例，目标是自己时直接处理
+func sendToInstance(instance string, env clusterEnvelope) error {
+	env.From = InstanceID
+	if instance == InstanceID {
+		return handleClusterEnvelope(env)
+	}
+	data, err := json.Marshal(env)
+	if err != nil {
+		return fmt.Errorf("编码集群消息失败: %w", err)
+	}
+	if err := repository.Rdb.Publish(repository.Ctx, instanceChannel(instance), data).Err(); err != nil {
+		return fmt.Errorf("发送集群消息失败: %w", err)
+	}
+	return nil
+}
+
+// 发给所有实例（包括自己）
+func broadcastToInstances(env clusterEnvelope) {
+	env.From = InstanceID
+	if err := handleClusterEnvelope(env); err != nil {
+		log.Println("❌ 处理集群消息失败:", err)
+	}
+	data, err := json.Marshal(env)
+	if err != nil {
+		log.Println("❌ 编码集群消息失败:", err)
+		return
+	}
+	if err := repository.Rdb.Publish(repository.Ctx, clusterBroadcast, data).Err(); err != nil {
+		log.Println("❌ 发送集群广播失败:", err)
+	}
+}
+
+// remoteConn 房主实例上代表一个客户端连接，写入的消息转发到连接所在实例。
+// 按值比较，同一连接的 remoteConn 相等，可以直接用于座位匹配
+type remoteConn struct {
+	RoomID   string
+	PlayerID string
+	ConnID   string
+	Instance string
+	Protocol int
+}
+
+var _ ReadWriteConn = remoteConn{} // 编译期断言实现
+
+func (r remoteConn) WriteMessage(messageType int, data []byte) error {
+	return sendToInstance(r.Instance, clusterEnvelope{
+		Kind:     clusterKindDeliver,
+		RoomID:   r.RoomID,
+		PlayerID: r.PlayerID,
+		ConnID:   r.ConnID,
+		Data:     string(data),
+	})
+}
+
+func (r remoteConn) ReadMessage() (int, []byte, error) {
+	return 0, nil, fmt.Errorf("remote connection cannot read")
+}
+
+func (r remoteConn) Close() error {
+	return sendToInstance(r.Instance, clusterEnvelope{
+		Kind:   clusterKindClose,
+		RoomID: r.RoomID,
+		ConnID: r.ConnID,
+	})
+}
+
+// localClient 连接在当前实例上的客户端
+type localClient struct {
+	ConnID   string
+	RoomID   string
+	PlayerID string
+	Role     string
+	Protocol int
+	conn     *Client
+
+	mu    sync.Mutex
+	owner string // 已加入的房主租约
+}
+
+var (
+	localClients   = make(map[string]*localClient)
+	localClientsMu sync.RWMutex
+)
+
+func registerLocalClient(roomID, playerID, role string, protocol int, conn *Client) *localClient {
+	lc := &localClient{
+		ConnID:   nextClusterID(),
+		RoomID:   roomID,
+		PlayerID: playerID,
+		Role:     role,
+		Protocol: protocol,
+		conn:     conn,
+	}
+	localClientsMu.Lock()
+	localClients[lc.ConnID] = lc
+	localClientsMu.Unlock()
+	return lc
+}
+
+// 连接断开，通知房主实例。先通知再移除，停机时据此确认断线都已交给房间处理
+func unregisterLocalClient(lc *localClient) {
+	defer func() {
+		localClientsMu.Lock()
+		delete(localClients, lc.ConnID)
+		localClientsMu.Unlock()
+	}()
+
+	lc.mu.Lock()
+	owner := lc.owner
+	lc.mu.Unlock()
+	if owner == "" {
+		return
+	}
+	err := sendToInstance(leaseInstance(owner), clusterEnvelope{
+		Kind:     clusterKindDisconnect,
+		RoomID:   lc.RoomID,
+		PlayerID: lc.PlayerID,
+		ConnID:   lc.ConnID,
+		Role:     lc.Role,
+		Protocol: lc.Protocol,
+	})
+	if err != nil {
+		log.Println("❌ 通知房主实例断线失败:", err)
+	}
+}
+
+func getLocalClient(connID string) *localClient {
+	localClientsMu.RLock()
+	defer localClientsMu.RUnlock()
+	return localClients[connID]
+}
+
+func localClientsOf(roomID string) []*localClient {
+	localClientsMu.RLock()
+	defer localClientsMu.RUnlock()
+	list := make([]*localClient, 0)
+	for _, lc := range localClients {
+		if roomID == "" || lc.RoomID == roomID {
+			list = append(list, lc)
+		}
+	}
+	return list
+}
+
+// 确认房主实例，首次连接或房主变化（故障转移）时在房主上加入房间
+func (lc *localClient) ensureJoined() (string, error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	owner, err := acquireRoomOwner(lc.RoomID)
+	if err != nil {
+		return "", err
+	}
+	if owner == lc.owner {
+		return owner, nil
+	}
+	if lc.owner != "" {
+		log.Printf("🔁 房间 %s 的租约由 %s 变为 %s，玩家 %s 重新加入\n", lc.RoomID, lc.owner, owner, lc.PlayerID)
+	}
+	err = sendToInstance(leaseInstance(owner), clusterEnvelope{
+		Kind:     clusterKindJoin,
+		RoomID:   lc.RoomID,
+		PlayerID: lc.PlayerID,
+		ConnID:   lc.ConnID,
+		Role:     lc.Role,
+		Protocol: lc.Protocol,
+	})
+	if err != nil {
+		return "", err
+	}
+	lc.owner = owner
+	return owner, nil
+}
+
+// 把客户端消息转发给房主实例
+func (lc *localClient) forward(data []byte) error {
+	owner, err := lc.ensureJoined()
+	if err != nil {
+		return err
+	}
+	return sendToInstance(leaseInstance(owner), clusterEnvelope{
+		Kind:     clusterKindMessage,
+		RoomID:   lc.RoomID,
+		PlayerID: lc.PlayerID,
+		ConnID:   lc.ConnID,
+		Protocol: lc.Protocol,
+		Data:     string(data),
+	})
+}
+
+// 可以转发到房主实例执行的房间操作，在房间 goroutine 内执行
+var roomCalls = map[string]func(roomID string, args json.RawMessage) (interface{}, error){
+	"add_ai":          callAddAI,
+	"remove_ai":       callRemoveAI,
+	"replace_with_ai": callReplaceWithAI,
+	"abandon_game":    callAbandonGame,
+}
+
+// 等待房主回复的调用
+var (
+	pendingCalls   = make(map[string]chan clusterEnvelope)
+	pendingCallsMu sync.Mutex
+)
+
+// CallRoom 在房间所属实例的房间 goroutine 内执行操作，结果解码到 result
+func CallRoom(roomID, op string, args interface{}, result interface{}) error {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return fmt.Errorf("编码参数失败: %w", err)
+	}
+	owner, err := acquireRoomOwner(roomID)
+	if err != nil {
+		return err
+	}
+
+	var reply []byte
+	if instance := leaseInstance(owner); instance == InstanceID {
+		reply, err = runRoomCall(roomID, op, data)
+	} else {
+		reply, err = callRemoteRoom(instance, roomID, op, data)
+	}
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(reply, result)
+}
+
+func runRoomCall(roomID, op string, args []byte) ([]byte, error) {
+	fn, ok := roomCalls[op]
+	if !ok {
+		return nil, fmt.Errorf("未知的房间操作: %s", op)
+	}
+	room, err := ownedRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	var callErr error
+	if !room.Call(func() { result, callErr = fn(roomID, args) }) {
+		return nil, fmt.Errorf("房间[%s]不存在", roomID)
+	}
+	if callErr != nil {
+		return nil, callErr
+	}
+	return json.Marshal(result)
+}
+
+func callRemoteRoom(owner, roomID, op string, args []byte) ([]byte, error) {
+	reqID := nextClusterID()
+	ch := make(chan clusterEnvelope, 1)
+	pendingCallsMu.Lock()
+	pendingCalls[reqID] = ch
+	pendingCallsMu.Unlock()
+	defer func() {
+		pendingCallsMu.Lock()
+		delete(pendingCalls, reqID)
+		pendingCallsMu.Unlock()
+	}()
+
+	err := sendToInstance(owner, clusterEnvelope{
+		Kind:   clusterKindCall,
+		RoomID: roomID,
+		Op:     op,
+		ReqID:  reqID,
+		Data:   string(args),
+	})
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case reply := <-ch:
+		if reply.Error != "" {
+			return nil, errors.New(reply.Error)
+		}
+		return []byte(reply.Data), nil
+	case <-time.After(clusterCallWait):
+		return nil, fmt.Errorf("房间[%s]所在实例无响应", roomID)
+	}
+}
+
+func handleClusterEnvelope(env clusterEnvelope) error {
+	switch env.Kind {
+	case clusterKindJoin, clusterKindMessage, clusterKindDisconnect:
+		return handleOwnerEnvelope(env)
+	case clusterKindCall:
+		go func() {
+			reply := clusterEnvelope{Kind: clusterKindReply, RoomID: env.RoomID, ReqID: env.ReqID}
+			data, err := runRoomCall(env.RoomID, env.Op, []byte(env.Data))
+			if err != nil {
+				reply.Error = err.Error()
+			} else {
+				reply.Data = string(data)
+			}
+			if err := sendToInstance(env.From, reply); err != nil {
+				log.Println("❌ 回复房间操作失败:", err)
+			}
+		}()
+	case clusterKindDeliver:
+		lc := getLocalClient(env.ConnID)
+		if lc == nil {
+			return errClientClosed
+		}
+		return lc.conn.WriteMessage(websocket.TextMessage, []byte(env.Data))
+	case clusterKindClose:
+		if lc := getLocalClient(env.ConnID); lc != nil {
+			lc.conn.Close()
+		}
+	case clusterKindReply:
+		pendingCallsMu.Lock()
+		ch, ok := pendingCalls[env.ReqID]
+		pendingCallsMu.Unlock()
+		if ok {
+			ch <- env
+		}
+	case clusterKindLobby:
+		deliverLobby(env.PlayerID, []byte(env.Data))
+	case clusterKindLobbyRoom:
+		deliverRoomEvent([]byte(env.Data))
+	case clusterKindRoomClosed:
+		if env.From != InstanceID {
+			closeLocalRoom(env.RoomID)
+		}
+		for _, lc := range localClientsOf(env.RoomID) {
+			lc.conn.Close()
+		}
+	default:
+		return fmt.Errorf("未知的集群消息类型: %s", env.Kind)
+	}
+	return nil
+}
+
+// 房主实例处理连接所在实例转发来的消息，统一投递到房间 goroutine
+func handleOwnerEnvelope(env clusterEnvelope) error {
+	conn := remoteConn{
+		RoomID:   env.RoomID,
+		PlayerID: env.PlayerID,
+		ConnID:   env.ConnID,
+		Instance: env.From,
+		Protocol: env.Protocol,
+	}
+	room, err := ownedRoom(env.RoomID)
+	if err != nil {
+		if env.Kind == clusterKindJoin {
+			// 房主已经变化，让客户端重连到新的房主
+			sendErrorMessage(conn, "房间所在服务器已变更，请重新连接")
+			conn.Close()
+		}
+		return err
+	}
+
+	switch env.Kind {
+	case clusterKindJoin:
+		room.Do(func() { joinRoom(conn, env.RoomID, env.PlayerID, env.Role) })
+	case clusterKindMessage:
+		room.Do(func() { dispatchMessage(conn, env.RoomID, env.PlayerID, []byte(env.Data)) })
+	case clusterKindDisconnect:
+		room.Do(func() {
+			if env.Role == RoleSpectator {
+				leaveSpectator(env.RoomID, env.PlayerID, conn)
+				return
+			}
+			cleanupOnDisconnect(env.RoomID, env.PlayerID, conn)
+		})
+	}
+	return nil
+}
+
+// StartCluster 订阅集群消息并定期续租，每个实例启动时调用
+func StartCluster() {
+	markInstanceAlive()
+	pubsub := repository.Rdb.Subscribe(repository.Ctx, instanceChannel(InstanceID), clusterBroadcast)
+	if _, err := pubsub.Receive(repository.Ctx); err != nil {
+		log.Fatalf("订阅集群消息失败: %v", err)
+	}
+	go func() {
+		for msg := range pubsub.Channel() {
+			var env clusterEnvelope
+			if err := json.Unmarshal([]byte(msg.Payload), &env); err != nil {
+				log.Println("❌ 集群消息解析失败:", err)
+				continue
+			}
+			// 自己发出的广播已经在本地处理过
+			if msg.Channel == clusterBroadcast && env.From == InstanceID {
+				continue
+			}
+			if err := handleClusterEnvelope(env); err != nil && err != errClientClosed {
+				log.Println("❌ 处理集群消息失败:", err)
+			}
+		}
+	}()
+	go clusterLoop()
+	go lobbyLoop()
+	log.Printf("✅ 实例 %s 已加入集群\n", InstanceID)
+}
+
+func markInstanceAlive() {
+	if err := repository.Rdb.Set(repository.Ctx, instanceAliveKey(InstanceID), 1, ownerLeaseTTL).Err(); err != nil {
+		log.Println("❌ 更新实例存活标记失败:", err)
+	}
+}
+
+func clusterLoop() {
+	ticker := time.NewTicker(clusterTick)
+	defer ticker.Stop()
+	for range ticker.C {
+		markInstanceAlive()
+		for _, roomID := range RoomIDs() {
+			keepRoomOwner(roomID)
+		}
+		// 房主宕机时接管或重新加入新的房主
+		for _, lc := range localClientsOf("") {
+			if _, err := lc.ensureJoined(); err != nil {
+				log.Printf("❌ 玩家 %s 加入房间 %s 失败: %v\n", lc.PlayerID, lc.RoomID, err)
+			}
+		}
+	}
+}
+
+// 续租；房间已删除或租约被其他实例接管时停止本地房间
+func keepRoomOwner(roomID string) {
+	n, err := repository.Rdb.Exists(repository.Ctx, fmt.Sprintf("room:%s:roomInfo", roomID)).Result()
+	if err == nil && n == 0 {
+		log.Printf("🧹 房间 %s 已不存在，停止运行\n", roomID)
+		CloseRoom(roomID)
+		return
+	}
+	room := getRoom(roomID)
+	if room == nil {
+		return
+	}
+	if !renewRoomOwner(roomID, room.lease) {
+		log.Printf("⚠️ 实例 %s 失去房间 %s 的租约，停止运行\n", InstanceID, roomID)
+		closeLocalRoom(roomID)
+		return
+	}
+	dropDeadInstanceConns(roomID)
+}
+
+// 连接所在实例宕机后收不到断线通知，由房主把这些玩家标记为离线、移除观战者
+func dropDeadInstanceConns(roomID string) {
+	room := getRoom(roomID)
+	if room == nil {
+		return
+	}
+	room.Do(func() {
+		alive := map[string]bool{InstanceID: true}
+		isDead := func(conn WriteOnlyConn) (remoteConn, bool) {
+			rc, ok := conn.(remoteConn)
+			if !ok {
+				return rc, false
+			}
+			if _, checked := alive[rc.Instance]; !checked {
+				alive[rc.Instance] = instanceAlive(rc.Instance)
+			}
+			return rc, !alive[rc.Instance]
+		}
+
+		for _, pc := range playersOf(roomID) {
+			if !pc.Online || pc.Conn == nil {
+				continue
+			}
+			if rc, dead := isDead(pc.Conn); dead {
+				log.Printf("⚠️ 实例 %s 已失联，玩家 %s 标记为离线\n", rc.Instance, pc.PlayerID)
+				cleanupOnDisconnect(roomID, pc.PlayerID, rc)
+			}
+		}
+
+		spectatorLock.Lock()
+		spectators := append([]dto.PlayerConn(nil), Spectators[roomID]...)
+		spectatorLock.Unlock()
+		for _, pc := range spectators {
+			if rc, dead := isDead(pc.Conn); dead {
+				leaveSpectator(roomID, pc.PlayerID, rc)
+			}
+		}
+	})
+}
@@ -19,14 +19,14 @@ func SetPlayerNormalCard(roomID, playerID string, value []entities.NormalCard) e
 		return fmt.Errorf("序列化玩家预留贵族卡失败: %w", err)
 	}
 
-	return repository.Rdb.HSet(repository.Ctx, playerNormalCardKey, "data", bytes).Err()
+	return stateRdb(roomID).HSet(repository.Ctx, playerNormalCardKey, "data", bytes).Err()
 }
 
 // GetJSONFromRedisHash 从 Redis 哈希的 "data" 字段获取并反序列化为传入的目标结构
 func GetPlayerNormalCard(roomID, playerID string) ([]entities.NormalCard, error) {
 	playerNormalCardKey := fmt.Sprintf("room:%s:player:%s:normalCard", roomID, playerID)
 
-	val, err := repository.Rdb.HGet(repository.Ctx, playerNormalCardKey, "data").Result()
+	val, err := stateRdb(roomID).HGet(repository.Ctx, playerNormalCardKey, "data").Result()
 	if err == redis.Nil {
 		return []entities.NormalCard{}, nil
 	}
@@ -48,7 +48,7 @@ func SetPlayerNobleCard(roomID, playerID string, value []entities.NobleCard) err
 	if err != nil {
 		return err
 	}
-	return repository.Rdb.HSet(repository.Ctx, playerCardKey, "data", bytes).Err()
+	return stateRdb(roomID).HSet(repository.Ctx, playerCardKey, "data", bytes).Err()
 }
 
 // GetJSONFromRedisHash 从 Redis 哈希的 "data" 字段获取并反序列化为传入的目标结构
@@ -56,7 +56,7 @@ func GetPlayerNobleCard(roomID, playerID string) ([]entities.NobleCard, error) {
 	playerNobleCardKey := fmt.Sprintf("room:%s:player:%s:nobleCard", roomID, playerID)
 
 	// 从 Redis 获取字符串形式的 JSON 数据
-	val, err := repository.Rdb.HGet(repository.Ctx, playerNobleCardKey, "data").Result()
+	val, err := stateRdb(roomID).HGet(repository.Ctx, playerNobleCardKey, "data").Result()
 	if err == redis.Nil {
 		return []entities.NobleCard{}, nil
 	}
@@ -78,13 +78,13 @@ func SetPlayerGem(roomID, playerID string, gem map[string]int) error {
 	if err != nil {
 		return err
 	}
-	return repository.Rdb.HSet(repository.Ctx, key, "data", bytes).Err()
+	return stateRdb(roomID).HSet(repository.Ctx, key, "data", bytes).Err()
 }
 
 func GetPlayerGem(roomID, playerID string) (map[string]int, error) {
 	key := fmt.Sprintf("room:%s:player:%s:gem", roomID, playerID)
 
-	val, err := repository.Rdb.HGet(repository.Ctx, key, "data").Result()
+	val, err := stateRdb(roomID).HGet(repository.Ctx, key, "data").Result()
 	if err == redis.Nil {
 		// 说明字段不存在，直接返回空列表，不报错
 		return map[string]int{}, nil
@@ -104,14 +104,25 @@ func GetPlayerGem(roomID, playerID string) (map[string]int, error) {
 // SetPlayerScore 将玩家的分数写入 Redis 哈希中（field 为 "data"）
 func SetPlayerScore(roomID, playerID string, score int) error {
 	key := fmt.Sprintf("room:%s:player:%s:score", roomID, playerID)
-	return repository.Rdb.HSet(repository.Ctx, key, "data", score).Err()
+	return stateRdb(roomID).HSet(repository.Ctx, key, "data", score).Err()
+}
+
+// SetPlayerScores 批量写入多个玩家的分数
+func SetPlayerScores(roomID string, scores map[string]int) error {
+	pipe := stateRdb(roomID).Pipeline()
+	for playerID, score := range scores {
+		key := fmt.Sprintf("room:%s:player:%s:score", roomID, playerID)
+		pipe.HSet(repository.Ctx, key, "data", score)
+	}
+	_, err := pipe.Exec(repository.Ctx)
+	return err
 }
 
 // GetPlayerScore 从 Redis 中获取玩家的分数（从 field "data" 取出并转为 int）
 func GetPlayerScore(roomID, playerID string) (int, error) {
 	key := fmt.Sprintf("room:%s:player:%s:score", roomID, playerID)
 
-	val, err := repository.Rdb.HGet(repository.Ctx, key, "data").Result()
+	val, err := stateRdb(roomID).HGet(repository.Ctx, key, "data").Result()
 	if err == redis.Nil {
 		// 说明字段不存在，直接返回 0，不报错
 		return 0, nil
@@ -136,13 +147,13 @@ func SetPlayerReserveCards(roomID, playerID string, reserve []entities.NormalCar
 		return fmt.Errorf("序列化玩家预留贵族卡失败: %w", err)
 	}
 
-	return repository.Rdb.HSet(repository.Ctx, key, "data", bytes).Err()
+	return stateRdb(roomID).HSet(repository.Ctx, key, "data", bytes).Err()
 }
 
 func GetPlayerReserveCards(roomID, playerID string) ([]entities.NormalCard, error) {
 	key := fmt.Sprintf("room:%s:player:%s:reserve", roomID, playerID)
 
-	val, err := repository.Rdb.HGet(repository.Ctx, key, "data").Result()
+	val, err := stateRdb(roomID).HGet(repository.Ctx, key, "data").Result()
 	if err == redis.Nil {
 		// 说明字段不存在，直接返回空列表，不报错
 		return []entities.NormalCard{}, nil
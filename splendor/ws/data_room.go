@@ -3,10 +3,12 @@ package ws
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"go-game/entities"
 	"go-game/repository"
 	"log"
+	"sort"
 	"strconv"
 
 	"github.com/go-redis/redis/v8"
@@ -20,7 +22,7 @@ func SetNormalCardByID(roomID string, card *entities.NormalCard) error {
 		return fmt.Errorf("卡牌序列化失败: %w", err)
 	}
 
-	if err := repository.Rdb.HSet(repository.Ctx, cardKey, card.ID, cardJSON).Err(); err != nil {
+	if err := stateRdb(roomID).HSet(repository.Ctx, cardKey, card.ID, cardJSON).Err(); err != nil {
 		return fmt.Errorf("保存卡牌失败: %w", err)
 	}
 
@@ -30,7 +32,7 @@ func SetNormalCardByID(roomID string, card *entities.NormalCard) error {
 func GetNormalCardByID(roomID string, cardID string) (*entities.NormalCard, error) {
 	cardKey := fmt.Sprintf("room:%s:card", roomID)
 
-	result, err := repository.Rdb.HGet(repository.Ctx, cardKey, cardID).Result()
+	result, err := stateRdb(roomID).HGet(repository.Ctx, cardKey, cardID).Result()
 	if err != nil {
 		if err == redis.Nil {
 			return nil, fmt.Errorf("卡牌 %s 不存在", cardID)
@@ -54,7 +56,7 @@ func SetNobleCardByID(roomID string, card *entities.NobleCard) error {
 		return fmt.Errorf("卡牌序列化失败: %w", err)
 	}
 
-	if err := repository.Rdb.HSet(repository.Ctx, cardKey, card.ID, cardJSON).Err(); err != nil {
+	if err := stateRdb(roomID).HSet(repository.Ctx, cardKey, card.ID, cardJSON).Err(); err != nil {
 		return fmt.Errorf("保存卡牌失败: %w", err)
 	}
 
@@ -64,7 +66,7 @@ func SetNobleCardByID(roomID string, card *entities.NobleCard) error {
 func GetNobleCardByID(roomID string, cardID string) (*entities.NobleCard, error) {
 	cardKey := fmt.Sprintf("room:%s:nobles", roomID)
 
-	result, err := repository.Rdb.HGet(repository.Ctx, cardKey, cardID).Result()
+	result, err := stateRdb(roomID).HGet(repository.Ctx, cardKey, cardID).Result()
 	if err != nil {
 		if err == redis.Nil {
 			return nil, fmt.Errorf("卡牌 %s 不存在", cardID)
@@ -84,11 +86,15 @@ func GetAllNormalCards(roomID string) (map[string]entities.NormalCard, error) {
 	cardKey := fmt.Sprintf("room:%s:card", roomID)
 
 	// 取出所有 field-value 对
-	result, err := repository.Rdb.HGetAll(repository.Ctx, cardKey).Result()
+	result, err := stateRdb(roomID).HGetAll(repository.Ctx, cardKey).Result()
 	if err != nil {
 		return nil, err
 	}
+	return parseNormalCards(result)
+}
 
+// 解析卡牌 Hash
+func parseNormalCards(result map[string]string) (map[string]entities.NormalCard, error) {
 	cards := make(map[string]entities.NormalCard, len(result))
 	for cardID, cardJSON := range result {
 		var card entities.NormalCard
@@ -101,14 +107,65 @@ func GetAllNormalCards(roomID string) (map[string]entities.NormalCard, error) {
 	return cards, nil
 }
 
+var errDeckEmpty = errors.New("牌堆已空")
+
+// 从指定等级的牌堆中随机取一张未翻开的卡牌
+func drawHiddenCard(roomID string, level int) (*entities.NormalCard, error) {
+	allCards, err := GetAllNormalCards(roomID)
+	if err != nil {
+		return nil, err
+	}
+	hidden := make([]entities.NormalCard, 0)
+	for _, card := range allCards {
+		if card.State == entities.CardStateHidden && card.Level == level {
+			hidden = append(hidden, card)
+		}
+	}
+	if len(hidden) == 0 {
+		return nil, fmt.Errorf("%w: 等级 %d", errDeckEmpty, level)
+	}
+	// 按 ID 排序后再抽，同样的随机数抽到同一张
+	sort.Slice(hidden, func(i, j int) bool { return hidden[i].ID < hidden[j].ID })
+	r, err := gameRand(roomID)
+	if err != nil {
+		return nil, err
+	}
+	card := hidden[r.IntN(len(hidden))]
+	return &card, nil
+}
+
+// 翻开同等级牌堆中的一张卡牌补到桌面上，牌堆已空时不补
+func revealNextCard(roomID string, level int) error {
+	next, err := drawHiddenCard(roomID, level)
+	if errors.Is(err, errDeckEmpty) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("翻开卡牌失败: %w", err)
+	}
+	next.State = entities.CardStateRevealed
+	if err := SetNormalCardByID(roomID, next); err != nil {
+		return fmt.Errorf("翻开卡牌失败: %w", err)
+	}
+	recordGameEvent(roomID, "card_revealed", map[string]interface{}{
+		"cardID": next.ID,
+		"level":  next.Level,
+	})
+	return nil
+}
+
 func GetAllNobleCards(roomID string) (map[string]entities.NobleCard, error) {
 	noblesKey := fmt.Sprintf("room:%s:nobles", roomID)
 
-	result, err := repository.Rdb.HGetAll(repository.Ctx, noblesKey).Result()
+	result, err := stateRdb(roomID).HGetAll(repository.Ctx, noblesKey).Result()
 	if err != nil {
 		return nil, err
 	}
+	return parseNobleCards(result)
+}
 
+// 解析贵族瓷砖 Hash
+func parseNobleCards(result map[string]string) (map[string]entities.NobleCard, error) {
 	nobles := make(map[string]entities.NobleCard, len(result))
 	for nobleID, nobleJSON := range result {
 		var noble entities.NobleCard
@@ -131,7 +188,7 @@ func SetGemCounts(roomID string, gemCounts map[string]int) error {
 	}
 
 	// 一次性写入 Redis 的 Hash
-	if err := repository.Rdb.HMSet(repository.Ctx, gemKey, gemStrMap).Err(); err != nil {
+	if err := stateRdb(roomID).HMSet(repository.Ctx, gemKey, gemStrMap).Err(); err != nil {
 		return fmt.Errorf("设置房间 %s 的宝石信息失败: %w", roomID, err)
 	}
 
@@ -141,11 +198,15 @@ func SetGemCounts(roomID string, gemCounts map[string]int) error {
 func GetGemCounts(roomID string) (map[string]int, error) {
 	gemKey := fmt.Sprintf("room:%s:gems", roomID)
 
-	result, err := repository.Rdb.HGetAll(repository.Ctx, gemKey).Result()
+	result, err := stateRdb(roomID).HGetAll(repository.Ctx, gemKey).Result()
 	if err != nil {
 		return nil, err
 	}
+	return parseGemCounts(result)
+}
 
+// 解析宝石池 Hash
+func parseGemCounts(result map[string]string) (map[string]int, error) {
 	gemCounts := make(map[string]int, len(result))
 	for color, countStr := range result {
 		count, err := strconv.Atoi(countStr)
@@ -159,7 +220,7 @@ func GetGemCounts(roomID string) (map[string]int, error) {
 }
 
 // 判断玩家信息是否存在
-func IsPlayerInfoExists(rdb *redis.Client, ctx context.Context, roomID, playerID string) (bool, error) {
+func IsPlayerInfoExists(rdb redis.Cmdable, ctx context.Context, roomID, playerID string) (bool, error) {
 	playerInfoKey := fmt.Sprintf("room:%s:player:%s:gem", roomID, playerID)
 	exists, err := rdb.Exists(ctx, playerInfoKey).Result()
 	if err != nil {
@@ -169,7 +230,7 @@ func IsPlayerInfoExists(rdb *redis.Client, ctx context.Context, roomID, playerID
 }
 
 // SetRoomInfo 设置房间的全部信息（Hash）
-func SetRoomInfo(rdb *redis.Client, ctx context.Context, roomID string, info entities.RoomInfo) error {
+func SetRoomInfo(rdb redis.Cmdable, ctx context.Context, roomID string, info entities.RoomInfo) error {
 	roomKey := fmt.Sprintf("room:%s:roomInfo", roomID)
 	roomStatus := strconv.FormatBool(info.RoomStatus)
 
@@ -178,22 +239,37 @@ func SetRoomInfo(rdb *redis.Client, ctx context.Context, roomID string, info ent
 		"roomStatus": roomStatus,
 		"maxPlayers": strconv.Itoa(info.MaxPlayers),
 		"userID":     info.UserID,
+		"private":    strconv.FormatBool(info.Private),
 	}
 
 	if err := rdb.HSet(ctx, roomKey, data).Err(); err != nil {
 		return fmt.Errorf("❌ 设置房间信息失败: %w", err)
 	}
-
+	markRoomChanged(roomID, lobbyRoomUpdated)
 	return nil
 }
 
 // GetRoomInfo 获取房间的全部信息（Hash）
 func GetRoomInfo(roomID string) (*entities.RoomInfo, error) {
+	return readRoomInfo(stateRdb(roomID), roomID)
+}
+
+// 两个游戏共用的代码读取房间信息的统一入口，读取已提交的数据，可以在任意 goroutine 调用
+func roomInfoOf(roomID string) (*entities.RoomInfo, error) {
+	return readRoomInfo(repository.Rdb, roomID)
+}
+
+func readRoomInfo(rdb redis.Cmdable, roomID string) (*entities.RoomInfo, error) {
 	roomKey := fmt.Sprintf("room:%s:roomInfo", roomID)
-	roomInfoMap, err := repository.Rdb.HGetAll(repository.Ctx, roomKey).Result()
+	roomInfoMap, err := rdb.HGetAll(repository.Ctx, roomKey).Result()
 	if err != nil {
 		return nil, fmt.Errorf("❌ 获取房间信息失败: %w", err)
 	}
+	return parseRoomInfo(roomInfoMap)
+}
+
+// 解析 roomInfo Hash
+func parseRoomInfo(roomInfoMap map[string]string) (*entities.RoomInfo, error) {
 	if len(roomInfoMap) == 0 {
 		return nil, fmt.Errorf("房间信息为空")
 	}
@@ -207,6 +283,7 @@ func GetRoomInfo(roomID string) (*entities.RoomInfo, error) {
 	roomInfo.RoomStatus = roomStatus
 	roomInfo.GameStatus = entities.RoomStatus(roomInfoMap["gameStatus"])
 	roomInfo.UserID = roomInfoMap["userID"]
+	roomInfo.Private, _ = strconv.ParseBool(roomInfoMap["private"])
 	// 字符串转 int
 	maxPlayersStr := roomInfoMap["maxPlayers"]
 	if maxPlayersStr != "" {
@@ -220,7 +297,7 @@ func GetRoomInfo(roomID string) (*entities.RoomInfo, error) {
 	return roomInfo, nil
 }
 
-func SetGameStatus(rdb *redis.Client, roomID string, status entities.RoomStatus) error {
+func SetGameStatus(rdb redis.Cmdable, roomID string, status entities.RoomStatus) error {
 	roomInfoKey := fmt.Sprintf("room:%s:roomInfo", roomID)
 	err := rdb.HSet(repository.Ctx, roomInfoKey, "gameStatus", string(status)).Err()
 	if err != nil {
@@ -231,7 +308,7 @@ func SetGameStatus(rdb *redis.Client, roomID string, status entities.RoomStatus)
 	return nil
 }
 
-func SetRoomStatus(rdb *redis.Client, roomID string, status bool) error {
+func SetRoomStatus(rdb redis.Cmdable, roomID string, status bool) error {
 	roomInfoKey := fmt.Sprintf("room:%s:roomInfo", roomID)
 	statusStr := strconv.FormatBool(status) // 将 bool 转为字符串 "true"/"false"
 
@@ -239,11 +316,12 @@ func SetRoomStatus(rdb *redis.Client, roomID string, status bool) error {
 	if err != nil {
 		return fmt.Errorf("更新房间状态失败: %w", err)
 	}
+	markRoomChanged(roomID, lobbyRoomUpdated)
 	return nil
 }
 
 // SetCurrentPlayer 设置当前玩家
-func SetCurrentPlayer(rdb *redis.Client, ctx context.Context, roomID, playerID string) error {
+func SetCurrentPlayer(rdb redis.Cmdable, ctx context.Context, roomID, playerID string) error {
 	key := fmt.Sprintf("room:%s:currentPlayer", roomID)
 	if err := rdb.Set(ctx, key, playerID, 0).Err(); err != nil {
 		return fmt.Errorf("设置当前玩家失败: %w", err)
@@ -253,7 +331,7 @@ func SetCurrentPlayer(rdb *redis.Client, ctx context.Context, roomID, playerID s
 }
 
 // GetCurrentPlayer 获取当前玩家
-func GetCurrentPlayer(rdb *redis.Client, ctx context.Context, roomID string) (string, error) {
+func GetCurrentPlayer(rdb redis.Cmdable, ctx context.Context, roomID string) (string, error) {
 	key := fmt.Sprintf("room:%s:currentPlayer", roomID)
 	playerID, err := rdb.Get(ctx, key).Result()
 	if err != nil {
@@ -265,7 +343,7 @@ func GetCurrentPlayer(rdb *redis.Client, ctx context.Context, roomID string) (st
 	return playerID, nil
 }
 
-func SetFirstPlayer(rdb *redis.Client, ctx context.Context, roomID, playerID string) error {
+func SetFirstPlayer(rdb redis.Cmdable, ctx context.Context, roomID, playerID string) error {
 	key := fmt.Sprintf("room:%s:firstPlayer", roomID)
 	if err := rdb.Set(ctx, key, playerID, 0).Err(); err != nil {
 		return fmt.Errorf("设置first玩家失败: %w", err)
@@ -275,7 +353,7 @@ func SetFirstPlayer(rdb *redis.Client, ctx context.Context, roomID, playerID str
 }
 
 // GetCurrentPlayer 获取当前玩家
-func GetFirstPlayer(rdb *redis.Client, ctx context.Context, roomID string) (string, error) {
+func GetFirstPlayer(rdb redis.Cmdable, ctx context.Context, roomID string) (string, error) {
 	key := fmt.Sprintf("room:%s:firstPlayer", roomID)
 	playerID, err := rdb.Get(ctx, key).Result()
 	if err != nil {
@@ -34,16 +34,20 @@ func SetLastData(roomID, playerID string, action string, payload interface{}) er
 		return fmt.Errorf("序列化 LastAction 失败: %w", err)
 	}
 
-	return repository.Rdb.HSet(repository.Ctx, lastDataKey, "data", bytes).Err()
+	return stateRdb(roomID).HSet(repository.Ctx, lastDataKey, "data", bytes).Err()
 }
 
 func GetLastData(roomID, playerID string) (*LastAction, error) {
 	lastDataKey := fmt.Sprintf("room:%s:last_data", roomID)
 
-	val, err := repository.Rdb.HGet(repository.Ctx, lastDataKey, "data").Result()
+	val, err := stateRdb(roomID).HGet(repository.Ctx, lastDataKey, "data").Result()
 	if err != nil && err != redis.Nil {
 		return nil, err
 	}
+	return parseLastData(val)
+}
+
+func parseLastData(val string) (*LastAction, error) {
 	if val == "" {
 		return nil, nil
 	}
@@ -0,0 +1,231 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-game/repository"
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/websocket"
+)
+
+// 客户端消息信封：{"type":"place_tile","requestId":"c1-42","payload":"5C"}。
+// 每条命令处理完都会回复 ack 或带错误码的 error，requestId 原样带回；
+// 带 requestId 的命令结果会保留一段时间，客户端超时重发同一 requestId 时直接返回上次的结果，不会重复执行
+type Envelope struct {
+	Type      string          `json:"type"`
+	RequestID string          `json:"requestId,omitempty"`
+	Version   *int64          `json:"version,omitempty"` // 客户端看到的房间版本号，见 state_tx.go
+	Payload   json.RawMessage `json:"payload,omitempty"`
+}
+
+// 错误码，客户端按错误码处理，message 只用于展示
+const (
+	CodeBadRequest  = "bad_request"  // 消息不是合法的信封
+	CodeUnknownType = "unknown_type" // 没有这种消息
+	CodeBadPayload  = "bad_payload"  // payload 格式不对
+	CodeConflict    = "conflict"     // 房间状态已变化，需要以最新同步数据为准
+	CodeRejected    = "rejected"     // 不符合规则或没有权限
+	CodeRateLimited = "rate_limited" // 操作太频繁
+
+	CodeUnsupportedProtocol = "unsupported_protocol" // 连接时声明的协议版本不受支持，见 protocol.go
+)
+
+// 重复 requestId 的结果保留时长
+const replyTTL = 10 * time.Minute
+
+// CommandError 带错误码的命令错误
+type CommandError struct {
+	Code    string
+	Message string
+}
+
+func (e *CommandError) Error() string {
+	return e.Message
+}
+
+func commandError(code, format string, args ...interface{}) error {
+	return &CommandError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// 错误对应的错误码，未标明的按不符合规则处理
+func errorCode(err error) string {
+	var cmdErr *CommandError
+	if errors.As(err, &cmdErr) {
+		return cmdErr.Code
+	}
+	if errors.Is(err, errStateConflict) {
+		return CodeConflict
+	}
+	return CodeRejected
+}
+
+// 消息 payload 的类型，用于解码和生成 schema
+type payloadSpec struct {
+	typ      reflect.Type
+	optional bool // 可以不带 payload
+	decode   func(json.RawMessage) (interface{}, error)
+}
+
+func payloadAs[T any]() *payloadSpec {
+	return &payloadSpec{typ: typeOf[T](), decode: decodePayloadAs[T]}
+}
+
+func optionalPayloadAs[T any]() *payloadSpec {
+	spec := payloadAs[T]()
+	spec.optional = true
+	return spec
+}
+
+// 按消息类型把 payload 解码成对应的结构体
+func decodePayloadAs[T any](raw json.RawMessage) (interface{}, error) {
+	var payload T
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, commandError(CodeBadPayload, "payload 格式错误: %v", err)
+	}
+	return payload, nil
+}
+
+// 取出消息的 payload。客户端消息在分发时已解码成对应类型；
+// 服务端内部构造的消息（如 AI 行动）可能是其他类型，按 JSON 转换
+func payloadOf[T any](msgMap map[string]interface{}) (T, error) {
+	var payload T
+	switch v := msgMap["payload"].(type) {
+	case T:
+		return v, nil
+	case nil:
+		return payload, commandError(CodeBadPayload, "缺少 payload")
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return payload, commandError(CodeBadPayload, "payload 格式错误: %v", err)
+		}
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return payload, commandError(CodeBadPayload, "payload 格式错误: %v", err)
+		}
+		return payload, nil
+	}
+}
+
+// 处理一条客户端消息：解析信封、去重、执行并回复。返回消息类型，以及命令是否被执行
+func handleEnvelope(conn ReadWriteConn, roomID, playerID string, msg []byte) (string, bool) {
+	var env Envelope
+	if err := json.Unmarshal(msg, &env); err != nil || env.Type == "" {
+		sendReply(conn, roomID, env, commandError(CodeBadRequest, "消息格式错误"))
+		return "", false
+	}
+	handler, found := messageHandlers[env.Type]
+	if !found {
+		log.Printf("⚠️ 未知的消息类型: %s", env.Type)
+		sendReply(conn, roomID, env, commandError(CodeUnknownType, "未知的消息类型: %s", env.Type))
+		return env.Type, false
+	}
+	if env.RequestID != "" {
+		if cached, err := repository.Rdb.Get(repository.Ctx, replyKey(roomID, playerID, env.RequestID)).Bytes(); err == nil {
+			// 重发的命令，返回上次的结果
+			if err := conn.WriteMessage(websocket.TextMessage, cached); err != nil {
+				log.Println("❌ 发送回复失败:", err)
+			}
+			return env.Type, false
+		} else if err != redis.Nil {
+			log.Println("❌ 读取命令结果失败:", err)
+		}
+	}
+
+	msgMap := map[string]interface{}{
+		"type":     env.Type,
+		"playerID": playerID,
+	}
+	if env.Version != nil {
+		msgMap["version"] = float64(*env.Version)
+	}
+	if spec := messagePayloads[env.Type]; spec != nil && len(env.Payload) > 0 {
+		payload, err := spec.decode(env.Payload)
+		if err != nil {
+			sendReply(conn, roomID, env, err)
+			return env.Type, false
+		}
+		msgMap["payload"] = payload
+	}
+
+	// 离开房间等命令会关闭连接，推迟到回复发出之后
+	dc := &deferredCloseConn{ReadWriteConn: conn}
+	err := handler(dc, repository.Rdb, roomID, playerID, msgMap)
+	if err != nil {
+		log.Printf("❌ 玩家[%s]操作 %s 失败: %v\n", playerID, env.Type, err)
+	}
+	data := sendReply(conn, roomID, env, err)
+	if env.RequestID != "" && data != nil {
+		if err := repository.Rdb.Set(repository.Ctx, replyKey(roomID, playerID, env.RequestID), data, replyTTL).Err(); err != nil {
+			log.Println("❌ 保存命令结果失败:", err)
+		}
+	}
+	if dc.closed {
+		conn.Close()
+	}
+	return env.Type, true
+}
+
+func replyKey(roomID, playerID, requestID string) string {
+	return fmt.Sprintf("room:%s:reply:%s:%s", roomID, playerID, requestID)
+}
+
+// 回复 ack 或 error，返回发出的数据。协议 1 的客户端只收到 error
+func sendReply(conn WriteOnlyConn, roomID string, env Envelope, err error) []byte {
+	var reply interface{} = AckReply{Type: "ack", For: env.Type, RequestID: env.RequestID}
+	if err != nil {
+		errReply := ErrorReply{
+			Type:      "error",
+			For:       env.Type,
+			RequestID: env.RequestID,
+			Code:      errorCode(err),
+			Message:   err.Error(),
+		}
+		if errReply.Code == CodeConflict {
+			// 客户端以这个版本之后的同步数据为准
+			version, verr := GetRoomVersion(roomID)
+			if verr != nil {
+				log.Println("❌", verr)
+			}
+			errReply.Version = &version
+		}
+		reply = errReply
+	} else if connProtocol(conn) < 2 {
+		return nil
+	}
+	data, marshalErr := json.Marshal(reply)
+	if marshalErr != nil {
+		log.Println("❌ 编码 JSON 失败:", marshalErr)
+		return nil
+	}
+	if conn == nil {
+		return data
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		log.Println("❌ 发送回复失败:", err)
+	}
+	return data
+}
+
+// 记录处理过程中的关闭请求，回复发出后再关闭
+type deferredCloseConn struct {
+	ReadWriteConn
+	closed bool
+}
+
+func (c *deferredCloseConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+// 取出被包装前的原始连接
+func unwrapConn(conn ReadWriteConn) ReadWriteConn {
+	if dc, ok := conn.(*deferredCloseConn); ok {
+		return dc.ReadWriteConn
+	}
+	return conn
+}
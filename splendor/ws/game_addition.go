@@ -2,7 +2,9 @@ package ws
 
 import (
 	"encoding/json"
+	"fmt"
 	"go-game/entities"
+	"go-game/repository"
 	"log"
 	"time"
 
@@ -10,24 +12,18 @@ import (
 	"github.com/gorilla/websocket"
 )
 
-func handlePlayAudioMessage(conn ReadWriteConn, rdb *redis.Client, roomID string, playerID string, msgMap map[string]interface{}) {
-	audioType, ok := msgMap["payload"].(string)
-	if !ok {
-		log.Println("❌ 消息格式错误")
-		return
+func handlePlayAudioMessage(conn ReadWriteConn, rdb redis.Cmdable, roomID string, playerID string, msgMap map[string]interface{}) error {
+	audioType, err := payloadOf[PlayAudioPayload](msgMap)
+	if err != nil {
+		return err
 	}
 
-	msg := map[string]interface{}{
-		"type":    "audio",
-		"message": audioType,
-	}
-	data, err := json.Marshal(msg)
+	data, err := json.Marshal(AudioMessage{Type: "audio", Message: string(audioType)})
 	if err != nil {
-		log.Println("❌ 编码 JSON 失败:", err)
-		return
+		return fmt.Errorf("编码 JSON 失败: %w", err)
 	}
 
-	for _, pc := range Rooms[roomID] {
+	for _, pc := range playersOf(roomID) {
 		if pc.Online && pc.Conn != nil {
 			err := pc.Conn.WriteMessage(websocket.TextMessage, data)
 			if err != nil {
@@ -35,31 +31,91 @@ func handlePlayAudioMessage(conn ReadWriteConn, rdb *redis.Client, roomID string
 			}
 		}
 	}
+	return nil
+}
+
+// 校验当前游戏状态是否允许发起或执行该投票，在原子操作中调用时读取操作中的数据
+func checkVoteAllowed(roomID, kind string) error {
+	roomInfo, err := GetRoomInfo(roomID)
+	if err != nil {
+		return fmt.Errorf("获取房间信息失败: %w", err)
+	}
+	ended := roomInfo.GameStatus == entities.RoomStatusEnd
+	switch kind {
+	case VoteKindRestart:
+		if roomInfo.GameStatus == entities.RoomStatusWaiting {
+			return fmt.Errorf("游戏尚未开始")
+		}
+		if ended {
+			return fmt.Errorf("游戏已结束，请发起再来一局")
+		}
+	case VoteKindRematch:
+		if !ended {
+			return fmt.Errorf("游戏尚未结束，无法再来一局")
+		}
+	default:
+		return fmt.Errorf("未知的投票类型: %s", kind)
+	}
+	return nil
 }
 
-func handleRestartGameMessage(conn ReadWriteConn, rdb *redis.Client, roomID string, playerID string, msgMap map[string]interface{}) {
+// 执行通过的投票：重开沿用本局起始玩家，再来一局轮换起始玩家（上一局在提交后归档，见 settleVote）
+func applyVote(roomID, kind string) error {
+	firstPlayer, err := GetFirstPlayer(stateRdb(roomID), repository.Ctx, roomID)
+	if err != nil {
+		return err
+	}
+	if kind == VoteKindRematch {
+		firstPlayer = nextSeatPlayer(roomID, firstPlayer)
+	}
+	return resetGameState(roomID, firstPlayer)
+}
+
+// 座位顺序中 playerID 的下一位，找不到时返回第一个座位
+func nextSeatPlayer(roomID, playerID string) string {
+	players := playersOf(roomID)
+	if len(players) == 0 {
+		return ""
+	}
+	for i, pc := range players {
+		if pc.PlayerID == playerID {
+			return players[(i+1)%len(players)].PlayerID
+		}
+	}
+	return players[0].PlayerID
+}
+
+// 保留座位，重新发牌并重置所有玩家数据，由 firstPlayer 开始新的一局
+func resetGameState(roomID, firstPlayer string) error {
+	// 新的一局，洗牌和之后的随机操作都由新的种子决定
+	if err := newGameSeed(roomID); err != nil {
+		return err
+	}
 	// 重置上次操作
-	if err := SetLastData(roomID, playerID, "", nil); err != nil {
-		log.Println("❌ 设置最后放置的 tile 失败:", err)
-		return
+	if err := SetLastData(roomID, firstPlayer, "", nil); err != nil {
+		return err
 	}
-	// 重置游戏状态
-	SetGameStatus(rdb, roomID, entities.RoomStatusPlaying)
 
-	for _, pc := range Rooms[roomID] {
-		err := InitPlayerDataToRedis(roomID, pc.PlayerID)
-		if err != nil {
-			log.Println("❌ 初始化玩家数据失败:", err)
-			return
+	for _, pc := range playersOf(roomID) {
+		if err := InitPlayerDataToRedis(roomID, pc.PlayerID); err != nil {
+			return fmt.Errorf("初始化玩家数据失败: %w", err)
 		}
 	}
 
-	err := InitRoomData(roomID)
-	if err != nil {
-		log.Println("❌ 初始化房间数据失败:", err)
-		return
+	if err := InitRoomData(roomID); err != nil {
+		return fmt.Errorf("初始化房间数据失败: %w", err)
 	}
 
-	time.Sleep(2 * time.Second)
-	BroadcastToRoom(roomID)
+	// 新的一局写入新的对局日志
+	startKey := fmt.Sprintf("room:%s:game_start_time", roomID)
+	repository.Rdb.Set(repository.Ctx, startKey, time.Now().Format("20060102_150405"), 0)
+
+	if err := SetFirstPlayer(stateRdb(roomID), repository.Ctx, roomID, firstPlayer); err != nil {
+		return err
+	}
+	if err := SetCurrentPlayer(stateRdb(roomID), repository.Ctx, roomID, firstPlayer); err != nil {
+		return err
+	}
+	// 重置游戏状态
+	return SetGameStatus(stateRdb(roomID), roomID, entities.RoomStatusPlaying)
 }
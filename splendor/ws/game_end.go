@@ -1,21 +1,168 @@
 package ws
 
 import (
+	"encoding/json"
+	"fmt"
 	"go-game/entities"
+	"go-game/repository"
 	"log"
+	"sort"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
-func handleGameEndMessage(conn ReadWriteConn, rdb *redis.Client, roomID string, playerID string, msgMap map[string]interface{}) {
-	err := SetGameStatus(rdb, roomID, entities.RoomStatusEnd)
+// 达到该分数后进入最后一轮
+const winningScore = 15
+
+// 对局结束后的排名
+type Standing struct {
+	Rank      int    `json:"rank"`
+	PlayerID  string `json:"playerID"`
+	Score     int    `json:"score"`
+	CardCount int    `json:"cardCount"` // 同分时购买卡牌少者优先
+}
+
+// 最后一轮结束后确认游戏结束，排名和对局结果在提交成功后由 recordGameEnd 写入
+func handleGameEndMessage(conn ReadWriteConn, rdb redis.Cmdable, roomID string, playerID string, msgMap map[string]interface{}) error {
+	if err := checkGameEnd(rdb, roomID); err != nil {
+		return err
+	}
+	if err := SetGameStatus(rdb, roomID, entities.RoomStatusEnd); err != nil {
+		return fmt.Errorf("设置游戏状态失败: %w", err)
+	}
+	return nil
+}
+
+// 校验是否满足结束条件：有玩家达到 15 分，且之后的最后一轮已轮回先手玩家
+func checkGameEnd(rdb redis.Cmdable, roomID string) error {
+	roomInfo, err := GetRoomInfo(roomID)
+	if err != nil {
+		return fmt.Errorf("获取房间信息失败: %w", err)
+	}
+	switch roomInfo.GameStatus {
+	case entities.RoomStatusEnd:
+		// 最后一轮结束时广播已经结束了游戏，重复的结束请求不再写入日志
+		return fmt.Errorf("游戏已结束")
+	case entities.RoomStatusLastTurn:
+		currentPlayer, err := GetCurrentPlayer(rdb, repository.Ctx, roomID)
+		if err != nil {
+			return fmt.Errorf("获取当前玩家失败: %w", err)
+		}
+		firstPlayer, err := GetFirstPlayer(rdb, repository.Ctx, roomID)
+		if err != nil {
+			return fmt.Errorf("获取先手玩家失败: %w", err)
+		}
+		if currentPlayer != firstPlayer {
+			return fmt.Errorf("最后一轮还没有结束")
+		}
+		return nil
+	default:
+		return fmt.Errorf("还没有玩家达到 %d 分，不能结束游戏", winningScore)
+	}
+}
+
+// 结束请求提交成功后记录排名和对局结果
+func recordGameEnd(h messageHandler) messageHandler {
+	return func(conn ReadWriteConn, rdb redis.Cmdable, roomID string, playerID string, msgMap map[string]interface{}) error {
+		if err := h(conn, rdb, roomID, playerID, msgMap); err != nil {
+			return err
+		}
+		logGameEnded(roomID)
+		log.Println("✅ 对局日志:", gameLogKey(gameLogID(roomID)))
+		return nil
+	}
+}
+
+// 超时没有打完的对局按当前局面结算并结束。返回对局是否开始过，开始过的对局由结束流程记录结果
+func callAbandonGame(roomID string, _ json.RawMessage) (interface{}, error) {
+	if !isGameStarted(roomID) {
+		return false, nil
+	}
+	if !isGameEnded(roomID) {
+		if err := SetGameStatus(stateRdb(roomID), roomID, entities.RoomStatusEnd); err != nil {
+			return nil, fmt.Errorf("设置游戏状态失败: %w", err)
+		}
+		logGameAbandoned(roomID)
+		BroadcastToRoom(roomID)
+	}
+	return true, nil
+}
+
+// 按分数计算当前排名
+func calcStandings(roomID string) ([]Standing, error) {
+	playerIDs, err := seatPlayerIDs(roomID)
 	if err != nil {
-		log.Println("Error setting game status:", err)
-		return
+		return nil, err
+	}
+	standings := make([]Standing, 0, len(playerIDs))
+	for _, playerID := range playerIDs {
+		score, err := GetPlayerScore(roomID, playerID)
+		if err != nil {
+			return nil, err
+		}
+		cards, err := GetPlayerNormalCard(roomID, playerID)
+		if err != nil {
+			return nil, err
+		}
+		standings = append(standings, Standing{
+			PlayerID:  playerID,
+			Score:     score,
+			CardCount: len(cards),
+		})
 	}
+	sort.SliceStable(standings, func(i, j int) bool {
+		if standings[i].Score != standings[j].Score {
+			return standings[i].Score > standings[j].Score
+		}
+		return standings[i].CardCount < standings[j].CardCount
+	})
+	for i := range standings {
+		standings[i].Rank = i + 1
+		if i > 0 && standings[i].Score == standings[i-1].Score && standings[i].CardCount == standings[i-1].CardCount {
+			standings[i].Rank = standings[i-1].Rank
+		}
+	}
+	return standings, nil
+}
+
+// 归档已结束的对局：日志已经在 Redis 中，这里把最终排名写入 game_logs:standings
+func archiveFinishedGame(roomID string) error {
+	gameID, data, err := finishedGameArchive(roomID)
+	if err != nil {
+		return err
+	}
+	return saveGameArchive(gameID, data)
+}
 
-	logPath := getGameLogFilePath(roomID)
-	log.Println("✅ 游戏日志保存于:", logPath)
+// 按房间当前数据生成对局的归档内容，返回对局 ID 和编码后的最终排名
+func finishedGameArchive(roomID string) (string, []byte, error) {
+	standings, err := calcStandings(roomID)
+	if err != nil {
+		return "", nil, err
+	}
+	gameID := gameLogID(roomID)
+	data, err := json.Marshal(map[string]interface{}{
+		"roomID":     roomID,
+		"archivedAt": time.Now().Format("2006-01-02 15:04:05"),
+		"log":        gameID,
+		"standings":  standings,
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("序列化排名失败: %w", err)
+	}
+	return gameID, data, nil
+}
+
+func saveGameArchive(gameID string, data []byte) error {
+	if err := repository.Rdb.HSet(repository.Ctx, archivedStandingsKey, gameID, data).Err(); err != nil {
+		return fmt.Errorf("写入排名失败: %w", err)
+	}
+	log.Println("✅ 对局已归档:", gameID)
+	return nil
+}
 
-	BroadcastToRoom(roomID)
+// 对局历史中记录的得分：声望分
+func standingScore(s Standing) int {
+	return s.Score
 }
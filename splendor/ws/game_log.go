@@ -0,0 +1,438 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-game/repository"
+	"log"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 对局日志：每局一个只追加的 Redis Stream（game_log:<房间>_<开局时间>），一条消息一条记录，record 字段为记录的 JSON。
+// 日志和对局数据一样放在 Redis 中，其他实例接管房间后接着同一个 Stream 写，回放和统计在任意实例上都能读到全部对局。
+//
+//	game_started     开局：随机种子、座位和完整的对局状态
+//	command          执行成功的玩家操作：操作内容、产生的领域事件（放置 tile、创建公司、购买卡牌等），以及对局状态的变化
+//	player_left      玩家中途离开
+//	player_replaced  离线玩家的座位由 AI 接管
+//	game_ended       对局结束：最终排名，房间回收时为中途结算（abandoned）
+//
+// 对局状态的变化以相对上一条记录的 JSON Patch 保存，从 game_started 的状态依次应用各条记录的 patch
+// 即可还原任意时刻的对局状态（见 rebuildGameState）。进程重启后的第一条记录保存完整状态，不依赖重启前的内存。
+// 发牌、洗牌等随机操作都由开局时生成的种子决定，同样的种子和操作顺序得到同样的结果。
+// 操作之外的记录和保存完整状态的记录同时保存对局数据 key 的原始内容，对局结束后从这些记录开始
+// 用同样的随机数重新执行每条操作，结果必须与日志一致（见 replayGameLog），规则实现中的不确定性会在这里暴露
+
+// 对局日志 Stream 的 key 前缀，后接对局 ID
+const gameLogKeyPrefix = "game_log:"
+
+// 已结束的对局 ID，ZSET，分数为结束时间（毫秒），回放列表和统计从这里查找对局
+const endedGamesKey = "game_logs:ended"
+
+// 归档的对局最终排名，Hash，对局 ID -> 排名（见 archiveFinishedGame）
+const archivedStandingsKey = "game_logs:standings"
+
+func gameLogKey(gameID string) string {
+	return gameLogKeyPrefix + gameID
+}
+
+const (
+	GameLogStarted  = "game_started"
+	GameLogCommand  = "command"
+	GameLogLeft     = "player_left"
+	GameLogReplaced = "player_replaced"
+	GameLogEnded    = "game_ended"
+)
+
+// GameLogRecord 对局日志中的一条记录
+type GameLogRecord struct {
+	Seq      int             `json:"seq"`
+	Type     string          `json:"type"`
+	Time     int64           `json:"time"`               // 毫秒时间戳
+	Version  int64           `json:"version"`            // 记录时的房间版本号
+	PlayerID string          `json:"playerID,omitempty"` // 执行操作或离开的玩家
+	Command  string          `json:"command,omitempty"`  // 操作的消息类型
+	Payload  json.RawMessage `json:"payload,omitempty"`
+	Seed     uint64          `json:"seed,omitempty"`
+	Players  []string        `json:"players,omitempty"` // 座位上的玩家，只在带原始数据的记录中
+	Events   []GameEvent     `json:"events,omitempty"`
+	State    json.RawMessage `json:"state,omitempty"` // 完整的对局状态
+	Keys     stateSnapshot   `json:"keys,omitempty"`  // 对局数据 key 的原始内容，回放从这里重新开始
+	Patch    []patchOp       `json:"patch,omitempty"` // 相对上一条记录的对局状态变化
+}
+
+// GameEvent 操作产生的领域事件
+type GameEvent struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// 事件数据中的 playerID，没有时为空
+func eventPlayerID(event GameEvent) string {
+	var data struct {
+		PlayerID string `json:"playerID"`
+	}
+	if err := json.Unmarshal(event.Data, &data); err != nil {
+		return ""
+	}
+	return data.PlayerID
+}
+
+// 房间正在写入的对局日志，挂在 Room 上
+type gameLogWriter struct {
+	mu     sync.Mutex
+	gameID string
+	seq    int
+	last   interface{} // 上一条记录之后的对局状态，nil 时下一条记录保存完整状态
+	events []GameEvent // 当前操作已产生、尚未写入的事件
+	ended  bool        // 已记录对局结束
+}
+
+func gameSeedKey(roomID string) string {
+	return fmt.Sprintf("room:%s:seed", roomID)
+}
+
+func gameRandKey(roomID string) string {
+	return fmt.Sprintf("room:%s:rng", roomID)
+}
+
+func botRandKey(roomID string) string {
+	return fmt.Sprintf("room:%s:bot_rng", roomID)
+}
+
+// 新的一局生成新的随机种子
+func newGameSeed(roomID string) error {
+	ctx := repository.Ctx
+	_, err := stateRdb(roomID).TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, gameSeedKey(roomID), strconv.FormatUint(rand.Uint64(), 10), 0)
+		pipe.Del(ctx, gameRandKey(roomID), botRandKey(roomID))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("生成随机种子失败: %w", err)
+	}
+	return nil
+}
+
+// SetGameSeed 指定房间第一局的随机种子（如每日挑战），需要在生成房间数据之前调用
+func SetGameSeed(roomID string, seed uint64) error {
+	if err := repository.Rdb.Set(repository.Ctx, gameSeedKey(roomID), strconv.FormatUint(seed, 10), 0).Err(); err != nil {
+		return fmt.Errorf("保存随机种子失败: %w", err)
+	}
+	return nil
+}
+
+// 本局的随机种子，还没有时生成
+func gameSeed(roomID string) (uint64, error) {
+	ctx := repository.Ctx
+	key := gameSeedKey(roomID)
+	rdb := stateRdb(roomID)
+	if _, err := rdb.SetNX(ctx, key, strconv.FormatUint(rand.Uint64(), 10), 0).Result(); err != nil {
+		return 0, fmt.Errorf("生成随机种子失败: %w", err)
+	}
+	seed, err := rdb.Get(ctx, key).Uint64()
+	if err != nil {
+		return 0, fmt.Errorf("获取随机种子失败: %w", err)
+	}
+	return seed, nil
+}
+
+// 本局的随机数生成器：由种子和本局第几次取随机数决定。计数在操作的事务中递增，随对局数据一起提交，失败的操作不影响之后的结果
+func gameRand(roomID string) (*rand.Rand, error) {
+	seed, err := gameSeed(roomID)
+	if err != nil {
+		return nil, err
+	}
+	n, err := stateRdb(roomID).Incr(repository.Ctx, gameRandKey(roomID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("获取随机数序号失败: %w", err)
+	}
+	return rand.New(rand.NewPCG(seed, uint64(n))), nil
+}
+
+// AI 决策用的随机数生成器：与 gameRand 同一个种子，计数单独保存。AI 在操作之外做决定，
+// 不能占用对局的计数，否则回放时之后的操作取到的随机数会不同；同样的种子下 AI 的选择仍然相同
+func botRand(roomID string) (*rand.Rand, error) {
+	seed, err := gameSeed(roomID)
+	if err != nil {
+		return nil, err
+	}
+	n, err := repository.Rdb.Incr(repository.Ctx, botRandKey(roomID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("获取随机数序号失败: %w", err)
+	}
+	return rand.New(rand.NewPCG(^seed, uint64(n))), nil
+}
+
+// 记录当前操作产生的领域事件，操作成功后随操作一起写入日志，失败时丢弃
+func recordGameEvent(roomID, eventType string, data interface{}) {
+	room := getRoom(roomID)
+	if room == nil {
+		return
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		log.Println("❌ 编码对局事件失败:", err)
+		return
+	}
+	room.gameLog.mu.Lock()
+	room.gameLog.events = append(room.gameLog.events, GameEvent{Type: eventType, Data: raw})
+	room.gameLog.mu.Unlock()
+}
+
+// 丢弃未写入的事件
+func discardGameEvents(roomID string) {
+	if room := getRoom(roomID); room != nil {
+		room.gameLog.mu.Lock()
+		room.gameLog.events = nil
+		room.gameLog.mu.Unlock()
+	}
+}
+
+// 开局：写入随机种子、座位和完整的对局状态
+func logGameStarted(roomID string) {
+	discardGameEvents(roomID)
+	seed, err := gameSeed(roomID)
+	if err != nil {
+		log.Println("❌ 写入对局日志失败:", err)
+		return
+	}
+	players, err := seatPlayerIDs(roomID)
+	if err != nil {
+		log.Println("❌ 写入对局日志失败:", err)
+		return
+	}
+	appendGameLog(roomID, GameLogRecord{Type: GameLogStarted, Seed: seed, Players: players})
+}
+
+// 玩家操作执行成功
+func logGameCommand(roomID, playerID string, msgMap map[string]interface{}) {
+	record := GameLogRecord{Type: GameLogCommand, PlayerID: playerID}
+	record.Command, _ = msgMap["type"].(string)
+	if payload, ok := msgMap["payload"]; ok {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			log.Println("❌ 编码操作内容失败:", err)
+		} else {
+			record.Payload = data
+		}
+	}
+	appendGameLog(roomID, record)
+}
+
+// 游戏进行中有玩家离开
+func logPlayerLeft(roomID, playerID, reason string) {
+	recordGameEvent(roomID, "player_left", map[string]string{"playerID": playerID, "reason": reason})
+	appendGameLog(roomID, GameLogRecord{Type: GameLogLeft, PlayerID: playerID})
+}
+
+// 离线玩家的座位由 AI 接管，玩家数据已迁移给 AI
+func logPlayerReplaced(roomID, playerID, aiID string) {
+	recordGameEvent(roomID, "player_replaced", map[string]string{"playerID": playerID, "aiID": aiID})
+	appendGameLog(roomID, GameLogRecord{Type: GameLogReplaced, PlayerID: playerID})
+}
+
+// 对局结束，记录最终排名
+func logGameEnded(roomID string) {
+	recordGameResult(roomID, false)
+}
+
+// 对局无人继续、房间被回收：按当前局面记录排名，不计入等级分
+func logGameAbandoned(roomID string) {
+	recordGameResult(roomID, true)
+}
+
+func recordGameResult(roomID string, abandoned bool) {
+	if isReplayRoom(roomID) {
+		return
+	}
+	standings, err := calcStandings(roomID)
+	if err != nil {
+		log.Println("❌ 计算排名失败:", err)
+		appendGameLog(roomID, GameLogRecord{Type: GameLogEnded})
+		return
+	}
+	recordGameEvent(roomID, "game_ended", map[string]interface{}{"standings": standings, "abandoned": abandoned})
+	appendGameLog(roomID, GameLogRecord{Type: GameLogEnded})
+	saveMatchResult(roomID, standings, abandoned)
+	verifyGameLog(gameLogID(roomID))
+}
+
+// 追加一条记录：带上当前操作的事件，以及对局状态相对上一条记录的变化
+func appendGameLog(roomID string, record GameLogRecord) {
+	room := getRoom(roomID)
+	if room == nil || isReplayRoom(roomID) {
+		return
+	}
+	w := &room.gameLog
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	events := w.events
+	w.events = nil
+
+	gameID := gameLogID(roomID)
+	if w.gameID != gameID {
+		// 新的一局，或进程重启、接管房间后第一次写入：接着日志中已有的记录编号
+		seq, ended, err := gameLogTail(gameID)
+		if err != nil {
+			log.Println("❌ 读取对局日志失败:", err)
+			return
+		}
+		w.gameID, w.seq, w.last, w.ended = gameID, seq, nil, ended
+	}
+	if record.Type == GameLogEnded && w.ended {
+		// 客户端在结束后仍可能发送 game_end，同一局只记录一次
+		return
+	}
+
+	doc, err := gameStateDoc(roomID)
+	if err != nil {
+		log.Println("❌ 读取对局状态失败:", err)
+		return
+	}
+	version, err := GetRoomVersion(roomID)
+	if err != nil {
+		log.Println("❌ 写入对局日志失败:", err)
+		return
+	}
+	record.Seq = w.seq
+	record.Time = time.Now().UnixMilli()
+	record.Version = version
+	record.Events = events
+	if w.last == nil || record.Type == GameLogStarted {
+		if record.State, err = json.Marshal(doc); err != nil {
+			log.Println("❌ 编码对局状态失败:", err)
+			return
+		}
+	} else {
+		record.Patch = diffJSON("", w.last, doc, nil)
+	}
+	if record.State != nil || record.Type != GameLogCommand {
+		// 座位或数据在操作之外发生了变化，回放从这里的原始数据重新开始
+		if record.Keys, err = snapshotState(roomID); err != nil {
+			log.Println("❌ 读取对局数据失败:", err)
+			return
+		}
+		if record.Players == nil {
+			if record.Players, err = seatPlayerIDs(roomID); err != nil {
+				log.Println("❌ 读取座位失败:", err)
+				return
+			}
+		}
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Println("❌ 编码对局日志失败:", err)
+		return
+	}
+	ctx := repository.Ctx
+	pipe := repository.Rdb.TxPipeline()
+	pipe.XAdd(ctx, &redis.XAddArgs{Stream: gameLogKey(gameID), Values: map[string]interface{}{"record": line}})
+	if record.Type == GameLogEnded {
+		pipe.ZAdd(ctx, endedGamesKey, &redis.Z{Score: float64(record.Time), Member: gameID})
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Println("❌ 写入对局日志失败:", err)
+		// 不确定是否已经写入，下一条记录保存完整状态
+		w.last = nil
+		return
+	}
+	w.seq++
+	w.last = doc
+	switch record.Type {
+	case GameLogStarted:
+		w.ended = false
+	case GameLogEnded:
+		w.ended = true
+	}
+}
+
+// 日志中已有的记录数，以及最后一条是否为对局结束，日志不存在时为 0
+func gameLogTail(gameID string) (int, bool, error) {
+	ctx := repository.Ctx
+	pipe := repository.Rdb.Pipeline()
+	countCmd := pipe.XLen(ctx, gameLogKey(gameID))
+	lastCmd := pipe.XRevRangeN(ctx, gameLogKey(gameID), "+", "-", 1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, false, err
+	}
+	ended := false
+	if msgs := lastCmd.Val(); len(msgs) > 0 {
+		record, err := parseGameLogMessage(msgs[0])
+		if err != nil {
+			return 0, false, err
+		}
+		ended = record.Type == GameLogEnded
+	}
+	return int(countCmd.Val()), ended, nil
+}
+
+// 读取对局日志的全部记录，日志不存在时返回空列表
+func readGameLog(gameID string) ([]GameLogRecord, error) {
+	msgs, err := repository.Rdb.XRange(repository.Ctx, gameLogKey(gameID), "-", "+").Result()
+	if err != nil {
+		return nil, fmt.Errorf("读取对局日志失败: %w", err)
+	}
+	records := make([]GameLogRecord, 0, len(msgs))
+	for _, msg := range msgs {
+		record, err := parseGameLogMessage(msg)
+		if err != nil {
+			return nil, fmt.Errorf("解析对局日志第 %d 条记录失败: %w", len(records)+1, err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func parseGameLogMessage(msg redis.XMessage) (GameLogRecord, error) {
+	var record GameLogRecord
+	data, ok := msg.Values["record"].(string)
+	if !ok {
+		return record, fmt.Errorf("消息 %s 没有 record 字段", msg.ID)
+	}
+	err := json.Unmarshal([]byte(data), &record)
+	return record, err
+}
+
+// 依次应用记录中的完整状态和 patch，还原 seq 为 upTo 的记录之后的对局状态，upTo < 0 时还原到最后一条记录
+func rebuildGameState(records []GameLogRecord, upTo int) (interface{}, error) {
+	var doc interface{}
+	for _, record := range records {
+		if upTo >= 0 && record.Seq > upTo {
+			break
+		}
+		var err error
+		if doc, err = applyGameRecord(doc, record); err != nil {
+			return nil, err
+		}
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("对局日志中没有完整的对局状态")
+	}
+	return doc, nil
+}
+
+// 在 doc 上应用一条记录：带完整状态的记录直接替换，否则应用 patch（会修改 doc）
+func applyGameRecord(doc interface{}, record GameLogRecord) (interface{}, error) {
+	if record.State != nil {
+		var state interface{}
+		if err := json.Unmarshal(record.State, &state); err != nil {
+			return nil, fmt.Errorf("解析第 %d 条记录的对局状态失败: %w", record.Seq, err)
+		}
+		return state, nil
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("第 %d 条记录之前没有完整的对局状态", record.Seq)
+	}
+	doc, err := applyPatch(doc, record.Patch)
+	if err != nil {
+		return nil, fmt.Errorf("应用第 %d 条记录失败: %w", record.Seq, err)
+	}
+	return doc, nil
+}
@@ -7,16 +7,15 @@ import (
 	"go-game/entities"
 	"go-game/repository"
 	"log"
-
-	"golang.org/x/exp/rand"
+	"math/rand/v2"
 )
 
-func GetRandomNobles(max int) []entities.NobleCard {
+func GetRandomNobles(r *rand.Rand, max int) []entities.NobleCard {
 	nobleList := make([]entities.NobleCard, len(const_data.NobleTilesList))
 	copy(nobleList, const_data.NobleTilesList)
 
 	// 打乱
-	rand.Shuffle(len(nobleList), func(i, j int) {
+	r.Shuffle(len(nobleList), func(i, j int) {
 		nobleList[i], nobleList[j] = nobleList[j], nobleList[i]
 	})
 
@@ -34,12 +33,17 @@ func InitRoomData(roomID string) error {
 	if err != nil {
 		return fmt.Errorf("获取房间信息失败: %w", err)
 	}
+	// 洗牌和之后的随机操作都由本局的种子决定，新房间第一局的种子可以由 SetGameSeed 指定
+	r, err := gameRand(roomID)
+	if err != nil {
+		return err
+	}
 	// 初始化卡牌信息
 	cardKey := fmt.Sprintf("room:%s:card", roomID)
-	pipe := repository.Rdb.Pipeline()
+	pipe := stateRdb(roomID).Pipeline()
 
 	for _, cards := range const_data.SplendorCards {
-		shuffled := rand.Perm(len(cards))
+		shuffled := r.Perm(len(cards))
 		for idx, rnd := range shuffled {
 			card := cards[rnd]
 			if idx < 4 {
@@ -71,14 +75,14 @@ func InitRoomData(roomID string) error {
 	}
 	gemKey := fmt.Sprintf("room:%s:gems", roomID)
 	for color, cnt := range gemCounts {
-		if _, err := repository.Rdb.HSet(repository.Ctx, gemKey, color, cnt).Result(); err != nil {
+		if _, err := stateRdb(roomID).HSet(repository.Ctx, gemKey, color, cnt).Result(); err != nil {
 			return fmt.Errorf("初始化宝石[%s]失败: %w", color, err)
 		}
 	}
 	noblesKey := fmt.Sprintf("room:%s:nobles", roomID)
-	randomNobles := GetRandomNobles(roomInfo.MaxPlayers + 1)
+	randomNobles := GetRandomNobles(r, roomInfo.MaxPlayers+1)
 
-	pipe = repository.Rdb.Pipeline()
+	pipe = stateRdb(roomID).Pipeline()
 	for _, noble := range randomNobles {
 		nobleJSON, err := json.Marshal(noble)
 		if err != nil {
@@ -0,0 +1,168 @@
+package ws
+
+import (
+	"encoding/json"
+	"go-game/dto"
+	"go-game/middleware"
+	"log"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// 大厅连接：/lobby/ws?token=...，需要登录，推送匹配结果、房间列表变化等不属于某个房间的消息。
+// 玩家可能连在任意实例上，消息通过集群广播发给所有实例，由连接所在实例投递。
+// 客户端发送 subscribe_rooms（payload 为可选的筛选条件）订阅房间列表，unsubscribe_rooms 取消
+
+// MatchFoundMessage 匹配成功，客户端随后用房间 ID 连接 /ws 加入对局
+type MatchFoundMessage struct {
+	Type    string   `json:"type"`
+	RoomID  string   `json:"roomID"`
+	Players []string `json:"players"` // 匹配到的真人玩家
+	Bots    int      `json:"bots"`    // 等待超时后补充的 AI 数量
+}
+
+// TournamentTableMessage 锦标赛新一轮的座位，客户端随后用房间 ID 连接 /ws 入座
+type TournamentTableMessage struct {
+	Type         string `json:"type"`
+	TournamentID string `json:"tournamentID"`
+	Round        int    `json:"round"`
+	Table        int    `json:"table"`
+	RoomID       string `json:"roomID"`
+}
+
+// 一个大厅连接
+type lobbyConn struct {
+	conn    *Client
+	filter  *dto.RoomFilter // 订阅房间列表时的筛选条件，未订阅时为 nil
+	visible map[string]bool // 已推送给客户端、符合筛选条件的房间
+}
+
+func (lc *lobbyConn) write(data []byte) {
+	if err := lc.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		log.Println("❌ 推送大厅消息失败:", err)
+	}
+}
+
+var (
+	lobbyClients   = make(map[string]map[*Client]*lobbyConn) // userID -> 连接，同一玩家可以开多个页面
+	lobbyClientsMu sync.RWMutex
+)
+
+// HandleLobbyWebSocket 大厅连接
+func HandleLobbyWebSocket(c *gin.Context) {
+	conn, err := upgradeConnection(c)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// 匹配结果、锦标赛分桌等推送只发给登录用户本人
+	userID := middleware.CurrentUser(c)
+	if queryID := c.Query("userID"); queryID != "" && queryID != userID {
+		sendErrorMessage(conn, "userID 与登录用户不一致")
+		return
+	}
+	if _, ok := acceptProtocol(conn, c.Query("protocol")); !ok {
+		return
+	}
+	lc := registerLobbyClient(userID, conn)
+	defer unregisterLobbyClient(userID, conn)
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var env Envelope
+		if err := json.Unmarshal(data, &env); err != nil || env.Type == "" {
+			sendReply(conn, "", env, commandError(CodeBadRequest, "消息格式错误"))
+			continue
+		}
+		sendReply(conn, "", env, handleLobbyMessage(lc, env))
+	}
+}
+
+func handleLobbyMessage(lc *lobbyConn, env Envelope) error {
+	switch env.Type {
+	case "subscribe_rooms":
+		var filter dto.RoomFilter
+		if len(env.Payload) > 0 {
+			if err := json.Unmarshal(env.Payload, &filter); err != nil {
+				return commandError(CodeBadPayload, "payload 格式错误: %v", err)
+			}
+		}
+		if !filter.Valid() {
+			return commandError(CodeBadPayload, "筛选条件无效")
+		}
+		return subscribeRooms(lc, filter)
+	case "unsubscribe_rooms":
+		unsubscribeRooms(lc)
+		return nil
+	}
+	return commandError(CodeUnknownType, "未知的消息类型: %s", env.Type)
+}
+
+func registerLobbyClient(userID string, conn *Client) *lobbyConn {
+	lobbyClientsMu.Lock()
+	defer lobbyClientsMu.Unlock()
+	if lobbyClients[userID] == nil {
+		lobbyClients[userID] = make(map[*Client]*lobbyConn)
+	}
+	lc := &lobbyConn{conn: conn}
+	lobbyClients[userID][conn] = lc
+	return lc
+}
+
+func unregisterLobbyClient(userID string, conn *Client) {
+	lobbyClientsMu.Lock()
+	defer lobbyClientsMu.Unlock()
+	delete(lobbyClients[userID], conn)
+	if len(lobbyClients[userID]) == 0 {
+		delete(lobbyClients, userID)
+	}
+}
+
+func lobbyClientsOf(userID string) []*lobbyConn {
+	lobbyClientsMu.RLock()
+	defer lobbyClientsMu.RUnlock()
+	list := make([]*lobbyConn, 0, len(lobbyClients[userID]))
+	for _, lc := range lobbyClients[userID] {
+		list = append(list, lc)
+	}
+	return list
+}
+
+// NotifyLobby 推送消息给玩家的大厅连接，玩家连在其他实例上时经集群广播转发
+func NotifyLobby(userID string, msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	broadcastToInstances(clusterEnvelope{
+		Kind:     clusterKindLobby,
+		PlayerID: userID,
+		Data:     string(data),
+	})
+	return nil
+}
+
+// 投递给连在当前实例上的大厅连接
+func deliverLobby(userID string, data []byte) {
+	for _, lc := range lobbyClientsOf(userID) {
+		lc.write(data)
+	}
+}
+
+// 停机时断开所有大厅连接
+func closeLobbyClients(data []byte) {
+	lobbyClientsMu.RLock()
+	defer lobbyClientsMu.RUnlock()
+	for _, conns := range lobbyClients {
+		for conn := range conns {
+			conn.WriteMessage(websocket.TextMessage, data)
+			conn.closeWith(websocket.CloseServiceRestart, "server restart")
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package ws
+
+import (
+	"encoding/json"
+	"go-game/dto"
+	"go-game/entities"
+	"reflect"
+	"strconv"
+)
+
+const gameName = "splendor"
+
+// GameName 游戏类型，匹配队列据此校验请求
+const GameName = gameName
+
+// 每局的人数范围
+const (
+	MinPlayers = 2
+	MaxPlayers = 4
+)
+
+// 客户端各类消息的 payload。分发时按消息类型解码，格式不对直接回复 bad_payload，不会进入处理函数
+
+// BuyCardPayload buy_card：要购买的卡牌 ID（翻开的或自己预留的）
+type BuyCardPayload int
+
+// GetGemPayload get_gem：宝石颜色 -> 拿取数量
+type GetGemPayload map[string]int
+
+// PreserveCardPayload preserve_card：翻开的卡牌 ID，或 {"level": 2} 从该等级牌堆盲抽
+type PreserveCardPayload struct {
+	CardID int `json:"cardID"`
+	Level  int `json:"level"` // 大于 0 时为盲抽
+}
+
+func (p *PreserveCardPayload) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &p.CardID); err == nil {
+		return nil
+	}
+	type plain PreserveCardPayload
+	return json.Unmarshal(data, (*plain)(p))
+}
+
+func (PreserveCardPayload) jsonSchema() map[string]interface{} {
+	type plain PreserveCardPayload
+	return map[string]interface{}{
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": "integer"},
+			schemaOf(typeOf[plain](), false),
+		},
+	}
+}
+
+// PlayAudioPayload play_audio：音效类型
+type PlayAudioPayload string
+
+// VotePayload vote：是否同意
+type VotePayload bool
+
+// KickPlayerPayload kick_player：要踢出的玩家 ID
+type KickPlayerPayload string
+
+// ChatPayload chat：聊天内容
+type ChatPayload string
+
+// MutePayload mute_player / unmute_player：玩家 ID，或 {"playerID": "...", "seconds": 60}
+type MutePayload struct {
+	PlayerID string `json:"playerID"`
+	Seconds  int    `json:"seconds"`
+}
+
+func (p *MutePayload) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &p.PlayerID); err == nil {
+		return nil
+	}
+	var v struct {
+		PlayerID string      `json:"playerID"`
+		Seconds  json.Number `json:"seconds"` // 兼容字符串形式的秒数
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	p.PlayerID = v.PlayerID
+	if v.Seconds != "" {
+		seconds, err := strconv.Atoi(v.Seconds.String())
+		if err != nil {
+			return err
+		}
+		p.Seconds = seconds
+	}
+	return nil
+}
+
+func (MutePayload) jsonSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": "string"},
+			map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"playerID": map[string]interface{}{"type": "string"},
+					"seconds":  map[string]interface{}{"type": []string{"integer", "string"}},
+				},
+			},
+		},
+	}
+}
+
+// AISeatPayload add_ai / remove_ai / replace_with_ai：玩家 ID，或 {"playerID": "...", "difficulty": "hard"}
+type AISeatPayload struct {
+	PlayerID   string `json:"playerID"`
+	Difficulty string `json:"difficulty"`
+}
+
+func (p *AISeatPayload) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &p.PlayerID); err == nil {
+		return nil
+	}
+	type plain AISeatPayload
+	return json.Unmarshal(data, (*plain)(p))
+}
+
+func (AISeatPayload) jsonSchema() map[string]interface{} {
+	type plain AISeatPayload
+	return map[string]interface{}{
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": "string"},
+			schemaOf(typeOf[plain](), false),
+		},
+	}
+}
+
+// 消息类型 -> payload 类型，没有 payload 的消息为 nil
+var messagePayloads = map[string]*payloadSpec{
+	"ready":           nil,
+	"get_gem":         payloadAs[GetGemPayload](),
+	"buy_card":        payloadAs[BuyCardPayload](),
+	"preserve_card":   payloadAs[PreserveCardPayload](),
+	"game_end":        nil,
+	"play_audio":      payloadAs[PlayAudioPayload](),
+	"restart_game":    nil,
+	"rematch":         nil,
+	"vote":            payloadAs[VotePayload](),
+	"add_ai":          optionalPayloadAs[AISeatPayload](),
+	"remove_ai":       payloadAs[AISeatPayload](),
+	"replace_with_ai": payloadAs[AISeatPayload](),
+	"leave_room":      nil,
+	"forfeit":         nil,
+	"kick_player":     payloadAs[KickPlayerPayload](),
+	"chat":            payloadAs[ChatPayload](),
+	"mute_player":     payloadAs[MutePayload](),
+	"unmute_player":   payloadAs[MutePayload](),
+	"resync":          nil,
+}
+
+// SyncMessage sync：玩家视角的完整同步数据，其他玩家盲抽预留的卡牌只显示等级
+type SyncMessage struct {
+	Type       string                            `json:"type"`
+	Seq        int64                             `json:"seq,omitempty"` // 同步流序号，由 sendSync 填写
+	PlayerID   string                            `json:"playerId"`
+	PlayerData map[string]dto.SplendorPlayerData `json:"playerData"`
+	RoomData   SyncRoomData                      `json:"roomData"`
+}
+
+// SyncRoomData 房间内所有人（包括观战者）都能看到的数据
+type SyncRoomData struct {
+	Card          map[int][]entities.NormalCard `json:"card"` // 等级 -> 翻开的卡牌
+	Gems          map[string]int                `json:"gems"`
+	Nobles        []entities.NobleCard          `json:"nobles"`
+	RoomInfo      *entities.RoomInfo            `json:"roomInfo"`
+	CurrentPlayer string                        `json:"currentPlayer"`
+	LastData      *LastAction                   `json:"lastData"`
+	Version       int64                         `json:"version"` // 客户端操作时带上，用于拒绝过期操作
+}
+
+// SpectatorSyncMessage sync：观战者看到的同步数据，盲抽预留的卡牌只显示等级
+type SpectatorSyncMessage struct {
+	Type       string                            `json:"type"`
+	Role       string                            `json:"role"`
+	PlayerData map[string]dto.SplendorPlayerData `json:"playerData"`
+	RoomData   SyncRoomData                      `json:"roomData"`
+}
+
+// 本游戏特有的服务端消息，通用消息见 protocol.go
+var gameOutboundMessages = map[string][]reflect.Type{
+	"sync": {typeOf[SyncMessage](), typeOf[SpectatorSyncMessage]()},
+}
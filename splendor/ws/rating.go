@@ -0,0 +1,123 @@
+package ws
+
+import (
+	"go-game/repository"
+	"math"
+	"time"
+)
+
+// 等级分：多人 Elo，每局按名次把每名玩家与其他每名玩家各算一次胜负，K 按对手数平分。
+// AI 按难度使用固定的等级分参与计算，自身不更新；没有真人玩家的对局不计分
+
+const (
+	InitialRating = 1500
+	ratingK       = 32
+)
+
+// AI 各难度的固定等级分
+var botRatings = map[string]int{
+	AIDifficultyEasy:   1200,
+	AIDifficultyNormal: 1500,
+	AIDifficultyHard:   1800,
+}
+
+// 对局是否计入等级分：至少两名玩家，且至少一名真人
+func isRatedMatch(match *repository.Match) bool {
+	if len(match.Players) < 2 {
+		return false
+	}
+	for _, p := range match.Players {
+		if !p.AI {
+			return true
+		}
+	}
+	return false
+}
+
+// 对局前的等级分：AI 使用固定分，已在 match 中填好
+func ratingBefore(p repository.MatchPlayer, current map[string]repository.Rating) int {
+	if p.AI {
+		return p.RatingBefore
+	}
+	if r, ok := current[p.PlayerID]; ok {
+		return r.Rating
+	}
+	return InitialRating
+}
+
+// 两名玩家按名次的实际得分：名次靠前得 1，同名次得 0.5
+func pairScore(a, b repository.MatchPlayer) float64 {
+	switch {
+	case a.Rank < b.Rank:
+		return 1
+	case a.Rank == b.Rank:
+		return 0.5
+	}
+	return 0
+}
+
+// 计算真人玩家的新等级分，实现 repository.RatingFunc
+func rateMatch(match *repository.Match, current map[string]repository.Rating) []repository.Rating {
+	n := len(match.Players)
+	before := make([]int, n)
+	for i, p := range match.Players {
+		before[i] = ratingBefore(p, current)
+	}
+	now := time.Now().UnixMilli()
+	k := float64(ratingK) / float64(n-1)
+	updated := make([]repository.Rating, 0, n)
+	for i := range match.Players {
+		p := &match.Players[i]
+		p.RatingBefore = before[i]
+		if p.AI {
+			p.RatingAfter = before[i]
+			continue
+		}
+		var delta float64
+		for j := range match.Players {
+			if i == j {
+				continue
+			}
+			expected := 1 / (1 + math.Pow(10, float64(before[j]-before[i])/400))
+			delta += k * (pairScore(*p, match.Players[j]) - expected)
+		}
+		p.RatingAfter = before[i] + int(math.Round(delta))
+
+		r := current[p.PlayerID]
+		r.PlayerID = p.PlayerID
+		r.Rating = p.RatingAfter
+		r.Games++
+		if p.Rank == 1 && !p.Left {
+			r.Wins++
+		}
+		r.UpdatedAt = now
+		updated = append(updated, r)
+	}
+	return updated
+}
+
+// SeatRatings 房间内玩家当前的等级分，真人没有记录时为初始分，AI 为对应难度的固定分
+func SeatRatings(roomID string, playerIDs []string) (map[string]int, error) {
+	humans := make([]string, 0, len(playerIDs))
+	for _, id := range playerIDs {
+		if !IsAIPlayer(id) {
+			humans = append(humans, id)
+		}
+	}
+	stored, err := repository.Matches.Ratings(repository.Ctx, humans)
+	if err != nil {
+		return nil, err
+	}
+	ratings := make(map[string]int, len(playerIDs))
+	for _, id := range playerIDs {
+		switch r, ok := stored[id]; {
+		case IsAIPlayer(id):
+			ratings[id] = botRating(roomID, id)
+		case ok:
+			ratings[id] = r.Rating
+		default:
+			ratings[id] = InitialRating
+		}
+	}
+	return ratings, nil
+}
@@ -0,0 +1,119 @@
+package ws
+
+import (
+	"go-game/repository"
+	"reflect"
+	"testing"
+)
+
+func TestIsRatedMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		players []repository.MatchPlayer
+		want    bool
+	}{
+		{"只有一名玩家", []repository.MatchPlayer{{PlayerID: "u1"}}, false},
+		{"两名真人", []repository.MatchPlayer{{PlayerID: "u1"}, {PlayerID: "u2"}}, true},
+		{"真人对 AI", []repository.MatchPlayer{{PlayerID: "u1"}, {PlayerID: "ai_1", AI: true}}, true},
+		{"全是 AI", []repository.MatchPlayer{{PlayerID: "ai_1", AI: true}, {PlayerID: "ai_2", AI: true}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRatedMatch(&repository.Match{Players: tt.players}); got != tt.want {
+				t.Fatalf("isRatedMatch = %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		players []repository.MatchPlayer
+		current map[string]repository.Rating
+		after   []int // 各座位对局后的等级分
+		want    []repository.Rating
+	}{
+		{
+			name:    "两名新玩家",
+			players: []repository.MatchPlayer{{PlayerID: "u1", Rank: 1}, {PlayerID: "u2", Rank: 2}},
+			after:   []int{1516, 1484},
+			want: []repository.Rating{
+				{PlayerID: "u1", Rating: 1516, Games: 1, Wins: 1},
+				{PlayerID: "u2", Rating: 1484, Games: 1},
+			},
+		},
+		{
+			name: "三人局 K 按对手数平分",
+			players: []repository.MatchPlayer{
+				{PlayerID: "u1", Rank: 2}, {PlayerID: "u2", Rank: 1}, {PlayerID: "u3", Rank: 3},
+			},
+			after: []int{1500, 1516, 1484},
+			want: []repository.Rating{
+				{PlayerID: "u1", Rating: 1500, Games: 1},
+				{PlayerID: "u2", Rating: 1516, Games: 1, Wins: 1},
+				{PlayerID: "u3", Rating: 1484, Games: 1},
+			},
+		},
+		{
+			name:    "同名次算平局，都算胜场",
+			players: []repository.MatchPlayer{{PlayerID: "u1", Rank: 1}, {PlayerID: "u2", Rank: 1}},
+			after:   []int{1500, 1500},
+			want: []repository.Rating{
+				{PlayerID: "u1", Rating: 1500, Games: 1, Wins: 1},
+				{PlayerID: "u2", Rating: 1500, Games: 1, Wins: 1},
+			},
+		},
+		{
+			name:    "在已有等级分上累计",
+			players: []repository.MatchPlayer{{PlayerID: "u1", Rank: 2}, {PlayerID: "u2", Rank: 1}},
+			current: map[string]repository.Rating{"u1": {PlayerID: "u1", Rating: 1600, Games: 10, Wins: 4}},
+			after:   []int{1580, 1520},
+			want: []repository.Rating{
+				{PlayerID: "u1", Rating: 1580, Games: 11, Wins: 4},
+				{PlayerID: "u2", Rating: 1520, Games: 1, Wins: 1},
+			},
+		},
+		{
+			name: "AI 使用固定分且不更新",
+			players: []repository.MatchPlayer{
+				{PlayerID: "u1", Rank: 1}, {PlayerID: "ai_1", Rank: 2, AI: true, RatingBefore: 1800},
+			},
+			after: []int{1527, 1800},
+			want:  []repository.Rating{{PlayerID: "u1", Rating: 1527, Games: 1, Wins: 1}},
+		},
+		{
+			name:    "中途离开不算胜场",
+			players: []repository.MatchPlayer{{PlayerID: "u1", Rank: 1, Left: true}, {PlayerID: "u2", Rank: 1, Left: true}},
+			after:   []int{1500, 1500},
+			want: []repository.Rating{
+				{PlayerID: "u1", Rating: 1500, Games: 1},
+				{PlayerID: "u2", Rating: 1500, Games: 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match := &repository.Match{Players: tt.players}
+			current := tt.current
+			if current == nil {
+				current = map[string]repository.Rating{}
+			}
+			got := rateMatch(match, current)
+			for i := range got {
+				if got[i].UpdatedAt == 0 {
+					t.Fatalf("%s 没有更新时间", got[i].PlayerID)
+				}
+				got[i].UpdatedAt = 0
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("rateMatch = %+v，期望 %+v", got, tt.want)
+			}
+			for i, p := range match.Players {
+				if p.RatingAfter != tt.after[i] {
+					t.Fatalf("%s 对局后 %d，期望 %d", p.PlayerID, p.RatingAfter, tt.after[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,523 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-game/middleware"
+	"go-game/repository"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// 对局回放：已结束对局的日志（见 game_log.go）可以通过 REST 接口查询，也可以连接回放 websocket
+// 按步骤播放。回放发送的是普通的 sync 消息，前端按观战（或指定玩家视角）渲染即可，另外发送：
+//
+//	replay_info   连接后发送一次：步骤数、各回合开始的步骤、玩家和最终排名
+//	replay_state  每次切换步骤前发送：当前步骤、回合、是否在播放，以及这一步的操作和事件
+//
+// 客户端可以发送 replay_play（payload 可带 {"intervalMs": 1000}）、replay_pause
+// 和 replay_seek（payload 为 {"step": n} 或 {"turn": n}）控制播放
+
+const (
+	defaultReplayInterval = time.Second
+	minReplayInterval     = 100 * time.Millisecond
+)
+
+// 对局 ID：<房间>_<开局时间>，见 gameLogID
+var gameIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ReplaySummary 已结束对局的概要
+type ReplaySummary struct {
+	GameID    string          `json:"gameID"`
+	RoomID    string          `json:"roomID"`
+	StartedAt int64           `json:"startedAt"` // 毫秒时间戳
+	EndedAt   int64           `json:"endedAt"`
+	Players   []string        `json:"players"`
+	Steps     int             `json:"steps"` // 日志记录数
+	Standings json.RawMessage `json:"standings,omitempty"`
+}
+
+// ReplayInfoMessage 回放连接建立后发送的概要
+type ReplayInfoMessage struct {
+	Type  string        `json:"type"`
+	Game  ReplaySummary `json:"game"`
+	Turns []int         `json:"turns"` // 第 n 个回合开始时的步骤
+}
+
+// ReplayStateMessage 回放切换到某一步
+type ReplayStateMessage struct {
+	Type     string      `json:"type"`
+	Step     int         `json:"step"`
+	Turn     int         `json:"turn"`
+	Playing  bool        `json:"playing"`
+	Record   string      `json:"record"` // 记录类型，见 game_log.go
+	PlayerID string      `json:"playerID,omitempty"`
+	Command  string      `json:"command,omitempty"`
+	Events   []GameEvent `json:"events,omitempty"`
+}
+
+// ReplayPlayPayload replay_play：自动播放，每步间隔 intervalMs 毫秒
+type ReplayPlayPayload struct {
+	IntervalMs int `json:"intervalMs,omitempty"`
+}
+
+// ReplaySeekPayload replay_seek：跳到指定步骤或回合
+type ReplaySeekPayload struct {
+	Step *int `json:"step,omitempty"`
+	Turn *int `json:"turn,omitempty"`
+}
+
+// 由日志生成对局概要，对局还没有结束时返回 false
+func summarizeGameLog(gameID string, records []GameLogRecord) (ReplaySummary, bool) {
+	if len(records) == 0 || records[len(records)-1].Type != GameLogEnded {
+		return ReplaySummary{}, false
+	}
+	summary := ReplaySummary{
+		GameID:    gameID,
+		StartedAt: records[0].Time,
+		EndedAt:   records[len(records)-1].Time,
+		Steps:     len(records),
+	}
+	// 对局 ID 中的开局时间形如 20060102_150405
+	if len(gameID) > 16 {
+		summary.RoomID = gameID[:len(gameID)-16]
+	}
+	for _, record := range records {
+		if record.Type == GameLogStarted {
+			summary.Players = record.Players
+		}
+	}
+	for _, event := range records[len(records)-1].Events {
+		if event.Type == "game_ended" {
+			var data struct {
+				Standings json.RawMessage `json:"standings"`
+			}
+			if err := json.Unmarshal(event.Data, &data); err == nil {
+				summary.Standings = data.Standings
+			}
+		}
+	}
+	return summary, true
+}
+
+// 已结束的对局：概要和各玩家的统计（见 stats.go）
+type finishedGame struct {
+	Summary ReplaySummary
+	Stats   map[string]*playerGameStats
+}
+
+// 对局索引中的一局，日志的记录数不变时不再重新解析
+type gameIndexEntry struct {
+	steps int64
+	game  *finishedGame // 还没有结束或无法解析的对局为 nil
+}
+
+// 已结束对局的索引：对局 ID -> 解析结果。列表和统计接口只解析新增或有变化的日志
+var (
+	gameIndex   = make(map[string]gameIndexEntry)
+	gameIndexMu sync.Mutex
+)
+
+// 暂不公开的房间（如当天的每日挑战，日志中有种子和全部发牌），ZSET，分数为公开时间（毫秒）
+const hiddenReplaysKey = "replay:hidden"
+
+// HideReplays 房间的对局在 until 之前不出现在回放列表、回放接口和统计中
+func HideReplays(roomID string, until time.Time) error {
+	err := repository.Rdb.ZAdd(repository.Ctx, hiddenReplaysKey, &redis.Z{Score: float64(until.UnixMilli()), Member: roomID}).Err()
+	if err != nil {
+		return fmt.Errorf("保存回放公开时间失败: %w", err)
+	}
+	return nil
+}
+
+// 当前暂不公开的房间，顺便清理已到公开时间的
+func hiddenReplayRooms() (map[string]bool, error) {
+	ctx := repository.Ctx
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	pipe := repository.Rdb.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, hiddenReplaysKey, "-inf", now)
+	roomsCmd := pipe.ZRange(ctx, hiddenReplaysKey, 0, -1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("读取暂不公开的回放失败: %w", err)
+	}
+	hidden := make(map[string]bool, len(roomsCmd.Val()))
+	for _, roomID := range roomsCmd.Val() {
+		hidden[roomID] = true
+	}
+	return hidden, nil
+}
+
+// 房间的对局是否暂不公开
+func replayHidden(roomID string) (bool, error) {
+	until, err := repository.Rdb.ZScore(repository.Ctx, hiddenReplaysKey, roomID).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("读取回放公开时间失败: %w", err)
+	}
+	return int64(until) > time.Now().UnixMilli(), nil
+}
+
+// 依次处理所有已结束且已公开的对局
+func forEachFinishedGame(fn func(game *finishedGame)) error {
+	games, err := finishedGames()
+	if err != nil {
+		return err
+	}
+	hidden, err := hiddenReplayRooms()
+	if err != nil {
+		return err
+	}
+	for _, game := range games {
+		if hidden[game.Summary.RoomID] {
+			continue
+		}
+		fn(game)
+	}
+	return nil
+}
+
+// 按已结束对局的列表更新索引，返回所有已结束的对局，无法解析的日志跳过
+func finishedGames() ([]*finishedGame, error) {
+	ctx := repository.Ctx
+	gameIDs, err := repository.Rdb.ZRange(ctx, endedGamesKey, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("读取已结束的对局失败: %w", err)
+	}
+	pipe := repository.Rdb.Pipeline()
+	steps := make([]*redis.IntCmd, len(gameIDs))
+	for i, gameID := range gameIDs {
+		steps[i] = pipe.XLen(ctx, gameLogKey(gameID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("读取对局日志失败: %w", err)
+	}
+
+	gameIndexMu.Lock()
+	defer gameIndexMu.Unlock()
+	games := make([]*finishedGame, 0, len(gameIDs))
+	seen := make(map[string]bool, len(gameIDs))
+	for i, gameID := range gameIDs {
+		seen[gameID] = true
+		entry, ok := gameIndex[gameID]
+		if !ok || entry.steps != steps[i].Val() {
+			entry = indexGameLog(gameID, steps[i].Val())
+			gameIndex[gameID] = entry
+		}
+		if entry.game != nil {
+			games = append(games, entry.game)
+		}
+	}
+	// 已删除的对局移出索引
+	for gameID := range gameIndex {
+		if !seen[gameID] {
+			delete(gameIndex, gameID)
+		}
+	}
+	return games, nil
+}
+
+// 解析一局的日志
+func indexGameLog(gameID string, steps int64) gameIndexEntry {
+	entry := gameIndexEntry{steps: steps}
+	records, err := readGameLog(gameID)
+	if err != nil {
+		log.Printf("❌ 读取对局日志 %s 失败: %v\n", gameID, err)
+		return entry
+	}
+	if summary, ok := summarizeGameLog(gameID, records); ok {
+		entry.game = &finishedGame{Summary: summary, Stats: collectGameStats(summary, records)}
+	}
+	return entry
+}
+
+// ListReplays 所有已结束的对局，最近结束的在前
+func ListReplays() ([]ReplaySummary, error) {
+	summaries := make([]ReplaySummary, 0)
+	err := forEachFinishedGame(func(game *finishedGame) {
+		summaries = append(summaries, game.Summary)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return summaries[i].EndedAt > summaries[j].EndedAt
+	})
+	return summaries, nil
+}
+
+// LoadReplay 读取已结束对局的概要和全部日志记录
+func LoadReplay(gameID string) (*ReplaySummary, []GameLogRecord, error) {
+	if !gameIDPattern.MatchString(gameID) {
+		return nil, nil, fmt.Errorf("对局 ID 格式错误")
+	}
+	records, err := readGameLog(gameID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil, fmt.Errorf("对局 %s 不存在", gameID)
+	}
+	summary, ok := summarizeGameLog(gameID, records)
+	if !ok {
+		return nil, nil, fmt.Errorf("对局 %s 尚未结束", gameID)
+	}
+	hidden, err := replayHidden(summary.RoomID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if hidden {
+		return nil, nil, fmt.Errorf("对局 %s 暂不公开", gameID)
+	}
+	return &summary, records, nil
+}
+
+// ReplayEvents 已结束对局的公开记录：不含随机种子、对局状态和原始数据（其中有各玩家的手牌和牌堆顺序），
+// 只有本人能看到的事件对其他人隐去细节。viewerID 为空时按观战者处理
+func ReplayEvents(gameID, viewerID string) ([]GameLogRecord, error) {
+	_, records, err := LoadReplay(gameID)
+	if err != nil {
+		return nil, err
+	}
+	public := make([]GameLogRecord, 0, len(records))
+	for _, record := range records {
+		public = append(public, GameLogRecord{
+			Seq:      record.Seq,
+			Type:     record.Type,
+			Time:     record.Time,
+			Version:  record.Version,
+			PlayerID: record.PlayerID,
+			Command:  record.Command,
+			Payload:  record.Payload,
+			Players:  record.Players,
+			Events:   redactEvents(record.Events, viewerID),
+		})
+	}
+	return public, nil
+}
+
+func redactEvents(events []GameEvent, viewerID string) []GameEvent {
+	if len(events) == 0 {
+		return nil
+	}
+	redacted := make([]GameEvent, 0, len(events))
+	for _, event := range events {
+		redacted = append(redacted, redactEvent(event, viewerID))
+	}
+	return redacted
+}
+
+// 一个回放连接的播放状态，只在连接自己的 goroutine 中使用
+type replaySession struct {
+	conn     *Client
+	summary  *ReplaySummary
+	records  []GameLogRecord
+	states   [][]byte // 每条记录之后的对局状态
+	turns    []int
+	viewerID string
+	step     int
+	playing  bool
+	interval time.Duration
+}
+
+// 依次应用日志记录，保存每一步之后的对局状态
+func newReplaySession(conn *Client, summary *ReplaySummary, records []GameLogRecord, viewerID string) (*replaySession, error) {
+	s := &replaySession{
+		conn:     conn,
+		summary:  summary,
+		records:  records,
+		states:   make([][]byte, len(records)),
+		turns:    []int{0},
+		viewerID: viewerID,
+		interval: defaultReplayInterval,
+	}
+	var doc interface{}
+	for i, record := range records {
+		var err error
+		if doc, err = applyGameRecord(doc, record); err != nil {
+			return nil, err
+		}
+		if s.states[i], err = json.Marshal(doc); err != nil {
+			return nil, fmt.Errorf("编码对局状态失败: %w", err)
+		}
+		for _, event := range record.Events {
+			// 下一位玩家的回合从下一步开始
+			if event.Type == "turn_started" && i+1 < len(records) {
+				s.turns = append(s.turns, i+1)
+				break
+			}
+		}
+	}
+	return s, nil
+}
+
+// 步骤所在的回合
+func (s *replaySession) turnOf(step int) int {
+	return sort.SearchInts(s.turns, step+1) - 1
+}
+
+// 发送某一步的状态和对局数据
+func (s *replaySession) show(step int) error {
+	s.step = step
+	record := s.records[step]
+	if err := writeJSON(s.conn, ReplayStateMessage{
+		Type:     "replay_state",
+		Step:     step,
+		Turn:     s.turnOf(step),
+		Playing:  s.playing,
+		Record:   record.Type,
+		PlayerID: record.PlayerID,
+		Command:  record.Command,
+		Events:   redactEvents(record.Events, s.viewerID),
+	}); err != nil {
+		return err
+	}
+	var state roomState
+	if err := json.Unmarshal(s.states[step], &state); err != nil {
+		return fmt.Errorf("解析对局状态失败: %w", err)
+	}
+	return writeJSON(s.conn, replaySync(&state, s.summary.Players, s.viewerID))
+}
+
+// 执行控制命令，返回需要回复给客户端的错误
+func (s *replaySession) control(env Envelope) error {
+	switch env.Type {
+	case "replay_play":
+		var payload ReplayPlayPayload
+		if len(env.Payload) > 0 {
+			if err := json.Unmarshal(env.Payload, &payload); err != nil {
+				return commandError(CodeBadPayload, "payload 格式错误: %v", err)
+			}
+		}
+		if payload.IntervalMs > 0 {
+			s.interval = max(time.Duration(payload.IntervalMs)*time.Millisecond, minReplayInterval)
+		}
+		s.playing = true
+		if s.step == len(s.records)-1 {
+			// 已经播放完时从头开始
+			return s.show(0)
+		}
+		return s.show(s.step)
+	case "replay_pause":
+		s.playing = false
+		return s.show(s.step)
+	case "replay_seek":
+		var payload ReplaySeekPayload
+		if err := json.Unmarshal(env.Payload, &payload); err != nil {
+			return commandError(CodeBadPayload, "payload 格式错误: %v", err)
+		}
+		step := -1
+		switch {
+		case payload.Step != nil:
+			step = *payload.Step
+		case payload.Turn != nil && *payload.Turn >= 0 && *payload.Turn < len(s.turns):
+			step = s.turns[*payload.Turn]
+		}
+		if step < 0 || step >= len(s.records) {
+			return commandError(CodeRejected, "超出回放范围")
+		}
+		return s.show(step)
+	}
+	return commandError(CodeUnknownType, "未知的消息类型: %s", env.Type)
+}
+
+// 处理控制命令并按间隔自动播放，直到连接断开
+func (s *replaySession) run(controls <-chan Envelope) {
+	if err := writeJSON(s.conn, ReplayInfoMessage{Type: "replay_info", Game: *s.summary, Turns: s.turns}); err != nil {
+		return
+	}
+	if err := s.show(0); err != nil {
+		return
+	}
+	for {
+		var next <-chan time.Time
+		if s.playing {
+			next = time.After(s.interval)
+		}
+		select {
+		case env, ok := <-controls:
+			if !ok {
+				return
+			}
+			err := s.control(env)
+			var cmdErr *CommandError
+			if err != nil && !errors.As(err, &cmdErr) {
+				log.Println("❌ 发送回放数据失败:", err)
+				return
+			}
+			sendReply(s.conn, "", env, err)
+		case <-next:
+			step := s.step + 1
+			if step >= len(s.records)-1 {
+				// 播放到最后一步后停止
+				step = len(s.records) - 1
+				s.playing = false
+			}
+			if err := s.show(step); err != nil {
+				log.Println("❌ 发送回放数据失败:", err)
+				return
+			}
+		}
+	}
+}
+
+// HandleReplayWebSocket 回放已结束的对局：/replay/ws?gameID=...&userID=...&token=...，
+// 带 userID 时以该玩家的视角回放，需要以该玩家的身份登录；否则以观战视角回放
+func HandleReplayWebSocket(c *gin.Context) {
+	conn, err := upgradeConnection(c)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	summary, records, err := LoadReplay(c.Query("gameID"))
+	if err != nil {
+		sendErrorMessage(conn, err.Error())
+		return
+	}
+	viewerID := c.Query("userID")
+	if viewerID != "" && viewerID != middleware.CurrentUser(c) {
+		sendErrorMessage(conn, "只能以自己的视角回放")
+		return
+	}
+	if _, ok := acceptProtocol(conn, c.Query("protocol")); !ok {
+		return
+	}
+	session, err := newReplaySession(conn, summary, records, viewerID)
+	if err != nil {
+		log.Printf("❌ 加载对局 %s 的回放失败: %v\n", summary.GameID, err)
+		sendErrorMessage(conn, "回放数据损坏")
+		return
+	}
+
+	controls := make(chan Envelope)
+	go func() {
+		defer close(controls)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var env Envelope
+			if err := json.Unmarshal(data, &env); err != nil || env.Type == "" {
+				sendReply(conn, "", env, commandError(CodeBadRequest, "消息格式错误"))
+				continue
+			}
+			select {
+			case controls <- env:
+			case <-conn.done:
+				return
+			}
+		}
+	}()
+	session.run(controls)
+}
@@ -9,47 +9,54 @@ import (
 	"time"
 
 	"github.com/go-redis/redis/v8"
-	"github.com/gorilla/websocket"
-	"golang.org/x/exp/rand"
 )
 
 // 校验房间是否有空位，并将玩家加入房间
-func validateAndJoinRoom(roomID, playerID string, conn *websocket.Conn) bool {
+func validateAndJoinRoom(roomID, playerID string, conn WriteOnlyConn) bool {
 	roomInfo, err := GetRoomInfo(roomID)
 	if err != nil {
 		log.Println("❌ 无法获取房间信息:", err)
 		return false
 	}
 	maxPlayers := roomInfo.MaxPlayers
+	room := getRoom(roomID)
+	if room == nil {
+		return false
+	}
 
-	// 查找玩家是否已经在房间中（包括掉线状态）
-	for i, pc := range Rooms[roomID] {
-		if pc.PlayerID == playerID {
-			Rooms[roomID][i].Conn = conn
-			Rooms[roomID][i].Online = true
-			log.Printf("玩家 %s 重连成功\n", playerID)
-			return true
+	joined := false
+	room.updatePlayers(func(players []dto.PlayerConn) []dto.PlayerConn {
+		// 查找玩家是否已经在房间中（包括掉线状态）
+		for i, pc := range players {
+			if pc.PlayerID == playerID {
+				players[i].Conn = conn
+				players[i].Online = true
+				log.Printf("玩家 %s 重连成功\n", playerID)
+				joined = true
+				return players
+			}
 		}
-	}
 
-	if len(Rooms[roomID]) >= maxPlayers {
-		return false
-	}
+		if len(players) >= maxPlayers {
+			return players
+		}
 
-	// 添加新玩家
-	Rooms[roomID] = append(Rooms[roomID], dto.PlayerConn{
-		PlayerID: playerID,
-		Conn:     conn,
-		Online:   true,
+		// 添加新玩家
+		log.Printf("玩家 %s 加入房间 %s\n", playerID, roomID)
+		joined = true
+		return append(players, dto.PlayerConn{
+			PlayerID: playerID,
+			Conn:     conn,
+			Online:   true,
+		})
 	})
-	log.Printf("玩家 %s 加入房间 %s\n", playerID, roomID)
-	return true
+	return joined
 }
 
 // 获取房间中玩家数量
 func getRoomPlayerCount(roomID string) int {
 	onLineCount := 0
-	for _, pc := range Rooms[roomID] {
+	for _, pc := range playersOf(roomID) {
 		if pc.Online {
 			onLineCount++
 		}
@@ -57,52 +64,74 @@ func getRoomPlayerCount(roomID string) int {
 	return onLineCount
 }
 
-func handleReadyMessage(conn ReadWriteConn, rdb *redis.Client, roomID, playerID string, msgMap map[string]interface{}) {
+func handleReadyMessage(conn ReadWriteConn, rdb redis.Cmdable, roomID, playerID string, msgMap map[string]interface{}) error {
+	InitPlayerData(roomID, playerID)
+	tryStartGame(roomID)
+	return nil
+}
+
+// 所有座位都已就座并完成初始化时开始游戏
+func tryStartGame(roomID string) {
 	roomInfo, err := GetRoomInfo(roomID)
 	if err != nil {
 		log.Println("❌ 无法获取房间信息:", err)
 		return
 	}
 	maxPlayers := roomInfo.MaxPlayers
-	InitPlayerData(roomID, playerID)
 	// 获取房间当前人数
 	playerCount := getRoomPlayerCount(roomID)
-	log.Printf("玩家加入 room=%s，ID=%s，当前人数=%d/%d", roomID, playerID, playerCount, maxPlayers)
+	log.Printf("房间 room=%s 当前人数=%d/%d", roomID, playerCount, maxPlayers)
 
-	if playerCount == maxPlayers {
-		err := SetRoomStatus(repository.Rdb, roomID, true)
-		if err != nil {
-			log.Println("❌ 设置房间状态失败:", err)
+	if playerCount != maxPlayers {
+		return
+	}
+	// 还有玩家没有准备（未初始化玩家数据）
+	for _, pc := range playersOf(roomID) {
+		exists, err := IsPlayerInfoExists(repository.Rdb, repository.Ctx, roomID, pc.PlayerID)
+		if err != nil || !exists {
 			return
 		}
+	}
 
-		err = SetGameStatus(repository.Rdb, roomID, entities.RoomStatusPlaying)
+	err = SetRoomStatus(repository.Rdb, roomID, true)
+	if err != nil {
+		log.Println("❌ 设置房间状态失败:", err)
+		return
+	}
+
+	err = SetGameStatus(repository.Rdb, roomID, entities.RoomStatusPlaying)
+	if err != nil {
+		log.Println("❌ 设置游戏状态失败:", err)
+		return
+	}
+
+	// 用于记录log
+	startKey := fmt.Sprintf("room:%s:game_start_time", roomID)
+	repository.Rdb.Set(repository.Ctx, startKey, time.Now().Format("20060102_150405"), 0)
+
+	playerID, err := GetCurrentPlayer(repository.Rdb, repository.Ctx, roomID)
+	if err != nil {
+		log.Println("❌ 获取当前玩家失败:", err)
+		return
+	}
+	if playerID == "" {
+		r, err := gameRand(roomID)
 		if err != nil {
-			log.Println("❌ 设置游戏状态失败:", err)
+			log.Println("❌ 选择起始玩家失败:", err)
 			return
 		}
-
-		// 用于记录log
-		startKey := fmt.Sprintf("room:%s:game_start_time", roomID)
-		repository.Rdb.Set(repository.Ctx, startKey, time.Now().Format("20060102_150405"), 0)
-
-		playerID, err := GetCurrentPlayer(repository.Rdb, repository.Ctx, roomID)
+		randomPlayerID := playersOf(roomID)[r.IntN(maxPlayers)]
+		err = SetCurrentPlayer(repository.Rdb, repository.Ctx, roomID, randomPlayerID.PlayerID)
 		if err != nil {
-			log.Println("❌ 获取当前玩家失败:", err)
+			log.Println("❌ 设置当前玩家失败:", err)
 			return
 		}
-		if playerID == "" {
-			randomPlayerID := Rooms[roomID][rand.Intn(maxPlayers)]
-			err := SetCurrentPlayer(repository.Rdb, repository.Ctx, roomID, randomPlayerID.PlayerID)
-			if err != nil {
-				log.Println("❌ 设置当前玩家失败:", err)
-				return
-			}
-			err = SetFirstPlayer(repository.Rdb, repository.Ctx, roomID, randomPlayerID.PlayerID)
-			if err != nil {
-				log.Println("❌ 设置第一个玩家失败:", err)
-				return
-			}
+		err = SetFirstPlayer(repository.Rdb, repository.Ctx, roomID, randomPlayerID.PlayerID)
+		if err != nil {
+			log.Println("❌ 设置第一个玩家失败:", err)
+			return
 		}
+		logGameStarted(roomID)
+		notifyGameStarted(roomID)
 	}
 }
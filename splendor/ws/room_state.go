@@ -0,0 +1,308 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-game/dto"
+	"go-game/entities"
+	"go-game/repository"
+	"sort"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 一次广播用到的全部房间数据。用一个 pipeline 读出房间和所有玩家的数据，
+// 之后每个玩家、观战者看到的同步消息都在内存中生成，不再按人逐个查询 Redis。
+// 编码为 JSON 即对局日志中的对局状态
+type roomState struct {
+	RoomID        string                             `json:"-"`
+	Version       int64                              `json:"-"`
+	RoomInfo      *entities.RoomInfo                 `json:"roomInfo"`
+	CurrentPlayer string                             `json:"currentPlayer"`
+	FirstPlayer   string                             `json:"firstPlayer"`
+	Cards         map[string]entities.NormalCard     `json:"cards"`
+	Nobles        map[string]entities.NobleCard      `json:"nobles"`
+	Gems          map[string]int                     `json:"gems"`
+	LastData      *LastAction                        `json:"lastData"`
+	Players       map[string]*dto.SplendorPlayerData `json:"players"`
+}
+
+// 对局数据 key（room:<id>: 之后的部分）及其类型，玩家操作通过 stateTx 读写这些 key
+var roomStateKeyTypes = map[string]string{
+	"roomInfo":      stateHash,
+	"card":          stateHash,
+	"nobles":        stateHash,
+	"gems":          stateHash,
+	"last_data":     stateHash,
+	"currentPlayer": stateString,
+	"firstPlayer":   stateString,
+	"seed":          stateString,
+	"rng":           stateString,
+}
+
+// 每个玩家的对局数据 key（room:<id>:player:<玩家>: 之后的部分）
+var playerStateKeyTypes = map[string]string{
+	"normalCard": stateHash,
+	"nobleCard":  stateHash,
+	"gem":        stateHash,
+	"score":      stateHash,
+	"reserve":    stateHash,
+}
+
+// key 属于房间的对局数据时返回其类型，否则返回空字符串
+func stateKeyType(roomID, key string) string {
+	name, ok := strings.CutPrefix(key, "room:"+roomID+":")
+	if !ok {
+		return ""
+	}
+	if kind, ok := roomStateKeyTypes[name]; ok {
+		return kind
+	}
+	if rest, ok := strings.CutPrefix(name, "player:"); ok {
+		if i := strings.LastIndex(rest, ":"); i > 0 {
+			return playerStateKeyTypes[rest[i+1:]]
+		}
+	}
+	return ""
+}
+
+// 玩家操作开始时读入的对局数据 key：房间级的 key 和房间内各玩家的 key
+func roomStateKeys(roomID string) []string {
+	keys := make([]string, 0, len(roomStateKeyTypes)+len(playerStateKeyTypes)*MaxPlayers)
+	for name := range roomStateKeyTypes {
+		keys = append(keys, fmt.Sprintf("room:%s:%s", roomID, name))
+	}
+	for _, pc := range playersOf(roomID) {
+		for name := range playerStateKeyTypes {
+			keys = append(keys, fmt.Sprintf("room:%s:player:%s:%s", roomID, pc.PlayerID, name))
+		}
+	}
+	return keys
+}
+
+// 读取房间数据和 playerIDs 中各玩家的数据
+func loadRoomState(roomID string, playerIDs []string) (*roomState, error) {
+	ctx := repository.Ctx
+	key := func(suffix string) string {
+		return fmt.Sprintf("room:%s:%s", roomID, suffix)
+	}
+
+	pipe := stateRdb(roomID).Pipeline()
+	roomInfoCmd := pipe.HGetAll(ctx, key("roomInfo"))
+	currentPlayerCmd := pipe.Get(ctx, key("currentPlayer"))
+	firstPlayerCmd := pipe.Get(ctx, key("firstPlayer"))
+	cardsCmd := pipe.HGetAll(ctx, key("card"))
+	noblesCmd := pipe.HGetAll(ctx, key("nobles"))
+	gemsCmd := pipe.HGetAll(ctx, key("gems"))
+	lastDataCmd := pipe.HGet(ctx, key("last_data"), "data")
+	versionCmd := pipe.Get(ctx, roomVersionKey(roomID))
+	type playerCmds struct {
+		normalCard, nobleCard, gem, score, reserve *redis.StringCmd
+	}
+	playerCmdMap := make(map[string]playerCmds, len(playerIDs))
+	for _, playerID := range playerIDs {
+		playerKey := func(suffix string) string {
+			return key("player:" + playerID + ":" + suffix)
+		}
+		playerCmdMap[playerID] = playerCmds{
+			normalCard: pipe.HGet(ctx, playerKey("normalCard"), "data"),
+			nobleCard:  pipe.HGet(ctx, playerKey("nobleCard"), "data"),
+			gem:        pipe.HGet(ctx, playerKey("gem"), "data"),
+			score:      pipe.HGet(ctx, playerKey("score"), "data"),
+			reserve:    pipe.HGet(ctx, playerKey("reserve"), "data"),
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("❌ Redis pipeline 执行失败: %w", err)
+	}
+
+	roomInfo, err := parseRoomInfo(roomInfoCmd.Val())
+	if err != nil {
+		return nil, fmt.Errorf("❌ 获取房间信息失败: %w", err)
+	}
+	state := &roomState{
+		RoomID:        roomID,
+		RoomInfo:      roomInfo,
+		CurrentPlayer: currentPlayerCmd.Val(),
+		FirstPlayer:   firstPlayerCmd.Val(),
+		Players:       make(map[string]*dto.SplendorPlayerData, len(playerIDs)),
+	}
+	if versionCmd.Err() != redis.Nil {
+		if state.Version, err = versionCmd.Int64(); err != nil {
+			return nil, fmt.Errorf("获取房间版本号失败: %w", err)
+		}
+	}
+	if state.Cards, err = parseNormalCards(cardsCmd.Val()); err != nil {
+		return nil, fmt.Errorf("❌ 获取所有卡牌失败: %w", err)
+	}
+	if state.Nobles, err = parseNobleCards(noblesCmd.Val()); err != nil {
+		return nil, fmt.Errorf("❌ 获取贵族瓷砖失败: %w", err)
+	}
+	if state.Gems, err = parseGemCounts(gemsCmd.Val()); err != nil {
+		return nil, fmt.Errorf("❌ 获取宝石信息失败: %w", err)
+	}
+	if state.LastData, err = parseLastData(lastDataCmd.Val()); err != nil {
+		return nil, fmt.Errorf("❌ 获取上次操作失败: %w", err)
+	}
+
+	for playerID, cmds := range playerCmdMap {
+		player := &dto.SplendorPlayerData{
+			NormalCard:  []entities.NormalCard{},
+			NobleCard:   []entities.NobleCard{},
+			Gem:         map[string]int{},
+			ReserveCard: []entities.NormalCard{},
+		}
+		fields := []struct {
+			cmd    *redis.StringCmd
+			target interface{}
+		}{
+			{cmds.normalCard, &player.NormalCard},
+			{cmds.nobleCard, &player.NobleCard},
+			{cmds.gem, &player.Gem},
+			{cmds.score, &player.Score},
+			{cmds.reserve, &player.ReserveCard},
+		}
+		for _, f := range fields {
+			if err := decodeDataField(f.cmd, f.target); err != nil {
+				return nil, fmt.Errorf("❌ 获取玩家[%s]数据失败: %w", playerID, err)
+			}
+		}
+		state.Players[playerID] = player
+	}
+	return state, nil
+}
+
+// 解析玩家 Hash 中 "data" 字段的 JSON，字段不存在时保留默认值
+func decodeDataField(cmd *redis.StringCmd, target interface{}) error {
+	if cmd.Err() == redis.Nil {
+		return nil
+	}
+	return json.Unmarshal([]byte(cmd.Val()), target)
+}
+
+// 对局日志中的对局状态：房间和所有座位上玩家的数据，分数按卡牌和贵族重新计算
+func gameStateDoc(roomID string) (interface{}, error) {
+	playerIDs, err := seatPlayerIDs(roomID)
+	if err != nil {
+		return nil, err
+	}
+	state, err := loadRoomState(roomID, playerIDs)
+	if err != nil {
+		return nil, err
+	}
+	state.refreshScores()
+	return toJSONValue(state)
+}
+
+// 回放中某一步的同步消息：viewerID 为空时是观战视角，否则是该玩家的视角
+func replaySync(state *roomState, _ []string, viewerID string) interface{} {
+	if viewerID != "" {
+		return state.playerSync(viewerID, state.roomData())
+	}
+	return buildSpectatorSync(state)
+}
+
+// 只有本人能看到的事件，其他人看到的版本：盲抽预留的卡牌不显示是哪张
+func redactEvent(event GameEvent, viewerID string) GameEvent {
+	if event.Type != "card_reserved" {
+		return event
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(event.Data, &data); err != nil {
+		return GameEvent{Type: event.Type}
+	}
+	if blind, _ := data["blind"].(bool); !blind || data["playerID"] == viewerID {
+		return event
+	}
+	delete(data, "cardID")
+	redacted, _ := json.Marshal(data)
+	return GameEvent{Type: event.Type, Data: redacted}
+}
+
+// 座位上的玩家 ID
+func connPlayerIDs(players []dto.PlayerConn) []string {
+	ids := make([]string, 0, len(players))
+	for _, pc := range players {
+		ids = append(ids, pc.PlayerID)
+	}
+	return ids
+}
+
+// 按玩家的卡牌和贵族重新计算分数，返回分数有变化的玩家
+func (s *roomState) refreshScores() map[string]int {
+	changed := make(map[string]int)
+	for playerID, player := range s.Players {
+		score := 0
+		for _, noble := range player.NobleCard {
+			score += noble.Points
+		}
+		for _, card := range player.NormalCard {
+			score += card.Points
+		}
+		if score != player.Score {
+			player.Score = score
+			changed[playerID] = score
+		}
+	}
+	return changed
+}
+
+// 某个视角下各玩家的数据：盲抽预留的卡牌对其他人只显示等级，viewerID 为空表示观战者
+func (s *roomState) playerData(viewerID string) map[string]dto.SplendorPlayerData {
+	playersData := make(map[string]dto.SplendorPlayerData, len(s.Players))
+	for playerID, player := range s.Players {
+		data := *player
+		if playerID != viewerID {
+			data.ReserveCard = make([]entities.NormalCard, len(player.ReserveCard))
+			for i, card := range player.ReserveCard {
+				if card.Blind {
+					card = hideBlindCard(card)
+				}
+				data.ReserveCard[i] = card
+			}
+		}
+		playersData[playerID] = data
+	}
+	return playersData
+}
+
+// 房间内所有人（包括观战者）都能看到的数据
+func (s *roomState) roomData() SyncRoomData {
+	revealedCards := map[int][]entities.NormalCard{}
+	for _, card := range s.Cards {
+		if card.State == entities.CardStateRevealed {
+			revealedCards[card.Level] = append(revealedCards[card.Level], card)
+		}
+	}
+	revealedNobles := make([]entities.NobleCard, 0)
+	for _, noble := range s.Nobles {
+		if noble.State == entities.CardStateRevealed {
+			revealedNobles = append(revealedNobles, noble)
+		}
+	}
+	// 按 ID 排序，每次同步顺序一致，增量同步时只产生实际变化的部分
+	for _, cards := range revealedCards {
+		sort.Slice(cards, func(i, j int) bool { return cards[i].ID < cards[j].ID })
+	}
+	sort.Slice(revealedNobles, func(i, j int) bool { return revealedNobles[i].ID < revealedNobles[j].ID })
+	return SyncRoomData{
+		Card:          revealedCards,
+		Gems:          s.Gems,
+		Nobles:        revealedNobles,
+		RoomInfo:      s.RoomInfo,
+		CurrentPlayer: s.CurrentPlayer,
+		LastData:      s.LastData,
+		Version:       s.Version,
+	}
+}
+
+// 玩家视角的同步消息
+func (s *roomState) playerSync(playerID string, roomData SyncRoomData) SyncMessage {
+	return SyncMessage{
+		Type:       "sync",
+		PlayerID:   playerID,
+		PlayerData: s.playerData(playerID),
+		RoomData:   roomData,
+	}
+}
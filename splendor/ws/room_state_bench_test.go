@@ -0,0 +1,113 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"go-game/dto"
+	"go-game/entities"
+	"go-game/repository"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// 统计发往 Redis 的命令数和往返次数，一个 pipeline 算一次往返
+type countHook struct {
+	cmds  int64
+	trips int64
+}
+
+func (h *countHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	atomic.AddInt64(&h.cmds, 1)
+	atomic.AddInt64(&h.trips, 1)
+	return ctx, nil
+}
+
+func (h *countHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (h *countHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	atomic.AddInt64(&h.cmds, int64(len(cmds)))
+	atomic.AddInt64(&h.trips, 1)
+	return ctx, nil
+}
+
+func (h *countHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+// 丢弃所有消息的连接
+type nopConn struct{}
+
+func (nopConn) WriteMessage(int, []byte) error { return nil }
+
+func (nopConn) ReadMessage() (int, []byte, error) { return 0, nil, fmt.Errorf("连接已关闭") }
+
+func (nopConn) Close() error { return nil }
+
+// 在 miniredis 上准备一个进行中的房间，players 个玩家全部在线
+func setupBenchRoom(tb testing.TB, players int) (string, *countHook) {
+	mr := miniredis.RunT(tb)
+	repository.Rdb = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	rdb := repository.Rdb
+	ctx := repository.Ctx
+	roomID := "bench"
+
+	SetRoomInfo(rdb, ctx, roomID, entities.RoomInfo{MaxPlayers: players, GameStatus: entities.RoomStatusPlaying, UserID: "p0"})
+	if err := InitRoomData(roomID); err != nil {
+		tb.Fatal(err)
+	}
+
+	OpenRoom(roomID)
+	seats := make([]dto.PlayerConn, players)
+	for i := range seats {
+		seats[i] = dto.PlayerConn{PlayerID: fmt.Sprintf("p%d", i), Conn: nopConn{}, Online: true}
+	}
+	RunInRoom(roomID, func() {
+		getRoom(roomID).updatePlayers(func([]dto.PlayerConn) []dto.PlayerConn { return seats })
+	})
+	for _, pc := range seats {
+		if err := InitPlayerDataToRedis(roomID, pc.PlayerID); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	SetCurrentPlayer(rdb, ctx, roomID, "p0")
+	SetFirstPlayer(rdb, ctx, roomID, "p0")
+	SetPlayerReserveCards(roomID, "p1", []entities.NormalCard{{ID: 1, Level: 2, Blind: true}})
+	SetLastData(roomID, "p0", "get_gem", map[string]int{"Red": 1})
+
+	hook := &countHook{}
+	rdb.AddHook(hook)
+	return roomID, hook
+}
+
+// 一次广播发往 Redis 的命令数和往返次数：
+// go test ./ws -run '^$' -bench Broadcast
+//
+// 广播改为每次读取一次房间数据（loadRoomState）前后，在同一台机器上用 -benchtime=2000x 测得：
+//
+//	players  逐字段读取（改动前）               一次读取（改动后）
+//	2        44 次往返  44 条命令   1.55 ms/op    2 次往返  19 条命令  0.68 ms/op
+//	3        79 次往返  79 条命令   2.86 ms/op    2 次往返  24 条命令  0.83 ms/op
+//	4        124 次往返 124 条命令  3.31 ms/op    2 次往返  29 条命令  0.82 ms/op
+//
+// 之后增量同步等改动让每次广播多做了一些事，当前的耗时和命令数以运行结果为准
+func BenchmarkBroadcast(b *testing.B) {
+	for _, players := range []int{2, 3, 4} {
+		b.Run(fmt.Sprintf("players=%d", players), func(b *testing.B) {
+			roomID, hook := setupBenchRoom(b, players)
+			RunInRoom(roomID, func() { BroadcastToRoom(roomID) }) // 第一次广播会写回玩家分数
+			atomic.StoreInt64(&hook.cmds, 0)
+			atomic.StoreInt64(&hook.trips, 0)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				RunInRoom(roomID, func() { BroadcastToRoom(roomID) })
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(&hook.cmds))/float64(b.N), "cmds/op")
+			b.ReportMetric(float64(atomic.LoadInt64(&hook.trips))/float64(b.N), "roundtrips/op")
+		})
+	}
+}
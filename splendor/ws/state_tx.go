@@ -0,0 +1,949 @@
+package ws
+
+import (
+	"context"
+	"encoding"
+	"errors"
+	"fmt"
+	"go-game/repository"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 玩家操作的原子提交：操作要改多个 key，直接写 Redis 时中途出错或进程退出会留下改了一半的房间，其他读者也会读到中间状态。
+// 操作开始时 WATCH 房间版本号和对局数据 key（roomStateKeys 给出的已知列表），用一个 pipeline 读入内存；
+// 操作中对对局数据的读写都落在内存里，成功后在 MULTI/EXEC 中写回改动过的 key 并递增版本号，失败时直接丢弃。
+// 操作期间这些 key 被其他连接改动时 EXEC 失败，操作按冲突拒绝。
+// 客户端可以在消息里带上收到的 version，落后于房间当前版本的操作直接拒绝
+
+var errStateConflict = errors.New("房间状态已变化，请刷新后重试")
+
+var errStateWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// 对局数据 key 的类型
+const (
+	stateString = "string"
+	stateHash   = "hash"
+	stateList   = "list"
+	stateSet    = "set"
+)
+
+func roomVersionKey(roomID string) string {
+	return fmt.Sprintf("room:%s:version", roomID)
+}
+
+// GetRoomVersion 获取房间版本号，每次成功提交玩家操作加 1
+func GetRoomVersion(roomID string) (int64, error) {
+	version, err := repository.Rdb.Get(repository.Ctx, roomVersionKey(roomID)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("获取房间版本号失败: %w", err)
+	}
+	return version, nil
+}
+
+// stateRdb 读写对局数据使用的客户端：房间正在执行玩家操作时为操作的事务，否则直接访问 Redis。
+// 只在房间 goroutine 内使用，其他 goroutine 读取已提交的数据时用 repository.Rdb
+func stateRdb(roomID string) redis.Cmdable {
+	if room := getRoom(roomID); room != nil {
+		if tx := room.tx.Load(); tx != nil {
+			return tx
+		}
+	}
+	return repository.Rdb
+}
+
+// atomicAction 包装玩家操作：操作要么全部生效，要么不留下任何改动
+func atomicAction(h messageHandler) messageHandler {
+	return func(conn ReadWriteConn, rdb redis.Cmdable, roomID string, playerID string, msgMap map[string]interface{}) error {
+		err := runAtomicAction(roomID, msgMap, func() error {
+			return h(conn, stateRdb(roomID), roomID, playerID, msgMap)
+		})
+		if err == nil {
+			logGameCommand(roomID, playerID, msgMap)
+		}
+		return err
+	}
+}
+
+// 在房间 goroutine 内执行 fn，fn 通过 stateRdb 读写对局数据
+func runAtomicAction(roomID string, msgMap map[string]interface{}, fn func() error) error {
+	room := getRoom(roomID)
+	if room == nil {
+		return fmt.Errorf("房间[%s]不存在", roomID)
+	}
+	ctx := repository.Ctx
+	versionKey := roomVersionKey(roomID)
+	keys := roomStateKeys(roomID)
+
+	discardGameEvents(roomID)
+	err := repository.Rdb.Watch(ctx, func(watch *redis.Tx) error {
+		tx, version, err := beginStateTx(ctx, roomID, watch, keys)
+		if err != nil {
+			return err
+		}
+		if expected, ok := msgMap["version"].(float64); ok && int64(expected) != version {
+			return fmt.Errorf("%w: 客户端版本 %d，当前版本 %d", errStateConflict, int64(expected), version)
+		}
+
+		room.tx.Store(tx)
+		err = fn()
+		room.tx.Store(nil)
+		if err != nil {
+			return err
+		}
+		_, err = watch.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			tx.flush(ctx, pipe)
+			pipe.Incr(ctx, versionKey)
+			return nil
+		})
+		return err
+	}, append([]string{versionKey}, keys...)...)
+	if err == nil {
+		return nil
+	}
+	discardGameEvents(roomID)
+	if errors.Is(err, redis.TxFailedErr) {
+		return errStateConflict
+	}
+	return err
+}
+
+// 内存中的一个对局数据 key
+type stateValue struct {
+	kind  string
+	str   string
+	isSet bool // string 类型的 key 是否存在
+	hash  map[string]string
+	list  []string
+	set   map[string]struct{}
+	dirty bool
+}
+
+func (v *stateValue) exists() bool {
+	switch v.kind {
+	case stateString:
+		return v.isSet
+	case stateHash:
+		return len(v.hash) > 0
+	case stateList:
+		return len(v.list) > 0
+	default:
+		return len(v.set) > 0
+	}
+}
+
+func (v *stateValue) clear() {
+	v.str, v.isSet = "", false
+	v.hash = map[string]string{}
+	v.list = nil
+	v.set = map[string]struct{}{}
+	v.dirty = true
+}
+
+// stateTx 一次玩家操作的事务：对局数据 key 的命令在内存中执行，其他命令直接发给 Redis
+type stateTx struct {
+	stateCmds
+	roomID string
+	watch  *redis.Tx // 执行 WATCH 的连接，读取列表之外的对局数据 key 时先 WATCH；回放对局日志时为 nil
+
+	mu     sync.Mutex
+	values map[string]*stateValue
+	queued []func(ctx context.Context, pipe redis.Pipeliner) // 不属于对局数据、但要和操作一起提交的写入
+}
+
+// 把一次写入加入事务，和对局数据一起在 MULTI/EXEC 中提交，事务失败时一并丢弃
+func (tx *stateTx) queue(fn func(ctx context.Context, pipe redis.Pipeliner)) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.queued = append(tx.queued, fn)
+}
+
+// 读入已知的对局数据 key，同时返回房间当前版本号
+func beginStateTx(ctx context.Context, roomID string, watch *redis.Tx, keys []string) (*stateTx, int64, error) {
+	tx := &stateTx{roomID: roomID, watch: watch, values: make(map[string]*stateValue, len(keys))}
+	tx.stateCmds = stateCmds{Cmdable: repository.Rdb, tx: tx}
+
+	pipe := watch.Pipeline()
+	versionCmd := pipe.Get(ctx, roomVersionKey(roomID))
+	cmds := make([]redis.Cmder, len(keys))
+	for i, key := range keys {
+		cmds[i] = readStateKey(ctx, pipe, key, stateKeyType(roomID, key))
+	}
+	pipe.Exec(ctx)
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err != nil && err != redis.Nil {
+			return nil, 0, fmt.Errorf("读取房间数据失败: %w", err)
+		}
+	}
+	version, err := versionCmd.Int64()
+	if err != nil && err != redis.Nil {
+		return nil, 0, fmt.Errorf("获取房间版本号失败: %w", err)
+	}
+	for i, key := range keys {
+		tx.values[key] = parseStateKey(stateKeyType(roomID, key), cmds[i])
+	}
+	return tx, version, nil
+}
+
+func readStateKey(ctx context.Context, rdb redis.Cmdable, key, kind string) redis.Cmder {
+	switch kind {
+	case stateString:
+		return rdb.Get(ctx, key)
+	case stateHash:
+		return rdb.HGetAll(ctx, key)
+	case stateList:
+		return rdb.LRange(ctx, key, 0, -1)
+	default:
+		return rdb.SMembers(ctx, key)
+	}
+}
+
+func parseStateKey(kind string, cmd redis.Cmder) *stateValue {
+	v := &stateValue{kind: kind, hash: map[string]string{}, set: map[string]struct{}{}}
+	switch cmd := cmd.(type) {
+	case *redis.StringCmd:
+		v.str, v.isSet = cmd.Val(), cmd.Err() == nil
+	case *redis.StringStringMapCmd:
+		if len(cmd.Val()) > 0 {
+			v.hash = cmd.Val()
+		}
+	case *redis.StringSliceCmd:
+		if kind == stateList {
+			v.list = cmd.Val()
+		} else {
+			for _, m := range cmd.Val() {
+				v.set[m] = struct{}{}
+			}
+		}
+	}
+	return v
+}
+
+// 取出 key 在内存中的值，列表之外的 key 先 WATCH 再读入
+func (tx *stateTx) value(ctx context.Context, key, kind string) (*stateValue, error) {
+	if v, ok := tx.values[key]; ok {
+		if v.kind != kind {
+			return nil, errStateWrongType
+		}
+		return v, nil
+	}
+	want := stateKeyType(tx.roomID, key)
+	var v *stateValue
+	if tx.watch == nil {
+		// 回放对局日志时只有快照中的数据，其余 key 都不存在
+		v = parseStateKey(want, nil)
+	} else {
+		if err := tx.watch.Watch(ctx, key).Err(); err != nil {
+			return nil, fmt.Errorf("监视房间数据失败: %w", err)
+		}
+		cmd := readStateKey(ctx, tx.watch, key, want)
+		if err := cmd.Err(); err != nil && err != redis.Nil {
+			return nil, err
+		}
+		v = parseStateKey(want, cmd)
+	}
+	tx.values[key] = v
+	if v.kind != kind {
+		return nil, errStateWrongType
+	}
+	return v, nil
+}
+
+// 把改动过的 key 整体写回
+func (tx *stateTx) flush(ctx context.Context, pipe redis.Pipeliner) {
+	for key, v := range tx.values {
+		if !v.dirty {
+			continue
+		}
+		pipe.Del(ctx, key)
+		if !v.exists() {
+			continue
+		}
+		switch v.kind {
+		case stateString:
+			pipe.Set(ctx, key, v.str, 0)
+		case stateHash:
+			pipe.HSet(ctx, key, v.hash)
+		case stateList:
+			pipe.RPush(ctx, key, stringArgs(v.list)...)
+		case stateSet:
+			members := make([]interface{}, 0, len(v.set))
+			for m := range v.set {
+				members = append(members, m)
+			}
+			pipe.SAdd(ctx, key, members...)
+		}
+	}
+	for _, fn := range tx.queued {
+		fn(ctx, pipe)
+	}
+}
+
+// 在内存中执行对局数据 key 的命令
+func (tx *stateTx) do(ctx context.Context, key, kind string, cmd redis.Cmder, fn func(v *stateValue) error) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	v, err := tx.value(ctx, key, kind)
+	if err == nil {
+		err = fn(v)
+	}
+	if err != nil {
+		cmd.SetErr(err)
+	}
+}
+
+// stateCmds 对局数据 key 的命令交给 stateTx 在内存中执行，其他命令交给内嵌的 Cmdable
+type stateCmds struct {
+	redis.Cmdable
+	tx        *stateTx
+	pipelined bool
+	done      []redis.Cmder // 在 pipeline 中已在内存执行的命令，Exec 时一并返回
+}
+
+func (c *stateCmds) stateKind(key string) string {
+	return stateKeyType(c.tx.roomID, key)
+}
+
+func (c *stateCmds) record(cmd redis.Cmder) {
+	if c.pipelined {
+		c.done = append(c.done, cmd)
+	}
+}
+
+func (c *stateCmds) Get(ctx context.Context, key string) *redis.StringCmd {
+	if c.stateKind(key) == "" {
+		return c.Cmdable.Get(ctx, key)
+	}
+	cmd := redis.NewStringCmd(ctx, "get", key)
+	c.tx.do(ctx, key, stateString, cmd, func(v *stateValue) error {
+		if !v.exists() {
+			return redis.Nil
+		}
+		cmd.SetVal(v.str)
+		return nil
+	})
+	c.record(cmd)
+	return cmd
+}
+
+func (c *stateCmds) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	if c.stateKind(key) == "" {
+		return c.Cmdable.Set(ctx, key, value, expiration)
+	}
+	cmd := redis.NewStatusCmd(ctx, "set", key, value)
+	c.tx.do(ctx, key, stateString, cmd, func(v *stateValue) error {
+		s, err := argString(value)
+		if err != nil {
+			return err
+		}
+		v.str, v.isSet, v.dirty = s, true, true
+		cmd.SetVal("OK")
+		return nil
+	})
+	c.record(cmd)
+	return cmd
+}
+
+func (c *stateCmds) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.BoolCmd {
+	if c.stateKind(key) == "" {
+		return c.Cmdable.SetNX(ctx, key, value, expiration)
+	}
+	cmd := redis.NewBoolCmd(ctx, "set", key, value, "nx")
+	c.tx.do(ctx, key, stateString, cmd, func(v *stateValue) error {
+		if v.exists() {
+			cmd.SetVal(false)
+			return nil
+		}
+		s, err := argString(value)
+		if err != nil {
+			return err
+		}
+		v.str, v.isSet, v.dirty = s, true, true
+		cmd.SetVal(true)
+		return nil
+	})
+	c.record(cmd)
+	return cmd
+}
+
+func (c *stateCmds) Incr(ctx context.Context, key string) *redis.IntCmd {
+	if c.stateKind(key) == "" {
+		return c.Cmdable.Incr(ctx, key)
+	}
+	cmd := redis.NewIntCmd(ctx, "incr", key)
+	c.tx.do(ctx, key, stateString, cmd, func(v *stateValue) error {
+		n := int64(0)
+		if v.exists() {
+			var err error
+			if n, err = strconv.ParseInt(v.str, 10, 64); err != nil {
+				return errors.New("ERR value is not an integer or out of range")
+			}
+		}
+		n++
+		v.str, v.isSet, v.dirty = strconv.FormatInt(n, 10), true, true
+		cmd.SetVal(n)
+		return nil
+	})
+	c.record(cmd)
+	return cmd
+}
+
+func (c *stateCmds) Del(ctx context.Context, keys ...string) *redis.IntCmd {
+	var others []string
+	var deleted int64
+	cmd := redis.NewIntCmd(ctx, stringArgs(append([]string{"del"}, keys...))...)
+	for _, key := range keys {
+		kind := c.stateKind(key)
+		if kind == "" {
+			others = append(others, key)
+			continue
+		}
+		c.tx.do(ctx, key, kind, cmd, func(v *stateValue) error {
+			if v.exists() {
+				deleted++
+			}
+			v.clear()
+			return nil
+		})
+	}
+	if len(others) > 0 {
+		n, err := c.Cmdable.Del(ctx, others...).Result()
+		if err != nil {
+			cmd.SetErr(err)
+		}
+		deleted += n
+	}
+	cmd.SetVal(deleted)
+	c.record(cmd)
+	return cmd
+}
+
+func (c *stateCmds) Exists(ctx context.Context, keys ...string) *redis.IntCmd {
+	var others []string
+	var found int64
+	cmd := redis.NewIntCmd(ctx, stringArgs(append([]string{"exists"}, keys...))...)
+	for _, key := range keys {
+		kind := c.stateKind(key)
+		if kind == "" {
+			others = append(others, key)
+			continue
+		}
+		c.tx.do(ctx, key, kind, cmd, func(v *stateValue) error {
+			if v.exists() {
+				found++
+			}
+			return nil
+		})
+	}
+	if len(others) > 0 {
+		n, err := c.Cmdable.Exists(ctx, others...).Result()
+		if err != nil {
+			cmd.SetErr(err)
+		}
+		found += n
+	}
+	cmd.SetVal(found)
+	c.record(cmd)
+	return cmd
+}
+
+func (c *stateCmds) HGet(ctx context.Context, key, field string) *redis.StringCmd {
+	if c.stateKind(key) == "" {
+		return c.Cmdable.HGet(ctx, key, field)
+	}
+	cmd := redis.NewStringCmd(ctx, "hget", key, field)
+	c.tx.do(ctx, key, stateHash, cmd, func(v *stateValue) error {
+		value, ok := v.hash[field]
+		if !ok {
+			return redis.Nil
+		}
+		cmd.SetVal(value)
+		return nil
+	})
+	c.record(cmd)
+	return cmd
+}
+
+func (c *stateCmds) HGetAll(ctx context.Context, key string) *redis.StringStringMapCmd {
+	if c.stateKind(key) == "" {
+		return c.Cmdable.HGetAll(ctx, key)
+	}
+	cmd := redis.NewStringStringMapCmd(ctx, "hgetall", key)
+	c.tx.do(ctx, key, stateHash, cmd, func(v *stateValue) error {
+		values := make(map[string]string, len(v.hash))
+		for field, value := range v.hash {
+			values[field] = value
+		}
+		cmd.SetVal(values)
+		return nil
+	})
+	c.record(cmd)
+	return cmd
+}
+
+// 写入 field/value 对，返回新增的 field 数
+func (c *stateCmds) hset(ctx context.Context, key string, cmd redis.Cmder, values []interface{}) int64 {
+	var added int64
+	c.tx.do(ctx, key, stateHash, cmd, func(v *stateValue) error {
+		args := flattenArgs(values)
+		if len(args) == 0 || len(args)%2 != 0 {
+			return errors.New("ERR wrong number of arguments for 'hset' command")
+		}
+		for i := 0; i < len(args); i += 2 {
+			field, err := argString(args[i])
+			if err != nil {
+				return err
+			}
+			value, err := argString(args[i+1])
+			if err != nil {
+				return err
+			}
+			if _, ok := v.hash[field]; !ok {
+				added++
+			}
+			v.hash[field] = value
+		}
+		v.dirty = true
+		return nil
+	})
+	return added
+}
+
+func (c *stateCmds) HSet(ctx context.Context, key string, values ...interface{}) *redis.IntCmd {
+	if c.stateKind(key) == "" {
+		return c.Cmdable.HSet(ctx, key, values...)
+	}
+	cmd := redis.NewIntCmd(ctx, "hset", key)
+	cmd.SetVal(c.hset(ctx, key, cmd, values))
+	c.record(cmd)
+	return cmd
+}
+
+func (c *stateCmds) HMSet(ctx context.Context, key string, values ...interface{}) *redis.BoolCmd {
+	if c.stateKind(key) == "" {
+		return c.Cmdable.HMSet(ctx, key, values...)
+	}
+	cmd := redis.NewBoolCmd(ctx, "hmset", key)
+	c.hset(ctx, key, cmd, values)
+	cmd.SetVal(cmd.Err() == nil)
+	c.record(cmd)
+	return cmd
+}
+
+func (c *stateCmds) HDel(ctx context.Context, key string, fields ...string) *redis.IntCmd {
+	if c.stateKind(key) == "" {
+		return c.Cmdable.HDel(ctx, key, fields...)
+	}
+	cmd := redis.NewIntCmd(ctx, "hdel", key)
+	c.tx.do(ctx, key, stateHash, cmd, func(v *stateValue) error {
+		var removed int64
+		for _, field := range fields {
+			if _, ok := v.hash[field]; ok {
+				delete(v.hash, field)
+				removed++
+			}
+		}
+		v.dirty = v.dirty || removed > 0
+		cmd.SetVal(removed)
+		return nil
+	})
+	c.record(cmd)
+	return cmd
+}
+
+func (c *stateCmds) HIncrBy(ctx context.Context, key, field string, incr int64) *redis.IntCmd {
+	if c.stateKind(key) == "" {
+		return c.Cmdable.HIncrBy(ctx, key, field, incr)
+	}
+	cmd := redis.NewIntCmd(ctx, "hincrby", key, field, incr)
+	c.tx.do(ctx, key, stateHash, cmd, func(v *stateValue) error {
+		n := int64(0)
+		if value, ok := v.hash[field]; ok {
+			var err error
+			if n, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return errors.New("ERR hash value is not an integer")
+			}
+		}
+		n += incr
+		v.hash[field] = strconv.FormatInt(n, 10)
+		v.dirty = true
+		cmd.SetVal(n)
+		return nil
+	})
+	c.record(cmd)
+	return cmd
+}
+
+func (c *stateCmds) LRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd {
+	if c.stateKind(key) == "" {
+		return c.Cmdable.LRange(ctx, key, start, stop)
+	}
+	cmd := redis.NewStringSliceCmd(ctx, "lrange", key, start, stop)
+	c.tx.do(ctx, key, stateList, cmd, func(v *stateValue) error {
+		n := int64(len(v.list))
+		if start < 0 {
+			start += n
+		}
+		if stop < 0 {
+			stop += n
+		}
+		if start < 0 {
+			start = 0
+		}
+		if stop >= n {
+			stop = n - 1
+		}
+		if start > stop {
+			cmd.SetVal([]string{})
+			return nil
+		}
+		cmd.SetVal(append([]string(nil), v.list[start:stop+1]...))
+		return nil
+	})
+	c.record(cmd)
+	return cmd
+}
+
+func (c *stateCmds) RPush(ctx context.Context, key string, values ...interface{}) *redis.IntCmd {
+	if c.stateKind(key) == "" {
+		return c.Cmdable.RPush(ctx, key, values...)
+	}
+	cmd := redis.NewIntCmd(ctx, "rpush", key)
+	c.tx.do(ctx, key, stateList, cmd, func(v *stateValue) error {
+		for _, arg := range flattenArgs(values) {
+			s, err := argString(arg)
+			if err != nil {
+				return err
+			}
+			v.list = append(v.list, s)
+		}
+		v.dirty = true
+		cmd.SetVal(int64(len(v.list)))
+		return nil
+	})
+	c.record(cmd)
+	return cmd
+}
+
+func (c *stateCmds) LRem(ctx context.Context, key string, count int64, value interface{}) *redis.IntCmd {
+	if c.stateKind(key) == "" {
+		return c.Cmdable.LRem(ctx, key, count, value)
+	}
+	cmd := redis.NewIntCmd(ctx, "lrem", key, count, value)
+	c.tx.do(ctx, key, stateList, cmd, func(v *stateValue) error {
+		target, err := argString(value)
+		if err != nil {
+			return err
+		}
+		var removed int64
+		kept := make([]string, 0, len(v.list))
+		if count >= 0 {
+			for _, item := range v.list {
+				if item == target && (count == 0 || removed < count) {
+					removed++
+					continue
+				}
+				kept = append(kept, item)
+			}
+		} else {
+			// 从尾部开始删除
+			for i := len(v.list) - 1; i >= 0; i-- {
+				if v.list[i] == target && removed < -count {
+					removed++
+					continue
+				}
+				kept = append([]string{v.list[i]}, kept...)
+			}
+		}
+		v.list = kept
+		v.dirty = v.dirty || removed > 0
+		cmd.SetVal(removed)
+		return nil
+	})
+	c.record(cmd)
+	return cmd
+}
+
+func (c *stateCmds) SAdd(ctx context.Context, key string, members ...interface{}) *redis.IntCmd {
+	if c.stateKind(key) == "" {
+		return c.Cmdable.SAdd(ctx, key, members...)
+	}
+	cmd := redis.NewIntCmd(ctx, "sadd", key)
+	c.tx.do(ctx, key, stateSet, cmd, func(v *stateValue) error {
+		var added int64
+		for _, arg := range flattenArgs(members) {
+			m, err := argString(arg)
+			if err != nil {
+				return err
+			}
+			if _, ok := v.set[m]; !ok {
+				v.set[m] = struct{}{}
+				added++
+			}
+		}
+		v.dirty = v.dirty || added > 0
+		cmd.SetVal(added)
+		return nil
+	})
+	c.record(cmd)
+	return cmd
+}
+
+func (c *stateCmds) SRem(ctx context.Context, key string, members ...interface{}) *redis.IntCmd {
+	if c.stateKind(key) == "" {
+		return c.Cmdable.SRem(ctx, key, members...)
+	}
+	cmd := redis.NewIntCmd(ctx, "srem", key)
+	c.tx.do(ctx, key, stateSet, cmd, func(v *stateValue) error {
+		var removed int64
+		for _, arg := range flattenArgs(members) {
+			m, err := argString(arg)
+			if err != nil {
+				return err
+			}
+			if _, ok := v.set[m]; ok {
+				delete(v.set, m)
+				removed++
+			}
+		}
+		v.dirty = v.dirty || removed > 0
+		cmd.SetVal(removed)
+		return nil
+	})
+	c.record(cmd)
+	return cmd
+}
+
+func (c *stateCmds) SMembers(ctx context.Context, key string) *redis.StringSliceCmd {
+	if c.stateKind(key) == "" {
+		return c.Cmdable.SMembers(ctx, key)
+	}
+	cmd := redis.NewStringSliceCmd(ctx, "smembers", key)
+	c.tx.do(ctx, key, stateSet, cmd, func(v *stateValue) error {
+		members := make([]string, 0, len(v.set))
+		for m := range v.set {
+			members = append(members, m)
+		}
+		cmd.SetVal(members)
+		return nil
+	})
+	c.record(cmd)
+	return cmd
+}
+
+func (c *stateCmds) SIsMember(ctx context.Context, key string, member interface{}) *redis.BoolCmd {
+	if c.stateKind(key) == "" {
+		return c.Cmdable.SIsMember(ctx, key, member)
+	}
+	cmd := redis.NewBoolCmd(ctx, "sismember", key, member)
+	c.tx.do(ctx, key, stateSet, cmd, func(v *stateValue) error {
+		m, err := argString(member)
+		if err != nil {
+			return err
+		}
+		_, ok := v.set[m]
+		cmd.SetVal(ok)
+		return nil
+	})
+	c.record(cmd)
+	return cmd
+}
+
+func (c *stateCmds) SCard(ctx context.Context, key string) *redis.IntCmd {
+	if c.stateKind(key) == "" {
+		return c.Cmdable.SCard(ctx, key)
+	}
+	cmd := redis.NewIntCmd(ctx, "scard", key)
+	c.tx.do(ctx, key, stateSet, cmd, func(v *stateValue) error {
+		cmd.SetVal(int64(len(v.set)))
+		return nil
+	})
+	c.record(cmd)
+	return cmd
+}
+
+// pipeline 中对局数据 key 的命令立即在内存执行，其他命令照常排队
+func (c *stateCmds) Pipeline() redis.Pipeliner {
+	return c.tx.pipeline(repository.Rdb.Pipeline())
+}
+
+func (c *stateCmds) TxPipeline() redis.Pipeliner {
+	return c.tx.pipeline(repository.Rdb.TxPipeline())
+}
+
+func (c *stateCmds) Pipelined(ctx context.Context, fn func(redis.Pipeliner) error) ([]redis.Cmder, error) {
+	return execStatePipe(ctx, c.Pipeline(), fn)
+}
+
+func (c *stateCmds) TxPipelined(ctx context.Context, fn func(redis.Pipeliner) error) ([]redis.Cmder, error) {
+	return execStatePipe(ctx, c.TxPipeline(), fn)
+}
+
+func execStatePipe(ctx context.Context, pipe redis.Pipeliner, fn func(redis.Pipeliner) error) ([]redis.Cmder, error) {
+	if err := fn(pipe); err != nil {
+		return nil, err
+	}
+	return pipe.Exec(ctx)
+}
+
+func (tx *stateTx) pipeline(pipe redis.Pipeliner) redis.Pipeliner {
+	return &statePipe{stateCmds: stateCmds{Cmdable: pipe, tx: tx, pipelined: true}, pipe: pipe}
+}
+
+// statePipe stateTx 上的 pipeline
+type statePipe struct {
+	stateCmds
+	pipe redis.Pipeliner
+}
+
+func (p *statePipe) Len() int {
+	return len(p.done) + p.pipe.Len()
+}
+
+func (p *statePipe) Do(ctx context.Context, args ...interface{}) *redis.Cmd {
+	return p.pipe.Do(ctx, args...)
+}
+
+func (p *statePipe) Process(ctx context.Context, cmd redis.Cmder) error {
+	return p.pipe.Process(ctx, cmd)
+}
+
+func (p *statePipe) Close() error {
+	p.done = nil
+	return p.pipe.Close()
+}
+
+// Discard 只丢弃排队中的命令，已在内存执行的命令随操作一起提交或丢弃
+func (p *statePipe) Discard() error {
+	p.done = nil
+	return p.pipe.Discard()
+}
+
+func (p *statePipe) Exec(ctx context.Context) ([]redis.Cmder, error) {
+	cmds := p.done
+	p.done = nil
+	if p.pipe.Len() > 0 {
+		queued, _ := p.pipe.Exec(ctx)
+		cmds = append(cmds, queued...)
+	}
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err != nil {
+			return cmds, err
+		}
+	}
+	return cmds, nil
+}
+
+func (p *statePipe) Auth(ctx context.Context, password string) *redis.StatusCmd {
+	return p.pipe.Auth(ctx, password)
+}
+
+func (p *statePipe) AuthACL(ctx context.Context, username, password string) *redis.StatusCmd {
+	return p.pipe.AuthACL(ctx, username, password)
+}
+
+func (p *statePipe) Select(ctx context.Context, index int) *redis.StatusCmd {
+	return p.pipe.Select(ctx, index)
+}
+
+func (p *statePipe) SwapDB(ctx context.Context, index1, index2 int) *redis.StatusCmd {
+	return p.pipe.SwapDB(ctx, index1, index2)
+}
+
+func (p *statePipe) ClientSetName(ctx context.Context, name string) *redis.BoolCmd {
+	return p.pipe.ClientSetName(ctx, name)
+}
+
+// 与 go-redis 展开命令参数的方式一致：只有一个参数时展开其中的 slice 和 map
+func flattenArgs(values []interface{}) []interface{} {
+	if len(values) != 1 {
+		return values
+	}
+	switch arg := values[0].(type) {
+	case []string:
+		return stringArgs(arg)
+	case []interface{}:
+		return arg
+	case map[string]interface{}:
+		args := make([]interface{}, 0, len(arg)*2)
+		for k, v := range arg {
+			args = append(args, k, v)
+		}
+		return args
+	case map[string]string:
+		args := make([]interface{}, 0, len(arg)*2)
+		for k, v := range arg {
+			args = append(args, k, v)
+		}
+		return args
+	default:
+		return values
+	}
+}
+
+// 与 go-redis 写入命令参数的方式一致，保证内存中的值与写入 Redis 后读出的值相同
+func argString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case int:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int8:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int16:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 64), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case time.Duration:
+		return strconv.FormatInt(v.Nanoseconds(), 10), nil
+	case encoding.BinaryMarshaler:
+		b, err := v.MarshalBinary()
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	default:
+		return "", fmt.Errorf("redis: can't marshal %T (implement encoding.BinaryMarshaler)", v)
+	}
+}
+
+func stringArgs(values []string) []interface{} {
+	result := make([]interface{}, len(values))
+	for i, v := range values {
+		result[i] = v
+	}
+	return result
+}
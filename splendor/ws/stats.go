@@ -0,0 +1,191 @@
+package ws
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+)
+
+// 玩家统计：从已结束对局的日志汇总，每局的统计随对局索引缓存（见 finishedGames）
+
+// PlayerStats Splendor 玩家的统计数据
+type PlayerStats struct {
+	PlayerID      string         `json:"playerID"`
+	Games         int            `json:"games"`
+	Wins          int            `json:"wins"`
+	Finished      int            `json:"finished"` // 坚持到结束的对局数，中途离开的不计
+	AvgScore      float64        `json:"avgScore"`
+	Reached15     int            `json:"reached15"`    // 达到 15 分的对局数
+	AvgTurnsTo15  float64        `json:"avgTurnsTo15"` // 达到 15 分时平均用了几个回合
+	Nobles        int            `json:"nobles"`
+	NobleRate     float64        `json:"nobleRate"`     // 获得过贵族的对局比例
+	NoblesPerGame float64        `json:"noblesPerGame"` // 平均每局获得的贵族数
+	GemsTaken     map[string]int `json:"gemsTaken"`     // 拿取的各色宝石数
+	CardBonuses   map[string]int `json:"cardBonuses"`   // 购买的各色卡牌数
+	FavoriteGems  []string       `json:"favoriteGems"`  // 按拿取宝石和购买卡牌的次数从多到少
+}
+
+// 一名玩家在一局中的统计，建立对局索引时从日志整理一次
+type playerGameStats struct {
+	GemsTaken   map[string]int
+	CardBonuses map[string]int
+	Nobles      int
+	Reached15   bool
+	TurnsTo15   int
+}
+
+// 从一局的日志整理开局时各座位玩家的统计
+func collectGameStats(summary ReplaySummary, records []GameLogRecord) map[string]*playerGameStats {
+	stats := make(map[string]*playerGameStats, len(summary.Players))
+	for _, playerID := range summary.Players {
+		s := &playerGameStats{GemsTaken: make(map[string]int), CardBonuses: make(map[string]int)}
+		s.TurnsTo15, s.Reached15 = turnsToWinningScore(records, playerID)
+		stats[playerID] = s
+	}
+	for _, record := range records {
+		for _, event := range record.Events {
+			s, ok := stats[eventPlayerID(event)]
+			if !ok {
+				continue
+			}
+			switch event.Type {
+			case "gems_taken":
+				var data struct {
+					Gems map[string]int `json:"gems"`
+				}
+				if err := json.Unmarshal(event.Data, &data); err == nil {
+					for color, n := range data.Gems {
+						s.GemsTaken[color] += n
+					}
+				}
+			case "card_bought":
+				var data struct {
+					Bonus string `json:"bonus"`
+				}
+				if err := json.Unmarshal(event.Data, &data); err == nil && data.Bonus != "" {
+					s.CardBonuses[data.Bonus]++
+				}
+			case "noble_visited":
+				s.Nobles++
+			}
+		}
+	}
+	return stats
+}
+
+// PlayerStatsOf 汇总玩家在所有已结束对局中的统计数据
+func PlayerStatsOf(playerID string) (*PlayerStats, error) {
+	stats := &PlayerStats{
+		PlayerID:    playerID,
+		GemsTaken:   make(map[string]int),
+		CardBonuses: make(map[string]int),
+	}
+	score, turnsTo15, nobleGames := 0, 0, 0
+	err := forEachFinishedGame(func(game *finishedGame) {
+		gameStats, ok := game.Stats[playerID]
+		if !ok {
+			return
+		}
+		stats.Games++
+		var standings []Standing
+		if err := json.Unmarshal(game.Summary.Standings, &standings); err == nil {
+			for _, s := range standings {
+				if s.PlayerID != playerID {
+					continue
+				}
+				stats.Finished++
+				score += s.Score
+				if s.Rank == 1 {
+					stats.Wins++
+				}
+			}
+		}
+
+		for color, n := range gameStats.GemsTaken {
+			stats.GemsTaken[color] += n
+		}
+		for color, n := range gameStats.CardBonuses {
+			stats.CardBonuses[color] += n
+		}
+		stats.Nobles += gameStats.Nobles
+		if gameStats.Nobles > 0 {
+			nobleGames++
+		}
+		if gameStats.Reached15 {
+			stats.Reached15++
+			turnsTo15 += gameStats.TurnsTo15
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if stats.Finished > 0 {
+		stats.AvgScore = round1(float64(score) / float64(stats.Finished))
+	}
+	if stats.Reached15 > 0 {
+		stats.AvgTurnsTo15 = round1(float64(turnsTo15) / float64(stats.Reached15))
+	}
+	if stats.Games > 0 {
+		stats.NobleRate = math.Round(float64(nobleGames)/float64(stats.Games)*100) / 100
+		stats.NoblesPerGame = round1(float64(stats.Nobles) / float64(stats.Games))
+	}
+	stats.FavoriteGems = favoriteGems(stats.GemsTaken, stats.CardBonuses)
+	return stats, nil
+}
+
+// 还原对局过程，返回玩家分数第一次达到 15 分时是自己的第几个回合
+func turnsToWinningScore(records []GameLogRecord, playerID string) (int, bool) {
+	var doc interface{}
+	turns := 0
+	for _, record := range records {
+		var err error
+		if doc, err = applyGameRecord(doc, record); err != nil {
+			return 0, false
+		}
+		state, _ := doc.(map[string]interface{})
+		if record.Type == GameLogStarted && state["currentPlayer"] == playerID {
+			turns = 1
+		}
+		players, _ := state["players"].(map[string]interface{})
+		player, _ := players[playerID].(map[string]interface{})
+		if score, _ := player["score"].(float64); score >= winningScore {
+			return turns, true
+		}
+		for _, event := range record.Events {
+			if event.Type == "turn_started" && eventPlayerID(event) == playerID {
+				turns++
+			}
+		}
+	}
+	return 0, false
+}
+
+// 按拿取宝石和购买卡牌的总次数排序的颜色
+func favoriteGems(gemsTaken, cardBonuses map[string]int) []string {
+	counts := make(map[string]int)
+	for color, n := range gemsTaken {
+		counts[color] += n
+	}
+	for color, n := range cardBonuses {
+		counts[color] += n
+	}
+	colors := make([]string, 0, len(counts))
+	for color, n := range counts {
+		if n > 0 {
+			colors = append(colors, color)
+		}
+	}
+	sort.Slice(colors, func(i, j int) bool {
+		if counts[colors[i]] != counts[colors[j]] {
+			return counts[colors[i]] > counts[colors[j]]
+		}
+		return colors[i] < colors[j]
+	})
+	return colors
+}
+
+// 保留一位小数
+func round1(v float64) float64 {
+	return math.Round(v*10) / 10
+}
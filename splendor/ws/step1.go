@@ -1,51 +1,69 @@
 package ws
 
 import (
+	"fmt"
 	"go-game/entities"
 	"go-game/repository"
 	"log"
-	"math/rand"
 	"strconv"
 
 	"github.com/go-redis/redis/v8"
 )
 
-func handleBuyCardMessage(conn ReadWriteConn, rdb *redis.Client, roomID string, playerID string, msgMap map[string]interface{}) {
+// 计算购买卡牌需要实际支付的宝石（已扣除卡牌折扣，不足部分用黄金补），无法支付时返回 false
+func calcCardPayment(cost map[string]int, playerGems map[string]int, cardCount map[string]int) (map[string]int, bool) {
+	paidGems := make(map[string]int) // 实际支付宝石数
+	remainingGold := playerGems["Gold"]
+
+	for color, need := range cost {
+		owned := playerGems[color] + cardCount[color]
+		if owned >= need {
+			if cardCount[color] < need {
+				paidGems[color] = need - cardCount[color]
+			}
+		} else {
+			needGold := need - owned
+			if remainingGold < needGold {
+				return nil, false
+			}
+			paidGems[color] = playerGems[color]
+			paidGems["Gold"] += needGold
+			remainingGold -= needGold
+		}
+	}
+	return paidGems, true
+}
+
+func handleBuyCardMessage(conn ReadWriteConn, rdb redis.Cmdable, roomID string, playerID string, msgMap map[string]interface{}) error {
 	currentPlayer, err := GetCurrentPlayer(rdb, repository.Ctx, roomID)
 	if err != nil {
-		log.Println("❌ 获取当前玩家失败:", err)
-		return
+		return fmt.Errorf("获取当前玩家失败: %w", err)
 	}
 	if currentPlayer != playerID {
-		log.Println("❌ 不是当前玩家的回合")
-		return
+		return fmt.Errorf("不是当前玩家的回合")
 	}
 
 	// 1. 获取卡牌 ID
-	cardIDFloat, ok := msgMap["payload"].(float64)
-	if !ok {
-		log.Println("❌ 消息格式错误: payload 不是数字")
-		return
+	payload, err := payloadOf[BuyCardPayload](msgMap)
+	if err != nil {
+		return err
 	}
-	cardID := int(cardIDFloat)
+	cardID := int(payload)
 
 	// 2. 获取卡牌信息
 	card, err := GetNormalCardByID(roomID, strconv.Itoa(cardID))
 	if err != nil {
-		log.Println("❌ 获取卡牌失败:", err)
-		return
+		return fmt.Errorf("获取卡牌失败: %w", err)
 	}
 
 	// 3. 获取玩家宝石
 	playerGems, err := GetPlayerGem(roomID, playerID)
 	if err != nil {
-		log.Println("❌ 获取玩家宝石失败:", err)
-		return
+		return fmt.Errorf("获取玩家宝石失败: %w", err)
 	}
 	playerCard, err := GetPlayerNormalCard(roomID, playerID)
 	if err != nil {
-		log.Println("❌ 获取玩家卡牌失败:", err)
-		return
+		return fmt.Errorf("获取玩家卡牌失败: %w", err)
 	}
 
 	cardCount := make(map[string]int)
@@ -54,35 +72,21 @@ func handleBuyCardMessage(conn ReadWriteConn, rdb *redis.Client, roomID string,
 	}
 
 	// 4. 检查是否能支付
-	required := card.Cost
-	paidGems := make(map[string]int) // 实际支付宝石数
-	remainingGold := playerGems["Gold"]
-
-	canBuy := true
-	for color, cost := range required {
-		owned := playerGems[color] + cardCount[color]
-		if owned >= cost {
-			if cardCount[color] < cost {
-				paidGems[color] = cost - cardCount[color]
-			}
-		} else {
-			needGold := cost - owned
-			if remainingGold >= needGold {
-				paidGems[color] = playerGems[color]
-				paidGems["Gold"] += needGold
-				remainingGold -= needGold
-			} else {
-				canBuy = false
-				break
-			}
-		}
-	}
-
+	paidGems, canBuy := calcCardPayment(card.Cost, playerGems, cardCount)
 	if !canBuy {
-		log.Println("❌ 玩家宝石不足，无法购买该卡牌")
-		return
+		return fmt.Errorf("玩家宝石不足，无法购买该卡牌")
 	}
 
+	recordGameEvent(roomID, "card_bought", map[string]interface{}{
+		"playerID": playerID,
+		"cardID":   card.ID,
+		"level":    card.Level,
+		"bonus":    card.Bonus,
+		"points":   card.Points,
+		"reserved": card.State == entities.CardStateBought,
+		"paid":     paidGems,
+	})
+
 	// 5. 扣除玩家宝石
 	for color, amount := range paidGems {
 		playerGems[color] -= amount
@@ -90,43 +94,37 @@ func handleBuyCardMessage(conn ReadWriteConn, rdb *redis.Client, roomID string,
 
 	// 6. 更新玩家宝石
 	if err := SetPlayerGem(roomID, playerID, playerGems); err != nil {
-		log.Println("❌ 更新玩家宝石失败:", err)
-		return
+		return fmt.Errorf("更新玩家宝石失败: %w", err)
 	}
 
 	gemCount, err := GetGemCounts(roomID)
 	if err != nil {
-		log.Println("❌ 获取宝石数量失败:", err)
-		return
+		return fmt.Errorf("获取宝石数量失败: %w", err)
 	}
 
 	for color, amount := range paidGems {
 		gemCount[color] += amount
 	}
 	if err := SetGemCounts(roomID, gemCount); err != nil {
-		log.Println("❌ 设置宝石数量失败:", err)
-		return
+		return fmt.Errorf("设置宝石数量失败: %w", err)
 	}
 
 	// 7. 玩家获得该颜色卡牌加 1（假设 SetPlayerCard 函数存在）
 	playerCards, err := GetPlayerNormalCard(roomID, playerID)
 	if err != nil {
-		log.Println("❌ 获取玩家卡牌失败:", err)
-		return
+		return fmt.Errorf("获取玩家卡牌失败: %w", err)
 	}
 
 	playerCards = append(playerCards, *card)
 
 	if err := SetPlayerNormalCard(roomID, playerID, playerCards); err != nil {
-		log.Println("❌ 设置玩家卡牌失败:", err)
-		return
+		return fmt.Errorf("设置玩家卡牌失败: %w", err)
 	}
 
 	if card.State == entities.CardStateBought {
 		playerReserveCards, err := GetPlayerReserveCards(roomID, playerID)
 		if err != nil {
-			log.Println("❌ 获取玩家保留卡牌失败:", err)
-			return
+			return fmt.Errorf("获取玩家保留卡牌失败: %w", err)
 		}
 		for i, c := range playerReserveCards {
 			if c.ID == card.ID {
@@ -135,50 +133,29 @@ func handleBuyCardMessage(conn ReadWriteConn, rdb *redis.Client, roomID string,
 			}
 		}
 		if err := SetPlayerReserveCards(roomID, playerID, playerReserveCards); err != nil {
-			log.Println("❌ 设置玩家保留卡牌失败:", err)
-			return
+			return fmt.Errorf("设置玩家保留卡牌失败: %w", err)
 		}
 	} else {
-		allCards, err := GetAllNormalCards(roomID)
-		if err != nil {
-			return
-		}
-		// 1. 将 map 转换为 slice
-		cardList := make([]entities.NormalCard, 0, len(allCards))
-		for _, card := range allCards {
-			cardList = append(cardList, card)
-		}
-
-		// 2. 打乱顺序
-		rand.Shuffle(len(cardList), func(i, j int) {
-			cardList[i], cardList[j] = cardList[j], cardList[i]
-		})
-
-		// 3. 遍历打乱后的 cardList 来做查找/操作
-		for _, c := range cardList {
-			if c.State == entities.CardStateHidden && c.Level == card.Level {
-				c.State = entities.CardStateRevealed
-				if err := SetNormalCardByID(roomID, &c); err != nil {
-					log.Println("❌ 翻开卡牌失败:", err)
-				}
-				break
-			}
+		// 从同等级的牌堆补一张，牌堆已空时不补
+		if err := revealNextCard(roomID, card.Level); err != nil {
+			return err
 		}
 		// 8. 设置该卡牌为已购买
 		card.State = entities.CardStateBought
 		if err := SetNormalCardByID(roomID, card); err != nil {
-			log.Println("❌ 更新卡牌状态失败:", err)
-			return
+			return fmt.Errorf("更新卡牌状态失败: %w", err)
 		}
 	}
 
 	err = SetLastData(roomID, playerID, "buy_card", card)
 	if err != nil {
-		log.Println("❌ 设置最后购买的卡牌失败:", err)
-		return
+		return fmt.Errorf("设置最后购买的卡牌失败: %w", err)
 	}
 
-	allNobles, _ := GetAllNobleCards(roomID)
+	allNobles, err := GetAllNobleCards(roomID)
+	if err != nil {
+		return fmt.Errorf("获取贵族卡失败: %w", err)
+	}
 	revealedNobles := make([]entities.NobleCard, 0)
 	for _, noble := range allNobles {
 		if noble.State == entities.CardStateRevealed {
@@ -189,15 +166,13 @@ func handleBuyCardMessage(conn ReadWriteConn, rdb *redis.Client, roomID string,
 	// 1. 获取玩家已有的折扣卡数量
 	playerNobleCards, err := GetPlayerNobleCard(roomID, playerID)
 	if err != nil {
-		log.Printf("获取玩家贵族卡失败: %v", err)
-		return
+		return fmt.Errorf("获取玩家贵族卡失败: %w", err)
 	}
 
 	// 2. 获取玩家已有的卡牌数量
 	playerCards, err = GetPlayerNormalCard(roomID, playerID)
 	if err != nil {
-		log.Printf("获取玩家卡牌失败: %v", err)
-		return
+		return fmt.Errorf("获取玩家卡牌失败: %w", err)
 	}
 
 	cardCount = make(map[string]int)
@@ -221,75 +196,62 @@ func handleBuyCardMessage(conn ReadWriteConn, rdb *redis.Client, roomID string,
 
 			// 写回 noble 状态（假设 noble.ID 是 string，cardID 用字符串即可）
 			if err := SetNobleCardByID(roomID, &noble); err != nil {
-				log.Printf("⚠️ 设置贵族卡 %s 状态失败: %v", noble.ID, err)
-				continue
+				return fmt.Errorf("设置贵族卡 %s 状态失败: %w", noble.ID, err)
 			}
 
 			// 添加到玩家的 noble 列表中
 			playerNobleCards = append(playerNobleCards, noble)
-			// 发送消息给客户端，通知玩家获得了新的 noble
-			handlePlayAudioMessage(conn, rdb, roomID, playerID, map[string]interface{}{
-				"payload": "get-noble-card",
+			recordGameEvent(roomID, "noble_visited", map[string]interface{}{
+				"playerID": playerID,
+				"nobleID":  noble.ID,
 			})
+			// 发送消息给客户端，通知玩家获得了新的 noble
+			if err := handlePlayAudioMessage(conn, rdb, roomID, playerID, map[string]interface{}{
+				"payload": PlayAudioPayload("get-noble-card"),
+			}); err != nil {
+				log.Println("❌ 播放音效失败:", err)
+			}
 		}
 	}
 
 	// 5. 更新玩家的 noble 列表到 Redis
 	if err := SetPlayerNobleCard(roomID, playerID, playerNobleCards); err != nil {
-		log.Printf("⚠️ 更新玩家贵族卡列表失败: %v", err)
-		return
+		return fmt.Errorf("更新玩家贵族卡列表失败: %w", err)
 	}
 
-	SwitchToNextPlayer(rdb, repository.Ctx, roomID, currentPlayer)
+	return SwitchToNextPlayer(rdb, repository.Ctx, roomID, currentPlayer)
 }
 
-func handleGetGemMessage(conn ReadWriteConn, rdb *redis.Client, roomID string, playerID string, msgMap map[string]interface{}) {
+func handleGetGemMessage(conn ReadWriteConn, rdb redis.Cmdable, roomID string, playerID string, msgMap map[string]interface{}) error {
 	currentPlayer, err := GetCurrentPlayer(rdb, repository.Ctx, roomID)
 	if err != nil {
-		log.Println("❌ 获取当前玩家失败:", err)
-		return
+		return fmt.Errorf("获取当前玩家失败: %w", err)
 	}
 	if currentPlayer != playerID {
-		log.Println("❌ 不是当前玩家的回合")
-		return
+		return fmt.Errorf("不是当前玩家的回合")
 	}
 	// 1. 获取玩家取的宝石数量（从 payload 中解析）
-	payload, ok := msgMap["payload"].(map[string]interface{})
-	if !ok {
-		log.Println("❌ 消息格式错误: payload 解析失败")
-		return
-	}
-
-	// 转换为 map[string]int
-	gemCount := make(map[string]int)
-	for color, val := range payload {
-		floatVal, ok := val.(float64) // JSON 数字默认解析为 float64
-		if !ok {
-			log.Printf("❌ 宝石颜色 %s 数量格式错误\n", color)
-			return
-		}
-		gemCount[color] = int(floatVal)
+	gemCount, err := payloadOf[GetGemPayload](msgMap)
+	if err != nil {
+		return err
 	}
 
 	// 2. 获取玩家当前的宝石
 	playerGem, err := GetPlayerGem(roomID, playerID)
 	if err != nil {
-		log.Println("❌ 获取玩家宝石失败:", err)
-		return
+		return fmt.Errorf("获取玩家宝石失败: %w", err)
 	}
 
 	// 3. 获取房间宝石
 	allGems, err := GetGemCounts(roomID)
 	if err != nil {
-		log.Println("❌ 获取宝石数量失败:", err)
-		return
+		return fmt.Errorf("获取宝石数量失败: %w", err)
 	}
 
 	// 4. 更新宝石信息
 	for color, num := range gemCount {
 		if allGems[color] < num {
-			log.Printf("❌ 房间宝石不足: %s 只剩 %d，玩家想拿 %d\n", color, allGems[color], num)
-			return
+			return fmt.Errorf("房间宝石不足: %s 只剩 %d，玩家想拿 %d", color, allGems[color], num)
 		}
 		allGems[color] -= num
 		playerGem[color] += num
@@ -297,83 +259,81 @@ func handleGetGemMessage(conn ReadWriteConn, rdb *redis.Client, roomID string, p
 
 	// 5. 写回 Redis
 	if err := SetGemCounts(roomID, allGems); err != nil {
-		log.Println("❌ 更新房间宝石失败:", err)
-		return
+		return fmt.Errorf("更新房间宝石失败: %w", err)
 	}
 	if err := SetPlayerGem(roomID, playerID, playerGem); err != nil {
-		log.Println("❌ 更新玩家宝石失败:", err)
-		return
+		return fmt.Errorf("更新玩家宝石失败: %w", err)
 	}
+	recordGameEvent(roomID, "gems_taken", map[string]interface{}{
+		"playerID": playerID,
+		"gems":     gemCount,
+	})
 
 	err = SetLastData(roomID, playerID, "get_gem", gemCount)
 	if err != nil {
-		log.Println("❌ 设置最后取的宝石失败:", err)
-		return
+		return fmt.Errorf("设置最后取的宝石失败: %w", err)
 	}
-	SwitchToNextPlayer(rdb, repository.Ctx, roomID, currentPlayer)
+	return SwitchToNextPlayer(rdb, repository.Ctx, roomID, currentPlayer)
 }
 
-func handleReserveCardMessage(conn ReadWriteConn, rdb *redis.Client, roomID string, playerID string, msgMap map[string]interface{}) {
+func handleReserveCardMessage(conn ReadWriteConn, rdb redis.Cmdable, roomID string, playerID string, msgMap map[string]interface{}) error {
 	currentPlayer, err := GetCurrentPlayer(rdb, repository.Ctx, roomID)
 	if err != nil {
-		log.Println("❌ 获取当前玩家失败:", err)
-		return
+		return fmt.Errorf("获取当前玩家失败: %w", err)
 	}
 	if currentPlayer != playerID {
-		log.Println("❌ 不是当前玩家的回合")
-		return
+		return fmt.Errorf("不是当前玩家的回合")
 	}
 
-	cardIDFloat, ok := msgMap["payload"].(float64)
-	if !ok {
-		log.Println("❌ 消息格式错误: payload 不是数字")
-		return
-	}
-	cardID := int(cardIDFloat)
-
-	// 2. 获取卡牌信息
-	card, err := GetNormalCardByID(roomID, strconv.Itoa(cardID))
+	// payload 为卡牌 ID 时预留翻开的卡牌，为 {"level": n} 时从该等级牌堆盲抽
+	payload, err := payloadOf[PreserveCardPayload](msgMap)
 	if err != nil {
-		log.Println("❌ 获取卡牌失败:", err)
-		return
+		return err
+	}
+	var card *entities.NormalCard
+	blind := payload.Level > 0
+	if blind {
+		card, err = drawHiddenCard(roomID, payload.Level)
+		if err != nil {
+			return fmt.Errorf("盲抽卡牌失败: %w", err)
+		}
+	} else {
+		// 2. 获取卡牌信息
+		card, err = GetNormalCardByID(roomID, strconv.Itoa(payload.CardID))
+		if err != nil {
+			return fmt.Errorf("获取卡牌失败: %w", err)
+		}
 	}
 
 	allGems, err := GetGemCounts(roomID)
 	if err != nil {
-		log.Println("❌ 获取宝石数量失败:", err)
-		return
+		return fmt.Errorf("获取宝石数量失败: %w", err)
 	}
 
 	if allGems["Gold"] <= 0 {
-		log.Println("❌ 宝石不足")
-		return
+		return fmt.Errorf("宝石不足")
 	}
 
 	allGems["Gold"] -= 1
 	if err := SetGemCounts(roomID, allGems); err != nil {
-		log.Println("❌ 更新宝石数量失败:", err)
-		return
+		return fmt.Errorf("更新宝石数量失败: %w", err)
 	}
 	// 2. 获取玩家当前的保留卡牌
 	playerReserveCards, err := GetPlayerReserveCards(roomID, playerID)
 	if err != nil {
-		log.Println("❌ 获取玩家保留卡牌失败:", err)
-		return
+		return fmt.Errorf("获取玩家保留卡牌失败: %w", err)
 	}
 	if len(playerReserveCards) >= 3 {
-		log.Println("❌ 玩家保留卡牌已满")
-		return
+		return fmt.Errorf("玩家保留卡牌已满")
 	}
 	playerGem, err := GetPlayerGem(roomID, playerID)
 	if err != nil {
-		log.Println("❌ 获取玩家宝石失败:", err)
-		return
+		return fmt.Errorf("获取玩家宝石失败: %w", err)
 	}
 	playerGem["Gold"] += 1
 	err = SetPlayerGem(roomID, playerID, playerGem)
 	if err != nil {
-		log.Println("❌ 设置玩家宝石失败:", err)
-		return
+		return fmt.Errorf("设置玩家宝石失败: %w", err)
 	}
 
 	playerReserveCards = append(playerReserveCards, entities.NormalCard{
@@ -383,51 +343,39 @@ func handleReserveCardMessage(conn ReadWriteConn, rdb *redis.Client, roomID stri
 		Points: card.Points,
 		Cost:   card.Cost,
 		State:  entities.CardStateBought,
+		Blind:  blind,
 	})
 
-	allCards, err := GetAllNormalCards(roomID)
-	if err != nil {
-		return
-	}
-
-	// 1. 将 map 转换为 slice
-	cardList := make([]entities.NormalCard, 0, len(allCards))
-	for _, card := range allCards {
-		cardList = append(cardList, card)
-	}
-
-	// 2. 打乱顺序
-	rand.Shuffle(len(cardList), func(i, j int) {
-		cardList[i], cardList[j] = cardList[j], cardList[i]
+	recordGameEvent(roomID, "card_reserved", map[string]interface{}{
+		"playerID": playerID,
+		"cardID":   card.ID,
+		"level":    card.Level,
+		"blind":    blind,
 	})
-
-	// 3. 遍历打乱后的 cardList 来做查找/操作
-	for _, c := range cardList {
-		if c.State == entities.CardStateHidden && c.Level == card.Level {
-			c.State = entities.CardStateRevealed
-			if err := SetNormalCardByID(roomID, &c); err != nil {
-				log.Println("❌ 翻开卡牌失败:", err)
-			}
-			break
+	// 预留翻开的卡牌后补一张同等级的卡牌，盲抽不影响桌面
+	if !blind {
+		if err := revealNextCard(roomID, card.Level); err != nil {
+			return err
 		}
 	}
 	// 8. 设置该卡牌为已购买
 	card.State = entities.CardStateBought
 	if err := SetNormalCardByID(roomID, card); err != nil {
-		log.Println("❌ 更新卡牌状态失败:", err)
-		return
+		return fmt.Errorf("更新卡牌状态失败: %w", err)
 	}
 
 	err = SetPlayerReserveCards(roomID, playerID, playerReserveCards)
 	if err != nil {
-		log.Println("❌ 设置玩家保留卡牌失败:", err)
-		return
+		return fmt.Errorf("设置玩家保留卡牌失败: %w", err)
 	}
 
-	err = SetLastData(roomID, playerID, "preserve_card", card)
+	lastCard := *card
+	if blind {
+		lastCard = hideBlindCard(lastCard)
+	}
+	err = SetLastData(roomID, playerID, "preserve_card", lastCard)
 	if err != nil {
-		log.Println("❌ 设置最后购买的卡牌失败:", err)
-		return
+		return fmt.Errorf("设置最后购买的卡牌失败: %w", err)
 	}
-	SwitchToNextPlayer(rdb, repository.Ctx, roomID, currentPlayer)
+	return SwitchToNextPlayer(rdb, repository.Ctx, roomID, currentPlayer)
 }
@@ -0,0 +1,286 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-game/dto"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/websocket"
+)
+
+// 增量同步：每个连接第一次同步（加入、重连）收到完整的 sync 消息，之后只收到相对上一条消息的
+// JSON Patch（RFC 6902）。每条消息都带递增的 seq，客户端发现 seq 不连续时发送 {"type":"resync"}
+// 重新获取完整数据。AI 的虚拟连接、观战者和协议 1 的客户端仍然收到完整数据。
+//
+//	完整数据：{"type":"sync","seq":1,...}
+//	增量数据：{"type":"patch","seq":2,"ops":[{"op":"replace","path":"/roomData/currentPlayer","value":"p2"}]}
+
+// 单个玩家的同步流，记录发给当前连接的最后一条数据
+type syncStream struct {
+	conn dto.ConnInterface
+	seq  int64
+	last interface{}
+}
+
+// 房间内各玩家的同步流，挂在 Room 上，房间关闭时一起释放
+type syncStreams struct {
+	mu      sync.Mutex
+	streams map[string]*syncStream
+}
+
+// JSON Patch 操作
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"` // remove 操作没有 value
+}
+
+func valueOp(op, path string, value interface{}) patchOp {
+	data, _ := json.Marshal(value) // 已是 JSON 解码后的数据，不会编码失败
+	return patchOp{Op: op, Path: path, Value: data}
+}
+
+// 生成发给该连接的消息：新连接或要求重新同步时是完整数据，否则是增量，没有变化时返回 nil
+func (s *syncStreams) next(playerID string, conn dto.ConnInterface, msg interface{}) (interface{}, error) {
+	doc, err := toJSONValue(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.streams == nil {
+		s.streams = make(map[string]*syncStream)
+	}
+	stream, ok := s.streams[playerID]
+	if !ok || stream.conn != conn {
+		stream = &syncStream{conn: conn}
+		s.streams[playerID] = stream
+	} else {
+		ops := diffJSON("", stream.last, doc, nil)
+		if len(ops) == 0 {
+			return nil, nil
+		}
+		stream.seq++
+		stream.last = doc
+		return PatchMessage{Type: "patch", Seq: stream.seq, Ops: ops}, nil
+	}
+
+	stream.seq++
+	stream.last = doc
+	full, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("❌ 同步消息不是 JSON 对象")
+	}
+	// 另外复制一份加上 seq，保存的 last 不带 seq，下次比较时不会因为 seq 产生变化
+	withSeq := make(map[string]interface{}, len(full)+1)
+	for k, v := range full {
+		withSeq[k] = v
+	}
+	withSeq["seq"] = stream.seq
+	return withSeq, nil
+}
+
+// 下一次同步发送完整数据
+func (s *syncStreams) reset(playerID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.streams, playerID)
+}
+
+// 按同步流向玩家发送 sync 消息
+func sendSync(roomID, playerID string, conn dto.ConnInterface, msg interface{}) error {
+	room := getRoom(roomID)
+	if _, isAI := conn.(*VirtualConn); isAI || room == nil || connProtocol(conn) < 2 {
+		return writeJSON(conn, msg)
+	}
+	out, err := room.streams.next(playerID, conn, msg)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	if err := writeJSON(conn, out); err != nil {
+		// 客户端可能没收到这条增量，下次从完整数据开始
+		room.streams.reset(playerID)
+		return err
+	}
+	return nil
+}
+
+func writeJSON(conn dto.ConnInterface, msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("❌ 编码 JSON 失败: %w", err)
+	}
+	return conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// 客户端发现 seq 不连续时请求重新同步
+func handleResyncMessage(conn ReadWriteConn, _ redis.Cmdable, roomID, playerID string, _ map[string]interface{}) error {
+	if room := getRoom(roomID); room != nil {
+		room.streams.reset(playerID)
+	}
+	if err := SyncRoomMessage(unwrapConn(conn), roomID, playerID); err != nil {
+		return fmt.Errorf("重新同步失败: %w", err)
+	}
+	return nil
+}
+
+// 转成 JSON 解码后的通用结构（map[string]interface{}、[]interface{}、float64 等），便于逐层比较
+func toJSONValue(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("❌ 编码 JSON 失败: %w", err)
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("❌ 解析 JSON 失败: %w", err)
+	}
+	return doc, nil
+}
+
+// 比较两份 JSON 数据，生成把 from 变成 to 的操作。对象逐个字段比较；
+// 数组长度不变时逐个元素比较，长度变化时整体替换
+func diffJSON(path string, from, to interface{}, ops []patchOp) []patchOp {
+	switch toVal := to.(type) {
+	case map[string]interface{}:
+		fromVal, ok := from.(map[string]interface{})
+		if !ok {
+			break
+		}
+		removed := make([]string, 0)
+		for key := range fromVal {
+			if _, ok := toVal[key]; !ok {
+				removed = append(removed, key)
+			}
+		}
+		sort.Strings(removed)
+		for _, key := range removed {
+			ops = append(ops, patchOp{Op: "remove", Path: path + "/" + escapePointer(key)})
+		}
+		keys := make([]string, 0, len(toVal))
+		for key := range toVal {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			child := path + "/" + escapePointer(key)
+			old, ok := fromVal[key]
+			if !ok {
+				ops = append(ops, valueOp("add", child, toVal[key]))
+				continue
+			}
+			ops = diffJSON(child, old, toVal[key], ops)
+		}
+		return ops
+	case []interface{}:
+		fromVal, ok := from.([]interface{})
+		if !ok || len(fromVal) != len(toVal) {
+			break
+		}
+		for i := range toVal {
+			ops = diffJSON(fmt.Sprintf("%s/%d", path, i), fromVal[i], toVal[i], ops)
+		}
+		return ops
+	}
+	if reflect.DeepEqual(from, to) {
+		return ops
+	}
+	return append(ops, valueOp("replace", path, to))
+}
+
+// JSON Pointer 转义（RFC 6901）
+func escapePointer(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
+
+// 把 diffJSON 生成的操作应用到 doc 上（会修改 doc），返回修改后的数据
+func applyPatch(doc interface{}, ops []patchOp) (interface{}, error) {
+	for _, op := range ops {
+		var value interface{}
+		if op.Op != "remove" {
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return nil, fmt.Errorf("解析 %s 的值失败: %w", op.Path, err)
+			}
+		}
+		var tokens []string
+		if op.Path != "" {
+			tokens = strings.Split(op.Path, "/")[1:]
+			for i, token := range tokens {
+				tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+			}
+		}
+		var err error
+		if doc, err = applyPatchOp(doc, tokens, op.Op, value); err != nil {
+			return nil, fmt.Errorf("%s %s: %w", op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+func applyPatchOp(node interface{}, tokens []string, op string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		if op == "remove" {
+			return nil, nil
+		}
+		return value, nil
+	}
+	key, last := tokens[0], len(tokens) == 1
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if last {
+			if op == "remove" {
+				delete(n, key)
+			} else {
+				n[key] = value
+			}
+			return n, nil
+		}
+		child, ok := n[key]
+		if !ok {
+			return nil, fmt.Errorf("字段 %s 不存在", key)
+		}
+		updated, err := applyPatchOp(child, tokens[1:], op, value)
+		if err != nil {
+			return nil, err
+		}
+		n[key] = updated
+		return n, nil
+	case []interface{}:
+		if last && op == "add" && key == "-" {
+			return append(n, value), nil
+		}
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 || i > len(n) || (i == len(n) && !(last && op == "add")) {
+			return nil, fmt.Errorf("数组下标 %s 越界", key)
+		}
+		if last {
+			switch op {
+			case "add":
+				n = append(n, nil)
+				copy(n[i+1:], n[i:])
+				n[i] = value
+			case "remove":
+				n = append(n[:i], n[i+1:]...)
+			default:
+				n[i] = value
+			}
+			return n, nil
+		}
+		updated, err := applyPatchOp(n[i], tokens[1:], op, value)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = updated
+		return n, nil
+	}
+	return nil, fmt.Errorf("路径 %s 不是对象或数组", key)
+}
@@ -6,7 +6,6 @@ import (
 	"go-game/repository"
 	"log"
 	"net/http"
-	"path"
 	"reflect"
 	"strconv"
 	"time"
@@ -30,12 +29,13 @@ func StringInSlice(target string, list []string) bool {
 }
 
 // 将 HTTP 请求升级为 WebSocket 连接
-func upgradeConnection(c *gin.Context) (*websocket.Conn, error) {
+func upgradeConnection(c *gin.Context) (*Client, error) {
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Println("WebSocket 升级失败:", err)
+		return nil, err
 	}
-	return conn, err
+	return newClient(conn), nil
 }
 
 // 自定义 HookFunc，把字符串转换成 int
@@ -50,10 +50,11 @@ func stringToIntHookFunc() mapstructure.DecodeHookFunc {
 
 // GetConn 用于根据 roomID 和 playerID 获取对应的 WebSocket 连接
 func GetConn(roomID string, playerID string) (dto.ConnInterface, error) {
-	players, ok := Rooms[roomID]
-	if !ok {
+	room := getRoom(roomID)
+	if room == nil {
 		return nil, fmt.Errorf("房间[%s]不存在", roomID)
 	}
+	players := room.Players()
 	var conn dto.ConnInterface
 	for _, p := range players {
 		if p.PlayerID == playerID {
@@ -64,15 +65,13 @@ func GetConn(roomID string, playerID string) (dto.ConnInterface, error) {
 	return conn, nil
 }
 
-func getGameLogFilePath(roomID string) string {
-	// 建议你在房间初始化时设置一个 startTime 或 gameID
-	// 这里假设你用启动时间生成文件名
+// 当前对局的 ID：<房间>_<开局时间>，也是对局日志的名字
+func gameLogID(roomID string) string {
 	startKey := fmt.Sprintf("room:%s:game_start_time", roomID)
 	startTimeStr, err := repository.Rdb.Get(repository.Ctx, startKey).Result()
 	if err != nil {
 		startTimeStr = time.Now().Format("20060102_150405") // fallback
-		repository.Rdb.Set(repository.Ctx, startKey, time.Now().Format("20060102_150405"), 0)
+		repository.Rdb.Set(repository.Ctx, startKey, startTimeStr, 0)
 	}
-	fileName := fmt.Sprintf("%s_%s.json", roomID, startTimeStr)
-	return path.Join("./game_logs", fileName)
+	return fmt.Sprintf("%s_%s", roomID, startTimeStr)
 }